@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"nextai/apps/gateway/internal/domain"
+)
+
+const (
+	agentBudgetReasonSteps     = "max_steps"
+	agentBudgetReasonToolCalls = "max_tool_calls"
+	agentBudgetReasonDuration  = "max_duration"
+)
+
+var errAgentRunDeadline = errors.New("agent_run_deadline_exceeded")
+
+type agentBudget struct {
+	MaxSteps     int
+	MaxToolCalls int
+	MaxDuration  time.Duration
+}
+
+// resolveAgentBudget merges the server-wide limits with biz_params.budget.
+// A request may only tighten the server limits, never lift them.
+func (s *Server) resolveAgentBudget(bizParams map[string]interface{}) (agentBudget, error) {
+	budget := agentBudget{
+		MaxSteps:     s.cfg.AgentMaxSteps,
+		MaxToolCalls: s.cfg.AgentMaxToolCalls,
+		MaxDuration:  s.cfg.AgentMaxDuration,
+	}
+	raw, ok := bizParams["budget"]
+	if !ok || raw == nil {
+		return budget, nil
+	}
+	payload, ok := raw.(map[string]interface{})
+	if !ok {
+		return budget, errors.New("biz_params.budget must be an object")
+	}
+	maxSteps, err := parseAgentBudgetValue(payload, "max_steps")
+	if err != nil {
+		return budget, err
+	}
+	maxToolCalls, err := parseAgentBudgetValue(payload, "max_tool_calls")
+	if err != nil {
+		return budget, err
+	}
+	maxSeconds, err := parseAgentBudgetValue(payload, "max_duration_seconds")
+	if err != nil {
+		return budget, err
+	}
+	budget.MaxSteps = tightenAgentLimit(budget.MaxSteps, maxSteps)
+	budget.MaxToolCalls = tightenAgentLimit(budget.MaxToolCalls, maxToolCalls)
+	if maxSeconds > 0 {
+		requested := time.Duration(maxSeconds) * time.Second
+		if budget.MaxDuration <= 0 || requested < budget.MaxDuration {
+			budget.MaxDuration = requested
+		}
+	}
+	return budget, nil
+}
+
+func parseAgentBudgetValue(payload map[string]interface{}, key string) (int, error) {
+	raw, ok := payload[key]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+	value, ok := raw.(float64)
+	if !ok || value < 1 || value != math.Trunc(value) {
+		return 0, fmt.Errorf("biz_params.budget.%s must be a positive integer", key)
+	}
+	return int(value), nil
+}
+
+func tightenAgentLimit(serverLimit, requested int) int {
+	if requested <= 0 {
+		return serverLimit
+	}
+	if serverLimit <= 0 || requested < serverLimit {
+		return requested
+	}
+	return serverLimit
+}
+
+// agentRunClock enforces MaxDuration on a run. Time spent waiting for tool
+// approvals does not count against it: pause stops the clock and resume
+// restarts it with whatever budget was left.
+type agentRunClock struct {
+	mu        sync.Mutex
+	limit     time.Duration
+	used      time.Duration
+	startedAt time.Time
+	timer     *time.Timer
+}
+
+func (b agentBudget) withDeadline(parent context.Context) (context.Context, *agentRunClock, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	clock := &agentRunClock{limit: b.MaxDuration, startedAt: time.Now()}
+	if b.MaxDuration > 0 {
+		clock.timer = time.AfterFunc(b.MaxDuration, func() { cancel(errAgentRunDeadline) })
+	}
+	return ctx, clock, func() {
+		clock.pause()
+		cancel(nil)
+	}
+}
+
+func (c *agentRunClock) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.startedAt.IsZero() {
+		return
+	}
+	c.used += time.Since(c.startedAt)
+	c.startedAt = time.Time{}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+func (c *agentRunClock) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.startedAt.IsZero() {
+		return
+	}
+	c.startedAt = time.Now()
+	if c.timer != nil {
+		c.timer.Reset(max(c.limit-c.used, 0))
+	}
+}
+
+// elapsed is the run time counted against MaxDuration so far.
+func (c *agentRunClock) elapsed() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.startedAt.IsZero() {
+		return c.used
+	}
+	return c.used + time.Since(c.startedAt)
+}
+
+func agentRunDeadlineExceeded(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errAgentRunDeadline)
+}
+
+func buildAgentBudgetEvent(step int, reason string, limit, used interface{}) domain.AgentEvent {
+	return domain.AgentEvent{
+		Type: "budget_exhausted",
+		Step: step,
+		Meta: map[string]interface{}{
+			"code":    "agent_budget_exhausted",
+			"message": fmt.Sprintf("agent run stopped: %s budget exhausted", reason),
+			"reason":  reason,
+			"limit":   limit,
+			"used":    used,
+		},
+	}
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/plugin"
+)
+
+const (
+	agentRunStatusRunning   = "running"
+	agentRunStatusCompleted = "completed"
+	agentRunStatusFailed    = "failed"
+	agentRunStatusCancelled = "cancelled"
+
+	agentRunHistoryLimit = 100
+)
+
+var errAgentRunCancelled = errors.New("agent_run_cancelled")
+
+type agentRun struct {
+	info      domain.AgentRunInfo
+	cancel    context.CancelCauseFunc
+	approvals map[string]*pendingToolApproval
+}
+
+func (s *Server) startAgentRun(parent context.Context, info domain.AgentRunInfo) (context.Context, string) {
+	ctx, cancel := context.WithCancelCause(parent)
+	now := nowISO()
+	info.ID = newID("run")
+	info.Status = agentRunStatusRunning
+	info.StartedAt = now
+	info.UpdatedAt = now
+
+	s.agentRunsMu.Lock()
+	defer s.agentRunsMu.Unlock()
+	if s.agentRuns == nil {
+		s.agentRuns = map[string]*agentRun{}
+	}
+	s.agentRuns[info.ID] = &agentRun{info: info, cancel: cancel}
+	s.pruneAgentRunsLocked()
+	return ctx, info.ID
+}
+
+func (s *Server) updateAgentRunStep(runID string, step int) {
+	s.agentRunsMu.Lock()
+	defer s.agentRunsMu.Unlock()
+	if run, ok := s.agentRuns[runID]; ok && run.info.Status == agentRunStatusRunning {
+		run.info.Step = step
+		run.info.UpdatedAt = nowISO()
+	}
+}
+
+func (s *Server) finishAgentRun(runID, status, message string) {
+	s.agentRunsMu.Lock()
+	defer s.agentRunsMu.Unlock()
+	run, ok := s.agentRuns[runID]
+	if !ok || run.info.Status != agentRunStatusRunning {
+		return
+	}
+	now := nowISO()
+	run.info.Status = status
+	run.info.Error = message
+	run.info.UpdatedAt = now
+	run.info.FinishedAt = now
+	run.cancel(nil)
+}
+
+func (s *Server) cancelAgentRunByID(runID string) (domain.AgentRunInfo, bool) {
+	s.agentRunsMu.Lock()
+	run, ok := s.agentRuns[runID]
+	if !ok {
+		s.agentRunsMu.Unlock()
+		return domain.AgentRunInfo{}, false
+	}
+	run.cancel(errAgentRunCancelled)
+	info := run.info
+	s.agentRunsMu.Unlock()
+	return info, true
+}
+
+// pruneAgentRunsLocked keeps every running entry and only the most recent
+// finished ones, so the registry does not grow with the server uptime.
+func (s *Server) pruneAgentRunsLocked() {
+	finished := make([]*agentRun, 0, len(s.agentRuns))
+	for _, run := range s.agentRuns {
+		if run.info.Status != agentRunStatusRunning {
+			finished = append(finished, run)
+		}
+	}
+	if len(finished) <= agentRunHistoryLimit {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].info.FinishedAt < finished[j].info.FinishedAt
+	})
+	for _, run := range finished[:len(finished)-agentRunHistoryLimit] {
+		delete(s.agentRuns, run.info.ID)
+		s.fileJournal.Forget(run.info.ID)
+	}
+}
+
+func agentRunCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errAgentRunCancelled)
+}
+
+func (s *Server) listAgentRuns(w http.ResponseWriter, r *http.Request) {
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	out := make([]domain.AgentRunInfo, 0)
+	s.agentRunsMu.RLock()
+	for _, run := range s.agentRuns {
+		if status != "" && run.info.Status != status {
+			continue
+		}
+		out = append(out, run.info)
+	}
+	s.agentRunsMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].StartedAt == out[j].StartedAt {
+			return out[i].ID > out[j].ID
+		}
+		return out[i].StartedAt > out[j].StartedAt
+	})
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) getAgentRun(w http.ResponseWriter, r *http.Request) {
+	runID := strings.TrimSpace(chi.URLParam(r, "run_id"))
+	s.agentRunsMu.RLock()
+	run, ok := s.agentRuns[runID]
+	var info domain.AgentRunInfo
+	if ok {
+		info = run.info
+	}
+	s.agentRunsMu.RUnlock()
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "agent run not found", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) cancelAgentRun(w http.ResponseWriter, r *http.Request) {
+	runID := strings.TrimSpace(chi.URLParam(r, "run_id"))
+	info, ok := s.cancelAgentRunByID(runID)
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "agent run not found", nil)
+		return
+	}
+	if info.Status != agentRunStatusRunning {
+		writeErr(w, http.StatusConflict, "agent_run_finished", "agent run has already finished", map[string]string{"status": info.Status})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, info)
+}
+
+// revertAgentRun restores the files changed by a finished run's file tools.
+// Files modified again after the run are reported as conflicts and block the
+// revert unless force is set.
+func (s *Server) revertAgentRun(w http.ResponseWriter, r *http.Request) {
+	runID := strings.TrimSpace(chi.URLParam(r, "run_id"))
+	var req domain.AgentRunRevertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeErr(w, http.StatusBadRequest, "invalid_json", "invalid request body", nil)
+		return
+	}
+	s.agentRunsMu.RLock()
+	run, ok := s.agentRuns[runID]
+	var info domain.AgentRunInfo
+	if ok {
+		info = run.info
+	}
+	s.agentRunsMu.RUnlock()
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "agent run not found", nil)
+		return
+	}
+	if info.Status == agentRunStatusRunning {
+		writeErr(w, http.StatusConflict, "agent_run_running", "agent run is still running", map[string]string{"status": info.Status})
+		return
+	}
+	result, err := s.fileJournal.Revert(runID, req.Force)
+	if errors.Is(err, plugin.ErrFileJournalNotFound) {
+		writeErr(w, http.StatusNotFound, "not_found", "agent run has no file changes to revert", nil)
+		return
+	}
+	out := domain.AgentRunRevertResult{
+		RunID:     runID,
+		Reverted:  result.Reverted,
+		Conflicts: make([]domain.AgentRunRevertConflict, 0, len(result.Conflicts)),
+	}
+	for _, conflict := range result.Conflicts {
+		out.Conflicts = append(out.Conflicts, domain.AgentRunRevertConflict{Path: conflict.Path, Reason: conflict.Reason})
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "revert_failed", err.Error(), out)
+		return
+	}
+	if len(out.Conflicts) > 0 && !req.Force {
+		writeErr(w, http.StatusConflict, "revert_conflict", "files changed after the run; retry with force to overwrite them", out)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
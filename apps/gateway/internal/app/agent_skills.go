@@ -0,0 +1,261 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/plugin"
+	"nextai/apps/gateway/internal/repo"
+)
+
+const (
+	loadSkillToolName       = "load_skill"
+	skillSummaryMaxRunes    = 160
+	assistantMetaSkillsUsed = "skills_used"
+)
+
+var (
+	errSkillNotFound     = errors.New("skill_not_found")
+	errSkillFileNotFound = errors.New("skill_file_not_found")
+)
+
+func enabledSkills(st *repo.State) []domain.SkillSpec {
+	out := make([]domain.SkillSpec, 0, len(st.Skills))
+	for _, spec := range st.Skills {
+		if spec.Enabled {
+			out = append(out, spec)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (s *Server) hasEnabledSkills() bool {
+	found := false
+	s.store.Read(func(st *repo.State) {
+		for _, spec := range st.Skills {
+			if spec.Enabled {
+				found = true
+				return
+			}
+		}
+	})
+	return found
+}
+
+// buildSkillIndex lists the enabled skills for the system prompt. Only a
+// one-line summary is included; the model pulls the rest through load_skill.
+func buildSkillIndex(skills []domain.SkillSpec) string {
+	if len(skills) == 0 {
+		return ""
+	}
+	lines := []string{
+		"## Skills",
+		fmt.Sprintf("Enabled skills are listed below. Before following a skill, call `%s` with its name to read the full instructions; pass `file` to read one of its references or scripts.", loadSkillToolName),
+	}
+	for _, skill := range skills {
+		line := "- " + skill.Name
+		summary := clipSkillSummary(strings.TrimSpace(skill.Description))
+		if summary == "" {
+			summary = skillSummary(skill.Content)
+		}
+		if summary != "" {
+			line += ": " + summary
+		}
+		if len(skill.Triggers) > 0 {
+			line += " (triggers: " + strings.Join(skill.Triggers, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func appendSkillIndex(guide string, skills []domain.SkillSpec) string {
+	index := buildSkillIndex(skills)
+	if index == "" {
+		return guide
+	}
+	return guide + "\n\n" + index
+}
+
+// skillSummary takes the description from SKILL.md front matter, or else the
+// first line of prose.
+func skillSummary(content string) string {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	start := 0
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+		for i := 1; i < len(lines); i++ {
+			line := strings.TrimSpace(lines[i])
+			if line == "---" {
+				start = i + 1
+				break
+			}
+			if value, ok := strings.CutPrefix(line, "description:"); ok {
+				return clipSkillSummary(strings.Trim(strings.TrimSpace(value), `"'`))
+			}
+		}
+	}
+	for _, line := range lines[start:] {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		return clipSkillSummary(line)
+	}
+	return ""
+}
+
+func clipSkillSummary(text string) string {
+	runes := []rune(text)
+	if len(runes) <= skillSummaryMaxRunes {
+		return text
+	}
+	return string(runes[:skillSummaryMaxRunes]) + "..."
+}
+
+// skillFilePaths flattens a references/scripts tree into sorted paths such as
+// references/api/auth.md, matching what readSkillVirtualFile accepts.
+func skillFilePaths(prefix string, node map[string]interface{}) []string {
+	out := []string{}
+	for key, value := range node {
+		path := prefix + "/" + key
+		switch child := value.(type) {
+		case string:
+			out = append(out, path)
+		case map[string]interface{}:
+			out = append(out, skillFilePaths(path, child)...)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// skillsUsedFromEvents returns the skills loaded successfully during a run,
+// in load order.
+func skillsUsedFromEvents(events []domain.AgentEvent) []string {
+	pending := map[string]string{}
+	seen := map[string]struct{}{}
+	out := []string{}
+	for _, evt := range events {
+		switch {
+		case evt.Type == "tool_call" && evt.ToolCall != nil && evt.ToolCall.Name == loadSkillToolName:
+			name, _ := evt.ToolCall.Input["name"].(string)
+			pending[fmt.Sprintf("%d/%s", evt.Step, evt.ToolCall.ID)] = strings.TrimSpace(name)
+		case evt.Type == "tool_result" && evt.ToolResult != nil && evt.ToolResult.Name == loadSkillToolName:
+			key := fmt.Sprintf("%d/%s", evt.Step, evt.ToolResult.ID)
+			name := pending[key]
+			delete(pending, key)
+			if _, dup := seen[name]; !evt.ToolResult.OK || name == "" || dup {
+				continue
+			}
+			seen[name] = struct{}{}
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// loadSkillTool gives the model the full content of an enabled skill, or one
+// of its reference or script files.
+type loadSkillTool struct {
+	store *repo.Store
+}
+
+func newLoadSkillTool(store *repo.Store) *loadSkillTool {
+	return &loadSkillTool{store: store}
+}
+
+func (t *loadSkillTool) Name() string {
+	return loadSkillToolName
+}
+
+func (t *loadSkillTool) ToolSpec() plugin.ToolSpec {
+	return plugin.ToolSpec{
+		Description: "Load an enabled skill listed in the system prompt. Without file, returns the skill instructions and the paths of its references and scripts; with file, returns that file.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Skill name from the skill index.",
+					"minLength":   1,
+				},
+				"file": map[string]interface{}{
+					"type":        "string",
+					"description": "Optional file path such as references/guide.md or scripts/run.sh.",
+				},
+			},
+			"required":             []string{"name"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+func (t *loadSkillTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), plugin.ToolInvocation{}, input)
+}
+
+func (t *loadSkillTool) InvokeContext(_ context.Context, _ plugin.ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	name, _ := input["name"].(string)
+	name = strings.TrimSpace(name)
+	file, _ := input["file"].(string)
+	file = strings.Trim(strings.TrimSpace(file), "/")
+
+	var skill domain.SkillSpec
+	found := false
+	t.store.Read(func(st *repo.State) {
+		skill, found = st.Skills[name]
+	})
+	if !found || !skill.Enabled {
+		return nil, fmt.Errorf("%w: %s", errSkillNotFound, name)
+	}
+
+	if file != "" {
+		content, ok := readSkillVirtualFile(skill, file)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s/%s", errSkillFileNotFound, name, file)
+		}
+		return map[string]interface{}{
+			"ok":      true,
+			"name":    name,
+			"file":    file,
+			"content": content,
+			"text":    fmt.Sprintf("skill %q file %s\n%s", name, file, content),
+		}, nil
+	}
+
+	references := skillFilePaths("references", skill.References)
+	scripts := skillFilePaths("scripts", skill.Scripts)
+	text := fmt.Sprintf("skill %q\n%s", name, strings.TrimSpace(skill.Content))
+	files := []string{}
+	if len(references) > 0 {
+		files = append(files, "references: "+strings.Join(references, ", "))
+	}
+	if len(scripts) > 0 {
+		files = append(files, "scripts: "+strings.Join(scripts, ", "))
+	}
+	// List the script tools under the names the model calls them by.
+	tools := skillToolNames(skill)
+	for idx, tool := range tools {
+		tools[idx] = modelToolName(tool)
+	}
+	if len(tools) > 0 {
+		files = append(files, "tools: "+strings.Join(tools, ", "))
+	}
+	if len(files) > 0 {
+		text += "\n\n" + strings.Join(files, "\n")
+	}
+	return map[string]interface{}{
+		"ok":         true,
+		"name":       name,
+		"content":    skill.Content,
+		"references": references,
+		"scripts":    scripts,
+		"tools":      tools,
+		"text":       text,
+	}, nil
+}
@@ -0,0 +1,291 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/plugin"
+	"nextai/apps/gateway/internal/runner"
+)
+
+const (
+	fallbackAgentToolWorkers = 4
+
+	toolOutputStreamMaxBytes  = 64 * 1024
+	toolOutputTailBytes       = 8 * 1024
+	toolOutputSummaryMaxRunes = 2000
+
+	modelToolNameMaxLength = 64
+)
+
+var modelToolNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+type toolCallOutcome struct {
+	Reply string
+	Err   error
+}
+
+// resolveToolWorkers reads biz_params.parallel_tool_calls. It accepts true
+// (use the server worker limit) or a positive worker count, which is capped at
+// the server limit. Anything else keeps the sequential behaviour.
+func (s *Server) resolveToolWorkers(bizParams map[string]interface{}) (int, error) {
+	limit := s.cfg.AgentToolWorkers
+	if limit <= 0 {
+		limit = fallbackAgentToolWorkers
+	}
+	raw, ok := bizParams["parallel_tool_calls"]
+	if !ok || raw == nil {
+		return 1, nil
+	}
+	switch value := raw.(type) {
+	case bool:
+		if value {
+			return limit, nil
+		}
+		return 1, nil
+	case float64:
+		if value < 1 || value != math.Trunc(value) {
+			return 0, errors.New("biz_params.parallel_tool_calls must be a boolean or a positive integer")
+		}
+		if int(value) > limit {
+			return limit, nil
+		}
+		return int(value), nil
+	default:
+		return 0, errors.New("biz_params.parallel_tool_calls must be a boolean or a positive integer")
+	}
+}
+
+// executeToolCalls runs calls with at most workers in flight. When stream is
+// not nil, tool output is relayed through it while the calls run. onStart is
+// called in call order before any call runs when executing in parallel, and
+// onDone is called from the calling goroutine in completion order. The
+// returned outcomes keep the original call order.
+func (s *Server) executeToolCalls(
+	ctx context.Context,
+	inv plugin.ToolInvocation,
+	calls []runner.ToolCall,
+	workers int,
+	stream *toolOutputStream,
+	onStart func(idx int),
+	onDone func(idx int, outcome toolCallOutcome),
+) []toolCallOutcome {
+	outcomes := make([]toolCallOutcome, len(calls))
+	if workers <= 1 || len(calls) <= 1 {
+		for idx, call := range calls {
+			onStart(idx)
+			reply, err := s.executeToolCall(ctx, stream.attach(idx, toolCallInvocation(inv, call), call.Name), toolCall{Name: call.Name, Input: safeMap(call.Arguments)})
+			outcomes[idx] = toolCallOutcome{Reply: reply, Err: err}
+			onDone(idx, outcomes[idx])
+		}
+		return outcomes
+	}
+
+	type finished struct {
+		idx     int
+		outcome toolCallOutcome
+	}
+	done := make(chan finished, len(calls))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for idx, call := range calls {
+		onStart(idx)
+		wg.Add(1)
+		go func(idx int, call runner.ToolCall) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					done <- finished{idx: idx, outcome: toolCallOutcome{Err: &toolError{
+						Code:    "tool_invoke_failed",
+						Message: fmt.Sprintf("tool %q invocation failed", call.Name),
+						Err:     fmt.Errorf("tool panicked: %v", r),
+					}}}
+				}
+			}()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			reply, err := s.executeToolCall(ctx, stream.attach(idx, toolCallInvocation(inv, call), call.Name), toolCall{Name: call.Name, Input: safeMap(call.Arguments)})
+			done <- finished{idx: idx, outcome: toolCallOutcome{Reply: reply, Err: err}}
+		}(idx, call)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for item := range done {
+		outcomes[item.idx] = item.outcome
+		onDone(item.idx, item.outcome)
+	}
+	return outcomes
+}
+
+// modelToolName folds a registered tool name into the ^[a-zA-Z0-9_-]{1,64}$
+// form providers accept for function names, e.g. skill.deploy.ship becomes
+// skill__deploy__ship. Names that already fit are returned unchanged.
+func modelToolName(name string) string {
+	folded := modelToolNameInvalid.ReplaceAllString(strings.ReplaceAll(name, ".", "__"), "_")
+	if len(folded) > modelToolNameMaxLength {
+		folded = folded[:modelToolNameMaxLength]
+	}
+	return folded
+}
+
+// modelToolNames maps the name sent to the model back to the registered tool
+// name. When two tools fold to the same name the first in sorted order wins.
+func modelToolNames(names []string) map[string]string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	out := make(map[string]string, len(sorted))
+	for _, name := range sorted {
+		folded := modelToolName(name)
+		if _, taken := out[folded]; !taken {
+			out[folded] = name
+		}
+	}
+	return out
+}
+
+// resolveModelToolCalls renames calls made by the model to the registered
+// tool names. Unknown names are kept so that execution reports them.
+func (s *Server) resolveModelToolCalls(calls []runner.ToolCall) []runner.ToolCall {
+	tools := s.toolPlugins()
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	byModelName := modelToolNames(names)
+	out := make([]runner.ToolCall, len(calls))
+	for idx, call := range calls {
+		if _, ok := tools[call.Name]; !ok {
+			if name, ok := byModelName[call.Name]; ok {
+				call.Name = name
+			}
+		}
+		out[idx] = call
+	}
+	return out
+}
+
+func toolCallInvocation(base plugin.ToolInvocation, call runner.ToolCall) plugin.ToolInvocation {
+	base.CallID = call.ID
+	return base
+}
+
+// toolOutputStream turns output chunks reported by running tools into
+// tool_output_delta events. Chunks arrive on tool goroutines, so emit must be
+// safe for concurrent use. After close, chunks from calls that outlived their
+// step are dropped.
+type toolOutputStream struct {
+	mu      sync.Mutex
+	step    int
+	emit    func(domain.AgentEvent)
+	closed  bool
+	pending map[int]string
+	seen    map[int]int
+	tails   map[int]string
+}
+
+func newToolOutputStream(step int, emit func(domain.AgentEvent)) *toolOutputStream {
+	return &toolOutputStream{
+		step:    step,
+		emit:    emit,
+		pending: map[int]string{},
+		seen:    map[int]int{},
+		tails:   map[int]string{},
+	}
+}
+
+// attach sets inv.OnOutput for the call at idx. A nil stream leaves inv as is.
+func (s *toolOutputStream) attach(idx int, inv plugin.ToolInvocation, name string) plugin.ToolInvocation {
+	if s == nil {
+		return inv
+	}
+	callID := inv.CallID
+	inv.OnOutput = func(chunk string) {
+		s.write(idx, callID, name, chunk)
+	}
+	return inv
+}
+
+func (s *toolOutputStream) write(idx int, callID, name, chunk string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	// Hold back a rune split across writes so every delta is valid UTF-8.
+	text, rest := splitIncompleteRune(s.pending[idx] + chunk)
+	s.pending[idx] = rest
+	if text == "" {
+		return
+	}
+	tail := s.tails[idx] + text
+	if len(tail) > toolOutputTailBytes {
+		tail = tail[len(tail)-toolOutputTailBytes:]
+	}
+	s.tails[idx] = tail
+	streamed := s.seen[idx]
+	s.seen[idx] += len(text)
+	if streamed >= toolOutputStreamMaxBytes {
+		return
+	}
+	s.emit(domain.AgentEvent{
+		Type:     "tool_output_delta",
+		Step:     s.step,
+		ToolCall: &domain.AgentToolCallPayload{ID: callID, Name: name},
+		Delta:    text,
+	})
+}
+
+func (s *toolOutputStream) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// summary returns the end of the output streamed for the call at idx, or ""
+// when the tool did not stream anything.
+func (s *toolOutputStream) summary(idx int) string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trimmed := strings.TrimSpace(s.tails[idx])
+	if trimmed == "" {
+		return ""
+	}
+	runes := []rune(trimmed)
+	if len(runes) <= toolOutputSummaryMaxRunes && s.seen[idx] == len(s.tails[idx]) {
+		return trimmed
+	}
+	if len(runes) > toolOutputSummaryMaxRunes {
+		runes = runes[len(runes)-toolOutputSummaryMaxRunes:]
+	}
+	return "..." + strings.TrimLeft(string(runes), string(utf8.RuneError))
+}
+
+func splitIncompleteRune(text string) (string, string) {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(text); i++ {
+		start := len(text) - i
+		if !utf8.RuneStart(text[start]) {
+			continue
+		}
+		if !utf8.FullRuneInString(text[start:]) {
+			return text[:start], text[start:]
+		}
+		break
+	}
+	return text, ""
+}
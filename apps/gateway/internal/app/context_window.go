@@ -0,0 +1,33 @@
+package app
+
+import (
+	"nextai/apps/gateway/internal/provider"
+	"nextai/apps/gateway/internal/runner"
+)
+
+// resolveContextWindow picks the smallest known window across the failover
+// slots so that a fallback never receives a prompt sized for the primary.
+func resolveContextWindow(configs []runner.GenerateConfig) runner.ContextWindow {
+	window := runner.ContextWindow{}
+	for _, cfg := range configs {
+		limit, ok := provider.ResolveModelLimit(cfg.ProviderID, cfg.Model)
+		if !ok {
+			continue
+		}
+		if window.ContextTokens == 0 || limit.Context < window.ContextTokens {
+			window = runner.ContextWindow{ContextTokens: limit.Context, OutputTokens: limit.Output}
+		}
+	}
+	return window
+}
+
+func buildContextCompactionMeta(compaction runner.ContextCompaction, at string) map[string]interface{} {
+	return map[string]interface{}{
+		"dropped_messages": compaction.DroppedMessages,
+		"summarized":       compaction.Summarized,
+		"tokens_before":    compaction.TokensBefore,
+		"tokens_after":     compaction.TokensAfter,
+		"budget":           compaction.Budget,
+		"compacted_at":     at,
+	}
+}
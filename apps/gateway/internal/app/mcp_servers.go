@@ -0,0 +1,282 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/plugin"
+	"nextai/apps/gateway/internal/repo"
+)
+
+const (
+	mcpStartupTimeout = 30 * time.Second
+
+	mcpStatusConnected = "connected"
+	mcpStatusDisabled  = "disabled"
+	mcpStatusError     = "error"
+)
+
+var mcpServerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// mcpServerRuntime is the live side of a stored MCP server: the connected
+// client, or the error from the last attempt to connect.
+type mcpServerRuntime struct {
+	client *plugin.MCPClient
+	err    string
+}
+
+// mcpClientConfig builds the client config of a stored server. Stdio servers
+// see the stored envs, with the server's own env taking precedence.
+func mcpClientConfig(name string, cfg domain.MCPServerConfig, envs map[string]string) plugin.MCPServerConfig {
+	env := map[string]string{}
+	if cfg.Command != "" {
+		for key, value := range envs {
+			env[key] = value
+		}
+	}
+	for key, value := range cfg.Env {
+		env[key] = value
+	}
+	return plugin.MCPServerConfig{
+		Name:    name,
+		Command: cfg.Command,
+		Args:    cfg.Args,
+		Env:     env,
+		Dir:     cfg.Cwd,
+		URL:     cfg.URL,
+		Headers: cfg.Headers,
+		Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second,
+	}
+}
+
+func mcpServerEnabled(cfg domain.MCPServerConfig) bool {
+	return cfg.Enabled == nil || *cfg.Enabled
+}
+
+// initMCPServers connects the stored servers in parallel. A server that
+// cannot be reached is logged and reported through GET /config/mcp-servers;
+// it does not stop the gateway from starting.
+func (s *Server) initMCPServers() {
+	stored := map[string]domain.MCPServerConfig{}
+	var envs map[string]string
+	s.store.Read(func(st *repo.State) {
+		for name, cfg := range st.MCPServers {
+			stored[name] = cfg
+		}
+		envs = storedEnvs(st)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), mcpStartupTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for name, cfg := range stored {
+		if !mcpServerEnabled(cfg) {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, cfg domain.MCPServerConfig) {
+			defer wg.Done()
+			client, err := plugin.ConnectMCPServer(ctx, mcpClientConfig(name, cfg, envs))
+			if err != nil {
+				log.Printf("connect mcp server %s failed: %v", name, err)
+			}
+			s.setMCPServer(name, client, err)
+		}(name, cfg)
+	}
+	wg.Wait()
+}
+
+// setMCPServer swaps the runtime of a server: tools of the previous client
+// are unregistered and the client closed before the new tools are added.
+func (s *Server) setMCPServer(name string, client *plugin.MCPClient, connectErr error) {
+	s.mcpMu.Lock()
+	defer s.mcpMu.Unlock()
+	if old, ok := s.mcpServers[name]; ok && old.client != nil {
+		for _, tool := range old.client.Tools() {
+			s.unregisterToolPlugin(tool.Name())
+		}
+		old.client.Close()
+	}
+	runtime := &mcpServerRuntime{client: client}
+	if connectErr != nil {
+		runtime.err = connectErr.Error()
+	}
+	if client == nil && connectErr == nil {
+		delete(s.mcpServers, name)
+		return
+	}
+	s.mcpServers[name] = runtime
+	if client != nil {
+		for _, tool := range client.Tools() {
+			s.registerToolPlugin(tool)
+		}
+	}
+}
+
+func (s *Server) closeMCPServers() {
+	s.mcpMu.Lock()
+	names := make([]string, 0, len(s.mcpServers))
+	for name := range s.mcpServers {
+		names = append(names, name)
+	}
+	s.mcpMu.Unlock()
+	for _, name := range names {
+		s.setMCPServer(name, nil, nil)
+	}
+}
+
+func (s *Server) mcpServerInfo(name string, cfg domain.MCPServerConfig) domain.MCPServerInfo {
+	cfg.Env = maskSecretStrings(cfg.Env)
+	cfg.Headers = maskSecretStrings(cfg.Headers)
+	info := domain.MCPServerInfo{Name: name, MCPServerConfig: cfg, Status: mcpStatusDisabled, Tools: []string{}}
+	if !mcpServerEnabled(cfg) {
+		return info
+	}
+	s.mcpMu.Lock()
+	defer s.mcpMu.Unlock()
+	runtime, ok := s.mcpServers[name]
+	switch {
+	case ok && runtime.client != nil:
+		info.Status = mcpStatusConnected
+		for _, tool := range runtime.client.Tools() {
+			info.Tools = append(info.Tools, tool.Name())
+		}
+	case ok:
+		info.Status = mcpStatusError
+		info.Error = runtime.err
+	default:
+		info.Status = mcpStatusError
+		info.Error = "not connected"
+	}
+	return info
+}
+
+func (s *Server) listMCPServers(w http.ResponseWriter, _ *http.Request) {
+	stored := map[string]domain.MCPServerConfig{}
+	s.store.Read(func(st *repo.State) {
+		for name, cfg := range st.MCPServers {
+			stored[name] = cfg
+		}
+	})
+	names := make([]string, 0, len(stored))
+	for name := range stored {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]domain.MCPServerInfo, 0, len(names))
+	for _, name := range names {
+		out = append(out, s.mcpServerInfo(name, stored[name]))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) getMCPServer(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "server_name")))
+	var cfg domain.MCPServerConfig
+	found := false
+	s.store.Read(func(st *repo.State) {
+		cfg, found = st.MCPServers[name]
+	})
+	if !found {
+		writeErr(w, http.StatusNotFound, "not_found", "mcp server not found", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.mcpServerInfo(name, cfg))
+}
+
+// putMCPServer creates or replaces a server. An enabled server must connect
+// and list its tools before the config is saved; its tools then replace
+// those of the previous connection.
+func (s *Server) putMCPServer(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "server_name")))
+	if !mcpServerNamePattern.MatchString(name) {
+		writeErr(w, http.StatusBadRequest, "invalid_mcp_server", "server name must match [a-z0-9][a-z0-9_-]{0,31}", nil)
+		return
+	}
+	var body domain.MCPServerConfig
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_json", "invalid request body", nil)
+		return
+	}
+	body.Command = strings.TrimSpace(body.Command)
+	body.URL = strings.TrimSpace(body.URL)
+	if (body.Command == "") == (body.URL == "") {
+		writeErr(w, http.StatusBadRequest, "invalid_mcp_server", "exactly one of command or url is required", nil)
+		return
+	}
+	if body.TimeoutSeconds < 0 {
+		writeErr(w, http.StatusBadRequest, "invalid_mcp_server", "timeout_seconds must be >= 0", nil)
+		return
+	}
+	// Secrets are returned masked; keep the stored ones when they come back
+	// that way or when env/headers are omitted.
+	s.store.Read(func(st *repo.State) {
+		if current, ok := st.MCPServers[name]; ok {
+			body.Env = mergeSecretStrings(body.Env, current.Env)
+			body.Headers = mergeSecretStrings(body.Headers, current.Headers)
+		}
+	})
+
+	var client *plugin.MCPClient
+	if mcpServerEnabled(body) {
+		var envs map[string]string
+		s.store.Read(func(st *repo.State) {
+			envs = storedEnvs(st)
+		})
+		connected, err := plugin.ConnectMCPServer(r.Context(), mcpClientConfig(name, body, envs))
+		if err != nil {
+			if errors.Is(err, plugin.ErrMCPServerConfigInvalid) {
+				writeErr(w, http.StatusBadRequest, "invalid_mcp_server", err.Error(), nil)
+				return
+			}
+			writeErr(w, http.StatusBadGateway, "mcp_server_unavailable", err.Error(), nil)
+			return
+		}
+		client = connected
+	}
+	if err := s.store.Write(func(st *repo.State) error {
+		if st.MCPServers == nil {
+			st.MCPServers = map[string]domain.MCPServerConfig{}
+		}
+		st.MCPServers[name] = body
+		return nil
+	}); err != nil {
+		if client != nil {
+			client.Close()
+		}
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+		return
+	}
+	s.setMCPServer(name, client, nil)
+	writeJSON(w, http.StatusOK, s.mcpServerInfo(name, body))
+}
+
+func (s *Server) deleteMCPServer(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "server_name")))
+	found := false
+	if err := s.store.Write(func(st *repo.State) error {
+		if _, found = st.MCPServers[name]; found {
+			delete(st.MCPServers, name)
+		}
+		return nil
+	}); err != nil {
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+		return
+	}
+	if !found {
+		writeErr(w, http.StatusNotFound, "not_found", "mcp server not found", nil)
+		return
+	}
+	s.setMCPServer(name, nil, nil)
+	writeJSON(w, http.StatusOK, map[string]bool{"deleted": true})
+}
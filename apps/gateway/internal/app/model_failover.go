@@ -0,0 +1,143 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/provider"
+	"nextai/apps/gateway/internal/repo"
+	"nextai/apps/gateway/internal/runner"
+)
+
+func (s *Server) setFallbackModels(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		FallbackLLMs []domain.ModelSlotConfig `json:"fallback_llms"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_json", "invalid request body", nil)
+		return
+	}
+	var out domain.ActiveModelsInfo
+	var failedSlot domain.ModelSlotConfig
+	if err := s.store.Write(func(st *repo.State) error {
+		slots := make([]domain.ModelSlotConfig, 0, len(body.FallbackLLMs))
+		seen := map[domain.ModelSlotConfig]struct{}{}
+		for _, item := range body.FallbackLLMs {
+			item.ProviderID = normalizeProviderID(item.ProviderID)
+			item.Model = strings.TrimSpace(item.Model)
+			failedSlot = item
+			if item.ProviderID == "" || item.Model == "" {
+				return errors.New("invalid_model_slot")
+			}
+			setting, ok := findProviderSettingByID(st, item.ProviderID)
+			if !ok {
+				return errors.New("provider_not_found")
+			}
+			normalizeProviderSetting(&setting)
+			resolvedModel, ok := provider.ResolveModelID(item.ProviderID, item.Model, setting.ModelAliases)
+			if !ok {
+				return errors.New("model_not_found")
+			}
+			slot := domain.ModelSlotConfig{ProviderID: item.ProviderID, Model: resolvedModel}
+			if _, exists := seen[slot]; exists {
+				continue
+			}
+			seen[slot] = struct{}{}
+			slots = append(slots, slot)
+		}
+		st.FallbackLLMs = slots
+		out = domain.ActiveModelsInfo{ActiveLLM: st.ActiveLLM, FallbackLLMs: cloneModelSlots(slots)}
+		return nil
+	}); err != nil {
+		details := map[string]string{"provider_id": failedSlot.ProviderID, "model": failedSlot.Model}
+		switch err.Error() {
+		case "invalid_model_slot":
+			writeErr(w, http.StatusBadRequest, "invalid_model_slot", "provider_id and model are required", details)
+			return
+		case "provider_not_found":
+			writeErr(w, http.StatusNotFound, "provider_not_found", "provider not found", details)
+			return
+		case "model_not_found":
+			writeErr(w, http.StatusBadRequest, "model_not_found", "model not found for provider", details)
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func resolveFallbackGenerateConfigs(st *repo.State) []runner.GenerateConfig {
+	out := make([]runner.GenerateConfig, 0, len(st.FallbackLLMs))
+	for _, slot := range st.FallbackLLMs {
+		providerID := normalizeProviderID(slot.ProviderID)
+		setting, ok := findProviderSettingByID(st, providerID)
+		if !ok {
+			continue
+		}
+		normalizeProviderSetting(&setting)
+		if !providerEnabled(setting) {
+			continue
+		}
+		model, ok := provider.ResolveModelID(providerID, slot.Model, setting.ModelAliases)
+		if !ok {
+			continue
+		}
+		out = append(out, runner.GenerateConfig{
+			ProviderID: providerID,
+			Model:      model,
+			APIKey:     resolveProviderAPIKey(providerID, setting, storedEnvs(st)),
+			BaseURL:    resolveProviderBaseURL(providerID, setting),
+			AdapterID:  provider.ResolveAdapter(providerID),
+			Headers:    sanitizeStringMap(setting.Headers),
+			TimeoutMS:  setting.TimeoutMS,
+		})
+	}
+	return out
+}
+
+// buildProviderFailoverEvent reports the slots that failed before the turn
+// ended. failed marks a turn where no slot produced an answer, in which case
+// the slot fields name the last slot tried.
+func buildProviderFailoverEvent(step int, result runner.FailoverResult, failed bool) domain.AgentEvent {
+	meta := map[string]interface{}{
+		"slot":        result.SlotIndex,
+		"provider_id": result.ProviderID,
+		"model":       result.Model,
+		"attempts":    providerFailoverAttempts(result),
+	}
+	if failed {
+		meta["failed"] = true
+	}
+	return domain.AgentEvent{
+		Type: "provider_failover",
+		Step: step,
+		Meta: meta,
+	}
+}
+
+func providerFailoverAttempts(result runner.FailoverResult) []map[string]interface{} {
+	attempts := make([]map[string]interface{}, 0, len(result.Attempts))
+	for _, attempt := range result.Attempts {
+		item := map[string]interface{}{
+			"provider_id": attempt.ProviderID,
+			"model":       attempt.Model,
+		}
+		if attempt.Err != nil {
+			_, code, message := mapRunnerError(attempt.Err)
+			item["code"] = code
+			item["message"] = message
+		}
+		attempts = append(attempts, item)
+	}
+	return attempts
+}
+
+func cloneModelSlots(in []domain.ModelSlotConfig) []domain.ModelSlotConfig {
+	out := make([]domain.ModelSlotConfig, len(in))
+	copy(out, in)
+	return out
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"nextai/apps/gateway/internal/repo"
+)
+
+// envReferencePattern matches a value that is only a variable reference,
+// $NAME or ${NAME}.
+var envReferencePattern = regexp.MustCompile(`^\$(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))$`)
+
+// storedEnvs copies the variables saved through /envs so they can be used
+// outside the store lock.
+func storedEnvs(st *repo.State) map[string]string {
+	out := make(map[string]string, len(st.Envs))
+	for key, value := range st.Envs {
+		if key = strings.TrimSpace(key); key != "" {
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// lookupRuntimeEnv resolves a variable for tools and providers. A stored
+// variable wins over the process environment, which already includes the
+// .env file.
+func lookupRuntimeEnv(envs map[string]string, name string) string {
+	if value, ok := envs[name]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// expandEnvReference replaces a $NAME or ${NAME} value with the stored
+// variable it names. References never reach the process environment, so a
+// config writer cannot read the gateway's own secrets through them. Any other
+// value is returned unchanged.
+func expandEnvReference(envs map[string]string, value string) string {
+	match := envReferencePattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return value
+	}
+	name := match[1]
+	if name == "" {
+		name = match[2]
+	}
+	return envs[name]
+}
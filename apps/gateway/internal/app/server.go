@@ -58,13 +58,15 @@ const (
 
 	cronWorkflowNodeExecutionSkipped = "skipped"
 
-	cronLeaseDirName = "cron-leases"
+	cronLeaseDirName   = "cron-leases"
+	editJournalDirName = "edit-journal"
 
 	aiToolsGuideRelativePath         = "docs/AI/AGENTS.md"
 	aiToolsGuideLegacyRelativePath   = "docs/AI/ai-tools.md"
 	aiToolsGuideLegacyV0RelativePath = "docs/ai-tools.md"
 	aiToolsGuidePathEnv              = "NEXTAI_AI_TOOLS_GUIDE_PATH"
 	disabledToolsEnv                 = "NEXTAI_DISABLED_TOOLS"
+	toolApprovalEnv                  = "NEXTAI_TOOL_APPROVAL"
 	enableBrowserToolEnv             = "NEXTAI_ENABLE_BROWSER_TOOL"
 	browserToolAgentDirEnv           = "NEXTAI_BROWSER_AGENT_DIR"
 	enableSearchToolEnv              = "NEXTAI_ENABLE_SEARCH_TOOL"
@@ -109,16 +111,29 @@ type Server struct {
 	store    *repo.Store
 	runner   *runner.Runner
 	channels map[string]plugin.ChannelPlugin
-	tools    map[string]plugin.ToolPlugin
+	tools    map[string]plugin.ToolPluginV2
+	toolsMu  sync.RWMutex
 
 	disabledTools map[string]struct{}
+	approvalTools map[string]struct{}
 	qqInboundMu   sync.RWMutex
 	qqInbound     qqInboundRuntimeState
+	agentRunsMu   sync.RWMutex
+	agentRuns     map[string]*agentRun
+	fileJournal   *plugin.FileJournal
+	mcpMu         sync.Mutex
+	mcpServers    map[string]*mcpServerRuntime
+	shellPolicy   plugin.ShellPolicy
+	skillToolsMu  sync.Mutex
+	skillTools    map[string]struct{}
 
 	cronStop chan struct{}
 	cronDone chan struct{}
 	cronWG   sync.WaitGroup
 
+	skillsStop chan struct{}
+	skillsDone chan struct{}
+
 	cronTaskExecutor func(context.Context, domain.CronJobSpec) error
 	closeOnce        sync.Once
 }
@@ -129,37 +144,69 @@ func NewServer(cfg config.Config) (*Server, error) {
 		return nil, err
 	}
 	srv := &Server{
-		cfg:      cfg,
-		store:    store,
-		runner:   runner.New(),
-		channels: map[string]plugin.ChannelPlugin{},
-		tools:    map[string]plugin.ToolPlugin{},
-		disabledTools: parseDisabledTools(
+		cfg:        cfg,
+		store:      store,
+		runner:     runner.New(),
+		channels:   map[string]plugin.ChannelPlugin{},
+		tools:      map[string]plugin.ToolPluginV2{},
+		agentRuns:  map[string]*agentRun{},
+		mcpServers: map[string]*mcpServerRuntime{},
+		skillTools: map[string]struct{}{},
+		disabledTools: parseToolNameSet(
 			os.Getenv(disabledToolsEnv),
 		),
-		cronStop: make(chan struct{}),
-		cronDone: make(chan struct{}),
+		approvalTools: parseToolNameSet(
+			os.Getenv(toolApprovalEnv),
+		),
+		cronStop:   make(chan struct{}),
+		cronDone:   make(chan struct{}),
+		skillsStop: make(chan struct{}),
+		skillsDone: make(chan struct{}),
 	}
 	srv.registerChannelPlugin(channel.NewConsoleChannel())
 	srv.registerChannelPlugin(channel.NewWebhookChannel())
 	srv.registerChannelPlugin(channel.NewQQChannel())
-	srv.registerToolPlugin(plugin.NewShellTool())
-	srv.registerToolPlugin(plugin.NewViewFileLinesTool(""))
-	srv.registerToolPlugin(plugin.NewEditFileLinesTool(""))
-	if parseBool(os.Getenv(enableBrowserToolEnv)) {
-		browserTool, toolErr := plugin.NewBrowserTool(strings.TrimSpace(os.Getenv(browserToolAgentDirEnv)))
-		if toolErr != nil {
-			return nil, fmt.Errorf("init browser tool failed: %w", toolErr)
-		}
-		srv.registerToolPlugin(browserTool)
+	shellPolicy, err := plugin.ShellPolicyFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("init shell tool failed: %w", err)
 	}
-	if parseBool(os.Getenv(enableSearchToolEnv)) {
-		searchTool, toolErr := plugin.NewSearchToolFromEnv()
-		if toolErr != nil {
-			return nil, fmt.Errorf("init search tool failed: %w", toolErr)
-		}
-		srv.registerToolPlugin(searchTool)
+	srv.shellPolicy = shellPolicy
+	srv.registerToolPlugin(plugin.NewShellToolWithPolicy(shellPolicy))
+	srv.registerToolPlugin(plugin.NewShellSessionTool(shellPolicy, cfg.ShellSessionIdle))
+	filePolicy, err := plugin.FilePolicyFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("init file tools failed: %w", err)
+	}
+	// The data directory holds the state file, the edit journal and the skill
+	// packages; letting the file tools write there would let the model edit
+	// its own configuration.
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("init file tools failed: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(dataDir); err == nil {
+		dataDir = resolved
+	}
+	filePolicy.DeniedRoots = append(filePolicy.DeniedRoots, dataDir)
+	srv.fileJournal = plugin.NewFileJournal(filepath.Join(cfg.DataDir, editJournalDirName))
+	if err := srv.fileJournal.Reset(); err != nil {
+		return nil, fmt.Errorf("init edit journal failed: %w", err)
+	}
+	srv.registerToolPlugin(plugin.NewViewFileLinesToolWithPolicy(filePolicy))
+	srv.registerToolPlugin(plugin.NewEditFileLinesToolWithPolicy(filePolicy, srv.fileJournal))
+	srv.registerToolPlugin(plugin.NewWriteFileTool(filePolicy, srv.fileJournal))
+	srv.registerToolPlugin(plugin.NewGlobFileTool(filePolicy))
+	srv.registerToolPlugin(plugin.NewGrepFileTool(filePolicy))
+	srv.registerToolPlugin(plugin.NewPatchFileTool(filePolicy, srv.fileJournal))
+	srv.registerToolPlugin(newLoadSkillTool(store))
+	if err := srv.initConfigurableTools(); err != nil {
+		return nil, err
 	}
+	srv.initMCPServers()
+	if err := srv.syncSkillDirs(); err != nil {
+		return nil, fmt.Errorf("load skill directories failed: %w", err)
+	}
+	srv.startSkillWatcher()
 	srv.startCronScheduler()
 	if !parseBool(os.Getenv(disableQQInboundSupervisorEnv)) {
 		srv.startQQInboundSupervisor()
@@ -172,6 +219,14 @@ func (s *Server) Close() {
 		close(s.cronStop)
 		<-s.cronDone
 		s.cronWG.Wait()
+		close(s.skillsStop)
+		<-s.skillsDone
+		s.closeMCPServers()
+		for _, tool := range s.toolPlugins() {
+			if closer, ok := tool.(interface{ Close() }); ok {
+				closer.Close()
+			}
+		}
 	})
 }
 
@@ -194,10 +249,22 @@ func (s *Server) registerToolPlugin(tp plugin.ToolPlugin) {
 	if name == "" {
 		return
 	}
-	s.tools[name] = tp
+	s.toolsMu.Lock()
+	old, replaced := s.tools[name]
+	s.tools[name] = plugin.AdaptToolPlugin(tp)
+	s.toolsMu.Unlock()
+	if closer, ok := old.(interface{ Close() }); replaced && ok {
+		closer.Close()
+	}
 }
 
-func parseDisabledTools(raw string) map[string]struct{} {
+func (s *Server) unregisterToolPlugin(name string) {
+	s.toolsMu.Lock()
+	delete(s.tools, strings.ToLower(strings.TrimSpace(name)))
+	s.toolsMu.Unlock()
+}
+
+func parseToolNameSet(raw string) map[string]struct{} {
 	out := map[string]struct{}{}
 	for _, part := range strings.Split(raw, ",") {
 		name := strings.ToLower(strings.TrimSpace(part))
@@ -209,14 +276,27 @@ func parseDisabledTools(raw string) map[string]struct{} {
 	return out
 }
 
+// toolDisabled applies the enabled flag stored through PUT /config/tools/{name}
+// and falls back to NEXTAI_DISABLED_TOOLS. Skill scripts are also disabled
+// whenever shell is.
 func (s *Server) toolDisabled(name string) bool {
 	if s == nil {
 		return false
 	}
-	if len(s.disabledTools) == 0 {
-		return false
+	name = strings.ToLower(strings.TrimSpace(name))
+	if strings.HasPrefix(name, skillToolPrefix) && s.toolDisabled(skillScriptGateTool) {
+		return true
 	}
-	_, ok := s.disabledTools[strings.ToLower(strings.TrimSpace(name))]
+	if s.store != nil {
+		var stored *bool
+		s.store.Read(func(st *repo.State) {
+			stored = st.Tools[name].Enabled
+		})
+		if stored != nil {
+			return !*stored
+		}
+	}
+	_, ok := s.disabledTools[name]
 	return ok
 }
 
@@ -243,6 +323,12 @@ func (s *Server) Handler() http.Handler {
 		})
 
 		api.Post("/agent/process", s.processAgent)
+		api.Get("/agent/runs", s.listAgentRuns)
+		api.Get("/agent/runs/{run_id}", s.getAgentRun)
+		api.Post("/agent/runs/{run_id}/cancel", s.cancelAgentRun)
+		api.Post("/agent/runs/{run_id}/revert", s.revertAgentRun)
+		api.Post("/agent/runs/{run_id}/approvals/{call_id}", s.decideToolApproval)
+		api.Get("/tools", s.listTools)
 		api.Post("/channels/qq/inbound", s.processQQInbound)
 		api.Get("/channels/qq/state", s.getQQInboundState)
 
@@ -262,11 +348,15 @@ func (s *Server) Handler() http.Handler {
 			r.Get("/", s.listProviders)
 			r.Get("/catalog", s.getModelCatalog)
 			r.Put("/{provider_id}/config", s.configureProvider)
+			r.Post("/{provider_id}/refresh", s.refreshProviderModels)
 			r.Delete("/{provider_id}", s.deleteProvider)
 			r.Get("/active", s.getActiveModels)
 			r.Put("/active", s.setActiveModels)
+			r.Put("/active/fallbacks", s.setFallbackModels)
 		})
 
+		api.Get("/usage", s.getUsage)
+
 		api.Route("/envs", func(r chi.Router) {
 			r.Get("/", s.listEnvs)
 			r.Put("/", s.putEnvs)
@@ -279,9 +369,13 @@ func (s *Server) Handler() http.Handler {
 			r.Post("/batch-disable", s.batchDisableSkills)
 			r.Post("/batch-enable", s.batchEnableSkills)
 			r.Post("/", s.createSkill)
+			r.Post("/import", s.importSkillArchive)
 			r.Post("/{skill_name}/disable", s.disableSkill)
 			r.Post("/{skill_name}/enable", s.enableSkill)
 			r.Delete("/{skill_name}", s.deleteSkill)
+			r.Get("/{skill_name}/export", s.exportSkillArchive)
+			r.Get("/{skill_name}/versions", s.listSkillVersions)
+			r.Post("/{skill_name}/rollback", s.rollbackSkill)
 			r.Get("/{skill_name}/files/{source}/{file_path}", s.loadSkillFile)
 		})
 
@@ -300,6 +394,13 @@ func (s *Server) Handler() http.Handler {
 			r.Put("/channels", s.putChannels)
 			r.Get("/channels/{channel_name}", s.getChannel)
 			r.Put("/channels/{channel_name}", s.putChannel)
+			r.Get("/tools", s.listToolConfigs)
+			r.Get("/tools/{tool_name}", s.getToolConfig)
+			r.Put("/tools/{tool_name}", s.putToolConfig)
+			r.Get("/mcp-servers", s.listMCPServers)
+			r.Get("/mcp-servers/{server_name}", s.getMCPServer)
+			r.Put("/mcp-servers/{server_name}", s.putMCPServer)
+			r.Delete("/mcp-servers/{server_name}", s.deleteMCPServer)
 		})
 	})
 
@@ -744,7 +845,12 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 	chatID := ""
 	activeLLM := domain.ModelSlotConfig{}
 	providerSetting := repo.ProviderSetting{}
+	fallbackConfigs := []runner.GenerateConfig{}
+	var runtimeEnvs map[string]string
 	historyInput := []domain.AgentInputMessage{}
+	approvalPolicy := toolApprovalPolicy{}
+	var allowedTools toolAllowlist
+	var skills []domain.SkillSpec
 	if err := s.store.Write(func(state *repo.State) error {
 		for id, c := range state.Chats {
 			if c.SessionID == req.SessionID && c.UserID == req.UserID && c.Channel == req.Channel {
@@ -779,9 +885,14 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 			})
 		}
 		historyInput = runtimeHistoryToAgentInputMessages(state.Histories[chatID])
+		approvalPolicy = s.resolveToolApprovalPolicy(state.Chats[chatID].Meta)
+		allowedTools = resolveToolAllowlist(state.Chats[chatID].Meta)
+		skills = enabledSkills(state)
 		activeLLM = state.ActiveLLM
 		activeLLM.ProviderID = normalizeProviderID(activeLLM.ProviderID)
 		providerSetting = getProviderSettingByID(state, activeLLM.ProviderID)
+		fallbackConfigs = resolveFallbackGenerateConfigs(state)
+		runtimeEnvs = storedEnvs(state)
 		return nil
 	}); err != nil {
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
@@ -792,6 +903,16 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 		writeErr(w, http.StatusBadRequest, "invalid_tool_input", err.Error(), nil)
 		return
 	}
+	budget, err := s.resolveAgentBudget(req.BizParams)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_budget", err.Error(), nil)
+		return
+	}
+	toolWorkers, err := s.resolveToolWorkers(req.BizParams)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	}
 
 	streaming := req.Stream
 	var flusher http.Flusher
@@ -809,7 +930,32 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 		}
 	}
 
+	budgetCtx, runClock, cancelBudget := budget.withDeadline(r.Context())
+	defer cancelBudget()
+	runCtx, runID := s.startAgentRun(budgetCtx, domain.AgentRunInfo{
+		ChatID:    chatID,
+		SessionID: req.SessionID,
+		UserID:    req.UserID,
+		Channel:   req.Channel,
+	})
+	toolInvocation := plugin.ToolInvocation{
+		ChatID:    chatID,
+		UserID:    req.UserID,
+		SessionID: req.SessionID,
+		Channel:   req.Channel,
+		RunID:     runID,
+		Env:       runtimeEnvs,
+	}
+	runStatus, runMessage := agentRunStatusCompleted, ""
+	defer func() {
+		if agentRunCancelled(runCtx) {
+			runStatus = agentRunStatusCancelled
+		}
+		s.finishAgentRun(runID, runStatus, runMessage)
+	}()
+
 	streamFail := func(status int, code, message string, details interface{}) {
+		runStatus, runMessage = agentRunStatusFailed, message
 		if !streaming || !streamStarted {
 			writeErr(w, status, code, message, details)
 			return
@@ -832,8 +978,26 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 	}
 
 	reply := ""
+	var contextCompaction *runner.ContextCompaction
+	usageTurns := make([]turnUsage, 0, 1)
+	answeredTurns := make([]turnUsage, 0, 1)
+	// Runs that fail, are cancelled or run out of budget still spent tokens;
+	// record them on every exit path. The success path records them with the
+	// reply and clears usageTurns.
+	defer func() {
+		if len(usageTurns) == 0 {
+			return
+		}
+		_ = s.store.Write(func(state *repo.State) error {
+			recordChatUsage(state, chatID, req.UserID, usageTurns)
+			return nil
+		})
+	}()
 	events := make([]domain.AgentEvent, 0, 12)
+	var eventsMu sync.Mutex
 	appendEvent := func(evt domain.AgentEvent) {
+		eventsMu.Lock()
+		defer eventsMu.Unlock()
 		events = append(events, evt)
 		if !streaming {
 			return
@@ -843,6 +1007,34 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 		flusher.Flush()
 		streamStarted = true
 	}
+	startStep := func(step int) {
+		s.updateAgentRunStep(runID, step)
+		appendEvent(domain.AgentEvent{Type: "step_started", Step: step, Meta: map[string]interface{}{"run_id": runID}})
+	}
+	failCancelled := func() {
+		streamFail(http.StatusConflict, "agent_run_cancelled", "agent run was cancelled", map[string]string{"run_id": runID})
+	}
+	failBudget := func(step int, reason string, limit, used interface{}) {
+		evt := buildAgentBudgetEvent(step, reason, limit, used)
+		message, _ := evt.Meta["message"].(string)
+		runStatus, runMessage = agentRunStatusFailed, message
+		if !streaming || !streamStarted {
+			writeErr(w, http.StatusUnprocessableEntity, "agent_budget_exhausted", message, map[string]interface{}{
+				"reason": reason,
+				"limit":  limit,
+				"used":   used,
+				"run_id": runID,
+			})
+			return
+		}
+		appendEvent(evt)
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}
+	failDeadline := func(step int) {
+		failBudget(step, agentBudgetReasonDuration, int(budget.MaxDuration/time.Second), int(runClock.elapsed()/time.Second))
+	}
+	toolCallCount := 0
 	replyChunkSize := replyChunkSizeDefault
 	appendReplyDeltas := func(step int, text string) {
 		for _, chunk := range splitReplyChunks(text, replyChunkSize) {
@@ -856,7 +1048,7 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 
 	if hasToolCall {
 		step := 1
-		appendEvent(domain.AgentEvent{Type: "step_started", Step: step})
+		startStep(step)
 		appendEvent(domain.AgentEvent{
 			Type: "tool_call",
 			Step: step,
@@ -865,8 +1057,18 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 				Input: safeMap(requestedToolCall.Input),
 			},
 		})
-		reply, err = s.executeToolCall(requestedToolCall)
+		outputStream := newToolOutputStream(step, appendEvent)
+		reply, err = s.executeToolCall(runCtx, outputStream.attach(0, toolInvocation, requestedToolCall.Name), requestedToolCall)
+		outputStream.close()
 		if err != nil {
+			if agentRunCancelled(runCtx) {
+				failCancelled()
+				return
+			}
+			if agentRunDeadlineExceeded(runCtx) {
+				failDeadline(step)
+				return
+			}
 			status, code, message := mapToolError(err)
 			streamFail(status, code, message, nil)
 			return
@@ -877,7 +1079,7 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 			ToolResult: &domain.AgentToolResultPayload{
 				Name:    requestedToolCall.Name,
 				OK:      true,
-				Summary: summarizeAgentEventText(reply),
+				Summary: summarizeToolResult(reply, outputStream.summary(0)),
 			},
 		})
 		appendReplyDeltas(step, reply)
@@ -904,31 +1106,53 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 			generateConfig = runner.GenerateConfig{
 				ProviderID: activeLLM.ProviderID,
 				Model:      activeLLM.Model,
-				APIKey:     resolveProviderAPIKey(activeLLM.ProviderID, providerSetting),
+				APIKey:     resolveProviderAPIKey(activeLLM.ProviderID, providerSetting, runtimeEnvs),
 				BaseURL:    resolveProviderBaseURL(activeLLM.ProviderID, providerSetting),
 				AdapterID:  provider.ResolveAdapter(activeLLM.ProviderID),
 				Headers:    sanitizeStringMap(providerSetting.Headers),
 				TimeoutMS:  providerSetting.TimeoutMS,
 			}
 		}
+		generateConfigs := []runner.GenerateConfig{generateConfig}
+		if generateConfig.AdapterID != provider.AdapterDemo {
+			generateConfigs = append(generateConfigs, fallbackConfigs...)
+		}
 
 		effectiveReq := req
+		systemPrompt := appendSkillIndex(aiToolsGuide, skills)
 		if len(historyInput) > 0 {
-			effectiveReq.Input = prependAIToolsGuide(historyInput, aiToolsGuide)
+			effectiveReq.Input = prependAIToolsGuide(historyInput, systemPrompt)
 		} else {
-			effectiveReq.Input = prependAIToolsGuide(req.Input, aiToolsGuide)
+			effectiveReq.Input = prependAIToolsGuide(req.Input, systemPrompt)
 		}
 		workflowInput := cloneAgentInputMessages(effectiveReq.Input)
+		contextWindow := resolveContextWindow(generateConfigs)
 		step := 1
 
 		for {
-			appendEvent(domain.AgentEvent{Type: "step_started", Step: step})
+			if agentRunCancelled(runCtx) {
+				failCancelled()
+				return
+			}
+			if agentRunDeadlineExceeded(runCtx) {
+				failDeadline(step)
+				return
+			}
+			if budget.MaxSteps > 0 && step > budget.MaxSteps {
+				failBudget(step-1, agentBudgetReasonSteps, budget.MaxSteps, step-1)
+				return
+			}
+			startStep(step)
 			turnReq := effectiveReq
-			turnReq.Input = workflowInput
+			fittedInput, compaction := runner.FitContextWindow(workflowInput, contextWindow)
+			if compaction.Applied() {
+				contextCompaction = &compaction
+			}
+			turnReq.Input = fittedInput
 			stepHadStreamingDelta := false
-			turn, runErr := runner.TurnResult{}, error(nil)
+			var onDelta func(string)
 			if streaming {
-				turn, runErr = s.runner.GenerateTurnStream(r.Context(), turnReq, generateConfig, s.listToolDefinitions(), func(delta string) {
+				onDelta = func(delta string) {
 					if delta == "" {
 						return
 					}
@@ -938,9 +1162,26 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 						Step:  step,
 						Delta: delta,
 					})
-				})
-			} else {
-				turn, runErr = s.runner.GenerateTurn(r.Context(), turnReq, generateConfig, s.listToolDefinitions())
+				}
+			}
+			turn, failover, runErr := s.runner.GenerateTurnWithFailover(runCtx, turnReq, generateConfigs, s.listToolDefinitions(allowedTools), onDelta)
+			if len(failover.Attempts) > 0 {
+				appendEvent(buildProviderFailoverEvent(step, failover, runErr != nil))
+			}
+			if runErr == nil {
+				answered := turnUsage{Step: step, Slot: failover.SlotIndex, ProviderID: failover.ProviderID, Model: failover.Model, Usage: turn.Usage}
+				answeredTurns = append(answeredTurns, answered)
+				if turn.Usage.TotalTokens > 0 {
+					usageTurns = append(usageTurns, answered)
+				}
+			}
+			if runErr != nil && agentRunCancelled(runCtx) {
+				failCancelled()
+				return
+			}
+			if runErr != nil && agentRunDeadlineExceeded(runCtx) {
+				failDeadline(step)
+				return
 			}
 			if runErr != nil {
 				if recoveredCall, recovered := recoverInvalidProviderToolCall(runErr, step); recovered {
@@ -993,7 +1234,11 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 					continue
 				}
 				status, code, message := mapRunnerError(runErr)
-				streamFail(status, code, message, nil)
+				var details interface{}
+				if len(failover.Attempts) > 0 {
+					details = map[string]interface{}{"attempts": providerFailoverAttempts(failover)}
+				}
+				streamFail(status, code, message, details)
 				return
 			}
 			if len(turn.ToolCalls) == 0 {
@@ -1004,7 +1249,9 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 				if !streaming || !stepHadStreamingDelta {
 					appendReplyDeltas(step, reply)
 				}
-				appendEvent(domain.AgentEvent{Type: "completed", Step: step, Reply: reply})
+				completed := domain.AgentEvent{Type: "completed", Step: step, Reply: reply}
+				completed.Meta = completedTurnsMeta(answeredTurns)
+				appendEvent(completed)
 				break
 			}
 
@@ -1019,57 +1266,108 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 			}
 			workflowInput = append(workflowInput, assistantMessage)
 
-			for _, call := range turn.ToolCalls {
+			calls := s.resolveModelToolCalls(turn.ToolCalls)
+			budgetHit := false
+			if budget.MaxToolCalls > 0 && toolCallCount+len(calls) > budget.MaxToolCalls {
+				calls = calls[:budget.MaxToolCalls-toolCallCount]
+				budgetHit = true
+			}
+			toolCallCount += len(calls)
+			appendToolCallEvent := func(call runner.ToolCall) {
 				appendEvent(domain.AgentEvent{
 					Type: "tool_call",
 					Step: step,
 					ToolCall: &domain.AgentToolCallPayload{
+						ID:    call.ID,
 						Name:  call.Name,
 						Input: safeMap(call.Arguments),
 					},
 				})
-				toolReply, toolErr := s.executeToolCall(toolCall{Name: call.Name, Input: safeMap(call.Arguments)})
-				if toolErr != nil {
-					toolReply = formatToolErrorFeedback(toolErr)
-					appendEvent(domain.AgentEvent{
-						Type: "tool_result",
-						Step: step,
-						ToolResult: &domain.AgentToolResultPayload{
-							Name:    call.Name,
-							OK:      false,
-							Summary: summarizeAgentEventText(toolReply),
-						},
-					})
-					workflowInput = append(workflowInput, domain.AgentInputMessage{
-						Role:    "tool",
-						Type:    "message",
-						Content: []domain.RuntimeContent{{Type: "text", Text: toolReply}},
-						Metadata: map[string]interface{}{
-							"tool_call_id": call.ID,
-							"name":         call.Name,
-						},
-					})
-					continue
+			}
+			outputStream := newToolOutputStream(step, appendEvent)
+			appendToolResultEvent := func(call runner.ToolCall, outcome toolCallOutcome, streamed string) {
+				summary := summarizeToolResult(outcome.Reply, streamed)
+				if outcome.Err != nil {
+					summary = summarizeAgentEventText(formatToolErrorFeedback(outcome.Err))
 				}
 				appendEvent(domain.AgentEvent{
 					Type: "tool_result",
 					Step: step,
 					ToolResult: &domain.AgentToolResultPayload{
+						ID:      call.ID,
 						Name:    call.Name,
-						OK:      true,
-						Summary: summarizeAgentEventText(toolReply),
+						OK:      outcome.Err == nil,
+						Summary: summary,
 					},
 				})
+			}
+			// Waiting on a human does not spend the run's duration budget.
+			runClock.pause()
+			denied, approvalErr := s.awaitToolApprovals(runCtx, runID, step, calls, approvalPolicy, appendEvent)
+			runClock.resume()
+			if approvalErr != nil {
+				if agentRunDeadlineExceeded(runCtx) {
+					failDeadline(step)
+				} else {
+					failCancelled()
+				}
+				return
+			}
+			outcomes := make([]toolCallOutcome, len(calls))
+			approved := make([]int, 0, len(calls))
+			for idx, call := range calls {
+				decision, ok := denied[idx]
+				if !ok {
+					approved = append(approved, idx)
+					continue
+				}
+				outcomes[idx] = toolCallOutcome{Err: deniedToolCallError(call.Name, decision)}
+				appendToolCallEvent(call)
+				appendToolResultEvent(call, outcomes[idx], "")
+			}
+			approvedCalls := make([]runner.ToolCall, 0, len(approved))
+			for _, idx := range approved {
+				approvedCalls = append(approvedCalls, calls[idx])
+			}
+			approvedOutcomes := s.executeToolCalls(runCtx, toolInvocation, approvedCalls, toolWorkers, outputStream, func(idx int) {
+				appendToolCallEvent(approvedCalls[idx])
+			}, func(idx int, outcome toolCallOutcome) {
+				if outcome.Err != nil && runCtx.Err() != nil {
+					return
+				}
+				appendToolResultEvent(approvedCalls[idx], outcome, outputStream.summary(idx))
+			})
+			outputStream.close()
+			for i, idx := range approved {
+				outcomes[idx] = approvedOutcomes[i]
+			}
+			if agentRunCancelled(runCtx) {
+				failCancelled()
+				return
+			}
+			if agentRunDeadlineExceeded(runCtx) {
+				failDeadline(step)
+				return
+			}
+			for idx, call := range calls {
+				toolReply := outcomes[idx].Reply
+				if outcomes[idx].Err != nil {
+					toolReply = formatToolErrorFeedback(outcomes[idx].Err)
+				}
 				workflowInput = append(workflowInput, domain.AgentInputMessage{
 					Role:    "tool",
 					Type:    "message",
 					Content: []domain.RuntimeContent{{Type: "text", Text: toolReply}},
 					Metadata: map[string]interface{}{
 						"tool_call_id": call.ID,
-						"name":         call.Name,
+						"name":         modelToolName(call.Name),
 					},
 				})
 			}
+			if budgetHit {
+				failBudget(step, agentBudgetReasonToolCalls, budget.MaxToolCalls, toolCallCount)
+				return
+			}
 			step++
 		}
 	}
@@ -1097,7 +1395,15 @@ func (s *Server) processAgentWithBody(w http.ResponseWriter, r *http.Request, bo
 				}
 			}
 		}
+		if contextCompaction != nil {
+			if chat.Meta == nil {
+				chat.Meta = map[string]interface{}{}
+			}
+			chat.Meta[domain.ChatMetaContextCompaction] = buildContextCompactionMeta(*contextCompaction, chat.UpdatedAt)
+		}
 		state.Chats[chatID] = chat
+		recordChatUsage(state, chatID, req.UserID, usageTurns)
+		usageTurns = nil
 		return nil
 	})
 
@@ -1533,223 +1839,68 @@ func splitReplyChunks(text string, chunkSize int) []string {
 	return out
 }
 
-func (s *Server) listToolDefinitions() []runner.ToolDefinition {
-	if len(s.tools) == 0 {
+func (s *Server) listToolDefinitions(allowlist toolAllowlist) []runner.ToolDefinition {
+	tools := s.toolPlugins()
+	if len(tools) == 0 {
 		return nil
 	}
-	names := make([]string, 0, len(s.tools))
-	for name := range s.tools {
-		if s.toolDisabled(name) {
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		if s.toolDisabled(name) || !allowlist.allows(name) {
+			continue
+		}
+		if name == loadSkillToolName && !s.hasEnabledSkills() {
 			continue
 		}
 		names = append(names, name)
 	}
 	sort.Strings(names)
 
+	byModelName := modelToolNames(names)
 	out := make([]runner.ToolDefinition, 0, len(names))
 	for _, name := range names {
-		out = append(out, buildToolDefinition(name))
+		modelName := modelToolName(name)
+		if byModelName[modelName] != name {
+			continue
+		}
+		def := buildToolDefinition(name, tools[name])
+		def.Name = modelName
+		out = append(out, def)
 	}
 	return out
 }
 
-func buildToolDefinition(name string) runner.ToolDefinition {
-	switch name {
-	case "view":
-		return runner.ToolDefinition{
-			Name:        "view",
-			Description: "Read line ranges for one or multiple files. input must be an array.",
-			Parameters: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"items": map[string]interface{}{
-						"type":        "array",
-						"description": "Array of view operations; pass one item for single-file view.",
-						"minItems":    1,
-						"items": map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"path": map[string]interface{}{
-									"type":        "string",
-									"description": "Absolute file path on local filesystem.",
-								},
-								"start": map[string]interface{}{
-									"type":        "integer",
-									"minimum":     1,
-									"description": "1-based starting line number (inclusive).",
-								},
-								"end": map[string]interface{}{
-									"type":        "integer",
-									"minimum":     1,
-									"description": "1-based ending line number (inclusive).",
-								},
-							},
-							"required":             []string{"path", "start", "end"},
-							"additionalProperties": false,
-						},
-					},
-				},
-				"required":             []string{"items"},
-				"additionalProperties": false,
-			},
-		}
-	case "edit":
-		return runner.ToolDefinition{
-			Name:        "edit",
-			Description: "Replace line ranges for one or multiple files. input must be an array.",
-			Parameters: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"items": map[string]interface{}{
-						"type":        "array",
-						"description": "Array of edit operations; pass one item for single-file edit.",
-						"minItems":    1,
-						"items": map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"path": map[string]interface{}{
-									"type":        "string",
-									"description": "Absolute file path on local filesystem.",
-								},
-								"start": map[string]interface{}{
-									"type":        "integer",
-									"minimum":     1,
-									"description": "1-based starting line number (inclusive).",
-								},
-								"end": map[string]interface{}{
-									"type":        "integer",
-									"minimum":     1,
-									"description": "1-based ending line number (inclusive).",
-								},
-								"content": map[string]interface{}{
-									"type":        "string",
-									"description": "Replacement text for the selected line range.",
-								},
-							},
-							"required":             []string{"path", "start", "end", "content"},
-							"additionalProperties": false,
-						},
-					},
-				},
-				"required":             []string{"items"},
-				"additionalProperties": false,
-			},
-		}
-	case "shell":
-		return runner.ToolDefinition{
-			Name:        "shell",
-			Description: "Execute one or multiple shell commands under server security controls. input must be an array.",
-			Parameters: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"items": map[string]interface{}{
-						"type":        "array",
-						"description": "Array of shell command operations; pass one item for single command.",
-						"minItems":    1,
-						"items": map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"command": map[string]interface{}{
-									"type": "string",
-								},
-								"cwd": map[string]interface{}{
-									"type": "string",
-								},
-								"timeout_seconds": map[string]interface{}{
-									"type":    "integer",
-									"minimum": 1,
-								},
-							},
-							"required":             []string{"command"},
-							"additionalProperties": false,
-						},
-					},
-				},
-				"required": []string{"items"},
-			},
-		}
-	case "browser":
-		return runner.ToolDefinition{
-			Name:        "browser",
-			Description: "Delegate browser tasks to local Playwright agent script. input must be an array.",
-			Parameters: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"items": map[string]interface{}{
-						"type":        "array",
-						"description": "Array of browser tasks; pass one item for single task.",
-						"minItems":    1,
-						"items": map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"task": map[string]interface{}{
-									"type":        "string",
-									"description": "Natural language task for browser agent.",
-								},
-								"timeout_seconds": map[string]interface{}{
-									"type":    "integer",
-									"minimum": 1,
-								},
-							},
-							"required":             []string{"task"},
-							"additionalProperties": false,
-						},
-					},
-				},
-				"required":             []string{"items"},
-				"additionalProperties": false,
-			},
-		}
-	case "search":
-		return runner.ToolDefinition{
-			Name:        "search",
-			Description: "Search the web via configured search APIs. input must be an array.",
-			Parameters: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"items": map[string]interface{}{
-						"type":        "array",
-						"description": "Array of search requests; pass one item for single query.",
-						"minItems":    1,
-						"items": map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"query": map[string]interface{}{
-									"type":        "string",
-									"description": "Search query text.",
-								},
-								"provider": map[string]interface{}{
-									"type":        "string",
-									"description": "Optional provider override: serpapi | tavily | brave.",
-								},
-								"count": map[string]interface{}{
-									"type":        "integer",
-									"minimum":     1,
-									"description": "Optional max results per query.",
-								},
-								"timeout_seconds": map[string]interface{}{
-									"type":        "integer",
-									"minimum":     1,
-									"description": "Optional timeout for a single query.",
-								},
-							},
-							"required":             []string{"query"},
-							"additionalProperties": false,
-						},
-					},
-				},
-				"required":             []string{"items"},
-				"additionalProperties": false,
-			},
-		}
-	default:
-		return runner.ToolDefinition{
-			Name: name,
-			Parameters: map[string]interface{}{
-				"type":                 "object",
-				"additionalProperties": true,
-			},
-		}
+func (s *Server) listTools(w http.ResponseWriter, _ *http.Request) {
+	tools := s.toolPlugins()
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]domain.ToolInfo, 0, len(names))
+	for _, name := range names {
+		def := buildToolDefinition(name, tools[name])
+		out = append(out, domain.ToolInfo{
+			Name:        name,
+			Description: def.Description,
+			Parameters:  def.Parameters,
+			Enabled:     !s.toolDisabled(name),
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func buildToolDefinition(name string, tp plugin.ToolPluginV2) runner.ToolDefinition {
+	if provider, ok := tp.(plugin.ToolSpecProvider); ok {
+		spec := provider.ToolSpec()
+		return runner.ToolDefinition{Name: name, Description: spec.Description, Parameters: spec.Parameters}
+	}
+	return runner.ToolDefinition{
+		Name: name,
+		Parameters: map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": true,
+		},
 	}
 }
 
@@ -1804,6 +1955,15 @@ func cloneAgentInputMessages(input []domain.AgentInputMessage) []domain.AgentInp
 	return out
 }
 
+// summarizeToolResult prefers the tail of the streamed output, which is what
+// the client saw last, over the head of the final reply.
+func summarizeToolResult(reply, streamed string) string {
+	if streamed != "" {
+		return streamed
+	}
+	return summarizeAgentEventText(reply)
+}
+
 func summarizeAgentEventText(text string) string {
 	trimmed := strings.TrimSpace(text)
 	if trimmed == "" {
@@ -1842,6 +2002,19 @@ func buildAssistantMessageMetadata(events []domain.AgentEvent) map[string]interf
 				continue
 			}
 			notices = append(notices, map[string]interface{}{"raw": string(raw)})
+		case "tool_result":
+			if evt.ToolResult == nil {
+				continue
+			}
+			raw, err := json.Marshal(domain.AgentEvent{
+				Type:       "tool_result",
+				Step:       evt.Step,
+				ToolResult: evt.ToolResult,
+			})
+			if err != nil {
+				continue
+			}
+			notices = append(notices, map[string]interface{}{"raw": string(raw)})
 		}
 	}
 	if len(notices) == 0 {
@@ -1850,6 +2023,9 @@ func buildAssistantMessageMetadata(events []domain.AgentEvent) map[string]interf
 	out := map[string]interface{}{
 		"tool_call_notices": notices,
 	}
+	if skills := skillsUsedFromEvents(events); len(skills) > 0 {
+		out[assistantMetaSkillsUsed] = skills
+	}
 	if textOrder > 0 {
 		out["text_order"] = textOrder
 	}
@@ -2016,7 +2192,7 @@ func parseShortcutToolCall(rawRequest map[string]interface{}) (toolCall, bool, e
 	if len(rawRequest) == 0 {
 		return toolCall{}, false, nil
 	}
-	shortcuts := []string{"view", "edit", "shell", "browser", "search"}
+	shortcuts := []string{"view", "edit", "write", "glob", "grep", "patch", "shell", "browser", "search"}
 	matched := make([]string, 0, 1)
 	for _, key := range shortcuts {
 		if raw, ok := rawRequest[key]; ok && raw != nil {
@@ -2060,6 +2236,14 @@ func normalizeToolName(name string) string {
 		return "view"
 	case "edit_file_lines", "edit_file_lins", "edit_file":
 		return "edit"
+	case "write_file", "create_file":
+		return "write"
+	case "list_files", "list_dir":
+		return "glob"
+	case "search_files":
+		return "grep"
+	case "apply_patch":
+		return "patch"
 	case "web_browser", "browser_use", "browser_tool":
 		return "browser"
 	case "web_search", "search_api", "search_tool":
@@ -2069,22 +2253,28 @@ func normalizeToolName(name string) string {
 	}
 }
 
-func (s *Server) executeToolCall(call toolCall) (string, error) {
-	if s.toolDisabled(call.Name) {
-		return "", &toolError{
-			Code:    "tool_disabled",
-			Message: fmt.Sprintf("tool %q is disabled by server config", call.Name),
-		}
+func (s *Server) executeToolCall(ctx context.Context, inv plugin.ToolInvocation, call toolCall) (string, error) {
+	if err := s.checkToolUsable(inv.ChatID, call.Name); err != nil {
+		return "", err
 	}
-	plug, ok := s.tools[call.Name]
+	plug, ok := s.toolPlugin(call.Name)
 	if !ok {
 		return "", &toolError{
 			Code:    "tool_not_supported",
 			Message: fmt.Sprintf("tool %q is not supported", call.Name),
 		}
 	}
+	if provider, ok := plug.(plugin.ToolSpecProvider); ok {
+		if err := plugin.ValidateToolInput(provider.ToolSpec().Parameters, call.Input); err != nil {
+			return "", &toolError{
+				Code:    "tool_input_invalid",
+				Message: fmt.Sprintf("tool %q input is invalid: %s", call.Name, strings.TrimPrefix(err.Error(), plugin.ErrToolInputInvalid.Error()+": ")),
+				Err:     err,
+			}
+		}
+	}
 
-	result, err := plug.Invoke(call.Input)
+	result, err := invokeToolPlugin(ctx, plug, inv, call.Input)
 	if err != nil {
 		return "", &toolError{
 			Code:    "tool_invoke_failed",
@@ -2108,6 +2298,32 @@ func (s *Server) executeToolCall(call toolCall) (string, error) {
 	return string(encoded), nil
 }
 
+// invokeToolPlugin passes ctx to the plugin and also stops waiting once ctx is
+// done, so a cancelled run does not block on a tool that ignores cancellation.
+// A panicking plugin fails the call instead of the gateway.
+func invokeToolPlugin(ctx context.Context, plug plugin.ToolPluginV2, inv plugin.ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	type invokeResult struct {
+		out map[string]interface{}
+		err error
+	}
+	done := make(chan invokeResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- invokeResult{err: fmt.Errorf("tool panicked: %v", r)}
+			}
+		}()
+		out, err := plug.InvokeContext(ctx, inv, input)
+		done <- invokeResult{out: out, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.out, res.err
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
 func (s *Server) resolveChannel(name string) (plugin.ChannelPlugin, map[string]interface{}, string, error) {
 	channelName := strings.ToLower(strings.TrimSpace(name))
 	if channelName == "" {
@@ -3367,10 +3583,15 @@ func (s *Server) getModelCatalog(w http.ResponseWriter, _ *http.Request) {
 			DisplayName: item.DisplayName,
 		})
 	}
+	var fallbackLLMs []domain.ModelSlotConfig
+	s.store.Read(func(st *repo.State) {
+		fallbackLLMs = cloneModelSlots(st.FallbackLLMs)
+	})
 	writeJSON(w, http.StatusOK, domain.ModelCatalogInfo{
 		Providers:     providers,
 		Defaults:      defaults,
 		ActiveLLM:     active,
+		FallbackLLMs:  fallbackLLMs,
 		ProviderTypes: typeOut,
 	})
 }
@@ -3430,7 +3651,59 @@ func (s *Server) configureProvider(w http.ResponseWriter, r *http.Request) {
 			setting.ModelAliases = sanitizedAliases
 		}
 		st.Providers[providerID] = setting
-		out = buildProviderInfo(providerID, setting)
+		out = buildProviderInfo(providerID, setting, storedEnvs(st))
+		return nil
+	}); err != nil {
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) refreshProviderModels(w http.ResponseWriter, r *http.Request) {
+	providerID := normalizeProviderID(chi.URLParam(r, "provider_id"))
+	if providerID == "" {
+		writeErr(w, http.StatusBadRequest, "invalid_provider_id", "provider_id is required", nil)
+		return
+	}
+	var setting repo.ProviderSetting
+	var envs map[string]string
+	found := false
+	s.store.Read(func(st *repo.State) {
+		setting, found = findProviderSettingByID(st, providerID)
+		envs = storedEnvs(st)
+	})
+	if !found {
+		writeErr(w, http.StatusNotFound, "not_found", "provider not found", map[string]string{"provider_id": providerID})
+		return
+	}
+	normalizeProviderSetting(&setting)
+
+	models, err := s.runner.ListModels(r.Context(), runner.GenerateConfig{
+		ProviderID: providerID,
+		APIKey:     resolveProviderAPIKey(providerID, setting, envs),
+		BaseURL:    resolveProviderBaseURL(providerID, setting),
+		AdapterID:  provider.ResolveAdapter(providerID),
+		Headers:    sanitizeStringMap(setting.Headers),
+		TimeoutMS:  setting.TimeoutMS,
+	})
+	if err != nil {
+		status, code, message := mapRunnerError(err)
+		writeErr(w, status, code, message, nil)
+		return
+	}
+	discovered := make([]string, 0, len(models))
+	for _, model := range models {
+		discovered = append(discovered, model.ID)
+	}
+
+	var out domain.ProviderInfo
+	if err := s.store.Write(func(st *repo.State) error {
+		current := getProviderSettingByID(st, providerID)
+		normalizeProviderSetting(&current)
+		current.DiscoveredModels = discovered
+		st.Providers[providerID] = current
+		out = buildProviderInfo(providerID, current, storedEnvs(st))
 		return nil
 	}); err != nil {
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
@@ -3457,6 +3730,15 @@ func (s *Server) deleteProvider(w http.ResponseWriter, r *http.Request) {
 		if deleted && normalizeProviderID(st.ActiveLLM.ProviderID) == providerID {
 			st.ActiveLLM = domain.ModelSlotConfig{}
 		}
+		if deleted {
+			fallbackLLMs := make([]domain.ModelSlotConfig, 0, len(st.FallbackLLMs))
+			for _, slot := range st.FallbackLLMs {
+				if normalizeProviderID(slot.ProviderID) != providerID {
+					fallbackLLMs = append(fallbackLLMs, slot)
+				}
+			}
+			st.FallbackLLMs = fallbackLLMs
+		}
 		return nil
 	}); err != nil {
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
@@ -3468,7 +3750,7 @@ func (s *Server) deleteProvider(w http.ResponseWriter, r *http.Request) {
 func (s *Server) getActiveModels(w http.ResponseWriter, _ *http.Request) {
 	var out domain.ActiveModelsInfo
 	s.store.Read(func(st *repo.State) {
-		out = domain.ActiveModelsInfo{ActiveLLM: st.ActiveLLM}
+		out = domain.ActiveModelsInfo{ActiveLLM: st.ActiveLLM, FallbackLLMs: cloneModelSlots(st.FallbackLLMs)}
 	})
 	writeJSON(w, http.StatusOK, out)
 }
@@ -3486,6 +3768,7 @@ func (s *Server) setActiveModels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var out domain.ModelSlotConfig
+	var fallbackLLMs []domain.ModelSlotConfig
 	if err := s.store.Write(func(st *repo.State) error {
 		setting, ok := findProviderSettingByID(st, body.ProviderID)
 		if !ok {
@@ -3504,6 +3787,7 @@ func (s *Server) setActiveModels(w http.ResponseWriter, r *http.Request) {
 			Model:      resolvedModel,
 		}
 		st.ActiveLLM = out
+		fallbackLLMs = cloneModelSlots(st.FallbackLLMs)
 		return nil
 	}); err != nil {
 		switch err.Error() {
@@ -3520,7 +3804,7 @@ func (s *Server) setActiveModels(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
 		return
 	}
-	writeJSON(w, http.StatusOK, domain.ActiveModelsInfo{ActiveLLM: out})
+	writeJSON(w, http.StatusOK, domain.ActiveModelsInfo{ActiveLLM: out, FallbackLLMs: fallbackLLMs})
 }
 
 func (s *Server) listEnvs(w http.ResponseWriter, _ *http.Request) {
@@ -3631,15 +3915,20 @@ func (s *Server) batchSetSkillEnabled(w http.ResponseWriter, r *http.Request, en
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
 		return
 	}
+	s.syncSkillTools()
 	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
 }
 
 func (s *Server) createSkill(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		Name       string                 `json:"name"`
-		Content    string                 `json:"content"`
-		References map[string]interface{} `json:"references"`
-		Scripts    map[string]interface{} `json:"scripts"`
+		Name        string                 `json:"name"`
+		Description string                 `json:"description"`
+		Triggers    []string               `json:"triggers"`
+		Version     string                 `json:"version"`
+		Content     string                 `json:"content"`
+		References  map[string]interface{} `json:"references"`
+		Scripts     map[string]interface{} `json:"scripts"`
+		Tools       []domain.SkillTool     `json:"tools"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeErr(w, http.StatusBadRequest, "invalid_json", "invalid request body", nil)
@@ -3649,24 +3938,40 @@ func (s *Server) createSkill(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusBadRequest, "invalid_skill", "name and content are required", nil)
 		return
 	}
+	name := strings.TrimSpace(body.Name)
+	spec := domain.SkillSpec{
+		Name:        name,
+		Description: strings.TrimSpace(body.Description),
+		Triggers:    body.Triggers,
+		Version:     strings.TrimSpace(body.Version),
+		Content:     body.Content,
+		Source:      skillSourceCustomized,
+		Path:        filepath.Join(s.cfg.DataDir, "skills", name),
+		References:  safeMap(body.References),
+		Scripts:     safeMap(body.Scripts),
+		Tools:       body.Tools,
+		Enabled:     true,
+	}
+	if spec.Version != "" && !validSemver(spec.Version) {
+		writeErr(w, http.StatusBadRequest, "invalid_skill", "version must be a semver version", nil)
+		return
+	}
+	tools, err := normalizeSkillTools(spec)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_skill", err.Error(), nil)
+		return
+	}
+	spec.Tools = tools
 	created := false
 	if err := s.store.Write(func(st *repo.State) error {
-		name := strings.TrimSpace(body.Name)
-		st.Skills[name] = domain.SkillSpec{
-			Name:       name,
-			Content:    body.Content,
-			Source:     "customized",
-			Path:       filepath.Join(s.cfg.DataDir, "skills", name),
-			References: safeMap(body.References),
-			Scripts:    safeMap(body.Scripts),
-			Enabled:    true,
-		}
+		st.Skills[name] = spec
 		created = true
 		return nil
 	}); err != nil {
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
 		return
 	}
+	s.syncSkillTools()
 	writeJSON(w, http.StatusOK, map[string]bool{"created": created})
 }
 
@@ -3697,6 +4002,7 @@ func (s *Server) setSkillEnabled(w http.ResponseWriter, name string, enabled boo
 		writeErr(w, http.StatusNotFound, "not_found", "skill not found", nil)
 		return
 	}
+	s.syncSkillTools()
 	key := "enabled"
 	if !enabled {
 		key = "disabled"
@@ -3707,16 +4013,34 @@ func (s *Server) setSkillEnabled(w http.ResponseWriter, name string, enabled boo
 func (s *Server) deleteSkill(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "skill_name")
 	deleted := false
+	onDisk := false
 	if err := s.store.Write(func(st *repo.State) error {
-		if _, ok := st.Skills[name]; ok {
-			delete(st.Skills, name)
-			deleted = true
+		spec, ok := st.Skills[name]
+		if !ok {
+			return nil
+		}
+		if skillLoadedFromDisk(spec) {
+			onDisk = true
+			return nil
 		}
+		delete(st.Skills, name)
+		deleted = true
 		return nil
 	}); err != nil {
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
 		return
 	}
+	if onDisk {
+		writeErr(w, http.StatusConflict, "skill_on_disk", "skill is loaded from a skill directory; remove the directory or disable the skill instead", nil)
+		return
+	}
+	if deleted {
+		// A deleted API skill may have been shadowing a skill directory.
+		if err := s.syncSkillDirs(); err != nil {
+			writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+			return
+		}
+	}
 	writeJSON(w, http.StatusOK, map[string]bool{"deleted": deleted})
 }
 
@@ -4000,19 +4324,31 @@ func (s *Server) putWorkspaceFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	source := strings.TrimSpace(body.Source)
-	if source == "" {
-		source = "customized"
-	}
+	// Disk ownership is decided by the scanner; anything written through the
+	// API is customized, whatever source the client sent.
 	spec := domain.SkillSpec{
-		Name:       name,
-		Content:    body.Content,
-		Source:     source,
-		Path:       filepath.Join(s.cfg.DataDir, "skills", name),
-		References: safeMap(body.References),
-		Scripts:    safeMap(body.Scripts),
-		Enabled:    body.Enabled,
+		Name:        name,
+		Description: body.Description,
+		Triggers:    body.Triggers,
+		Version:     strings.TrimSpace(body.Version),
+		Content:     body.Content,
+		Source:      skillSourceCustomized,
+		Path:        filepath.Join(s.cfg.DataDir, "skills", name),
+		References:  safeMap(body.References),
+		Scripts:     safeMap(body.Scripts),
+		Tools:       body.Tools,
+		Enabled:     body.Enabled,
+	}
+	if spec.Version != "" && !validSemver(spec.Version) {
+		writeErr(w, http.StatusBadRequest, "invalid_skill", "version must be a semver version", nil)
+		return
+	}
+	tools, err := normalizeSkillTools(spec)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_skill", err.Error(), nil)
+		return
 	}
+	spec.Tools = tools
 	if err := s.store.Write(func(st *repo.State) error {
 		st.Skills[name] = spec
 		return nil
@@ -4020,6 +4356,7 @@ func (s *Server) putWorkspaceFile(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
 		return
 	}
+	s.syncSkillTools()
 	writeJSON(w, http.StatusOK, map[string]bool{"updated": true})
 }
 
@@ -4050,6 +4387,7 @@ func (s *Server) deleteWorkspaceFile(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
 		return
 	}
+	s.syncSkillTools()
 	writeJSON(w, http.StatusOK, map[string]bool{"deleted": deleted})
 }
 
@@ -4124,6 +4462,10 @@ func (s *Server) importWorkspace(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
 		return
 	}
+	if err := s.syncSkillDirs(); err != nil {
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]bool{"imported": true})
 }
 
@@ -4351,19 +4693,28 @@ func normalizeWorkspaceSkills(in map[string]domain.SkillSpec, dataDir string) (m
 		if content == "" {
 			return nil, fmt.Errorf("skill %q content is required", name)
 		}
-		source := strings.TrimSpace(rawSpec.Source)
-		if source == "" {
-			source = "customized"
-		}
-		out[name] = domain.SkillSpec{
-			Name:       name,
-			Content:    rawSpec.Content,
-			Source:     source,
-			Path:       filepath.Join(dataDir, "skills", name),
-			References: safeMap(rawSpec.References),
-			Scripts:    safeMap(rawSpec.Scripts),
-			Enabled:    rawSpec.Enabled,
+		spec := domain.SkillSpec{
+			Name:        name,
+			Description: rawSpec.Description,
+			Triggers:    rawSpec.Triggers,
+			Version:     strings.TrimSpace(rawSpec.Version),
+			Content:     rawSpec.Content,
+			Source:      skillSourceCustomized,
+			Path:        filepath.Join(dataDir, "skills", name),
+			References:  safeMap(rawSpec.References),
+			Scripts:     safeMap(rawSpec.Scripts),
+			Tools:       rawSpec.Tools,
+			Enabled:     rawSpec.Enabled,
+		}
+		if spec.Version != "" && !validSemver(spec.Version) {
+			return nil, fmt.Errorf("skill %q version must be a semver version", name)
+		}
+		tools, err := normalizeSkillTools(spec)
+		if err != nil {
+			return nil, fmt.Errorf("skill %q: %w", name, err)
 		}
+		spec.Tools = tools
+		out[name] = spec
 	}
 	return out, nil
 }
@@ -4432,15 +4783,25 @@ func cloneWorkspaceSkills(in map[string]domain.SkillSpec) map[string]domain.Skil
 }
 
 func cloneWorkspaceSkill(in domain.SkillSpec) domain.SkillSpec {
-	return domain.SkillSpec{
-		Name:       in.Name,
-		Content:    in.Content,
-		Source:     in.Source,
-		Path:       in.Path,
-		References: cloneWorkspaceJSONMap(in.References),
-		Scripts:    cloneWorkspaceJSONMap(in.Scripts),
-		Enabled:    in.Enabled,
+	out := domain.SkillSpec{
+		Name:        in.Name,
+		Description: in.Description,
+		Triggers:    append([]string(nil), in.Triggers...),
+		Version:     in.Version,
+		Content:     in.Content,
+		Source:      in.Source,
+		Path:        in.Path,
+		References:  cloneWorkspaceJSONMap(in.References),
+		Scripts:     cloneWorkspaceJSONMap(in.Scripts),
+		Enabled:     in.Enabled,
+	}
+	for _, tool := range in.Tools {
+		if tool.Parameters != nil {
+			tool.Parameters = cloneWorkspaceJSONMap(tool.Parameters)
+		}
+		out.Tools = append(out.Tools, tool)
 	}
+	return out
 }
 
 func cloneWorkspaceJSONMap(in map[string]interface{}) map[string]interface{} {
@@ -4579,6 +4940,12 @@ func mapToolError(err error) (status int, code string, message string) {
 			return http.StatusForbidden, te.Code, te.Message
 		case "tool_not_supported":
 			return http.StatusBadRequest, te.Code, te.Message
+		case "tool_call_denied":
+			return http.StatusForbidden, te.Code, te.Message
+		case "tool_not_allowed":
+			return http.StatusForbidden, te.Code, te.Message
+		case "tool_input_invalid":
+			return http.StatusBadRequest, "invalid_tool_input", te.Message
 		case "tool_invoke_failed":
 			switch {
 			case errors.Is(te.Err, plugin.ErrShellToolCommandMissing):
@@ -4587,10 +4954,30 @@ func mapToolError(err error) (status int, code string, message string) {
 				return http.StatusBadRequest, "invalid_tool_input", "tool input items must be a non-empty array of objects"
 			case errors.Is(te.Err, plugin.ErrShellToolExecutorUnavailable):
 				return http.StatusBadGateway, "tool_runtime_unavailable", "shell executor is unavailable on current host"
+			case errors.Is(te.Err, plugin.ErrShellToolCommandDenied):
+				return http.StatusForbidden, "shell_command_denied", "shell command is not allowed by policy"
+			case errors.Is(te.Err, plugin.ErrShellToolCwdDenied):
+				return http.StatusForbidden, "shell_cwd_denied", "shell cwd is outside the allowed roots"
+			case errors.Is(te.Err, plugin.ErrShellToolSandboxUnavailable):
+				return http.StatusBadGateway, "shell_sandbox_unavailable", "shell sandbox is unavailable on current host"
+			case errors.Is(te.Err, plugin.ErrShellSessionActionInvalid):
+				return http.StatusBadRequest, "invalid_tool_input", "tool input action must be open, exec, read, write or close"
+			case errors.Is(te.Err, plugin.ErrShellSessionNotFound):
+				return http.StatusNotFound, "shell_session_not_found", "shell session not found"
+			case errors.Is(te.Err, plugin.ErrShellSessionBusy):
+				return http.StatusConflict, "shell_session_busy", "shell session is still running a command"
+			case errors.Is(te.Err, plugin.ErrShellSessionIdle):
+				return http.StatusConflict, "shell_session_idle", "shell session has no running command to write to"
+			case errors.Is(te.Err, plugin.ErrShellSessionLimit):
+				return http.StatusTooManyRequests, "shell_session_limit_reached", "too many open shell sessions"
+			case errors.Is(te.Err, plugin.ErrShellSessionUnsupported):
+				return http.StatusBadGateway, "tool_runtime_unavailable", "shell sessions are unavailable on current host"
 			case errors.Is(te.Err, plugin.ErrFileLinesToolPathMissing):
 				return http.StatusBadRequest, "invalid_tool_input", "tool input path is required"
 			case errors.Is(te.Err, plugin.ErrFileLinesToolPathInvalid):
 				return http.StatusBadRequest, "invalid_tool_input", "tool input path is invalid"
+			case errors.Is(te.Err, plugin.ErrFileToolPathDenied):
+				return http.StatusForbidden, "file_path_denied", "file path is outside the allowed roots or matches a denied pattern"
 			case errors.Is(te.Err, plugin.ErrFileLinesToolItemsInvalid):
 				return http.StatusBadRequest, "invalid_tool_input", "tool input items must be a non-empty array of objects"
 			case errors.Is(te.Err, plugin.ErrFileLinesToolStartInvalid):
@@ -4607,6 +4994,18 @@ func mapToolError(err error) (status int, code string, message string) {
 				return http.StatusBadRequest, "invalid_tool_input", "tool input line range is out of file bounds"
 			case errors.Is(te.Err, plugin.ErrFileLinesToolFileNotFound):
 				return http.StatusBadRequest, "invalid_tool_input", "target file does not exist"
+			case errors.Is(te.Err, plugin.ErrFileLinesToolStale):
+				return http.StatusConflict, "file_changed", "target file changed since it was viewed; view it again before editing"
+			case errors.Is(te.Err, plugin.ErrFileToolFileExists):
+				return http.StatusConflict, "file_exists", "target file already exists; set overwrite to replace it"
+			case errors.Is(te.Err, plugin.ErrFileToolNotDirectory):
+				return http.StatusBadRequest, "invalid_tool_input", "tool input path must be a directory"
+			case errors.Is(te.Err, plugin.ErrFileToolPatternMissing):
+				return http.StatusBadRequest, "invalid_tool_input", "tool input pattern is required"
+			case errors.Is(te.Err, plugin.ErrFileToolPatternInvalid):
+				return http.StatusBadRequest, "invalid_tool_input", "tool input pattern is invalid"
+			case errors.Is(te.Err, plugin.ErrFileToolPatchInvalid):
+				return http.StatusBadRequest, "invalid_tool_input", "tool input patch is not a valid unified diff"
 			case errors.Is(te.Err, plugin.ErrBrowserToolItemsInvalid):
 				return http.StatusBadRequest, "invalid_tool_input", "tool input items must be a non-empty array of objects"
 			case errors.Is(te.Err, plugin.ErrBrowserToolTaskMissing):
@@ -4619,6 +5018,14 @@ func mapToolError(err error) (status int, code string, message string) {
 				return http.StatusBadRequest, "invalid_tool_input", "tool input provider is unsupported"
 			case errors.Is(te.Err, plugin.ErrSearchToolProviderUnconfigured):
 				return http.StatusBadRequest, "invalid_tool_input", "tool input provider is not configured"
+			case errors.Is(te.Err, errSkillNotFound):
+				return http.StatusNotFound, "skill_not_found", "skill is not found or not enabled"
+			case errors.Is(te.Err, errSkillFileNotFound):
+				return http.StatusNotFound, "skill_file_not_found", "skill file not found"
+			case errors.Is(te.Err, plugin.ErrMCPServerUnavailable):
+				return http.StatusBadGateway, "mcp_server_unavailable", te.Message
+			case errors.Is(te.Err, plugin.ErrMCPCallFailed):
+				return http.StatusBadGateway, "mcp_call_failed", te.Message
 			default:
 				return http.StatusBadGateway, te.Code, te.Message
 			}
@@ -4697,6 +5104,7 @@ func (s *Server) collectProviderCatalog() ([]domain.ProviderInfo, map[string]str
 
 	s.store.Read(func(st *repo.State) {
 		active = st.ActiveLLM
+		envs := storedEnvs(st)
 		settingsByID := map[string]repo.ProviderSetting{}
 
 		for rawID, setting := range st.Providers {
@@ -4715,24 +5123,27 @@ func (s *Server) collectProviderCatalog() ([]domain.ProviderInfo, map[string]str
 		sort.Strings(ids)
 		for _, id := range ids {
 			setting := settingsByID[id]
-			out = append(out, buildProviderInfo(id, setting))
+			out = append(out, buildProviderInfo(id, setting, envs))
 			defaults[id] = provider.DefaultModelID(id)
+			if defaults[id] == "" && len(setting.DiscoveredModels) > 0 {
+				defaults[id] = setting.DiscoveredModels[0]
+			}
 		}
 	})
 	return out, defaults, active
 }
 
-func buildProviderInfo(providerID string, setting repo.ProviderSetting) domain.ProviderInfo {
+func buildProviderInfo(providerID string, setting repo.ProviderSetting, envs map[string]string) domain.ProviderInfo {
 	normalizeProviderSetting(&setting)
 	spec := provider.ResolveProvider(providerID)
-	apiKey := resolveProviderAPIKey(providerID, setting)
+	apiKey := resolveProviderAPIKey(providerID, setting, envs)
 	return domain.ProviderInfo{
 		ID:                 providerID,
 		Name:               spec.Name,
 		DisplayName:        resolveProviderDisplayName(setting, spec.Name),
 		OpenAICompatible:   provider.ResolveAdapter(providerID) == provider.AdapterOpenAICompatible,
 		APIKeyPrefix:       spec.APIKeyPrefix,
-		Models:             provider.ResolveModels(providerID, setting.ModelAliases),
+		Models:             provider.ResolveModelsWithDiscovered(providerID, setting.ModelAliases, setting.DiscoveredModels),
 		Headers:            sanitizeStringMap(setting.Headers),
 		TimeoutMS:          setting.TimeoutMS,
 		ModelAliases:       sanitizeStringMap(setting.ModelAliases),
@@ -4744,11 +5155,14 @@ func buildProviderInfo(providerID string, setting repo.ProviderSetting) domain.P
 	}
 }
 
-func resolveProviderAPIKey(providerID string, setting repo.ProviderSetting) string {
+// resolveProviderAPIKey returns the configured key, following a $NAME
+// reference to a stored or process variable, and falls back to
+// <PREFIX>_API_KEY.
+func resolveProviderAPIKey(providerID string, setting repo.ProviderSetting, envs map[string]string) string {
 	if key := strings.TrimSpace(setting.APIKey); key != "" {
-		return key
+		return strings.TrimSpace(expandEnvReference(envs, key))
 	}
-	return strings.TrimSpace(os.Getenv(providerEnvPrefix(providerID) + "_API_KEY"))
+	return strings.TrimSpace(lookupRuntimeEnv(envs, providerEnvPrefix(providerID)+"_API_KEY"))
 }
 
 func resolveProviderBaseURL(providerID string, setting repo.ProviderSetting) string {
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,6 +11,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -20,6 +23,7 @@ import (
 	"nextai/apps/gateway/internal/plugin"
 	"nextai/apps/gateway/internal/provider"
 	"nextai/apps/gateway/internal/repo"
+	"nextai/apps/gateway/internal/runner"
 )
 
 func newTestServer(t *testing.T) *Server {
@@ -270,6 +274,36 @@ func TestMapToolErrorShellExecutorUnavailable(t *testing.T) {
 	}
 }
 
+func TestProcessAgentDeniesFilePathOutsidePolicy(t *testing.T) {
+	srv := newTestServer(t)
+	envPath := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envPath, []byte("TOKEN=secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	procReq := fmt.Sprintf(`{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"read env"}]}],
+		"session_id":"s-file-denied",
+		"user_id":"u-file-denied",
+		"channel":"console",
+		"stream":false,
+		"view":[{"path":%q,"start":1,"end":1}]
+	}`, envPath)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"file_path_denied"`) {
+		t.Fatalf("expected 403 file_path_denied, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	feedback := formatToolErrorFeedback(&toolError{
+		Code:    "tool_invoke_failed",
+		Message: `tool "view" invocation failed`,
+		Err:     plugin.ErrFileToolPathDenied,
+	})
+	if !strings.Contains(feedback, "tool_error code=file_path_denied") {
+		t.Fatalf("unexpected tool feedback: %q", feedback)
+	}
+}
+
 func TestAPIKeyAuthMiddleware(t *testing.T) {
 	dir, err := os.MkdirTemp("", "nextai-gateway-auth-")
 	if err != nil {
@@ -1201,6 +1235,194 @@ func TestProcessAgentRunsShellTool(t *testing.T) {
 	}
 }
 
+type recordingToolPlugin struct {
+	mu  sync.Mutex
+	inv plugin.ToolInvocation
+}
+
+func (p *recordingToolPlugin) Name() string {
+	return "recorder"
+}
+
+func (p *recordingToolPlugin) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return p.InvokeContext(context.Background(), plugin.ToolInvocation{}, input)
+}
+
+func (p *recordingToolPlugin) InvokeContext(_ context.Context, inv plugin.ToolInvocation, _ map[string]interface{}) (map[string]interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inv = inv
+	return map[string]interface{}{"text": "recorded"}, nil
+}
+
+func TestProcessAgentPassesInvocationMetadataToTools(t *testing.T) {
+	srv := newTestServer(t)
+	recorder := &recordingToolPlugin{}
+	srv.registerToolPlugin(recorder)
+
+	procReq := `{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"record"}]}],
+		"session_id":"s-recorder",
+		"user_id":"u-recorder",
+		"channel":"console",
+		"stream":false,
+		"biz_params":{"tool":{"name":"recorder","input":{}}}
+	}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+	}
+	recorder.mu.Lock()
+	inv := recorder.inv
+	recorder.mu.Unlock()
+	if inv.UserID != "u-recorder" || inv.SessionID != "s-recorder" || inv.Channel != "console" {
+		t.Fatalf("unexpected invocation identity: %+v", inv)
+	}
+	if inv.ChatID == "" || !strings.HasPrefix(inv.RunID, "run") {
+		t.Fatalf("expected chat and run ids, got=%+v", inv)
+	}
+}
+
+type panickingToolPlugin struct{}
+
+func (p panickingToolPlugin) Name() string {
+	return "panicker"
+}
+
+func (p panickingToolPlugin) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return p.InvokeContext(context.Background(), plugin.ToolInvocation{}, input)
+}
+
+func (p panickingToolPlugin) InvokeContext(context.Context, plugin.ToolInvocation, map[string]interface{}) (map[string]interface{}, error) {
+	panic("boom")
+}
+
+func TestPanickingToolFailsTheCallNotTheGateway(t *testing.T) {
+	srv := newTestServer(t)
+	srv.registerToolPlugin(panickingToolPlugin{})
+
+	procReq := `{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"panic"}]}],
+		"session_id":"s-panic",
+		"user_id":"u-panic",
+		"channel":"console",
+		"stream":false,
+		"biz_params":{"tool":{"name":"panicker","input":{}}}
+	}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code == http.StatusOK || !strings.Contains(w.Body.String(), "tool_invoke_failed") {
+		t.Fatalf("expected tool_invoke_failed, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	calls := []runner.ToolCall{{ID: "a", Name: "panicker"}, {ID: "b", Name: "panicker"}}
+	outcomes := srv.executeToolCalls(context.Background(), plugin.ToolInvocation{}, calls, 2, nil, func(int) {}, func(int, toolCallOutcome) {})
+	for idx, outcome := range outcomes {
+		if outcome.Err == nil || !strings.Contains(errors.Unwrap(outcome.Err).Error(), "tool panicked: boom") {
+			t.Fatalf("expected call %d to report the panic, got=%v", idx, outcome.Err)
+		}
+	}
+}
+
+type schemaToolPlugin struct {
+	calls atomic.Int32
+}
+
+func (p *schemaToolPlugin) Name() string {
+	return "greeter"
+}
+
+func (p *schemaToolPlugin) ToolSpec() plugin.ToolSpec {
+	return plugin.ToolSpec{
+		Description: "Greet someone.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{"type": "string", "minLength": 1},
+			},
+			"required":             []string{"name"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+func (p *schemaToolPlugin) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	p.calls.Add(1)
+	return map[string]interface{}{"text": "hello " + fmt.Sprint(input["name"])}, nil
+}
+
+func TestListToolsReportsPluginSchemas(t *testing.T) {
+	t.Setenv("NEXTAI_DISABLED_TOOLS", "shell")
+	srv := newTestServer(t)
+	srv.registerToolPlugin(&schemaToolPlugin{})
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tools", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("list tools status=%d body=%s", w.Code, w.Body.String())
+	}
+	var tools []domain.ToolInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &tools); err != nil {
+		t.Fatalf("decode tools failed: %v", err)
+	}
+	byName := map[string]domain.ToolInfo{}
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+	}
+	greeter, ok := byName["greeter"]
+	if !ok || greeter.Description != "Greet someone." || !greeter.Enabled {
+		t.Fatalf("expected greeter with its own spec, got=%+v", greeter)
+	}
+	if required, _ := greeter.Parameters["required"].([]interface{}); len(required) != 1 || required[0] != "name" {
+		t.Fatalf("expected greeter schema, got=%#v", greeter.Parameters)
+	}
+	if shell, ok := byName["shell"]; !ok || shell.Enabled {
+		t.Fatalf("expected shell listed as disabled, got=%+v", shell)
+	}
+	if view := byName["view"]; view.Description == "" || view.Parameters["type"] != "object" {
+		t.Fatalf("expected view schema declared by the plugin, got=%+v", view)
+	}
+}
+
+func TestProcessAgentValidatesToolInputAgainstSchema(t *testing.T) {
+	srv := newTestServer(t)
+	greeter := &schemaToolPlugin{}
+	srv.registerToolPlugin(greeter)
+
+	procReq := `{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"greet"}]}],
+		"session_id":"s-schema-tool",
+		"user_id":"u-schema-tool",
+		"channel":"console",
+		"stream":false,
+		"biz_params":{"tool":{"name":"greeter","input":{"name":"ann","loud":true}}}
+	}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"invalid_tool_input"`) || !strings.Contains(w.Body.String(), "loud is not allowed") {
+		t.Fatalf("expected schema validation error, got=%d body=%s", w.Code, w.Body.String())
+	}
+	if greeter.calls.Load() != 0 {
+		t.Fatalf("tool invoked despite invalid input")
+	}
+
+	_, absPath := newToolTestPath(t, "schema-view")
+	procReq = fmt.Sprintf(`{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"view"}]}],
+		"session_id":"s-schema-view",
+		"user_id":"u-schema-view",
+		"channel":"console",
+		"stream":false,
+		"view":[{"path":%q,"start":0,"end":1}]
+	}`, absPath)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `items[0].start must be \u003e= 1`) {
+		t.Fatalf("expected view schema validation error, got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
 func TestProcessAgentRejectsUnknownTool(t *testing.T) {
 	srv := newTestServer(t)
 
@@ -1427,6 +1649,34 @@ func TestWorkspaceImportReplace(t *testing.T) {
 	}
 }
 
+func TestAPIWrittenSkillsSurviveSkillDirScan(t *testing.T) {
+	srv := newTestServer(t)
+
+	importBody := `{"mode":"replace","payload":{"version":"v1","skills":{"from-disk":{"content":"# exported from a disk skill","source":"filesystem","enabled":true}}}}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/workspace/import", strings.NewReader(importBody)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("import status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/workspace/files/skills/spoofed.json", strings.NewReader(`{"content":"# spoofed","source":"builtin","enabled":true}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("put skill status=%d body=%s", w.Code, w.Body.String())
+	}
+	if err := srv.syncSkillDirs(); err != nil {
+		t.Fatalf("sync skill dirs failed: %v", err)
+	}
+
+	srv.store.Read(func(st *repo.State) {
+		for _, name := range []string{"from-disk", "spoofed"} {
+			spec, ok := st.Skills[name]
+			if !ok || spec.Source != skillSourceCustomized {
+				t.Fatalf("expected %s to be kept as customized, got=%+v ok=%v", name, spec, ok)
+			}
+		}
+	})
+}
+
 func TestProcessAgentOpenAIRequiresAPIKey(t *testing.T) {
 	srv := newTestServer(t)
 
@@ -1485,6 +1735,81 @@ func TestProcessAgentOpenAIConfigured(t *testing.T) {
 	}
 }
 
+func TestStoredEnvsReachToolsAndProviderKeys(t *testing.T) {
+	t.Setenv("NEXTAI_TEST_RUNTIME_VAR", "from-os")
+	var gotAuth atomic.Value
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth.Store(r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"provider reply"}}]}`))
+	}))
+	defer mock.Close()
+
+	srv := newTestServer(t)
+	envs := `{"NEXTAI_TEST_RUNTIME_VAR":"from-store","NEXTAI_TEST_PROVIDER_KEY":"sk-stored-secret"}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/envs", strings.NewReader(envs)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("put envs status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	procReq := `{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"/shell env"}]}],
+		"session_id":"s-env",
+		"user_id":"u-env",
+		"channel":"console",
+		"stream":false,
+		"biz_params":{"tool":{"name":"shell","items":[{"command":"printf \"var=%s\" \"$NEXTAI_TEST_RUNTIME_VAR\""}]}}
+	}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "var=from-store") {
+		t.Fatalf("expected stored env in shell output, status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	configProvider := `{"api_key":"$NEXTAI_TEST_PROVIDER_KEY","base_url":"` + mock.URL + `"}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/models/openai/config", strings.NewReader(configProvider)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("config provider status=%d body=%s", w.Code, w.Body.String())
+	}
+	var info domain.ProviderInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode provider failed: %v", err)
+	}
+	if !info.HasAPIKey || info.CurrentAPIKey != maskKey("sk-stored-secret") {
+		t.Fatalf("expected api key resolved from stored env, got=%+v", info)
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/models/active", strings.NewReader(`{"provider_id":"openai","model":"gpt-4o-mini"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("set active status=%d body=%s", w.Code, w.Body.String())
+	}
+	procReq = `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"hello"}]}],"session_id":"s-env","user_id":"u-env","channel":"console","stream":false}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+	}
+	if got, _ := gotAuth.Load().(string); got != "Bearer sk-stored-secret" {
+		t.Fatalf("expected provider to receive the stored key, got=%q", got)
+	}
+
+	t.Setenv("NEXTAI_TEST_PROCESS_SECRET", "sk-process-secret")
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/models/openai/config", strings.NewReader(`{"api_key":"${NEXTAI_TEST_PROCESS_SECRET}"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("config provider status=%d body=%s", w.Code, w.Body.String())
+	}
+	info = domain.ProviderInfo{}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode provider failed: %v", err)
+	}
+	if info.HasAPIKey || info.CurrentAPIKey != "" {
+		t.Fatalf("expected references to ignore the process environment, got=%+v", info)
+	}
+}
+
 func TestProcessAgentOmitsBlacklistedToolsFromModelRequest(t *testing.T) {
 	t.Setenv("NEXTAI_DISABLED_TOOLS", "shell")
 
@@ -1545,6 +1870,49 @@ func TestProcessAgentOmitsBlacklistedToolsFromModelRequest(t *testing.T) {
 	}
 }
 
+func TestBuildToolDefinitionDescribesFileTools(t *testing.T) {
+	srv := newTestServer(t)
+	for _, name := range []string{"write", "glob", "grep", "patch"} {
+		def := buildToolDefinition(name, srv.tools[name])
+		if strings.TrimSpace(def.Description) == "" {
+			t.Fatalf("expected description for %s", name)
+		}
+		props, _ := def.Parameters["properties"].(map[string]interface{})
+		items, _ := props["items"].(map[string]interface{})
+		itemSchema, _ := items["items"].(map[string]interface{})
+		required, _ := itemSchema["required"].([]string)
+		if len(required) == 0 || required[0] != "path" {
+			t.Fatalf("expected %s items to require path, got=%#v", name, itemSchema)
+		}
+	}
+}
+
+func TestProcessAgentAppliesPatchShortcut(t *testing.T) {
+	srv := newTestServer(t)
+	_, absPath := newToolTestPath(t, "patch-shortcut")
+	if err := os.WriteFile(absPath, []byte("alpha\nbeta\n"), 0o644); err != nil {
+		t.Fatalf("seed tool test file failed: %v", err)
+	}
+
+	procReq := fmt.Sprintf(`{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"patch file"}]}],
+		"session_id":"s-patch-shortcut",
+		"user_id":"u-patch-shortcut",
+		"channel":"console",
+		"stream":false,
+		"patch":[{"path":%q,"patch":"@@ -1,2 +1,2 @@\n alpha\n-beta\n+BETA\n"}]
+	}`, absPath)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+	}
+	raw, _ := os.ReadFile(absPath)
+	if string(raw) != "alpha\nBETA\n" {
+		t.Fatalf("unexpected patched content: %q", raw)
+	}
+}
+
 func TestProcessAgentViewsSpecificFileLines(t *testing.T) {
 	srv := newTestServer(t)
 	_, absPath := newToolTestPath(t, "view-lines")
@@ -1655,6 +2023,65 @@ func TestProcessAgentEditsSpecificFileLines(t *testing.T) {
 	}
 }
 
+func TestRevertAgentRunRestoresEditedFile(t *testing.T) {
+	srv := newTestServer(t)
+	_, absPath := newToolTestPath(t, "revert-run")
+	if err := os.WriteFile(absPath, []byte("line-1\nline-2\n"), 0o644); err != nil {
+		t.Fatalf("seed tool test file failed: %v", err)
+	}
+
+	procReq := fmt.Sprintf(`{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"edit then revert"}]}],
+		"session_id":"s-revert-run",
+		"user_id":"u-revert-run",
+		"channel":"console",
+		"stream":false,
+		"edit":[{"path":%q,"start":2,"end":2,"content":"changed"}]
+	}`, absPath)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+	}
+	var out domain.AgentProcessResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode process response failed: %v", err)
+	}
+	runID, _ := out.Events[0].Meta["run_id"].(string)
+	if runID == "" {
+		t.Fatalf("expected run_id on first event, got=%+v", out.Events[0])
+	}
+
+	if err := os.WriteFile(absPath, []byte("line-1\nchanged\nuser\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wConflict := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(wConflict, httptest.NewRequest(http.MethodPost, "/agent/runs/"+runID+"/revert", nil))
+	if wConflict.Code != http.StatusConflict || !strings.Contains(wConflict.Body.String(), `"code":"revert_conflict"`) {
+		t.Fatalf("expected 409 revert_conflict, got=%d body=%s", wConflict.Code, wConflict.Body.String())
+	}
+
+	wForce := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(wForce, httptest.NewRequest(http.MethodPost, "/agent/runs/"+runID+"/revert", strings.NewReader(`{"force":true}`)))
+	if wForce.Code != http.StatusOK {
+		t.Fatalf("forced revert status=%d body=%s", wForce.Code, wForce.Body.String())
+	}
+	var result domain.AgentRunRevertResult
+	if err := json.Unmarshal(wForce.Body.Bytes(), &result); err != nil || len(result.Reverted) != 1 || len(result.Conflicts) != 1 {
+		t.Fatalf("unexpected revert result: %s", wForce.Body.String())
+	}
+	restored, _ := os.ReadFile(absPath)
+	if string(restored) != "line-1\nline-2\n" {
+		t.Fatalf("unexpected restored content: %q", restored)
+	}
+
+	wAgain := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(wAgain, httptest.NewRequest(http.MethodPost, "/agent/runs/"+runID+"/revert", nil))
+	if wAgain.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after revert, got=%d body=%s", wAgain.Code, wAgain.Body.String())
+	}
+}
+
 func TestProcessAgentViewsMultipleFilesWithInputItems(t *testing.T) {
 	srv := newTestServer(t)
 	_, absPathA := newToolTestPath(t, "view-multi-a")
@@ -1851,6 +2278,32 @@ func TestProcessAgentRejectsSearchToolWithoutQuery(t *testing.T) {
 	}
 }
 
+func TestExecuteToolCallAcceptsLegacySearchInput(t *testing.T) {
+	var gotQuery, gotNum string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery, gotNum = r.URL.Query().Get("q"), r.URL.Query().Get("num")
+		_, _ = w.Write([]byte(`{"organic_results":[{"title":"NextAI","link":"https://example.com","snippet":"legacy"}]}`))
+	}))
+	defer mock.Close()
+	t.Setenv("NEXTAI_ENABLE_SEARCH_TOOL", "true")
+	t.Setenv("NEXTAI_SEARCH_SERPAPI_KEY", "test-key")
+	t.Setenv("NEXTAI_SEARCH_SERPAPI_BASE_URL", mock.URL)
+
+	srv := newTestServer(t)
+	out, err := srv.executeToolCall(context.Background(), plugin.ToolInvocation{}, toolCall{
+		Name: "search",
+		Input: map[string]interface{}{
+			"items": []interface{}{map[string]interface{}{"q": "nextai", "count": "2", "timeout_seconds": "5"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected legacy search input to be accepted, got=%v", err)
+	}
+	if gotQuery != "nextai" || gotNum != "2" || !strings.Contains(out, "https://example.com") {
+		t.Fatalf("unexpected search request q=%q num=%q out=%s", gotQuery, gotNum, out)
+	}
+}
+
 func TestProcessAgentRejectsSearchToolWithUnsupportedProvider(t *testing.T) {
 	t.Setenv("NEXTAI_ENABLE_SEARCH_TOOL", "true")
 	t.Setenv("NEXTAI_SEARCH_SERPAPI_KEY", "test-key")
@@ -1936,45 +2389,582 @@ func TestProcessAgentRunsMultiStepAgentLoop(t *testing.T) {
 	}
 }
 
-func TestProcessAgentContinuesAfterToolInputError(t *testing.T) {
-	_, absPath := newToolTestPath(t, "edit-lines-error-continue")
-	if err := os.WriteFile(absPath, []byte("line-1\nline-2\n"), 0o644); err != nil {
-		t.Fatalf("seed tool test file failed: %v", err)
-	}
-	toolArgs, err := json.Marshal(map[string]interface{}{
-		"items": []map[string]interface{}{
-			{
-				"path":    absPath,
-				"start":   9,
-				"end":     9,
-				"content": "x",
-			},
-		},
-	})
-	if err != nil {
-		t.Fatalf("marshal tool args failed: %v", err)
-	}
-
-	var calls int
+func TestProcessAgentInjectsSkillIndexAndLoadsSkill(t *testing.T) {
+	var requests []map[string]interface{}
 	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		calls++
-		if r.Method != http.MethodPost || r.URL.Path != "/chat/completions" {
-			w.WriteHeader(http.StatusNotFound)
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode request body failed: %v", err)
+		}
+		requests = append(requests, body)
+		if len(requests) == 1 {
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"","tool_calls":[{"id":"call_skill","type":"function","function":{"name":"load_skill","arguments":"{\"name\":\"deploy\"}"}}]}}]}`))
 			return
 		}
-		if calls == 1 {
-			_ = json.NewEncoder(w).Encode(map[string]interface{}{
-				"choices": []map[string]interface{}{
-					{
-						"message": map[string]interface{}{
-							"content": "",
-							"tool_calls": []map[string]interface{}{
-								{
-									"id":   "call_edit",
-									"type": "function",
-									"function": map[string]interface{}{
-										"name":      "edit",
-										"arguments": string(toolArgs),
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"deployed"}}]}`))
+	}))
+	defer mock.Close()
+
+	srv := newTestServer(t)
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPut, "/models/openai/config", `{"api_key":"sk-test","base_url":"` + mock.URL + `"}`},
+		{http.MethodPut, "/models/active", `{"provider_id":"openai","model":"gpt-4o-mini"}`},
+		{http.MethodPost, "/skills", `{"name":"deploy","content":"---\nname: deploy\ndescription: Ship the gateway to staging\n---\nRun scripts/ship.sh.","references":{"env.md":"staging env"},"scripts":{"ship.sh":"echo ship"}}`},
+		{http.MethodPost, "/skills", `{"name":"draft","content":"# Draft\nNot ready."}`},
+		{http.MethodPost, "/skills/draft/disable", ``},
+	} {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s status=%d body=%s", req.method, req.path, w.Code, w.Body.String())
+		}
+	}
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"deploy it"}]}],"session_id":"s-skill","user_id":"u-skill","channel":"console","stream":false}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 model calls, got=%d", len(requests))
+	}
+
+	first, _ := json.Marshal(requests[0]["messages"])
+	if !strings.Contains(string(first), "## Skills") || !strings.Contains(string(first), "- deploy: Ship the gateway to staging") {
+		t.Fatalf("expected skill index in system prompt, got=%s", first)
+	}
+	if strings.Contains(string(first), "draft") {
+		t.Fatalf("expected disabled skill to be left out, got=%s", first)
+	}
+	tools, _ := json.Marshal(requests[0]["tools"])
+	if !strings.Contains(string(tools), `"name":"load_skill"`) {
+		t.Fatalf("expected load_skill tool definition, got=%s", tools)
+	}
+	second, _ := json.Marshal(requests[1]["messages"])
+	if !strings.Contains(string(second), "Run scripts/ship.sh.") || !strings.Contains(string(second), "references/env.md") {
+		t.Fatalf("expected loaded skill content in tool result, got=%s", second)
+	}
+
+	var chatID string
+	srv.store.Read(func(st *repo.State) {
+		for id, chat := range st.Chats {
+			if chat.SessionID == "s-skill" {
+				chatID = id
+			}
+		}
+	})
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/chats/"+chatID, nil))
+	var history domain.ChatHistory
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil || len(history.Messages) == 0 {
+		t.Fatalf("decode history failed: %v body=%s", err, w.Body.String())
+	}
+	used, _ := history.Messages[len(history.Messages)-1].Metadata["skills_used"].([]interface{})
+	if len(used) != 1 || used[0] != "deploy" {
+		t.Fatalf("expected skills_used=[deploy], got=%#v", history.Messages[len(history.Messages)-1].Metadata)
+	}
+}
+
+func TestParseSkillManifestReadsFrontmatter(t *testing.T) {
+	manifest, body := parseSkillManifest("---\nname: deploy\ndescription: \"Ship it: safely\"\ntriggers:\n  - deploy\n  - 'release'\n---\n\n# Deploy\nsteps\n")
+	if manifest.Name != "deploy" || manifest.Description != "Ship it: safely" {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	if strings.Join(manifest.Triggers, ",") != "deploy,release" {
+		t.Fatalf("unexpected triggers: %#v", manifest.Triggers)
+	}
+	if body != "# Deploy\nsteps\n" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+
+	manifest, _ = parseSkillManifest("---\ntriggers: [lint, \"fmt\"]\n---\nbody")
+	if strings.Join(manifest.Triggers, ",") != "lint,fmt" {
+		t.Fatalf("unexpected inline triggers: %#v", manifest.Triggers)
+	}
+	manifest, body = parseSkillManifest("no frontmatter")
+	if manifest.Name != "" || body != "no frontmatter" {
+		t.Fatalf("expected plain content to pass through, got=%+v %q", manifest, body)
+	}
+}
+
+func writeSkillPackage(t *testing.T, dir, manifest string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "SKILL.md"), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSkillDirectoriesAreScannedAndReloaded(t *testing.T) {
+	t.Setenv("NEXTAI_DISABLE_QQ_INBOUND_SUPERVISOR", "true")
+	dataDir := t.TempDir()
+	builtinDir := t.TempDir()
+	writeSkillPackage(t, filepath.Join(builtinDir, "lint"), "---\ndescription: Lint the repo\n---\nrun lint", nil)
+	writeSkillPackage(t, filepath.Join(builtinDir, "deploy"), "---\ndescription: builtin deploy\n---\nold", nil)
+	deployDir := filepath.Join(dataDir, "skills", "deploy")
+	writeSkillPackage(t, deployDir, "---\nname: deploy\ndescription: Ship to staging\ntriggers: [deploy]\n---\nRun scripts/ship.sh.", map[string]string{
+		"references/env.md": "staging env",
+		"scripts/ship.sh":   "echo ship",
+	})
+
+	srv, err := NewServer(config.Config{Host: "127.0.0.1", Port: "0", DataDir: dataDir, BuiltinSkillsDir: builtinDir, SkillsReload: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	skill := func(name string) (domain.SkillSpec, bool) {
+		var spec domain.SkillSpec
+		found := false
+		srv.store.Read(func(st *repo.State) { spec, found = st.Skills[name] })
+		return spec, found
+	}
+	deploy, ok := skill("deploy")
+	if !ok || deploy.Source != "filesystem" || deploy.Description != "Ship to staging" || deploy.Path != deployDir {
+		t.Fatalf("expected data dir skill to override builtin, got=%+v", deploy)
+	}
+	if len(deploy.Triggers) != 1 || deploy.References["env.md"] != "staging env" || deploy.Scripts["ship.sh"] != "echo ship" {
+		t.Fatalf("unexpected skill package contents: %+v", deploy)
+	}
+	if lint, ok := skill("lint"); !ok || lint.Source != "builtin" || lint.Enabled {
+		t.Fatalf("expected new builtin skill to start disabled, got=%+v", lint)
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/skills/lint/enable", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("enable status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/skills/lint", nil))
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"skill_on_disk"`) {
+		t.Fatalf("expected 409 skill_on_disk, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	writeSkillPackage(t, deployDir, "---\ndescription: Ship to production\n---\nRun scripts/ship.sh --prod.", nil)
+	if err := os.WriteFile(filepath.Join(builtinDir, "lint", "SKILL.md"), []byte("---\ndescription: Lint everything\n---\nrun lint"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deploy, _ = skill("deploy")
+		if deploy.Description == "Ship to production" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected skill to be reloaded, got=%+v", deploy)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lint, _ := skill("lint"); !lint.Enabled || lint.Description != "Lint everything" {
+		t.Fatalf("expected reload to keep the enabled flag, got=%+v", lint)
+	}
+
+	if err := os.RemoveAll(deployDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.syncSkillDirs(); err != nil {
+		t.Fatal(err)
+	}
+	if deploy, _ = skill("deploy"); deploy.Source != "builtin" {
+		t.Fatalf("expected builtin skill once the override is removed, got=%+v", deploy)
+	}
+}
+
+func TestSkillScriptsRegisterAsToolsWhileEnabled(t *testing.T) {
+	dataDir := t.TempDir()
+	writeSkillPackage(t, filepath.Join(dataDir, "skills", "lint"), "---\ndescription: Lint\n---\nrun lint", map[string]string{
+		"scripts/check.sh": "printf checked",
+		"tools.json":       `[{"name":"check","script":"check.sh","description":"Run the linter."}]`,
+	})
+	srv := newTestServerWithDataDir(t, dataDir)
+
+	toolNames := func() string {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tools", nil))
+		var tools []domain.ToolInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &tools); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, tool := range tools {
+			if strings.HasPrefix(tool.Name, "skill.") {
+				names = append(names, tool.Name)
+			}
+		}
+		return strings.Join(names, ",")
+	}
+	if got := toolNames(); got != "" {
+		t.Fatalf("expected a new disk skill to stay disabled, got=%q", got)
+	}
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/skills/lint/enable", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("enable status=%d body=%s", w.Code, w.Body.String())
+	}
+	if got := toolNames(); got != "skill.lint.check" {
+		t.Fatalf("expected tools.json declaration to register, got=%q", got)
+	}
+
+	invalid := `{"name":"deploy","content":"ship","scripts":{"ship.sh":"x"},"tools":[{"name":"ship","script":"missing.sh"}]}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/skills", strings.NewReader(invalid)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_skill") {
+		t.Fatalf("expected missing script to be rejected, got=%d body=%s", w.Code, w.Body.String())
+	}
+	create := `{"name":"deploy","content":"ship","scripts":{"ship.sh":"read -r input; printf \"shipping %s\" \"$input\""},"tools":[{"name":"ship","script":"scripts/ship.sh","interpreter":"sh","parameters":{"type":"object","properties":{"env":{"type":"string"}},"required":["env"]}}]}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/skills", strings.NewReader(create)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("create skill status=%d body=%s", w.Code, w.Body.String())
+	}
+	if got := toolNames(); got != "skill.deploy.ship,skill.lint.check" {
+		t.Fatalf("unexpected skill tools: %q", got)
+	}
+
+	procReq := `{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"ship"}]}],
+		"session_id":"s-skill-tool",
+		"user_id":"u-skill-tool",
+		"channel":"console",
+		"stream":false,
+		"biz_params":{"tool":{"name":"skill.deploy.ship","input":{"env":"staging"}}}
+	}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `shipping {\"env\":\"staging\"}`) {
+		t.Fatalf("expected script output, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{"/skills/deploy/disable", "/skills/lint/disable"} {
+		w = httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", path, w.Code, w.Body.String())
+		}
+	}
+	if got := toolNames(); got != "" {
+		t.Fatalf("expected tools of disabled skills to be removed, got=%q", got)
+	}
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected disabled skill tool to be unavailable, body=%s", w.Body.String())
+	}
+}
+
+func TestFileToolsCannotReachDataDir(t *testing.T) {
+	srv := newTestServer(t)
+	target := filepath.Join(srv.cfg.DataDir, "skills", "evil", "SKILL.md")
+	procReq, _ := json.Marshal(map[string]interface{}{
+		"input":      []interface{}{map[string]interface{}{"role": "user", "type": "message", "content": []interface{}{map[string]interface{}{"type": "text", "text": "write"}}}},
+		"session_id": "s-data-dir",
+		"user_id":    "u-data-dir",
+		"channel":    "console",
+		"stream":     false,
+		"biz_params": map[string]interface{}{"tool": map[string]interface{}{"name": "write", "items": []interface{}{map[string]interface{}{"path": target, "content": "run scripts/x.sh"}}}},
+	})
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", bytes.NewReader(procReq)))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"file_path_denied"`) {
+		t.Fatalf("expected the data dir to be denied, got=%d body=%s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written under the data dir, stat err=%v", err)
+	}
+}
+
+func TestSkillScriptToolsUseProviderSafeNames(t *testing.T) {
+	var requests []map[string]interface{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode request body failed: %v", err)
+		}
+		requests = append(requests, body)
+		if len(requests) == 1 {
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"","tool_calls":[{"id":"call_ship","type":"function","function":{"name":"skill__deploy__ship","arguments":"{}"}}]}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"shipped"}}]}`))
+	}))
+	defer mock.Close()
+
+	srv := newTestServer(t)
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPut, "/models/openai/config", `{"api_key":"sk-test","base_url":"` + mock.URL + `"}`},
+		{http.MethodPut, "/models/active", `{"provider_id":"openai","model":"gpt-4o-mini"}`},
+		{http.MethodPost, "/skills", `{"name":"deploy","content":"ship","scripts":{"ship.sh":"printf shipping"},"tools":[{"name":"ship","script":"ship.sh"}]}`},
+	} {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s status=%d body=%s", req.method, req.path, w.Code, w.Body.String())
+		}
+	}
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"ship it"}]}],"session_id":"s-safe-name","user_id":"u-safe-name","channel":"console","stream":false}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 model calls, got=%d", len(requests))
+	}
+	validName := regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	found := false
+	for _, raw := range requests[0]["tools"].([]interface{}) {
+		fn, _ := raw.(map[string]interface{})["function"].(map[string]interface{})
+		name, _ := fn["name"].(string)
+		if !validName.MatchString(name) {
+			t.Fatalf("tool name %q is not accepted by providers", name)
+		}
+		found = found || name == "skill__deploy__ship"
+	}
+	if !found {
+		t.Fatalf("expected skill__deploy__ship in tool definitions, got=%v", requests[0]["tools"])
+	}
+
+	var out domain.AgentProcessResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	ran := false
+	for _, evt := range out.Events {
+		if evt.Type == "tool_result" && evt.ToolResult != nil && evt.ToolResult.Name == "skill.deploy.ship" && evt.ToolResult.OK {
+			ran = true
+		}
+	}
+	if !ran {
+		t.Fatalf("expected the call to run skill.deploy.ship, got=%+v", out.Events)
+	}
+	second, _ := json.Marshal(requests[1]["messages"])
+	if !strings.Contains(string(second), "shipping") || strings.Contains(string(second), "skill.deploy.ship") {
+		t.Fatalf("expected tool result under the provider-safe name, got=%s", second)
+	}
+}
+
+func TestCompareSemverFollowsPrecedence(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.2.0", "1.10.0", "2.0.0"}
+	for i := 1; i < len(ordered); i++ {
+		if got := compareSemver(ordered[i-1], ordered[i]); got != -1 {
+			t.Fatalf("compareSemver(%s, %s)=%d, want -1", ordered[i-1], ordered[i], got)
+		}
+	}
+	if compareSemver("1.0.0+build.1", "1.0.0") != 0 || validSemver("1.0") || validSemver("v1.0.0") {
+		t.Fatal("unexpected semver handling")
+	}
+}
+
+func buildSkillZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSkillArchiveImportExportAndRollback(t *testing.T) {
+	source := newTestServer(t)
+	create := `{"name":"deploy","version":"1.0.0","description":"Ship: safely","triggers":["deploy"],"content":"Run the ship tool.","references":{"env.md":"staging"},"scripts":{"ship.sh":"echo ship"},"tools":[{"name":"ship","script":"ship.sh"}]}`
+	w := httptest.NewRecorder()
+	source.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/skills", strings.NewReader(create)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("create skill status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	source.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/skills/deploy/export", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" || w.Header().Get("X-Skill-Version") != "1.0.0" {
+		t.Fatalf("export status=%d headers=%v body=%s", w.Code, w.Header(), w.Body.String())
+	}
+	exported := w.Body.Bytes()
+	checksum := w.Header().Get("X-Skill-Checksum")
+
+	srv := newTestServer(t)
+	importArchive := func(raw []byte, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/skills/import"+query, bytes.NewReader(raw)))
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) domain.SkillImportResult {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("import status=%d body=%s", w.Code, w.Body.String())
+		}
+		var result domain.SkillImportResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	installed := func() domain.SkillSpec {
+		var spec domain.SkillSpec
+		srv.store.Read(func(st *repo.State) { spec = st.Skills["deploy"] })
+		return spec
+	}
+
+	result := decode(importArchive(exported, ""))
+	if result.Change != "new" || !result.Applied || result.Checksum != checksum || len(result.Added) != 4 {
+		t.Fatalf("unexpected first import: %+v", result)
+	}
+	spec := installed()
+	if spec.Version != "1.0.0" || spec.Description != "Ship: safely" || spec.Content != "Run the ship tool." || spec.Source != "customized" || len(spec.Tools) != 1 {
+		t.Fatalf("unexpected imported skill: %+v", spec)
+	}
+	if _, ok := srv.toolPlugin("skill.deploy.ship"); !ok {
+		t.Fatal("expected imported script tool to be registered")
+	}
+
+	upgrade := buildSkillZip(t, map[string]string{
+		"SKILL.md":             "---\nname: deploy\nversion: 1.1.0\n---\nRun the ship tool twice.",
+		"references/env.md":    "production",
+		"scripts/ship.sh":      "echo ship",
+		"scripts/rollback.sh":  "echo back",
+		"unrelated/readme.txt": "ignored",
+	})
+	result = decode(importArchive(upgrade, "?dry_run=true"))
+	if result.Change != "upgrade" || result.Applied || result.PreviousVersion != "1.0.0" {
+		t.Fatalf("unexpected dry run: %+v", result)
+	}
+	if strings.Join(result.Added, ",") != "scripts/rollback.sh" || strings.Join(result.Removed, ",") != "tools.json" || strings.Join(result.Modified, ",") != "SKILL.md,references/env.md" {
+		t.Fatalf("unexpected diff: %+v", result)
+	}
+	if installed().Version != "1.0.0" {
+		t.Fatal("dry run must not change the installed skill")
+	}
+	if result = decode(importArchive(upgrade, "")); !result.Applied || installed().Version != "1.1.0" {
+		t.Fatalf("expected upgrade to apply, got=%+v", result)
+	}
+	if _, ok := srv.toolPlugin("skill.deploy.ship"); ok {
+		t.Fatal("expected tool dropped by the upgrade to be unregistered")
+	}
+
+	tampered := buildSkillZip(t, map[string]string{
+		"deploy/SKILL.md":        "---\nname: deploy\nversion: 1.2.0\n---\nchanged",
+		"deploy/checksum.sha256": checksum + "\n",
+	})
+	if w := importArchive(tampered, ""); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "skill_checksum_mismatch") {
+		t.Fatalf("expected checksum mismatch, got=%d body=%s", w.Code, w.Body.String())
+	}
+	reused := buildSkillZip(t, map[string]string{"SKILL.md": "---\nname: deploy\nversion: 1.0.0\n---\nsomething else"})
+	if w := importArchive(reused, ""); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "skill_version_conflict") {
+		t.Fatalf("expected version conflict, got=%d body=%s", w.Code, w.Body.String())
+	}
+	unversioned := buildSkillZip(t, map[string]string{"SKILL.md": "---\nname: deploy\n---\nbody"})
+	if w := importArchive(unversioned, ""); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_skill_archive") {
+		t.Fatalf("expected missing version to be rejected, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/skills/deploy/versions", nil))
+	var versions []domain.SkillVersionInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &versions); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != "1.1.0" || !versions[0].Current || versions[1].Version != "1.0.0" || versions[1].Current || versions[1].Checksum != checksum {
+		t.Fatalf("unexpected versions: %+v", versions)
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/skills/deploy/rollback", strings.NewReader(`{"version":"1.0.0"}`)))
+	if result = decode(w); result.Change != "downgrade" || result.PreviousVersion != "1.1.0" {
+		t.Fatalf("unexpected rollback: %+v", result)
+	}
+	if spec := installed(); spec.Version != "1.0.0" || spec.References["env.md"] != "staging" || len(spec.Tools) != 1 {
+		t.Fatalf("expected rollback to restore 1.0.0, got=%+v", spec)
+	}
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/skills/deploy/rollback", strings.NewReader(`{"version":"9.9.9"}`)))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "skill_version_not_found") {
+		t.Fatalf("expected unknown version to be rejected, got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestLoadSkillToolReadsFilesOfEnabledSkills(t *testing.T) {
+	srv := newTestServer(t)
+	if err := srv.store.Write(func(st *repo.State) error {
+		st.Skills["deploy"] = domain.SkillSpec{Name: "deploy", Content: "steps", Scripts: map[string]interface{}{"bin": map[string]interface{}{"ship.sh": "echo ship"}}, Enabled: true}
+		st.Skills["off"] = domain.SkillSpec{Name: "off", Content: "hidden"}
+		return nil
+	}); err != nil {
+		t.Fatalf("seed skills failed: %v", err)
+	}
+	tool := newLoadSkillTool(srv.store)
+	out, err := tool.Invoke(map[string]interface{}{"name": "deploy", "file": "scripts/bin/ship.sh"})
+	if err != nil || out["content"] != "echo ship" {
+		t.Fatalf("unexpected skill file result: %#v err=%v", out, err)
+	}
+	if _, err := tool.Invoke(map[string]interface{}{"name": "deploy", "file": "scripts/missing.sh"}); !errors.Is(err, errSkillFileNotFound) {
+		t.Fatalf("expected errSkillFileNotFound, got=%v", err)
+	}
+	if _, err := tool.Invoke(map[string]interface{}{"name": "off"}); !errors.Is(err, errSkillNotFound) {
+		t.Fatalf("expected disabled skill to be hidden, got=%v", err)
+	}
+}
+
+func TestProcessAgentContinuesAfterToolInputError(t *testing.T) {
+	_, absPath := newToolTestPath(t, "edit-lines-error-continue")
+	if err := os.WriteFile(absPath, []byte("line-1\nline-2\n"), 0o644); err != nil {
+		t.Fatalf("seed tool test file failed: %v", err)
+	}
+	toolArgs, err := json.Marshal(map[string]interface{}{
+		"items": []map[string]interface{}{
+			{
+				"path":    absPath,
+				"start":   9,
+				"end":     9,
+				"content": "x",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal tool args failed: %v", err)
+	}
+
+	var calls int
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Method != http.MethodPost || r.URL.Path != "/chat/completions" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if calls == 1 {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"choices": []map[string]interface{}{
+					{
+						"message": map[string]interface{}{
+							"content": "",
+							"tool_calls": []map[string]interface{}{
+								{
+									"id":   "call_edit",
+									"type": "function",
+									"function": map[string]interface{}{
+										"name":      "edit",
+										"arguments": string(toolArgs),
 									},
 								},
 							},
@@ -2466,19 +3456,82 @@ func TestProcessAgentStreamFlushesEventsInRealTime(t *testing.T) {
 	}
 }
 
-func TestModelsCatalogReflectsStateProviders(t *testing.T) {
+func TestProcessAgentStreamsToolOutputDeltas(t *testing.T) {
 	srv := newTestServer(t)
 
-	configProvider := `{"display_name":"My Custom Gateway","enabled":true}`
-	w1 := httptest.NewRecorder()
-	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/custom-openai/config", strings.NewReader(configProvider)))
-	if w1.Code != http.StatusOK {
-		t.Fatalf("config custom provider status=%d body=%s", w1.Code, w1.Body.String())
-	}
-
-	wDelete := httptest.NewRecorder()
-	srv.Handler().ServeHTTP(wDelete, httptest.NewRequest(http.MethodDelete, "/models/openai", nil))
-	if wDelete.Code != http.StatusOK {
+	procReq := `{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"stream tool output"}]}],
+		"session_id":"s-tool-output",
+		"user_id":"u-tool-output",
+		"channel":"console",
+		"stream":true,
+		"biz_params":{"tool":{"name":"shell","items":[{"command":"printf first; sleep 0.3; printf second"}]}}
+	}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+	}
+	streamed := ""
+	deltas := 0
+	var result *domain.AgentToolResultPayload
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: {") {
+			continue
+		}
+		var evt domain.AgentEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &evt); err != nil {
+			t.Fatalf("decode event failed: %v line=%s", err, line)
+		}
+		switch evt.Type {
+		case "tool_output_delta":
+			if result != nil {
+				t.Fatalf("tool_output_delta after tool_result, body=%s", w.Body.String())
+			}
+			if evt.ToolCall == nil || evt.ToolCall.Name != "shell" {
+				t.Fatalf("expected delta to name the tool, got=%+v", evt.ToolCall)
+			}
+			deltas++
+			streamed += evt.Delta
+		case "tool_result":
+			result = evt.ToolResult
+		}
+	}
+	if deltas < 2 || streamed != "firstsecond" {
+		t.Fatalf("expected incremental tool output, deltas=%d streamed=%q", deltas, streamed)
+	}
+	if result == nil || result.Summary != "firstsecond" {
+		t.Fatalf("expected tool_result summary from streamed output, got=%+v", result)
+	}
+
+	var chatID string
+	srv.store.Read(func(state *repo.State) {
+		for id, chat := range state.Chats {
+			if chat.SessionID == "s-tool-output" {
+				chatID = id
+			}
+		}
+	})
+	history := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(history, httptest.NewRequest(http.MethodGet, "/chats/"+chatID, nil))
+	if !strings.Contains(history.Body.String(), `\"type\":\"tool_result\"`) || !strings.Contains(history.Body.String(), `firstsecond`) {
+		t.Fatalf("expected persisted tool_result notice, body=%s", history.Body.String())
+	}
+}
+
+func TestModelsCatalogReflectsStateProviders(t *testing.T) {
+	srv := newTestServer(t)
+
+	configProvider := `{"display_name":"My Custom Gateway","enabled":true}`
+	w1 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/custom-openai/config", strings.NewReader(configProvider)))
+	if w1.Code != http.StatusOK {
+		t.Fatalf("config custom provider status=%d body=%s", w1.Code, w1.Body.String())
+	}
+
+	wDelete := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(wDelete, httptest.NewRequest(http.MethodDelete, "/models/openai", nil))
+	if wDelete.Code != http.StatusOK {
 		t.Fatalf("delete openai status=%d body=%s", wDelete.Code, wDelete.Body.String())
 	}
 
@@ -2615,6 +3668,1258 @@ func TestConfigureProviderExposesModelAliasesInProviderInfo(t *testing.T) {
 	}
 }
 
+func TestRefreshProviderModelsListsDiscoveredModelsForCustomProvider(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/models" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer sk-local" {
+			t.Errorf("unexpected auth header: %q", got)
+		}
+		_, _ = w.Write([]byte(`{"data":[{"id":"qwen2.5-7b"},{"id":"llama3.1-8b"}]}`))
+	}))
+	defer mock.Close()
+
+	srv := newTestServer(t)
+	configProvider := `{"api_key":"sk-local","base_url":"` + mock.URL + `"}`
+	w1 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/local-llm/config", strings.NewReader(configProvider)))
+	if w1.Code != http.StatusOK {
+		t.Fatalf("config provider status=%d body=%s", w1.Code, w1.Body.String())
+	}
+
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodPost, "/models/local-llm/refresh", nil))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("refresh status=%d body=%s", w2.Code, w2.Body.String())
+	}
+	var refreshed domain.ProviderInfo
+	if err := json.Unmarshal(w2.Body.Bytes(), &refreshed); err != nil {
+		t.Fatalf("decode refresh response failed: %v body=%s", err, w2.Body.String())
+	}
+	if len(refreshed.Models) != 2 || refreshed.Models[0].ID != "llama3.1-8b" || refreshed.Models[1].ID != "qwen2.5-7b" {
+		t.Fatalf("unexpected discovered models: %+v", refreshed.Models)
+	}
+
+	w3 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w3, httptest.NewRequest(http.MethodGet, "/models/catalog", nil))
+	var catalogOut struct {
+		Providers []domain.ProviderInfo `json:"providers"`
+		Defaults  map[string]string     `json:"defaults"`
+	}
+	if err := json.Unmarshal(w3.Body.Bytes(), &catalogOut); err != nil {
+		t.Fatalf("decode catalog failed: %v body=%s", err, w3.Body.String())
+	}
+	if catalogOut.Defaults["local-llm"] != "llama3.1-8b" {
+		t.Fatalf("expected discovered default model, got=%v", catalogOut.Defaults)
+	}
+	found := false
+	for _, item := range catalogOut.Providers {
+		if item.ID == "local-llm" {
+			found = len(item.Models) == 2
+		}
+	}
+	if !found {
+		t.Fatalf("expected discovered models in catalog, body=%s", w3.Body.String())
+	}
+}
+
+func TestRefreshProviderModelsRejectsUnknownProvider(t *testing.T) {
+	srv := newTestServer(t)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/models/missing/refresh", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestProcessAgentFailsOverToFallbackSlot(t *testing.T) {
+	primaryCalls := 0
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		primaryCalls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/chat/completions" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"backup reply"}}]}`))
+	}))
+	defer backup.Close()
+
+	srv := newTestServer(t)
+	configureFailoverSlots(t, srv, primary.URL, backup.URL)
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodGet, "/models/active", nil))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("get active status=%d body=%s", w2.Code, w2.Body.String())
+	}
+	var active domain.ActiveModelsInfo
+	if err := json.Unmarshal(w2.Body.Bytes(), &active); err != nil {
+		t.Fatalf("decode fallbacks response failed: %v", err)
+	}
+	if active.ActiveLLM.ProviderID != "openai" || len(active.FallbackLLMs) != 1 || active.FallbackLLMs[0].ProviderID != "backup" {
+		t.Fatalf("unexpected active models: %+v", active)
+	}
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"hello"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false}`
+	w3 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w3, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w3.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w3.Code, w3.Body.String())
+	}
+	var out domain.AgentProcessResponse
+	if err := json.Unmarshal(w3.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode process response failed: %v body=%s", err, w3.Body.String())
+	}
+	if out.Reply != "backup reply" || primaryCalls != 1 {
+		t.Fatalf("expected backup reply after one primary call, reply=%q primaryCalls=%d", out.Reply, primaryCalls)
+	}
+	var failover *domain.AgentEvent
+	for i := range out.Events {
+		if out.Events[i].Type == "provider_failover" {
+			failover = &out.Events[i]
+		}
+	}
+	if failover == nil {
+		t.Fatalf("expected provider_failover event, events=%+v", out.Events)
+	}
+	if failover.Meta["provider_id"] != "backup" || failover.Meta["model"] != "local-model" || failover.Meta["slot"] != float64(1) {
+		t.Fatalf("unexpected failover meta: %+v", failover.Meta)
+	}
+}
+
+func configureFailoverSlots(t *testing.T, srv *Server, primaryURL, backupURL string) {
+	t.Helper()
+	for _, item := range []struct{ id, url string }{{"openai", primaryURL}, {"backup", backupURL}} {
+		w := httptest.NewRecorder()
+		body := `{"api_key":"sk-test","base_url":"` + item.url + `"}`
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/models/"+item.id+"/config", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("config provider %s status=%d body=%s", item.id, w.Code, w.Body.String())
+		}
+	}
+	w1 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/active", strings.NewReader(`{"provider_id":"openai","model":"gpt-4o-mini"}`)))
+	if w1.Code != http.StatusOK {
+		t.Fatalf("set active status=%d body=%s", w1.Code, w1.Body.String())
+	}
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodPut, "/models/active/fallbacks", strings.NewReader(`{"fallback_llms":[{"provider_id":"backup","model":"local-model"}]}`)))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("set fallbacks status=%d body=%s", w2.Code, w2.Body.String())
+	}
+}
+
+func TestProcessAgentReportsAnsweringSlotAndUsagePerProvider(t *testing.T) {
+	primaryCalls := 0
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		primaryCalls++
+		if primaryCalls > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"","tool_calls":[{"id":"call_shell","type":"function","function":{"name":"shell","arguments":"{\"items\":[{\"command\":\"printf hi\"}]}"}}]}}],"usage":{"prompt_tokens":8,"completion_tokens":2,"total_tokens":10}}`))
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"backup reply"}}],"usage":{"prompt_tokens":4,"completion_tokens":1,"total_tokens":5}}`))
+	}))
+	defer backup.Close()
+
+	srv := newTestServer(t)
+	configureFailoverSlots(t, srv, primary.URL, backup.URL)
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"hello"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+	}
+	var out domain.AgentProcessResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode process response failed: %v body=%s", err, w.Body.String())
+	}
+	completed := out.Events[len(out.Events)-1]
+	if completed.Type != "completed" || completed.Meta["provider_id"] != "backup" || completed.Meta["slot"] != float64(1) {
+		t.Fatalf("unexpected completed event: %+v", completed)
+	}
+	turns, _ := completed.Meta["turns"].([]interface{})
+	if len(turns) != 2 {
+		t.Fatalf("expected one entry per LLM turn, got=%+v", completed.Meta["turns"])
+	}
+	first, _ := turns[0].(map[string]interface{})
+	second, _ := turns[1].(map[string]interface{})
+	if first["slot"] != float64(0) || first["provider_id"] != "openai" || second["slot"] != float64(1) || second["provider_id"] != "backup" {
+		t.Fatalf("unexpected turn slots: %+v", turns)
+	}
+	byProvider, _ := completed.Meta["usage_by_provider"].([]interface{})
+	if len(byProvider) != 2 {
+		t.Fatalf("expected usage for both providers, got=%+v", completed.Meta["usage_by_provider"])
+	}
+	for i, want := range []struct {
+		provider string
+		total    float64
+	}{{"openai", 10}, {"backup", 5}} {
+		item, _ := byProvider[i].(map[string]interface{})
+		usage, _ := item["usage"].(map[string]interface{})
+		if item["provider_id"] != want.provider || usage["total_tokens"] != want.total {
+			t.Fatalf("unexpected usage for %s: %+v", want.provider, item)
+		}
+	}
+	total, _ := completed.Meta["usage"].(map[string]interface{})
+	if total["total_tokens"] != float64(15) {
+		t.Fatalf("unexpected total usage: %+v", completed.Meta["usage"])
+	}
+}
+
+func TestProcessAgentReportsAttemptsWhenEverySlotFails(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	srv := newTestServer(t)
+	configureFailoverSlots(t, srv, failing.URL, failing.URL)
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"hello"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected failure when every slot fails, body=%s", w.Body.String())
+	}
+	var body struct {
+		Error struct {
+			Details struct {
+				Attempts []map[string]interface{} `json:"attempts"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body failed: %v body=%s", err, w.Body.String())
+	}
+	attempts := body.Error.Details.Attempts
+	if len(attempts) != 2 || attempts[0]["provider_id"] != "openai" || attempts[1]["provider_id"] != "backup" {
+		t.Fatalf("expected both failed slots in details, got=%s", w.Body.String())
+	}
+
+	streamReq := strings.Replace(procReq, `"stream":false`, `"stream":true`, 1)
+	ws := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(ws, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(streamReq)))
+	stream := ws.Body.String()
+	if !strings.Contains(stream, `"type":"provider_failover"`) || !strings.Contains(stream, `"failed":true`) {
+		t.Fatalf("expected failed provider_failover event in stream, body=%s", stream)
+	}
+}
+
+func TestProcessAgentRecordsTokenUsage(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"counted"}}],"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}`))
+	}))
+	defer mock.Close()
+
+	srv := newTestServer(t)
+	w1 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/openai/config", strings.NewReader(`{"api_key":"sk-test","base_url":"`+mock.URL+`"}`)))
+	if w1.Code != http.StatusOK {
+		t.Fatalf("config provider status=%d body=%s", w1.Code, w1.Body.String())
+	}
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodPut, "/models/active", strings.NewReader(`{"provider_id":"openai","model":"gpt-4o-mini"}`)))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("set active status=%d body=%s", w2.Code, w2.Body.String())
+	}
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"hello"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false}`
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+		}
+		var out domain.AgentProcessResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode process response failed: %v", err)
+		}
+		completed := out.Events[len(out.Events)-1]
+		usage, _ := completed.Meta["usage"].(map[string]interface{})
+		if completed.Type != "completed" || usage["total_tokens"] != float64(15) || completed.Meta["provider_id"] != "openai" {
+			t.Fatalf("unexpected completed event: %+v", completed)
+		}
+	}
+
+	w3 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w3, httptest.NewRequest(http.MethodGet, "/chats?user_id=u1", nil))
+	var chats []domain.ChatSpec
+	if err := json.Unmarshal(w3.Body.Bytes(), &chats); err != nil {
+		t.Fatalf("decode chats failed: %v body=%s", err, w3.Body.String())
+	}
+	if len(chats) != 1 || chats[0].Usage == nil || chats[0].Usage.TotalTokens != 30 || chats[0].Usage.PromptTokens != 24 {
+		t.Fatalf("unexpected chat usage: %+v", chats)
+	}
+
+	w4 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w4, httptest.NewRequest(http.MethodGet, "/usage?provider_id=openai", nil))
+	if w4.Code != http.StatusOK {
+		t.Fatalf("usage status=%d body=%s", w4.Code, w4.Body.String())
+	}
+	var summary domain.UsageSummary
+	if err := json.Unmarshal(w4.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("decode usage failed: %v", err)
+	}
+	if summary.Total.Requests != 2 || summary.Total.TotalTokens != 30 {
+		t.Fatalf("unexpected usage total: %+v", summary.Total)
+	}
+	if len(summary.ByModel) != 1 || summary.ByModel[0].Key != "openai/gpt-4o-mini" || len(summary.ByUser) != 1 || summary.ByUser[0].Key != "u1" {
+		t.Fatalf("unexpected usage groups: %+v", summary)
+	}
+
+	w5 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w5, httptest.NewRequest(http.MethodGet, "/usage?user_id=someone-else", nil))
+	var empty domain.UsageSummary
+	if err := json.Unmarshal(w5.Body.Bytes(), &empty); err != nil || empty.Total.Requests != 0 {
+		t.Fatalf("expected empty usage for other user, body=%s", w5.Body.String())
+	}
+
+	w6 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w6, httptest.NewRequest(http.MethodGet, "/usage?from=yesterday", nil))
+	if w6.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid range, got=%d", w6.Code)
+	}
+}
+
+func TestProcessAgentCompactsHistoryToModelContextWindow(t *testing.T) {
+	var received []interface{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		received, _ = req["messages"].([]interface{})
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"compact reply"}}]}`))
+	}))
+	defer mock.Close()
+
+	srv := newTestServer(t)
+	w1 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/openai/config", strings.NewReader(`{"api_key":"sk-test","base_url":"`+mock.URL+`"}`)))
+	if w1.Code != http.StatusOK {
+		t.Fatalf("config provider status=%d body=%s", w1.Code, w1.Body.String())
+	}
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodPut, "/models/active", strings.NewReader(`{"provider_id":"openai","model":"gpt-4o-mini"}`)))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("set active status=%d body=%s", w2.Code, w2.Body.String())
+	}
+
+	long := strings.Repeat("x", 100000)
+	if err := srv.store.Write(func(st *repo.State) error {
+		st.Chats["chat-long"] = domain.ChatSpec{
+			ID: "chat-long", Name: "long", SessionID: "s-long", UserID: "u1", Channel: "console",
+			Meta: map[string]interface{}{}, CreatedAt: nowISO(), UpdatedAt: nowISO(),
+		}
+		history := make([]domain.RuntimeMessage, 0, 6)
+		for i := 0; i < 6; i++ {
+			role := "user"
+			if i%2 == 1 {
+				role = "assistant"
+			}
+			history = append(history, domain.RuntimeMessage{
+				ID:      newID("msg"),
+				Role:    role,
+				Type:    "message",
+				Content: []domain.RuntimeContent{{Type: "text", Text: long}},
+			})
+		}
+		st.Histories["chat-long"] = history
+		return nil
+	}); err != nil {
+		t.Fatalf("seed history failed: %v", err)
+	}
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"latest"}]}],"session_id":"s-long","user_id":"u1","channel":"console","stream":false}`
+	w3 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w3, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w3.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w3.Code, w3.Body.String())
+	}
+	if len(received) == 0 || len(received) >= 8 {
+		t.Fatalf("expected compacted history, got %d messages", len(received))
+	}
+	last := received[len(received)-1].(map[string]interface{})
+	if last["role"] != "user" || last["content"] != "latest" {
+		t.Fatalf("expected latest user message last, got=%v", last)
+	}
+
+	var chat domain.ChatSpec
+	srv.store.Read(func(st *repo.State) {
+		chat = st.Chats["chat-long"]
+	})
+	compaction, ok := chat.Meta[domain.ChatMetaContextCompaction].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected context compaction meta, got=%+v", chat.Meta)
+	}
+	if dropped, _ := compaction["dropped_messages"].(int); dropped == 0 {
+		t.Fatalf("unexpected compaction meta: %+v", compaction)
+	}
+}
+
+func TestAgentRunRegistryCancelsInFlightRun(t *testing.T) {
+	providerStarted := make(chan struct{})
+	releaseProvider := make(chan struct{})
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(providerStarted)
+		select {
+		case <-r.Context().Done():
+		case <-releaseProvider:
+		}
+	}))
+	defer mock.Close()
+	defer close(releaseProvider)
+
+	srv := newTestServer(t)
+	w1 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/openai/config", strings.NewReader(`{"api_key":"sk-test","base_url":"`+mock.URL+`"}`)))
+	if w1.Code != http.StatusOK {
+		t.Fatalf("config provider status=%d body=%s", w1.Code, w1.Body.String())
+	}
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodPut, "/models/active", strings.NewReader(`{"provider_id":"openai","model":"gpt-4o-mini"}`)))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("set active status=%d body=%s", w2.Code, w2.Body.String())
+	}
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"hello"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false}`
+	processDone := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+		processDone <- w
+	}()
+	select {
+	case <-providerStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("provider was not called")
+	}
+
+	w3 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w3, httptest.NewRequest(http.MethodGet, "/agent/runs?status=running", nil))
+	var runs []domain.AgentRunInfo
+	if err := json.Unmarshal(w3.Body.Bytes(), &runs); err != nil {
+		t.Fatalf("decode runs failed: %v body=%s", err, w3.Body.String())
+	}
+	if len(runs) != 1 || runs[0].UserID != "u1" || runs[0].Step != 1 {
+		t.Fatalf("unexpected running runs: %+v", runs)
+	}
+	runID := runs[0].ID
+
+	w4 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w4, httptest.NewRequest(http.MethodPost, "/agent/runs/"+runID+"/cancel", nil))
+	if w4.Code != http.StatusAccepted {
+		t.Fatalf("cancel status=%d body=%s", w4.Code, w4.Body.String())
+	}
+
+	var processResp *httptest.ResponseRecorder
+	select {
+	case processResp = <-processDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent run did not stop after cancel")
+	}
+	if processResp.Code != http.StatusConflict || !strings.Contains(processResp.Body.String(), "agent_run_cancelled") {
+		t.Fatalf("unexpected cancelled response status=%d body=%s", processResp.Code, processResp.Body.String())
+	}
+
+	w5 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w5, httptest.NewRequest(http.MethodGet, "/agent/runs/"+runID, nil))
+	var run domain.AgentRunInfo
+	if err := json.Unmarshal(w5.Body.Bytes(), &run); err != nil {
+		t.Fatalf("decode run failed: %v", err)
+	}
+	if run.Status != "cancelled" || run.FinishedAt == "" {
+		t.Fatalf("unexpected run after cancel: %+v", run)
+	}
+
+	w6 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w6, httptest.NewRequest(http.MethodPost, "/agent/runs/"+runID+"/cancel", nil))
+	if w6.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for finished run, got=%d", w6.Code)
+	}
+	w7 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w7, httptest.NewRequest(http.MethodGet, "/agent/runs/run-missing", nil))
+	if w7.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown run, got=%d", w7.Code)
+	}
+}
+
+func TestProcessAgentReportsRunIDInStepStarted(t *testing.T) {
+	srv := newTestServer(t)
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"hello"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w.Code, w.Body.String())
+	}
+	var out domain.AgentProcessResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode process response failed: %v", err)
+	}
+	runID, _ := out.Events[0].Meta["run_id"].(string)
+	if out.Events[0].Type != "step_started" || runID == "" {
+		t.Fatalf("expected run_id on step_started, got=%+v", out.Events[0])
+	}
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodGet, "/agent/runs/"+runID, nil))
+	var run domain.AgentRunInfo
+	if err := json.Unmarshal(w2.Body.Bytes(), &run); err != nil || run.Status != "completed" {
+		t.Fatalf("expected completed run, body=%s", w2.Body.String())
+	}
+}
+
+func newLoopingToolServer(t *testing.T) (*Server, func()) {
+	t.Helper()
+	toolCalls := `[` +
+		`{"index":0,"id":"call_a","type":"function","function":{"name":"shell","arguments":"{\"items\":[{\"command\":\"printf a\"}]}"}},` +
+		`{"index":1,"id":"call_b","type":"function","function":{"name":"shell","arguments":"{\"items\":[{\"command\":\"printf b\"}]}"}}` +
+		`]`
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if stream, _ := req["stream"].(bool); stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(`data: {"choices":[{"delta":{"tool_calls":` + toolCalls + `}}]}` + "\n\ndata: [DONE]\n\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"","tool_calls":` + toolCalls + `}}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`))
+	}))
+	srv := newTestServer(t)
+	w1 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/openai/config", strings.NewReader(`{"api_key":"sk-test","base_url":"`+mock.URL+`"}`)))
+	if w1.Code != http.StatusOK {
+		t.Fatalf("config provider status=%d body=%s", w1.Code, w1.Body.String())
+	}
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodPut, "/models/active", strings.NewReader(`{"provider_id":"openai","model":"gpt-4o-mini"}`)))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("set active status=%d body=%s", w2.Code, w2.Body.String())
+	}
+	return srv, mock.Close
+}
+
+func TestProcessAgentStopsWhenStepBudgetIsExhausted(t *testing.T) {
+	srv, closeMock := newLoopingToolServer(t)
+	defer closeMock()
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"loop"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false,"biz_params":{"budget":{"max_steps":2}}}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got=%d body=%s", w.Code, w.Body.String())
+	}
+	var body domain.APIErrorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body failed: %v", err)
+	}
+	details, _ := body.Error.Details.(map[string]interface{})
+	if body.Error.Code != "agent_budget_exhausted" || details["reason"] != "max_steps" || details["used"] != float64(2) {
+		t.Fatalf("unexpected budget error: %+v", body.Error)
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/usage", nil))
+	var summary domain.UsageSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("decode usage failed: %v", err)
+	}
+	if summary.Total.Requests != 2 || summary.Total.TotalTokens != 24 {
+		t.Fatalf("expected usage of the stopped run to be recorded, got=%+v", summary.Total)
+	}
+}
+
+func TestProcessAgentStreamEmitsBudgetExhaustedForToolCalls(t *testing.T) {
+	srv, closeMock := newLoopingToolServer(t)
+	defer closeMock()
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"loop"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":true,"biz_params":{"budget":{"max_tool_calls":3}}}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("stream status=%d body=%s", w.Code, w.Body.String())
+	}
+	dataLines := make([]string, 0)
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "data: ") {
+			dataLines = append(dataLines, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	if len(dataLines) < 2 || dataLines[len(dataLines)-1] != "[DONE]" {
+		t.Fatalf("unexpected stream tail: %v", dataLines)
+	}
+	var last domain.AgentEvent
+	if err := json.Unmarshal([]byte(dataLines[len(dataLines)-2]), &last); err != nil {
+		t.Fatalf("decode terminal event failed: %v", err)
+	}
+	if last.Type != "budget_exhausted" || last.Meta["reason"] != "max_tool_calls" || last.Meta["limit"] != float64(3) || last.Step != 2 {
+		t.Fatalf("unexpected terminal event: %+v", last)
+	}
+	if strings.Count(w.Body.String(), `"type":"tool_call"`) != 3 {
+		t.Fatalf("expected exactly 3 tool calls before stopping, body=%s", w.Body.String())
+	}
+}
+
+func TestProcessAgentRejectsInvalidBudget(t *testing.T) {
+	srv := newTestServer(t)
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"hi"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false,"biz_params":{"budget":{"max_steps":0}}}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_budget") {
+		t.Fatalf("expected invalid_budget, got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestResolveAgentBudgetOnlyTightensServerLimits(t *testing.T) {
+	srv := &Server{cfg: config.Config{AgentMaxSteps: 10, AgentMaxToolCalls: 0, AgentMaxDuration: time.Minute}}
+	budget, err := srv.resolveAgentBudget(map[string]interface{}{
+		"budget": map[string]interface{}{"max_steps": float64(50), "max_tool_calls": float64(5), "max_duration_seconds": float64(10)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if budget.MaxSteps != 10 || budget.MaxToolCalls != 5 || budget.MaxDuration != 10*time.Second {
+		t.Fatalf("unexpected budget: %+v", budget)
+	}
+}
+
+func TestAgentRunClockExcludesPausedTime(t *testing.T) {
+	ctx, clock, cancel := agentBudget{MaxDuration: 200 * time.Millisecond}.withDeadline(context.Background())
+	defer cancel()
+
+	clock.pause()
+	time.Sleep(300 * time.Millisecond)
+	if ctx.Err() != nil || clock.elapsed() >= 200*time.Millisecond {
+		t.Fatalf("expected paused time not to count, err=%v elapsed=%v", ctx.Err(), clock.elapsed())
+	}
+	clock.resume()
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the deadline to fire once the clock resumed")
+	}
+	if !agentRunDeadlineExceeded(ctx) {
+		t.Fatalf("expected deadline cause, got=%v", context.Cause(ctx))
+	}
+}
+
+func TestProcessAgentRunsIndependentToolCallsInParallel(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	var toolMessages []interface{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		calls++
+		current := calls
+		mu.Unlock()
+		if current == 1 {
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"","tool_calls":[` +
+				`{"id":"call_slow","type":"function","function":{"name":"shell","arguments":"{\"items\":[{\"command\":\"sleep 0.3; printf slow\"}]}"}},` +
+				`{"id":"call_fast","type":"function","function":{"name":"shell","arguments":"{\"items\":[{\"command\":\"printf fast\"}]}"}}` +
+				`]}}]}`))
+			return
+		}
+		messages, _ := req["messages"].([]interface{})
+		for _, raw := range messages {
+			if msg, _ := raw.(map[string]interface{}); msg["role"] == "tool" {
+				toolMessages = append(toolMessages, msg)
+			}
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"all done"}}]}`))
+	}))
+	defer mock.Close()
+
+	srv := newTestServer(t)
+	w1 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/openai/config", strings.NewReader(`{"api_key":"sk-test","base_url":"`+mock.URL+`"}`)))
+	if w1.Code != http.StatusOK {
+		t.Fatalf("config provider status=%d body=%s", w1.Code, w1.Body.String())
+	}
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodPut, "/models/active", strings.NewReader(`{"provider_id":"openai","model":"gpt-4o-mini"}`)))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("set active status=%d body=%s", w2.Code, w2.Body.String())
+	}
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"run both"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false,"biz_params":{"parallel_tool_calls":2}}`
+	w3 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w3, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w3.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w3.Code, w3.Body.String())
+	}
+	var out domain.AgentProcessResponse
+	if err := json.Unmarshal(w3.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode process response failed: %v", err)
+	}
+	resultOrder := make([]string, 0, 2)
+	for _, evt := range out.Events {
+		if evt.Type == "tool_result" && evt.ToolResult != nil {
+			resultOrder = append(resultOrder, evt.ToolResult.ID)
+		}
+	}
+	if strings.Join(resultOrder, ",") != "call_fast,call_slow" {
+		t.Fatalf("expected results in completion order, got=%v", resultOrder)
+	}
+	if len(toolMessages) != 2 {
+		t.Fatalf("expected 2 tool messages in follow-up request, got=%v", toolMessages)
+	}
+	first := toolMessages[0].(map[string]interface{})
+	second := toolMessages[1].(map[string]interface{})
+	if first["tool_call_id"] != "call_slow" || second["tool_call_id"] != "call_fast" {
+		t.Fatalf("expected tool messages in original call order, got=%v", toolMessages)
+	}
+}
+
+func TestProcessAgentRejectsInvalidParallelToolCalls(t *testing.T) {
+	srv := newTestServer(t)
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"hi"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false,"biz_params":{"parallel_tool_calls":"yes"}}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestProcessAgentWaitsForToolApproval(t *testing.T) {
+	t.Setenv("NEXTAI_TOOL_APPROVAL", "shell")
+	var mu sync.Mutex
+	calls := 0
+	var toolMessages []interface{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"","tool_calls":[` +
+				`{"id":"call_a","type":"function","function":{"name":"shell","arguments":"{\"items\":[{\"command\":\"printf approved\"}]}"}},` +
+				`{"id":"call_b","type":"function","function":{"name":"shell","arguments":"{\"items\":[{\"command\":\"printf denied\"}]}"}}` +
+				`]}}]}`))
+			return
+		}
+		messages, _ := req["messages"].([]interface{})
+		for _, raw := range messages {
+			if msg, _ := raw.(map[string]interface{}); msg["role"] == "tool" {
+				toolMessages = append(toolMessages, msg)
+			}
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"all done"}}]}`))
+	}))
+	defer mock.Close()
+
+	srv := newTestServer(t)
+	w1 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w1, httptest.NewRequest(http.MethodPut, "/models/openai/config", strings.NewReader(`{"api_key":"sk-test","base_url":"`+mock.URL+`"}`)))
+	if w1.Code != http.StatusOK {
+		t.Fatalf("config provider status=%d body=%s", w1.Code, w1.Body.String())
+	}
+	w2 := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w2, httptest.NewRequest(http.MethodPut, "/models/active", strings.NewReader(`{"provider_id":"openai","model":"gpt-4o-mini"}`)))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("set active status=%d body=%s", w2.Code, w2.Body.String())
+	}
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"run both"}]}],"session_id":"s1","user_id":"u1","channel":"console","stream":false}`
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+		done <- w
+	}()
+
+	var run domain.AgentRunInfo
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/agent/runs?status=running", nil))
+		var runs []domain.AgentRunInfo
+		_ = json.Unmarshal(w.Body.Bytes(), &runs)
+		if len(runs) == 1 && len(runs[0].PendingApprovals) == 2 {
+			run = runs[0]
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected two pending approvals, got=%s", w.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	wInvalid := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(wInvalid, httptest.NewRequest(http.MethodPost, "/agent/runs/"+run.ID+"/approvals/call_a", strings.NewReader(`{"decision":"maybe"}`)))
+	if wInvalid.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid decision, got=%d body=%s", wInvalid.Code, wInvalid.Body.String())
+	}
+	wMissing := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(wMissing, httptest.NewRequest(http.MethodPost, "/agent/runs/"+run.ID+"/approvals/call_x", strings.NewReader(`{"decision":"approve"}`)))
+	if wMissing.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown call, got=%d body=%s", wMissing.Code, wMissing.Body.String())
+	}
+	wApprove := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(wApprove, httptest.NewRequest(http.MethodPost, "/agent/runs/"+run.ID+"/approvals/call_a", strings.NewReader(`{"decision":"approve"}`)))
+	if wApprove.Code != http.StatusOK {
+		t.Fatalf("approve status=%d body=%s", wApprove.Code, wApprove.Body.String())
+	}
+	wDeny := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(wDeny, httptest.NewRequest(http.MethodPost, "/agent/runs/"+run.ID+"/approvals/call_b", strings.NewReader(`{"decision":"deny","reason":"not now"}`)))
+	if wDeny.Code != http.StatusOK {
+		t.Fatalf("deny status=%d body=%s", wDeny.Code, wDeny.Body.String())
+	}
+
+	var w3 *httptest.ResponseRecorder
+	select {
+	case w3 = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent run did not resume after approvals")
+	}
+	if w3.Code != http.StatusOK {
+		t.Fatalf("process status=%d body=%s", w3.Code, w3.Body.String())
+	}
+	var out domain.AgentProcessResponse
+	if err := json.Unmarshal(w3.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode process response failed: %v", err)
+	}
+	required := 0
+	for _, evt := range out.Events {
+		if evt.Type == "approval_required" {
+			required++
+		}
+	}
+	if required != 2 {
+		t.Fatalf("expected 2 approval_required events, got=%+v", out.Events)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(toolMessages) != 2 {
+		t.Fatalf("expected 2 tool messages, got=%v", toolMessages)
+	}
+	approved, _ := toolMessages[0].(map[string]interface{})["content"].(string)
+	rejected, _ := toolMessages[1].(map[string]interface{})["content"].(string)
+	if !strings.Contains(approved, "approved") || !strings.Contains(rejected, "tool_call_denied") || !strings.Contains(rejected, "not now") {
+		t.Fatalf("unexpected tool messages: %v", toolMessages)
+	}
+}
+
+func TestSkillScriptsFollowShellApprovalAndDisabledState(t *testing.T) {
+	t.Setenv("NEXTAI_TOOL_APPROVAL", "shell")
+	var mu sync.Mutex
+	calls := 0
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"","tool_calls":[` +
+				`{"id":"call_ship","type":"function","function":{"name":"skill__deploy__ship","arguments":"{}"}}` +
+				`]}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"done"}}]}`))
+	}))
+	defer mock.Close()
+
+	srv := newTestServer(t)
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPut, "/models/openai/config", `{"api_key":"sk-test","base_url":"` + mock.URL + `"}`},
+		{http.MethodPut, "/models/active", `{"provider_id":"openai","model":"gpt-4o-mini"}`},
+		{http.MethodPost, "/skills", `{"name":"deploy","content":"ship","scripts":{"ship.sh":"printf shipped"},"tools":[{"name":"ship","script":"ship.sh"}]}`},
+	} {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s status=%d body=%s", req.method, req.path, w.Code, w.Body.String())
+		}
+	}
+
+	procReq := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"ship it"}]}],"session_id":"s-skill-approval","user_id":"u1","channel":"console","stream":false}`
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+		done <- w
+	}()
+	var run domain.AgentRunInfo
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/agent/runs?status=running", nil))
+		var runs []domain.AgentRunInfo
+		_ = json.Unmarshal(w.Body.Bytes(), &runs)
+		if len(runs) == 1 && len(runs[0].PendingApprovals) == 1 {
+			run = runs[0]
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the skill script to wait for approval, got=%s", w.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if run.PendingApprovals[0].Name != "skill.deploy.ship" {
+		t.Fatalf("unexpected pending approval: %+v", run.PendingApprovals[0])
+	}
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/runs/"+run.ID+"/approvals/call_ship", strings.NewReader(`{"decision":"deny"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("deny status=%d body=%s", w.Code, w.Body.String())
+	}
+	select {
+	case w = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent run did not resume after the decision")
+	}
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"type":"approval_required"`) {
+		t.Fatalf("expected approval_required for the skill script, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/tools/shell", strings.NewReader(`{"enabled":false}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("disable shell status=%d body=%s", w.Code, w.Body.String())
+	}
+	direct := `{"input":[{"role":"user","type":"message","content":[{"type":"text","text":"ship"}]}],"session_id":"s-skill-approval","user_id":"u1","channel":"console","stream":false,"biz_params":{"tool":{"name":"skill.deploy.ship","input":{}}}}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(direct)))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"tool_disabled"`) {
+		t.Fatalf("expected skill script to be disabled with shell, got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestToolApprovalPolicyMergesChatMeta(t *testing.T) {
+	t.Setenv("NEXTAI_TOOL_APPROVAL", "edit")
+	srv := newTestServer(t)
+	policy := srv.resolveToolApprovalPolicy(map[string]interface{}{"tool_approval": []interface{}{"Shell"}})
+	if !policy.requires("edit") || !policy.requires("shell") || policy.requires("view") {
+		t.Fatalf("unexpected policy: %v", policy)
+	}
+	if !srv.resolveToolApprovalPolicy(map[string]interface{}{"tool_approval": "*"}).requires("view") {
+		t.Fatal("expected wildcard chat policy to require every tool")
+	}
+}
+
+func TestPutToolConfigTogglesToolAtRuntime(t *testing.T) {
+	t.Setenv("NEXTAI_DISABLED_TOOLS", "shell")
+	srv := newTestServer(t)
+	shellReq := `{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"/shell"}]}],
+		"session_id":"s-tool-toggle",
+		"user_id":"u-tool-toggle",
+		"channel":"console",
+		"stream":false,
+		"biz_params":{"tool":{"name":"shell","items":[{"command":"printf toggled"}]}}
+	}`
+	process := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(shellReq)))
+		return w
+	}
+	if w := process(); w.Code != http.StatusForbidden {
+		t.Fatalf("expected env-disabled shell, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/tools/shell", strings.NewReader(`{"enabled":true}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"enabled":true`) {
+		t.Fatalf("enable shell status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := process(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "toggled") {
+		t.Fatalf("expected shell to run after enabling, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/tools/view", strings.NewReader(`{"enabled":false}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("disable view status=%d body=%s", w.Code, w.Body.String())
+	}
+	for _, def := range srv.listToolDefinitions(nil) {
+		if def.Name == "view" {
+			t.Fatal("expected disabled view to be hidden from the model")
+		}
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/tools/shell", strings.NewReader(`{"config":{"x":1}}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"tool_not_configurable"`) {
+		t.Fatalf("expected tool_not_configurable, got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/tools/desktop", strings.NewReader(`{"enabled":true}`)))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown tool, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	reloaded := newTestServerWithDataDir(t, srv.cfg.DataDir)
+	if reloaded.toolDisabled("shell") || !reloaded.toolDisabled("view") {
+		t.Fatal("expected tool toggles to survive a restart")
+	}
+}
+
+func TestPutToolConfigBuildsSearchTool(t *testing.T) {
+	for _, key := range []string{"NEXTAI_ENABLE_SEARCH_TOOL", "NEXTAI_SEARCH_SERPAPI_KEY", "NEXTAI_SEARCH_TAVILY_KEY", "NEXTAI_SEARCH_BRAVE_KEY", "NEXTAI_SEARCH_DEFAULT_PROVIDER"} {
+		t.Setenv(key, "")
+	}
+	srv := newTestServer(t)
+	if _, ok := srv.toolPlugin("search"); ok {
+		t.Fatal("expected search to be unregistered without config")
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/tools/search", strings.NewReader(`{"enabled":true}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"invalid_tool_config"`) {
+		t.Fatalf("expected invalid_tool_config, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/tools/search", strings.NewReader(`{"enabled":true,"config":{"providers":{"tavily":{"api_key":"k"}}}}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"enabled":true`) {
+		t.Fatalf("configure search status=%d body=%s", w.Code, w.Body.String())
+	}
+	if _, ok := srv.toolPlugin("search"); !ok {
+		t.Fatal("expected search to be registered after configuring it")
+	}
+	reloaded := newTestServerWithDataDir(t, srv.cfg.DataDir)
+	if _, ok := reloaded.toolPlugin("search"); !ok {
+		t.Fatal("expected stored search config to register the tool on startup")
+	}
+}
+
+func TestToolConfigMasksSecretsAndKeepsThemOnUpdate(t *testing.T) {
+	for _, key := range []string{"NEXTAI_ENABLE_SEARCH_TOOL", "NEXTAI_SEARCH_SERPAPI_KEY", "NEXTAI_SEARCH_TAVILY_KEY", "NEXTAI_SEARCH_BRAVE_KEY", "NEXTAI_SEARCH_DEFAULT_PROVIDER"} {
+		t.Setenv(key, "")
+	}
+	srv := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/tools/search", strings.NewReader(`{"enabled":true,"config":{"providers":{"tavily":{"api_key":"tvly-secret-value"}}}}`)))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "tvly-secret-value") || !strings.Contains(w.Body.String(), `"api_key":"tvl***lue"`) {
+		t.Fatalf("expected masked api_key in put response, got=%d body=%s", w.Code, w.Body.String())
+	}
+	for _, path := range []string{"/config/tools", "/config/tools/search"} {
+		w = httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "tvly-secret-value") {
+			t.Fatalf("expected %s to mask the api_key, got=%d body=%s", path, w.Code, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/tools/search", strings.NewReader(`{"config":{"providers":{"tavily":{"api_key":"tvl***lue","max_results":3}}}}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("update search status=%d body=%s", w.Code, w.Body.String())
+	}
+	var stored interface{}
+	srv.store.Read(func(st *repo.State) {
+		stored = st.Tools["search"].Config["providers"].(map[string]interface{})["tavily"].(map[string]interface{})["api_key"]
+	})
+	if stored != "tvly-secret-value" {
+		t.Fatalf("expected masked api_key to keep the stored value, got=%v", stored)
+	}
+}
+
+func TestChatAllowedToolsLimitsTools(t *testing.T) {
+	srv := newTestServer(t)
+	createReq := `{"name":"limited","session_id":"s-allowed","user_id":"u-allowed","channel":"console","meta":{"allowed_tools":["view"]}}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/chats", strings.NewReader(createReq)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("create chat status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	procReq := `{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"/shell"}]}],
+		"session_id":"s-allowed",
+		"user_id":"u-allowed",
+		"channel":"console",
+		"stream":false,
+		"biz_params":{"tool":{"name":"shell","items":[{"command":"pwd"}]}}
+	}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"tool_not_allowed"`) {
+		t.Fatalf("expected 403 tool_not_allowed, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	defs := srv.listToolDefinitions(resolveToolAllowlist(map[string]interface{}{"allowed_tools": "view, Edit"}))
+	names := make([]string, 0, len(defs))
+	for _, def := range defs {
+		names = append(names, def.Name)
+	}
+	if strings.Join(names, ",") != "edit,view" {
+		t.Fatalf("unexpected allowed tool definitions: %v", names)
+	}
+}
+
+func newMCPStubServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params struct {
+				Arguments map[string]interface{} `json:"arguments"`
+			} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil || len(msg.ID) == 0 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		var result interface{}
+		switch msg.Method {
+		case "initialize":
+			result = map[string]interface{}{"protocolVersion": "2025-03-26", "capabilities": map[string]interface{}{}, "serverInfo": map[string]interface{}{"name": "stub"}}
+		case "tools/list":
+			result = map[string]interface{}{"tools": []interface{}{map[string]interface{}{
+				"name":        "echo",
+				"description": "Echo the text back.",
+				"inputSchema": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}},
+					"required":   []string{"text"},
+				},
+			}}}
+		case "tools/call":
+			result = map[string]interface{}{"content": []interface{}{map[string]interface{}{"type": "text", "text": fmt.Sprintf("echo: %v", msg.Params.Arguments["text"])}}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func TestMCPServerToolsAreRegistered(t *testing.T) {
+	stub := newMCPStubServer(t)
+	srv := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/mcp-servers/stub", strings.NewReader(`{"url":"`+stub.URL+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("put mcp server status=%d body=%s", w.Code, w.Body.String())
+	}
+	var info domain.MCPServerInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode mcp server info failed: %v", err)
+	}
+	if info.Status != "connected" || len(info.Tools) != 1 || info.Tools[0] != "mcp__stub__echo" {
+		t.Fatalf("unexpected mcp server info: %+v", info)
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tools", nil))
+	if !strings.Contains(w.Body.String(), `"name":"mcp__stub__echo","description":"Echo the text back."`) {
+		t.Fatalf("expected mcp tool in /tools, body=%s", w.Body.String())
+	}
+
+	procReq := `{
+		"input":[{"role":"user","type":"message","content":[{"type":"text","text":"echo"}]}],
+		"session_id":"s-mcp",
+		"user_id":"u-mcp",
+		"channel":"console",
+		"stream":false,
+		"biz_params":{"tool":{"name":"mcp__stub__echo","input":{"text":"hello"}}}
+	}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agent/process", strings.NewReader(procReq)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "echo: hello") {
+		t.Fatalf("expected mcp tool result, got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	reloaded := newTestServerWithDataDir(t, srv.cfg.DataDir)
+	if _, ok := reloaded.toolPlugin("mcp__stub__echo"); !ok {
+		t.Fatal("expected stored mcp server to reconnect on startup")
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/config/mcp-servers/stub", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete mcp server status=%d body=%s", w.Code, w.Body.String())
+	}
+	if _, ok := srv.toolPlugin("mcp__stub__echo"); ok {
+		t.Fatal("expected mcp tools to be removed with the server")
+	}
+}
+
+func TestMCPServerMasksSecretHeadersAndEnv(t *testing.T) {
+	stub := newMCPStubServer(t)
+	srv := newTestServer(t)
+
+	body := `{"url":"` + stub.URL + `","headers":{"Authorization":"Bearer secret-token","X-Trace":"on"},"env":{"GITHUB_TOKEN":"ghp-secret-value"}}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/mcp-servers/stub", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("put mcp server status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config/mcp-servers", nil))
+	var infos []domain.MCPServerInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil || len(infos) != 1 {
+		t.Fatalf("decode mcp servers failed: %v body=%s", err, w.Body.String())
+	}
+	info := infos[0]
+	if info.Headers["Authorization"] != "Bea***ken" || info.Headers["X-Trace"] != "on" || info.Env["GITHUB_TOKEN"] != "ghp***lue" {
+		t.Fatalf("expected secrets to be masked, got headers=%v env=%v", info.Headers, info.Env)
+	}
+
+	update, _ := json.Marshal(map[string]interface{}{"url": stub.URL, "headers": info.Headers, "env": info.Env})
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/mcp-servers/stub", bytes.NewReader(update)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("update mcp server status=%d body=%s", w.Code, w.Body.String())
+	}
+	srv.store.Read(func(st *repo.State) {
+		cfg := st.MCPServers["stub"]
+		if cfg.Headers["Authorization"] != "Bearer secret-token" || cfg.Env["GITHUB_TOKEN"] != "ghp-secret-value" {
+			t.Fatalf("expected masked secrets to keep the stored values, got headers=%v env=%v", cfg.Headers, cfg.Env)
+		}
+	})
+}
+
+func TestPutMCPServerRejectsUnreachableServer(t *testing.T) {
+	srv := newTestServer(t)
+	cases := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{name: "stub", body: `{}`, status: http.StatusBadRequest, code: "invalid_mcp_server"},
+		{name: "Bad.Name", body: `{"url":"http://127.0.0.1:1"}`, status: http.StatusBadRequest, code: "invalid_mcp_server"},
+		{name: "stub", body: `{"command":"` + filepath.Join(t.TempDir(), "missing") + `"}`, status: http.StatusBadGateway, code: "mcp_server_unavailable"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/mcp-servers/"+tc.name, strings.NewReader(tc.body)))
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), `"code":"`+tc.code+`"`) {
+			t.Fatalf("body=%s: expected %d %s, got=%d body=%s", tc.body, tc.status, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config/mcp-servers", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("expected nothing saved, got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestSetFallbackModelsRejectsUnknownProvider(t *testing.T) {
+	srv := newTestServer(t)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/models/active/fallbacks", strings.NewReader(`{"fallback_llms":[{"provider_id":"missing","model":"m"}]}`)))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
 func TestSetActiveModelsRejectsDisabledProvider(t *testing.T) {
 	srv := newTestServer(t)
 
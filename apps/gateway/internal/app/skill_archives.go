@@ -0,0 +1,562 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/repo"
+)
+
+const (
+	skillArchiveMaxBytes     = 8 << 20
+	skillArchiveMaxUnpacked  = 32 << 20
+	skillArchiveChecksumFile = "checksum.sha256"
+	skillVersionsDirName     = "skill-versions"
+
+	skillChangeNew       = "new"
+	skillChangeUpgrade   = "upgrade"
+	skillChangeDowngrade = "downgrade"
+	skillChangeUnchanged = "unchanged"
+	skillChangeReplace   = "replace"
+)
+
+var (
+	errSkillArchiveInvalid   = errors.New("invalid_skill_archive")
+	errSkillChecksumMismatch = errors.New("skill_checksum_mismatch")
+	errSkillVersionConflict  = errors.New("skill_version_conflict")
+	errSkillVersionNotFound  = errors.New("skill_version_not_found")
+)
+
+var (
+	skillArchiveNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+	semverPattern           = regexp.MustCompile(`^(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+)
+
+func validSemver(version string) bool {
+	return semverPattern.MatchString(version)
+}
+
+// compareSemver orders two valid versions by semver precedence; build
+// metadata is ignored.
+func compareSemver(a, b string) int {
+	ma, mb := semverPattern.FindStringSubmatch(a), semverPattern.FindStringSubmatch(b)
+	for i := 1; i <= 3; i++ {
+		x, _ := strconv.ParseUint(ma[i], 10, 64)
+		y, _ := strconv.ParseUint(mb[i], 10, 64)
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case ma[4] == mb[4]:
+		return 0
+	case ma[4] == "":
+		return 1
+	case mb[4] == "":
+		return -1
+	}
+	pa, pb := strings.Split(ma[4], "."), strings.Split(mb[4], ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] == pb[i] {
+			continue
+		}
+		x, errX := strconv.ParseUint(pa[i], 10, 64)
+		y, errY := strconv.ParseUint(pb[i], 10, 64)
+		switch {
+		case errX == nil && errY == nil:
+			if x < y {
+				return -1
+			}
+			return 1
+		case errX == nil:
+			return -1
+		case errY == nil:
+			return 1
+		case pa[i] < pb[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch {
+	case len(pa) < len(pb):
+		return -1
+	case len(pa) > len(pb):
+		return 1
+	}
+	return 0
+}
+
+// renderSkillManifest writes SKILL.md for an archive. Front matter already in
+// the content is folded into the generated one.
+func renderSkillManifest(spec domain.SkillSpec) string {
+	manifest, body := parseSkillManifest(spec.Content)
+	description := strings.Join(strings.Fields(spec.Description), " ")
+	if description == "" {
+		description = manifest.Description
+	}
+	triggers := spec.Triggers
+	if len(triggers) == 0 {
+		triggers = manifest.Triggers
+	}
+	lines := []string{"---", "name: " + spec.Name}
+	if spec.Version != "" {
+		lines = append(lines, "version: "+spec.Version)
+	}
+	if description != "" {
+		lines = append(lines, `description: "`+description+`"`)
+	}
+	if len(triggers) > 0 {
+		lines = append(lines, "triggers:")
+		for _, trigger := range triggers {
+			lines = append(lines, `  - "`+trigger+`"`)
+		}
+	}
+	lines = append(lines, "---", "", body)
+	return strings.Join(lines, "\n")
+}
+
+// skillArchiveFiles lays a skill out the way a skill directory does. Both the
+// exported archive and the checksum are derived from it, so a skill keeps its
+// checksum across export and import.
+func skillArchiveFiles(spec domain.SkillSpec) (map[string]string, error) {
+	files := map[string]string{skillManifestFile: renderSkillManifest(spec)}
+	if len(spec.Tools) > 0 {
+		raw, err := json.MarshalIndent(spec.Tools, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files[skillToolsFile] = string(raw) + "\n"
+	}
+	for _, prefix := range []string{skillDirReferences, skillDirScripts} {
+		node := spec.References
+		if prefix == skillDirScripts {
+			node = spec.Scripts
+		}
+		for _, path := range skillFilePaths(prefix, node) {
+			if content, ok := readSkillVirtualFile(spec, path); ok {
+				files[path] = content
+			}
+		}
+	}
+	return files, nil
+}
+
+func skillFilesChecksum(files map[string]string) string {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	hash := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(hash, "%s\x00%d\x00", path, len(files[path]))
+		io.WriteString(hash, files[path])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeSkillArchive zips the files under a <name>/ folder together with
+// checksum.sha256.
+func writeSkillArchive(name string, files map[string]string, checksum string) ([]byte, error) {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(path, content string) error {
+		fw, err := zw.Create(name + "/" + path)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, content)
+		return err
+	}
+	for _, path := range paths {
+		if err := write(path, files[path]); err != nil {
+			return nil, err
+		}
+	}
+	if err := write(skillArchiveChecksumFile, checksum+"\n"); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readSkillArchive loads a skill from a zip archive. SKILL.md may sit at the
+// root or inside a single top-level folder, whose name is the fallback skill
+// name. A bundled checksum.sha256 must match the archive contents.
+func readSkillArchive(raw []byte) (domain.SkillSpec, string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return domain.SkillSpec{}, "", fmt.Errorf("%w: %v", errSkillArchiveInvalid, err)
+	}
+	var unpacked uint64
+	roots := map[string]struct{}{}
+	for _, file := range zr.File {
+		name := strings.TrimSuffix(file.Name, "/")
+		if !fs.ValidPath(name) {
+			return domain.SkillSpec{}, "", fmt.Errorf("%w: unsafe path %q", errSkillArchiveInvalid, file.Name)
+		}
+		if unpacked += file.UncompressedSize64; unpacked > skillArchiveMaxUnpacked {
+			return domain.SkillSpec{}, "", fmt.Errorf("%w: archive unpacks to more than %d bytes", errSkillArchiveInvalid, skillArchiveMaxUnpacked)
+		}
+		roots[strings.SplitN(name, "/", 2)[0]] = struct{}{}
+	}
+
+	var fsys fs.FS = zr
+	fallbackName := ""
+	if _, err := fs.Stat(zr, skillManifestFile); err != nil && len(roots) == 1 {
+		for root := range roots {
+			fallbackName = root
+		}
+		if fsys, err = fs.Sub(zr, fallbackName); err != nil {
+			return domain.SkillSpec{}, "", fmt.Errorf("%w: %v", errSkillArchiveInvalid, err)
+		}
+	}
+	spec, err := loadSkillPackage(fsys, fallbackName)
+	if err != nil {
+		return domain.SkillSpec{}, "", fmt.Errorf("%w: %v", errSkillArchiveInvalid, err)
+	}
+	if !skillArchiveNamePattern.MatchString(spec.Name) {
+		return domain.SkillSpec{}, "", fmt.Errorf("%w: skill name must match [A-Za-z0-9][A-Za-z0-9._-]{0,63}", errSkillArchiveInvalid)
+	}
+	if !validSemver(spec.Version) {
+		return domain.SkillSpec{}, "", fmt.Errorf("%w: %s must set a semver version, got %q", errSkillArchiveInvalid, skillManifestFile, spec.Version)
+	}
+	files, err := skillArchiveFiles(spec)
+	if err != nil {
+		return domain.SkillSpec{}, "", err
+	}
+	checksum := skillFilesChecksum(files)
+	if bundled, err := fs.ReadFile(fsys, skillArchiveChecksumFile); err == nil {
+		if fields := strings.Fields(string(bundled)); len(fields) == 0 || !strings.EqualFold(fields[0], checksum) {
+			return domain.SkillSpec{}, "", fmt.Errorf("%w: archive contents do not match %s", errSkillChecksumMismatch, skillArchiveChecksumFile)
+		}
+	}
+	return spec, checksum, nil
+}
+
+func diffSkillFiles(before, after map[string]string) (added, removed, modified []string) {
+	added, removed, modified = []string{}, []string{}, []string{}
+	for path, content := range after {
+		previous, ok := before[path]
+		switch {
+		case !ok:
+			added = append(added, path)
+		case previous != content:
+			modified = append(modified, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(modified)
+	return added, removed, modified
+}
+
+func (s *Server) skillVersionsDir(name string) string {
+	return filepath.Join(s.cfg.DataDir, skillVersionsDirName, name)
+}
+
+// saveSkillVersion keeps a skill in the version history. A version is never
+// overwritten: the same version with other contents is a conflict.
+func (s *Server) saveSkillVersion(spec domain.SkillSpec, files map[string]string, checksum string) error {
+	dir := s.skillVersionsDir(spec.Name)
+	path := filepath.Join(dir, spec.Version+".zip")
+	if raw, err := os.ReadFile(path); err == nil {
+		if _, stored, err := readSkillArchive(raw); err == nil && stored == checksum {
+			return nil
+		}
+		return fmt.Errorf("%w: version %s of %s is already stored with other contents", errSkillVersionConflict, spec.Version, spec.Name)
+	}
+	archive, err := writeSkillArchive(spec.Name, files, checksum)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, archive, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Server) loadSkillVersion(name, version string) (domain.SkillSpec, string, error) {
+	if !skillArchiveNamePattern.MatchString(name) || !validSemver(version) {
+		return domain.SkillSpec{}, "", fmt.Errorf("%w: %s@%s", errSkillVersionNotFound, name, version)
+	}
+	raw, err := os.ReadFile(filepath.Join(s.skillVersionsDir(name), version+".zip"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return domain.SkillSpec{}, "", fmt.Errorf("%w: %s@%s", errSkillVersionNotFound, name, version)
+		}
+		return domain.SkillSpec{}, "", err
+	}
+	return readSkillArchive(raw)
+}
+
+func (s *Server) storedSkillVersions(name string) ([]domain.SkillVersionInfo, error) {
+	out := []domain.SkillVersionInfo{}
+	if !skillArchiveNamePattern.MatchString(name) {
+		return out, nil
+	}
+	entries, err := os.ReadDir(s.skillVersionsDir(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return out, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		version, ok := strings.CutSuffix(entry.Name(), ".zip")
+		if !ok || entry.IsDir() || !validSemver(version) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		_, checksum, err := s.loadSkillVersion(name, version)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, domain.SkillVersionInfo{
+			Version:   version,
+			Checksum:  checksum,
+			Size:      info.Size(),
+			CreatedAt: info.ModTime().UTC().Format(time.RFC3339),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return compareSemver(out[i].Version, out[j].Version) > 0 })
+	return out, nil
+}
+
+// applySkillArchive installs a skill read from an archive and reports how it
+// differs from the installed one. Both the replaced version, when it has one,
+// and the new version are kept in the version history. Reinstalling the
+// installed version changes nothing.
+func (s *Server) applySkillArchive(spec domain.SkillSpec, checksum string, dryRun bool) (domain.SkillImportResult, error) {
+	files, err := skillArchiveFiles(spec)
+	if err != nil {
+		return domain.SkillImportResult{}, err
+	}
+	var current domain.SkillSpec
+	exists := false
+	s.store.Read(func(st *repo.State) {
+		current, exists = st.Skills[spec.Name]
+	})
+
+	result := domain.SkillImportResult{Name: spec.Name, Version: spec.Version, Checksum: checksum, Change: skillChangeNew}
+	var previousFiles map[string]string
+	if exists {
+		if previousFiles, err = skillArchiveFiles(current); err != nil {
+			return domain.SkillImportResult{}, err
+		}
+		result.PreviousVersion = current.Version
+		result.PreviousChecksum = skillFilesChecksum(previousFiles)
+		switch {
+		case !validSemver(current.Version):
+			result.Change = skillChangeReplace
+		case current.Version == spec.Version && result.PreviousChecksum == checksum:
+			result.Change = skillChangeUnchanged
+		case current.Version == spec.Version:
+			return domain.SkillImportResult{}, fmt.Errorf("%w: version %s of %s is installed with other contents", errSkillVersionConflict, spec.Version, spec.Name)
+		case compareSemver(spec.Version, current.Version) > 0:
+			result.Change = skillChangeUpgrade
+		default:
+			result.Change = skillChangeDowngrade
+		}
+	}
+	result.Added, result.Removed, result.Modified = diffSkillFiles(previousFiles, files)
+	if _, stored, err := s.loadSkillVersion(spec.Name, spec.Version); err == nil && stored != checksum {
+		return domain.SkillImportResult{}, fmt.Errorf("%w: version %s of %s is already stored with other contents", errSkillVersionConflict, spec.Version, spec.Name)
+	}
+	if dryRun {
+		return result, nil
+	}
+	if result.Change == skillChangeUnchanged {
+		return result, s.saveSkillVersion(spec, files, checksum)
+	}
+
+	if exists && validSemver(current.Version) && current.Version != spec.Version {
+		if err := s.saveSkillVersion(current, previousFiles, result.PreviousChecksum); err != nil && !errors.Is(err, errSkillVersionConflict) {
+			return domain.SkillImportResult{}, err
+		}
+	}
+	if err := s.saveSkillVersion(spec, files, checksum); err != nil {
+		return domain.SkillImportResult{}, err
+	}
+	spec.Source = skillSourceCustomized
+	spec.Path = filepath.Join(s.skillsDir(), spec.Name)
+	spec.Enabled = !exists || current.Enabled
+	if err := s.store.Write(func(st *repo.State) error {
+		st.Skills[spec.Name] = spec
+		return nil
+	}); err != nil {
+		return domain.SkillImportResult{}, err
+	}
+	s.syncSkillTools()
+	result.Applied = true
+	return result, nil
+}
+
+func writeSkillArchiveErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errSkillArchiveInvalid):
+		writeErr(w, http.StatusBadRequest, "invalid_skill_archive", err.Error(), nil)
+	case errors.Is(err, errSkillChecksumMismatch):
+		writeErr(w, http.StatusBadRequest, "skill_checksum_mismatch", err.Error(), nil)
+	case errors.Is(err, errSkillVersionConflict):
+		writeErr(w, http.StatusConflict, "skill_version_conflict", err.Error(), nil)
+	case errors.Is(err, errSkillVersionNotFound):
+		writeErr(w, http.StatusNotFound, "skill_version_not_found", err.Error(), nil)
+	default:
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+	}
+}
+
+// importSkillArchive takes a zip archive as the raw request body. With
+// dry_run=true only the version diff is returned.
+func (s *Server) importSkillArchive(w http.ResponseWriter, r *http.Request) {
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, skillArchiveMaxBytes))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_skill_archive", fmt.Sprintf("archive must be a zip file of at most %d bytes", skillArchiveMaxBytes), nil)
+		return
+	}
+	spec, checksum, err := readSkillArchive(raw)
+	if err != nil {
+		writeSkillArchiveErr(w, err)
+		return
+	}
+	result, err := s.applySkillArchive(spec, checksum, parseBool(r.URL.Query().Get("dry_run")))
+	if err != nil {
+		writeSkillArchiveErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// exportSkillArchive downloads a skill as a zip archive. A skill without a
+// version needs ?version= to stamp one.
+func (s *Server) exportSkillArchive(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "skill_name")
+	var spec domain.SkillSpec
+	found := false
+	s.store.Read(func(st *repo.State) {
+		spec, found = st.Skills[name]
+	})
+	if !found {
+		writeErr(w, http.StatusNotFound, "not_found", "skill not found", nil)
+		return
+	}
+	if !skillArchiveNamePattern.MatchString(spec.Name) {
+		writeErr(w, http.StatusBadRequest, "invalid_skill_archive", "skill name must match [A-Za-z0-9][A-Za-z0-9._-]{0,63} to be exported", nil)
+		return
+	}
+	if version := strings.TrimSpace(r.URL.Query().Get("version")); version != "" {
+		spec.Version = version
+	}
+	if !validSemver(spec.Version) {
+		writeErr(w, http.StatusBadRequest, "invalid_skill_version", "skill has no semver version; pass ?version=", nil)
+		return
+	}
+	files, err := skillArchiveFiles(spec)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+		return
+	}
+	checksum := skillFilesChecksum(files)
+	archive, err := writeSkillArchive(spec.Name, files, checksum)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.zip"`, spec.Name, spec.Version))
+	w.Header().Set("X-Skill-Version", spec.Version)
+	w.Header().Set("X-Skill-Checksum", checksum)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(archive)
+}
+
+func (s *Server) listSkillVersions(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "skill_name")
+	var spec domain.SkillSpec
+	found := false
+	s.store.Read(func(st *repo.State) {
+		spec, found = st.Skills[name]
+	})
+	versions, err := s.storedSkillVersions(name)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+		return
+	}
+	if !found && len(versions) == 0 {
+		writeErr(w, http.StatusNotFound, "not_found", "skill not found", nil)
+		return
+	}
+	if found {
+		if files, err := skillArchiveFiles(spec); err == nil {
+			checksum := skillFilesChecksum(files)
+			for i := range versions {
+				versions[i].Current = versions[i].Version == spec.Version && versions[i].Checksum == checksum
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+// rollbackSkill reinstalls a version from the history.
+func (s *Server) rollbackSkill(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_json", "invalid request body", nil)
+		return
+	}
+	spec, checksum, err := s.loadSkillVersion(chi.URLParam(r, "skill_name"), strings.TrimSpace(body.Version))
+	if err != nil {
+		writeSkillArchiveErr(w, err)
+		return
+	}
+	result, err := s.applySkillArchive(spec, checksum, false)
+	if err != nil {
+		writeSkillArchiveErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,348 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/repo"
+)
+
+const (
+	skillSourceBuiltin    = "builtin"
+	skillSourceCustomized = "customized"
+	skillSourceFilesystem = "filesystem"
+
+	skillManifestFile  = "SKILL.md"
+	skillDirReferences = "references"
+	skillDirScripts    = "scripts"
+	skillFileMaxBytes  = 256 * 1024
+	skillTreeMaxDepth  = 8
+)
+
+// skillLoadedFromDisk reports whether a skill is owned by a skill directory.
+// Such skills are replaced on every scan; only their enabled flag is kept.
+func skillLoadedFromDisk(spec domain.SkillSpec) bool {
+	return spec.Source == skillSourceBuiltin || spec.Source == skillSourceFilesystem
+}
+
+func (s *Server) skillsDir() string {
+	return filepath.Join(s.cfg.DataDir, "skills")
+}
+
+type skillManifest struct {
+	Name        string
+	Description string
+	Version     string
+	Triggers    []string
+}
+
+// parseSkillManifest splits SKILL.md into its front matter and body. Only the
+// flat subset of YAML that skill manifests use is understood: scalar values,
+// inline lists ([a, b]) and block lists ("- a").
+func parseSkillManifest(raw string) (skillManifest, string) {
+	text := strings.ReplaceAll(raw, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return skillManifest{}, text
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "---" {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return skillManifest{}, text
+	}
+
+	var manifest skillManifest
+	listKey := ""
+	for _, line := range lines[1:end] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok {
+			if listKey == "triggers" {
+				if value := unquoteSkillValue(item); value != "" {
+					manifest.Triggers = append(manifest.Triggers, value)
+				}
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		listKey = key
+		switch key {
+		case "name":
+			manifest.Name = unquoteSkillValue(value)
+		case "description":
+			manifest.Description = unquoteSkillValue(value)
+		case "version":
+			manifest.Version = unquoteSkillValue(value)
+		case "triggers":
+			if inner, ok := strings.CutPrefix(value, "["); ok {
+				for _, item := range strings.Split(strings.TrimSuffix(inner, "]"), ",") {
+					if item = unquoteSkillValue(item); item != "" {
+						manifest.Triggers = append(manifest.Triggers, item)
+					}
+				}
+			} else if value != "" {
+				manifest.Triggers = append(manifest.Triggers, unquoteSkillValue(value))
+			}
+		}
+	}
+	return manifest, strings.TrimLeft(strings.Join(lines[end+1:], "\n"), "\n")
+}
+
+func unquoteSkillValue(raw string) string {
+	value := strings.TrimSpace(raw)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return strings.TrimSpace(value)
+}
+
+// loadSkillDir reads one skill package from a skill directory.
+func loadSkillDir(dir, source string) (domain.SkillSpec, error) {
+	spec, err := loadSkillPackage(os.DirFS(dir), filepath.Base(dir))
+	if err != nil {
+		return domain.SkillSpec{}, err
+	}
+	spec.Source = source
+	spec.Path = dir
+	return spec, nil
+}
+
+// loadSkillPackage reads SKILL.md, the files under references/ and scripts/,
+// and the script tools declared in tools.json. The same layout is used for
+// skill directories and skill archives.
+func loadSkillPackage(fsys fs.FS, fallbackName string) (domain.SkillSpec, error) {
+	raw, err := fs.ReadFile(fsys, skillManifestFile)
+	if err != nil {
+		return domain.SkillSpec{}, err
+	}
+	manifest, body := parseSkillManifest(string(raw))
+	name := manifest.Name
+	if name == "" {
+		name = fallbackName
+	}
+	if strings.TrimSpace(body) == "" {
+		return domain.SkillSpec{}, fmt.Errorf("%s has no content", skillManifestFile)
+	}
+	references, err := loadSkillTree(fsys, skillDirReferences, 0)
+	if err != nil {
+		return domain.SkillSpec{}, err
+	}
+	scripts, err := loadSkillTree(fsys, skillDirScripts, 0)
+	if err != nil {
+		return domain.SkillSpec{}, err
+	}
+	spec := domain.SkillSpec{
+		Name:        name,
+		Description: manifest.Description,
+		Triggers:    manifest.Triggers,
+		Version:     manifest.Version,
+		Content:     body,
+		References:  references,
+		Scripts:     scripts,
+		Enabled:     true,
+	}
+	if raw, err := fs.ReadFile(fsys, skillToolsFile); err == nil {
+		if err := json.Unmarshal(raw, &spec.Tools); err != nil {
+			return domain.SkillSpec{}, fmt.Errorf("invalid %s: %w", skillToolsFile, err)
+		}
+		if spec.Tools, err = normalizeSkillTools(spec); err != nil {
+			return domain.SkillSpec{}, fmt.Errorf("invalid %s: %w", skillToolsFile, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return domain.SkillSpec{}, err
+	}
+	return spec, nil
+}
+
+// loadSkillTree mirrors a directory as nested maps of file contents, the
+// shape readSkillVirtualFile walks. Hidden entries and oversized files are
+// skipped.
+func loadSkillTree(fsys fs.FS, dir string, depth int) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return out, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		rel := path.Join(dir, name)
+		if entry.IsDir() {
+			if depth+1 >= skillTreeMaxDepth {
+				continue
+			}
+			child, err := loadSkillTree(fsys, rel, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = child
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Size() > skillFileMaxBytes {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, rel)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = string(content)
+	}
+	return out, nil
+}
+
+// scanSkillRoot loads every <root>/<name>/SKILL.md. A broken package is
+// logged and skipped so that one bad skill does not hide the others.
+func scanSkillRoot(root, source string) []domain.SkillSpec {
+	if strings.TrimSpace(root) == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("scan skills in %s failed: %v", root, err)
+		}
+		return nil
+	}
+	out := make([]domain.SkillSpec, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, skillManifestFile)); err != nil {
+			continue
+		}
+		spec, err := loadSkillDir(dir, source)
+		if err != nil {
+			log.Printf("load skill %s failed: %v", dir, err)
+			continue
+		}
+		out = append(out, spec)
+	}
+	return out
+}
+
+// syncSkillDirs replaces the disk-backed skills in the store with a fresh
+// scan. Skills in DataDir/skills override builtin ones of the same name, and
+// skills created through the API override both. New disk skills start
+// disabled; known ones keep their enabled flag.
+func (s *Server) syncSkillDirs() error {
+	scanned := map[string]domain.SkillSpec{}
+	for _, spec := range scanSkillRoot(s.cfg.BuiltinSkillsDir, skillSourceBuiltin) {
+		scanned[spec.Name] = spec
+	}
+	for _, spec := range scanSkillRoot(s.skillsDir(), skillSourceFilesystem) {
+		scanned[spec.Name] = spec
+	}
+	if err := s.store.Write(func(st *repo.State) error {
+		for name, spec := range st.Skills {
+			if _, ok := scanned[name]; skillLoadedFromDisk(spec) && !ok {
+				delete(st.Skills, name)
+			}
+		}
+		for name, spec := range scanned {
+			// Anything that can write to a skill root could otherwise turn
+			// its scripts into tools, so a skill found on disk for the first
+			// time waits for an operator to enable it.
+			spec.Enabled = false
+			if existing, ok := st.Skills[name]; ok {
+				if !skillLoadedFromDisk(existing) {
+					continue
+				}
+				spec.Enabled = existing.Enabled
+			}
+			st.Skills[name] = spec
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	s.syncSkillTools()
+	return nil
+}
+
+// skillDirsFingerprint summarises names, sizes and modification times under
+// the skill roots so the watcher only rescans after something changed.
+func skillDirsFingerprint(roots ...string) string {
+	entries := []string{}
+	for _, root := range roots {
+		if strings.TrimSpace(root) == "" {
+			continue
+		}
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			entries = append(entries, fmt.Sprintf("%s|%d|%d", path, info.Size(), info.ModTime().UnixNano()))
+			return nil
+		})
+	}
+	sort.Strings(entries)
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSkillWatcher polls the skill roots every cfg.SkillsReload and rescans
+// them when they change. A zero interval turns hot reload off.
+func (s *Server) startSkillWatcher() {
+	if s.cfg.SkillsReload <= 0 {
+		close(s.skillsDone)
+		return
+	}
+	last := skillDirsFingerprint(s.cfg.BuiltinSkillsDir, s.skillsDir())
+	go func() {
+		defer close(s.skillsDone)
+		ticker := time.NewTicker(s.cfg.SkillsReload)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				current := skillDirsFingerprint(s.cfg.BuiltinSkillsDir, s.skillsDir())
+				if current == last {
+					continue
+				}
+				if err := s.syncSkillDirs(); err != nil {
+					log.Printf("reload skills failed: %v", err)
+					continue
+				}
+				last = current
+			case <-s.skillsStop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/plugin"
+	"nextai/apps/gateway/internal/repo"
+)
+
+const (
+	skillToolPrefix = "skill."
+	skillToolsFile  = "tools.json"
+
+	// skillScriptGateTool is the tool whose enabled flag and approval policy
+	// also govern skill scripts, since they run arbitrary shell too.
+	skillScriptGateTool = "shell"
+)
+
+var (
+	skillToolNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+	skillToolNameInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+)
+
+// skillToolName namespaces a script tool under its skill, e.g.
+// skill.deploy.rollback.
+func skillToolName(skill, tool string) string {
+	return skillToolPrefix + skillToolNameInvalid.ReplaceAllString(strings.ToLower(strings.TrimSpace(skill)), "_") + "." + tool
+}
+
+// normalizeSkillTools checks the tool declarations of a skill against its
+// scripts. Script paths may omit the scripts/ prefix.
+func normalizeSkillTools(spec domain.SkillSpec) ([]domain.SkillTool, error) {
+	if len(spec.Tools) == 0 {
+		return nil, nil
+	}
+	out := make([]domain.SkillTool, 0, len(spec.Tools))
+	seen := map[string]struct{}{}
+	for _, tool := range spec.Tools {
+		tool.Name = strings.ToLower(strings.TrimSpace(tool.Name))
+		if !skillToolNamePattern.MatchString(tool.Name) {
+			return nil, fmt.Errorf("tool name %q must match [a-z0-9][a-z0-9_-]{0,31}", tool.Name)
+		}
+		if _, dup := seen[tool.Name]; dup {
+			return nil, fmt.Errorf("tool %q is declared twice", tool.Name)
+		}
+		seen[tool.Name] = struct{}{}
+		script := strings.Trim(filepath.ToSlash(strings.TrimSpace(tool.Script)), "/")
+		if !strings.HasPrefix(script, skillDirScripts+"/") {
+			script = skillDirScripts + "/" + script
+		}
+		if _, ok := readSkillVirtualFile(spec, script); !ok {
+			return nil, fmt.Errorf("tool %q script %s not found", tool.Name, script)
+		}
+		tool.Script = script
+		tool.Interpreter = strings.TrimSpace(tool.Interpreter)
+		if tool.TimeoutSeconds < 0 {
+			return nil, fmt.Errorf("tool %q timeout_seconds must be >= 0", tool.Name)
+		}
+		if tool.Parameters != nil {
+			if kind, _ := tool.Parameters["type"].(string); kind != "object" {
+				return nil, errors.New("tool parameters must be an object schema")
+			}
+		}
+		out = append(out, tool)
+	}
+	return out, nil
+}
+
+func (s *Server) newSkillScriptTool(skill domain.SkillSpec, tool domain.SkillTool) (*plugin.SkillScriptTool, bool) {
+	content, ok := readSkillVirtualFile(skill, tool.Script)
+	if !ok {
+		return nil, false
+	}
+	script := plugin.SkillScript{
+		Name:        skillToolName(skill.Name, tool.Name),
+		Skill:       skill.Name,
+		Script:      tool.Script,
+		Description: tool.Description,
+		Parameters:  tool.Parameters,
+		Interpreter: tool.Interpreter,
+		Content:     content,
+		Timeout:     time.Duration(tool.TimeoutSeconds) * time.Second,
+	}
+	if skillLoadedFromDisk(skill) {
+		script.Path = filepath.Join(skill.Path, filepath.FromSlash(tool.Script))
+		script.Dir = skill.Path
+	}
+	return plugin.NewSkillScriptTool(script, s.shellPolicy), true
+}
+
+// syncSkillTools registers the script tools of enabled skills and drops the
+// ones whose skill was disabled, changed or removed. It runs after every
+// change to the stored skills.
+func (s *Server) syncSkillTools() {
+	var skills []domain.SkillSpec
+	s.store.Read(func(st *repo.State) {
+		skills = enabledSkills(st)
+	})
+	desired := map[string]*plugin.SkillScriptTool{}
+	for _, skill := range skills {
+		for _, tool := range skill.Tools {
+			if scriptTool, ok := s.newSkillScriptTool(skill, tool); ok {
+				desired[strings.ToLower(scriptTool.Name())] = scriptTool
+			}
+		}
+	}
+
+	s.skillToolsMu.Lock()
+	defer s.skillToolsMu.Unlock()
+	for name := range s.skillTools {
+		if _, ok := desired[name]; !ok {
+			s.unregisterToolPlugin(name)
+		}
+	}
+	s.skillTools = make(map[string]struct{}, len(desired))
+	for name, scriptTool := range desired {
+		s.registerToolPlugin(scriptTool)
+		s.skillTools[name] = struct{}{}
+	}
+}
+
+func skillToolNames(skill domain.SkillSpec) []string {
+	out := make([]string, 0, len(skill.Tools))
+	for _, tool := range skill.Tools {
+		out = append(out, skillToolName(skill.Name, tool.Name))
+	}
+	return out
+}
@@ -0,0 +1,252 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/runner"
+)
+
+const (
+	toolApprovalAllTools = "*"
+	toolApprovalApprove  = "approve"
+	toolApprovalDeny     = "deny"
+	toolApprovalTimeout  = "timeout"
+
+	chatMetaToolApproval = "tool_approval"
+)
+
+type toolApprovalPolicy map[string]struct{}
+
+// requires reports whether a call needs approval. Skill scripts need it
+// whenever shell does.
+func (p toolApprovalPolicy) requires(name string) bool {
+	if len(p) == 0 {
+		return false
+	}
+	if _, ok := p[toolApprovalAllTools]; ok {
+		return true
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if _, ok := p[name]; ok {
+		return true
+	}
+	if strings.HasPrefix(name, skillToolPrefix) {
+		_, ok := p[skillScriptGateTool]
+		return ok
+	}
+	return false
+}
+
+type pendingToolApproval struct {
+	info     domain.AgentToolApproval
+	decision chan domain.AgentToolApprovalDecision
+}
+
+// resolveToolApprovalPolicy merges NEXTAI_TOOL_APPROVAL with the chat's
+// meta.tool_approval. A chat can add tools to the policy but cannot remove
+// the ones required by the server.
+func (s *Server) resolveToolApprovalPolicy(chatMeta map[string]interface{}) toolApprovalPolicy {
+	policy := toolApprovalPolicy{}
+	for name := range s.approvalTools {
+		policy[name] = struct{}{}
+	}
+	switch value := chatMeta[chatMetaToolApproval].(type) {
+	case string:
+		for name := range parseToolNameSet(value) {
+			policy[name] = struct{}{}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if name, ok := item.(string); ok {
+				if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+					policy[name] = struct{}{}
+				}
+			}
+		}
+	}
+	return policy
+}
+
+// awaitToolApprovals pauses the run until every call matching the policy has
+// been approved or denied. Denied calls (including timeouts) are returned by
+// index; a non-nil error means the run context ended while waiting.
+func (s *Server) awaitToolApprovals(
+	ctx context.Context,
+	runID string,
+	step int,
+	calls []runner.ToolCall,
+	policy toolApprovalPolicy,
+	appendEvent func(domain.AgentEvent),
+) (map[int]domain.AgentToolApprovalDecision, error) {
+	pending := map[int]*pendingToolApproval{}
+	for idx, call := range calls {
+		if !policy.requires(call.Name) {
+			continue
+		}
+		approval := s.registerToolApproval(runID, domain.AgentToolApproval{
+			CallID:      call.ID,
+			Name:        call.Name,
+			Input:       safeMap(call.Arguments),
+			Step:        step,
+			RequestedAt: nowISO(),
+		})
+		pending[idx] = approval
+		appendEvent(domain.AgentEvent{
+			Type: "approval_required",
+			Step: step,
+			ToolCall: &domain.AgentToolCallPayload{
+				ID:    call.ID,
+				Name:  call.Name,
+				Input: safeMap(call.Arguments),
+			},
+			Meta: map[string]interface{}{"run_id": runID},
+		})
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	defer func() {
+		for _, approval := range pending {
+			s.removeToolApproval(runID, approval.info.CallID)
+		}
+	}()
+
+	var timeout <-chan time.Time
+	if s.cfg.ToolApprovalTimeout > 0 {
+		timer := time.NewTimer(s.cfg.ToolApprovalTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	denied := map[int]domain.AgentToolApprovalDecision{}
+	for idx, call := range calls {
+		approval, ok := pending[idx]
+		if !ok {
+			continue
+		}
+		var decision domain.AgentToolApprovalDecision
+		select {
+		case decision = <-approval.decision:
+		case <-timeout:
+			decision = domain.AgentToolApprovalDecision{Decision: toolApprovalTimeout}
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+		if decision.Decision != toolApprovalApprove {
+			denied[idx] = decision
+		}
+		meta := map[string]interface{}{"run_id": runID, "decision": decision.Decision}
+		if decision.Reason != "" {
+			meta["reason"] = decision.Reason
+		}
+		appendEvent(domain.AgentEvent{
+			Type:     "approval_resolved",
+			Step:     step,
+			ToolCall: &domain.AgentToolCallPayload{ID: call.ID, Name: call.Name},
+			Meta:     meta,
+		})
+	}
+	return denied, nil
+}
+
+func (s *Server) registerToolApproval(runID string, info domain.AgentToolApproval) *pendingToolApproval {
+	approval := &pendingToolApproval{info: info, decision: make(chan domain.AgentToolApprovalDecision, 1)}
+	s.agentRunsMu.Lock()
+	defer s.agentRunsMu.Unlock()
+	run, ok := s.agentRuns[runID]
+	if !ok {
+		return approval
+	}
+	if run.approvals == nil {
+		run.approvals = map[string]*pendingToolApproval{}
+	}
+	run.approvals[info.CallID] = approval
+	pendingApprovals := make([]domain.AgentToolApproval, 0, len(run.info.PendingApprovals)+1)
+	run.info.PendingApprovals = append(append(pendingApprovals, run.info.PendingApprovals...), info)
+	run.info.UpdatedAt = nowISO()
+	return approval
+}
+
+func (s *Server) removeToolApproval(runID, callID string) {
+	s.agentRunsMu.Lock()
+	defer s.agentRunsMu.Unlock()
+	run, ok := s.agentRuns[runID]
+	if !ok {
+		return
+	}
+	delete(run.approvals, callID)
+	var kept []domain.AgentToolApproval
+	for _, item := range run.info.PendingApprovals {
+		if item.CallID != callID {
+			kept = append(kept, item)
+		}
+	}
+	run.info.PendingApprovals = kept
+}
+
+// resolveToolApproval hands the decision to the waiting run. It reports false
+// when the run or the pending call does not exist (or was already decided).
+func (s *Server) resolveToolApproval(runID, callID string, decision domain.AgentToolApprovalDecision) (domain.AgentToolApproval, bool) {
+	s.agentRunsMu.Lock()
+	defer s.agentRunsMu.Unlock()
+	run, ok := s.agentRuns[runID]
+	if !ok {
+		return domain.AgentToolApproval{}, false
+	}
+	approval, ok := run.approvals[callID]
+	if !ok {
+		return domain.AgentToolApproval{}, false
+	}
+	select {
+	case approval.decision <- decision:
+		return approval.info, true
+	default:
+		return domain.AgentToolApproval{}, false
+	}
+}
+
+func deniedToolCallError(name string, decision domain.AgentToolApprovalDecision) error {
+	message := fmt.Sprintf("tool %q call was denied", name)
+	if decision.Decision == toolApprovalTimeout {
+		message = fmt.Sprintf("tool %q call was not approved in time", name)
+	}
+	if reason := strings.TrimSpace(decision.Reason); reason != "" {
+		message += ": " + reason
+	}
+	return &toolError{Code: "tool_call_denied", Message: message}
+}
+
+func (s *Server) decideToolApproval(w http.ResponseWriter, r *http.Request) {
+	runID := strings.TrimSpace(chi.URLParam(r, "run_id"))
+	callID := strings.TrimSpace(chi.URLParam(r, "call_id"))
+	var req domain.AgentToolApprovalDecision
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_json", "invalid request body", nil)
+		return
+	}
+	req.Decision = strings.ToLower(strings.TrimSpace(req.Decision))
+	if req.Decision != toolApprovalApprove && req.Decision != toolApprovalDeny {
+		writeErr(w, http.StatusBadRequest, "invalid_approval", "decision must be approve or deny", nil)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	approval, ok := s.resolveToolApproval(runID, callID, req)
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "pending approval not found", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"run_id":   runID,
+		"call_id":  approval.CallID,
+		"name":     approval.Name,
+		"decision": req.Decision,
+		"reason":   req.Reason,
+	})
+}
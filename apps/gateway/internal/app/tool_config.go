@@ -0,0 +1,352 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/plugin"
+	"nextai/apps/gateway/internal/repo"
+)
+
+const chatMetaAllowedTools = "allowed_tools"
+
+// secretConfigKey matches config keys whose values are credentials, such as
+// api_key, token or Authorization. Their values are masked in responses.
+var secretConfigKey = regexp.MustCompile(`(?i)key|token|secret|passw|auth|credential|cookie`)
+
+// toolFactory builds a tool from its stored config. Tools with a factory are
+// only registered once they can be built, and are rebuilt whenever their
+// config changes.
+type toolFactory func(cfg map[string]interface{}) (plugin.ToolPlugin, error)
+
+// toolAllowlist limits the tools of one chat. A nil allowlist allows every
+// tool.
+type toolAllowlist map[string]struct{}
+
+func (a toolAllowlist) allows(name string) bool {
+	if a == nil {
+		return true
+	}
+	_, ok := a[strings.ToLower(strings.TrimSpace(name))]
+	return ok
+}
+
+// resolveToolAllowlist reads meta.allowed_tools, given either as a
+// comma-separated string or an array of names.
+func resolveToolAllowlist(chatMeta map[string]interface{}) toolAllowlist {
+	switch value := chatMeta[chatMetaAllowedTools].(type) {
+	case string:
+		return toolAllowlist(parseToolNameSet(value))
+	case []interface{}:
+		out := toolAllowlist{}
+		for _, item := range value {
+			if name, ok := item.(string); ok {
+				if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+					out[name] = struct{}{}
+				}
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+func (s *Server) toolFactories() map[string]toolFactory {
+	return map[string]toolFactory{
+		"browser": func(cfg map[string]interface{}) (plugin.ToolPlugin, error) {
+			agentDir, _ := cfg["agent_dir"].(string)
+			agentDir = strings.TrimSpace(agentDir)
+			if agentDir == "" {
+				agentDir = strings.TrimSpace(os.Getenv(browserToolAgentDirEnv))
+			}
+			return plugin.NewBrowserTool(agentDir)
+		},
+		"search": func(cfg map[string]interface{}) (plugin.ToolPlugin, error) {
+			return plugin.NewSearchToolWithConfig(cfg)
+		},
+	}
+}
+
+// initConfigurableTools registers the tools that need configuration. A tool
+// switched on by its env flag must build, as before; one enabled only through
+// the stored config is skipped with a log line so a stale config cannot stop
+// the server from starting.
+func (s *Server) initConfigurableTools() error {
+	envEnabled := map[string]bool{
+		"browser": parseBool(os.Getenv(enableBrowserToolEnv)),
+		"search":  parseBool(os.Getenv(enableSearchToolEnv)),
+	}
+	stored := map[string]domain.ToolConfig{}
+	s.store.Read(func(st *repo.State) {
+		for name, cfg := range st.Tools {
+			stored[name] = cfg
+		}
+	})
+	for name, factory := range s.toolFactories() {
+		cfg := stored[name]
+		if !envEnabled[name] && (cfg.Enabled == nil || !*cfg.Enabled) {
+			continue
+		}
+		tool, err := factory(cfg.Config)
+		if err != nil {
+			if envEnabled[name] && cfg.Enabled == nil {
+				return fmt.Errorf("init %s tool failed: %w", name, err)
+			}
+			log.Printf("skip %s tool: %v", name, err)
+			continue
+		}
+		s.registerToolPlugin(tool)
+	}
+	return nil
+}
+
+func (s *Server) toolPlugin(name string) (plugin.ToolPluginV2, bool) {
+	s.toolsMu.RLock()
+	defer s.toolsMu.RUnlock()
+	tp, ok := s.tools[name]
+	return tp, ok
+}
+
+func (s *Server) toolPlugins() map[string]plugin.ToolPluginV2 {
+	s.toolsMu.RLock()
+	defer s.toolsMu.RUnlock()
+	out := make(map[string]plugin.ToolPluginV2, len(s.tools))
+	for name, tp := range s.tools {
+		out[name] = tp
+	}
+	return out
+}
+
+func (s *Server) toolEnabledByDefault(name string) bool {
+	switch name {
+	case "browser":
+		return parseBool(os.Getenv(enableBrowserToolEnv))
+	case "search":
+		return parseBool(os.Getenv(enableSearchToolEnv))
+	}
+	_, disabled := s.disabledTools[name]
+	return !disabled
+}
+
+// checkToolUsable reports why a tool cannot be called in a chat: it is
+// disabled server-wide or missing from the chat's meta.allowed_tools.
+func (s *Server) checkToolUsable(chatID, name string) error {
+	if s.toolDisabled(name) {
+		return &toolError{
+			Code:    "tool_disabled",
+			Message: fmt.Sprintf("tool %q is disabled by server config", name),
+		}
+	}
+	if chatID == "" {
+		return nil
+	}
+	var allowlist toolAllowlist
+	s.store.Read(func(st *repo.State) {
+		allowlist = resolveToolAllowlist(st.Chats[chatID].Meta)
+	})
+	if !allowlist.allows(name) {
+		return &toolError{
+			Code:    "tool_not_allowed",
+			Message: fmt.Sprintf("tool %q is not allowed in this chat", name),
+		}
+	}
+	return nil
+}
+
+// maskSecretConfig copies cfg with the string values of secret-looking keys
+// passed through maskKey, at any depth.
+func maskSecretConfig(cfg map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(cfg))
+	for key, value := range cfg {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			out[key] = maskSecretConfig(v)
+		case string:
+			if secretConfigKey.MatchString(key) {
+				v = maskKey(v)
+			}
+			out[key] = v
+		default:
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// mergeSecretConfig keeps the stored value of a secret-looking key that the
+// update omits or sends back masked, so a client can PUT what GET returned.
+// An empty string still clears the value.
+func mergeSecretConfig(next, current map[string]interface{}) map[string]interface{} {
+	if next == nil || current == nil {
+		return next
+	}
+	out := make(map[string]interface{}, len(next))
+	for key, value := range next {
+		out[key] = value
+	}
+	for key, stored := range current {
+		switch storedValue := stored.(type) {
+		case map[string]interface{}:
+			if nested, ok := out[key].(map[string]interface{}); ok {
+				out[key] = mergeSecretConfig(nested, storedValue)
+			}
+		case string:
+			if !secretConfigKey.MatchString(key) {
+				continue
+			}
+			if value, present := out[key]; !present || value == maskKey(storedValue) {
+				out[key] = storedValue
+			}
+		}
+	}
+	return out
+}
+
+// maskSecretStrings is maskSecretConfig for flat string maps such as MCP
+// headers and env.
+func maskSecretStrings(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for key, value := range in {
+		if secretConfigKey.MatchString(key) {
+			value = maskKey(value)
+		}
+		out[key] = value
+	}
+	return out
+}
+
+// mergeSecretStrings restores masked values from current. A nil update keeps
+// current as a whole.
+func mergeSecretStrings(next, current map[string]string) map[string]string {
+	if next == nil {
+		return current
+	}
+	out := make(map[string]string, len(next))
+	for key, value := range next {
+		if stored, ok := current[key]; ok && secretConfigKey.MatchString(key) && value == maskKey(stored) {
+			value = stored
+		}
+		out[key] = value
+	}
+	return out
+}
+
+func (s *Server) toolConfigInfo(name string) domain.ToolConfigInfo {
+	info := domain.ToolConfigInfo{Name: name, Config: map[string]interface{}{}}
+	s.store.Read(func(st *repo.State) {
+		if cfg, ok := st.Tools[name]; ok && cfg.Config != nil {
+			info.Config = maskSecretConfig(cfg.Config)
+		}
+	})
+	_, registered := s.toolPlugin(name)
+	info.Enabled = registered && !s.toolDisabled(name)
+	return info
+}
+
+func (s *Server) listToolConfigs(w http.ResponseWriter, _ *http.Request) {
+	names := map[string]struct{}{}
+	for name := range s.toolPlugins() {
+		names[name] = struct{}{}
+	}
+	for name := range s.toolFactories() {
+		names[name] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	out := make([]domain.ToolConfigInfo, 0, len(sorted))
+	for _, name := range sorted {
+		out = append(out, s.toolConfigInfo(name))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) getToolConfig(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "tool_name")))
+	_, registered := s.toolPlugin(name)
+	if _, configurable := s.toolFactories()[name]; !registered && !configurable {
+		writeErr(w, http.StatusNotFound, "not_found", fmt.Sprintf("tool %q is not supported", name), nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.toolConfigInfo(name))
+}
+
+// putToolConfig switches a tool on or off and replaces its config. Omitted
+// fields keep their stored value, and so do secrets the config omits or
+// sends back masked. Tools with a factory are rebuilt from the
+// new config before it is saved, so an invalid config is rejected without
+// touching the running tool.
+func (s *Server) putToolConfig(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "tool_name")))
+	_, registered := s.toolPlugin(name)
+	factory, configurable := s.toolFactories()[name]
+	if !registered && !configurable {
+		writeErr(w, http.StatusNotFound, "not_found", fmt.Sprintf("tool %q is not supported", name), nil)
+		return
+	}
+	var body domain.ToolConfig
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid_json", "invalid request body", nil)
+		return
+	}
+	if len(body.Config) > 0 && !configurable {
+		writeErr(w, http.StatusBadRequest, "tool_not_configurable", fmt.Sprintf("tool %q has no config", name), nil)
+		return
+	}
+
+	current := domain.ToolConfig{}
+	s.store.Read(func(st *repo.State) {
+		current = st.Tools[name]
+	})
+	next := current
+	if body.Enabled != nil {
+		enabled := *body.Enabled
+		next.Enabled = &enabled
+	}
+	if body.Config != nil {
+		next.Config = mergeSecretConfig(body.Config, current.Config)
+	}
+	enabled := s.toolEnabledByDefault(name)
+	if next.Enabled != nil {
+		enabled = *next.Enabled
+	}
+
+	var rebuilt plugin.ToolPlugin
+	if configurable && enabled {
+		tool, err := factory(next.Config)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid_tool_config", err.Error(), nil)
+			return
+		}
+		rebuilt = tool
+	}
+	if err := s.store.Write(func(st *repo.State) error {
+		if st.Tools == nil {
+			st.Tools = map[string]domain.ToolConfig{}
+		}
+		st.Tools[name] = next
+		return nil
+	}); err != nil {
+		writeErr(w, http.StatusInternalServerError, "store_error", err.Error(), nil)
+		return
+	}
+	if rebuilt != nil {
+		s.registerToolPlugin(rebuilt)
+	}
+	writeJSON(w, http.StatusOK, s.toolConfigInfo(name))
+}
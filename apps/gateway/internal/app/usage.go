@@ -0,0 +1,204 @@
+package app
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/repo"
+)
+
+const usageDayLayout = "2006-01-02"
+
+// turnUsage records which model slot answered one LLM turn and the tokens it
+// reported.
+type turnUsage struct {
+	Step       int
+	Slot       int
+	ProviderID string
+	Model      string
+	Usage      domain.TokenUsage
+}
+
+func (s *Server) getUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from := strings.TrimSpace(query.Get("from"))
+	to := strings.TrimSpace(query.Get("to"))
+	for _, day := range []string{from, to} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse(usageDayLayout, day); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid_usage_range", "from and to must use YYYY-MM-DD", map[string]string{"value": day})
+			return
+		}
+	}
+	providerID := normalizeProviderID(query.Get("provider_id"))
+	userID := strings.TrimSpace(query.Get("user_id"))
+
+	records := make([]domain.UsageRecord, 0)
+	s.store.Read(func(st *repo.State) {
+		for _, record := range st.Usage {
+			if from != "" && record.Day < from {
+				continue
+			}
+			if to != "" && record.Day > to {
+				continue
+			}
+			if providerID != "" && record.ProviderID != providerID {
+				continue
+			}
+			if userID != "" && record.UserID != userID {
+				continue
+			}
+			records = append(records, record)
+		}
+	})
+	writeJSON(w, http.StatusOK, summarizeUsage(records))
+}
+
+func summarizeUsage(records []domain.UsageRecord) domain.UsageSummary {
+	out := domain.UsageSummary{}
+	byProvider := map[string]*domain.UsageGroup{}
+	byModel := map[string]*domain.UsageGroup{}
+	byUser := map[string]*domain.UsageGroup{}
+	byDay := map[string]*domain.UsageGroup{}
+	for _, record := range records {
+		addUsageGroup(&out.Total, record)
+		addUsageGroup(usageGroupFor(byProvider, record.ProviderID), record)
+		addUsageGroup(usageGroupFor(byModel, record.ProviderID+"/"+record.Model), record)
+		addUsageGroup(usageGroupFor(byUser, record.UserID), record)
+		addUsageGroup(usageGroupFor(byDay, record.Day), record)
+	}
+	out.ByProvider = sortedUsageGroups(byProvider)
+	out.ByModel = sortedUsageGroups(byModel)
+	out.ByUser = sortedUsageGroups(byUser)
+	out.ByDay = sortedUsageGroups(byDay)
+	return out
+}
+
+func usageGroupFor(groups map[string]*domain.UsageGroup, key string) *domain.UsageGroup {
+	group, ok := groups[key]
+	if !ok {
+		group = &domain.UsageGroup{Key: key}
+		groups[key] = group
+	}
+	return group
+}
+
+func addUsageGroup(group *domain.UsageGroup, record domain.UsageRecord) {
+	group.Requests += record.Requests
+	group.PromptTokens += record.PromptTokens
+	group.CompletionTokens += record.CompletionTokens
+	group.TotalTokens += record.TotalTokens
+}
+
+func sortedUsageGroups(groups map[string]*domain.UsageGroup) []domain.UsageGroup {
+	out := make([]domain.UsageGroup, 0, len(groups))
+	for _, group := range groups {
+		out = append(out, *group)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func addTokenUsage(total *domain.TokenUsage, usage domain.TokenUsage) {
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+}
+
+func sumTurnUsage(turns []turnUsage) domain.TokenUsage {
+	total := domain.TokenUsage{}
+	for _, turn := range turns {
+		addTokenUsage(&total, turn.Usage)
+	}
+	return total
+}
+
+// completedTurnsMeta builds the completed event meta: the slot that answered
+// each turn, the final slot, and token usage both in total and per
+// provider/model when the upstream reported any.
+func completedTurnsMeta(turns []turnUsage) map[string]interface{} {
+	if len(turns) == 0 {
+		return nil
+	}
+	last := turns[len(turns)-1]
+	items := make([]map[string]interface{}, 0, len(turns))
+	byProvider := make([]map[string]interface{}, 0, 1)
+	index := map[string]int{}
+	counted := make([]turnUsage, 0, len(turns))
+	for _, turn := range turns {
+		item := map[string]interface{}{
+			"step":        turn.Step,
+			"slot":        turn.Slot,
+			"provider_id": turn.ProviderID,
+			"model":       turn.Model,
+		}
+		if turn.Usage.TotalTokens > 0 {
+			item["usage"] = turn.Usage
+			counted = append(counted, turn)
+			key := turn.ProviderID + "/" + turn.Model
+			pos, ok := index[key]
+			if !ok {
+				pos = len(byProvider)
+				index[key] = pos
+				byProvider = append(byProvider, map[string]interface{}{
+					"provider_id": turn.ProviderID,
+					"model":       turn.Model,
+					"usage":       domain.TokenUsage{},
+				})
+			}
+			total := byProvider[pos]["usage"].(domain.TokenUsage)
+			addTokenUsage(&total, turn.Usage)
+			byProvider[pos]["usage"] = total
+		}
+		items = append(items, item)
+	}
+	meta := map[string]interface{}{
+		"slot":        last.Slot,
+		"provider_id": last.ProviderID,
+		"model":       last.Model,
+		"turns":       items,
+	}
+	if len(counted) > 0 {
+		meta["usage"] = sumTurnUsage(counted)
+		meta["usage_by_provider"] = byProvider
+	}
+	return meta
+}
+
+func recordChatUsage(st *repo.State, chatID, userID string, turns []turnUsage) {
+	if len(turns) == 0 {
+		return
+	}
+	if chat, ok := st.Chats[chatID]; ok {
+		total := domain.TokenUsage{}
+		if chat.Usage != nil {
+			total = *chat.Usage
+		}
+		addTokenUsage(&total, sumTurnUsage(turns))
+		chat.Usage = &total
+		st.Chats[chatID] = chat
+	}
+	if st.Usage == nil {
+		st.Usage = map[string]domain.UsageRecord{}
+	}
+	day := time.Now().UTC().Format(usageDayLayout)
+	for _, turn := range turns {
+		key := strings.Join([]string{day, turn.ProviderID, turn.Model, userID}, "|")
+		record, ok := st.Usage[key]
+		if !ok {
+			record = domain.UsageRecord{Day: day, ProviderID: turn.ProviderID, Model: turn.Model, UserID: userID}
+		}
+		record.Requests++
+		record.PromptTokens += turn.Usage.PromptTokens
+		record.CompletionTokens += turn.Usage.CompletionTokens
+		record.TotalTokens += turn.Usage.TotalTokens
+		st.Usage[key] = record
+	}
+}
@@ -2,6 +2,19 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAgentMaxSteps      = 32
+	defaultAgentMaxToolCalls  = 128
+	defaultAgentMaxRunSeconds = 600
+	defaultAgentToolWorkers   = 4
+	defaultToolApprovalSecs   = 300
+	defaultShellSessionIdle   = 600
+	defaultSkillsReloadSecs   = 5
 )
 
 type Config struct {
@@ -10,6 +23,17 @@ type Config struct {
 	DataDir string
 	APIKey  string
 	WebDir  string
+
+	AgentMaxSteps     int
+	AgentMaxToolCalls int
+	AgentMaxDuration  time.Duration
+	AgentToolWorkers  int
+
+	ToolApprovalTimeout time.Duration
+	ShellSessionIdle    time.Duration
+
+	BuiltinSkillsDir string
+	SkillsReload     time.Duration
 }
 
 func Load() Config {
@@ -27,5 +51,34 @@ func Load() Config {
 	}
 	apiKey := os.Getenv("NEXTAI_API_KEY")
 	webDir := os.Getenv("NEXTAI_WEB_DIR")
-	return Config{Host: host, Port: port, DataDir: dataDir, APIKey: apiKey, WebDir: webDir}
+	return Config{
+		Host:              host,
+		Port:              port,
+		DataDir:           dataDir,
+		APIKey:            apiKey,
+		WebDir:            webDir,
+		AgentMaxSteps:     envInt("NEXTAI_AGENT_MAX_STEPS", defaultAgentMaxSteps),
+		AgentMaxToolCalls: envInt("NEXTAI_AGENT_MAX_TOOL_CALLS", defaultAgentMaxToolCalls),
+		AgentMaxDuration:  time.Duration(envInt("NEXTAI_AGENT_MAX_RUN_SECONDS", defaultAgentMaxRunSeconds)) * time.Second,
+		AgentToolWorkers:  envInt("NEXTAI_AGENT_TOOL_WORKERS", defaultAgentToolWorkers),
+
+		ToolApprovalTimeout: time.Duration(envInt("NEXTAI_TOOL_APPROVAL_TIMEOUT_SECONDS", defaultToolApprovalSecs)) * time.Second,
+		ShellSessionIdle:    time.Duration(envInt("NEXTAI_SHELL_SESSION_IDLE_SECONDS", defaultShellSessionIdle)) * time.Second,
+
+		BuiltinSkillsDir: strings.TrimSpace(os.Getenv("NEXTAI_BUILTIN_SKILLS_DIR")),
+		SkillsReload:     time.Duration(envInt("NEXTAI_SKILLS_RELOAD_SECONDS", defaultSkillsReloadSecs)) * time.Second,
+	}
+}
+
+// envInt treats 0 as "no limit" and falls back to def for unset or invalid values.
+func envInt(key string, def int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return def
+	}
+	return value
 }
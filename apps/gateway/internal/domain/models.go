@@ -1,12 +1,13 @@
 package domain
 
 const (
-	DefaultChatID         = "chat-default"
-	DefaultChatName       = "Default Chat"
-	DefaultChatSessionID  = "session-default"
-	DefaultChatUserID     = "demo-user"
-	DefaultChatChannel    = "console"
-	ChatMetaSystemDefault = "system_default"
+	DefaultChatID             = "chat-default"
+	DefaultChatName           = "Default Chat"
+	DefaultChatSessionID      = "session-default"
+	DefaultChatUserID         = "demo-user"
+	DefaultChatChannel        = "console"
+	ChatMetaSystemDefault     = "system_default"
+	ChatMetaContextCompaction = "context_compaction"
 
 	DefaultCronJobID       = "cron-default"
 	DefaultCronJobName     = "\u4f60\u597d\u6587\u672c\u4efb\u52a1"
@@ -34,6 +35,40 @@ type ChatSpec struct {
 	CreatedAt string                 `json:"created_at"`
 	UpdatedAt string                 `json:"updated_at"`
 	Meta      map[string]interface{} `json:"meta"`
+	Usage     *TokenUsage            `json:"usage,omitempty"`
+}
+
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type UsageRecord struct {
+	Day              string `json:"day"`
+	ProviderID       string `json:"provider_id"`
+	Model            string `json:"model"`
+	UserID           string `json:"user_id"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+}
+
+type UsageGroup struct {
+	Key              string `json:"key"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+}
+
+type UsageSummary struct {
+	Total      UsageGroup   `json:"total"`
+	ByProvider []UsageGroup `json:"by_provider"`
+	ByModel    []UsageGroup `json:"by_model"`
+	ByUser     []UsageGroup `json:"by_user"`
+	ByDay      []UsageGroup `json:"by_day"`
 }
 
 type RuntimeContent struct {
@@ -70,11 +105,13 @@ type AgentProcessRequest struct {
 }
 
 type AgentToolCallPayload struct {
+	ID    string                 `json:"id,omitempty"`
 	Name  string                 `json:"name"`
 	Input map[string]interface{} `json:"input,omitempty"`
 }
 
 type AgentToolResultPayload struct {
+	ID      string `json:"id,omitempty"`
 	Name    string `json:"name"`
 	OK      bool   `json:"ok"`
 	Summary string `json:"summary,omitempty"`
@@ -90,6 +127,92 @@ type AgentEvent struct {
 	Meta       map[string]interface{}  `json:"meta,omitempty"`
 }
 
+type AgentRunInfo struct {
+	ID         string `json:"id"`
+	ChatID     string `json:"chat_id"`
+	SessionID  string `json:"session_id"`
+	UserID     string `json:"user_id"`
+	Channel    string `json:"channel"`
+	Status     string `json:"status"`
+	Step       int    `json:"step"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  string `json:"started_at"`
+	UpdatedAt  string `json:"updated_at"`
+	FinishedAt string `json:"finished_at,omitempty"`
+
+	PendingApprovals []AgentToolApproval `json:"pending_approvals,omitempty"`
+}
+
+type AgentToolApproval struct {
+	CallID      string                 `json:"call_id"`
+	Name        string                 `json:"name"`
+	Input       map[string]interface{} `json:"input,omitempty"`
+	Step        int                    `json:"step"`
+	RequestedAt string                 `json:"requested_at"`
+}
+
+type AgentToolApprovalDecision struct {
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type ToolInfo struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	Enabled     bool                   `json:"enabled"`
+}
+
+// ToolConfig is the stored runtime setting of a tool. A nil Enabled keeps the
+// default from the environment.
+type ToolConfig struct {
+	Enabled *bool                  `json:"enabled,omitempty"`
+	Config  map[string]interface{} `json:"config,omitempty"`
+}
+
+type ToolConfigInfo struct {
+	Name    string                 `json:"name"`
+	Enabled bool                   `json:"enabled"`
+	Config  map[string]interface{} `json:"config"`
+}
+
+// MCPServerConfig is a stored MCP server. Command starts a stdio server;
+// URL connects to a streamable HTTP server instead. A nil Enabled means
+// enabled.
+type MCPServerConfig struct {
+	Enabled        *bool             `json:"enabled,omitempty"`
+	Command        string            `json:"command,omitempty"`
+	Args           []string          `json:"args,omitempty"`
+	Env            map[string]string `json:"env,omitempty"`
+	Cwd            string            `json:"cwd,omitempty"`
+	URL            string            `json:"url,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"`
+}
+
+type MCPServerInfo struct {
+	Name string `json:"name"`
+	MCPServerConfig
+	Status string   `json:"status"`
+	Error  string   `json:"error,omitempty"`
+	Tools  []string `json:"tools"`
+}
+
+type AgentRunRevertRequest struct {
+	Force bool `json:"force,omitempty"`
+}
+
+type AgentRunRevertConflict struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type AgentRunRevertResult struct {
+	RunID     string                   `json:"run_id"`
+	Reverted  []string                 `json:"reverted"`
+	Conflicts []AgentRunRevertConflict `json:"conflicts"`
+}
+
 type AgentProcessResponse struct {
 	Reply  string       `json:"reply"`
 	Events []AgentEvent `json:"events,omitempty"`
@@ -257,13 +380,15 @@ type ModelSlotConfig struct {
 }
 
 type ActiveModelsInfo struct {
-	ActiveLLM ModelSlotConfig `json:"active_llm"`
+	ActiveLLM    ModelSlotConfig   `json:"active_llm"`
+	FallbackLLMs []ModelSlotConfig `json:"fallback_llms"`
 }
 
 type ModelCatalogInfo struct {
 	Providers     []ProviderInfo     `json:"providers"`
 	Defaults      map[string]string  `json:"defaults"`
 	ActiveLLM     ModelSlotConfig    `json:"active_llm"`
+	FallbackLLMs  []ModelSlotConfig  `json:"fallback_llms"`
 	ProviderTypes []ProviderTypeInfo `json:"provider_types"`
 }
 
@@ -273,13 +398,52 @@ type EnvVar struct {
 }
 
 type SkillSpec struct {
-	Name       string                 `json:"name"`
-	Content    string                 `json:"content"`
-	Source     string                 `json:"source"`
-	Path       string                 `json:"path"`
-	References map[string]interface{} `json:"references"`
-	Scripts    map[string]interface{} `json:"scripts"`
-	Enabled    bool                   `json:"enabled"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Triggers    []string               `json:"triggers,omitempty"`
+	Version     string                 `json:"version,omitempty"`
+	Content     string                 `json:"content"`
+	Source      string                 `json:"source"`
+	Path        string                 `json:"path"`
+	References  map[string]interface{} `json:"references"`
+	Scripts     map[string]interface{} `json:"scripts"`
+	Tools       []SkillTool            `json:"tools,omitempty"`
+	Enabled     bool                   `json:"enabled"`
+}
+
+// SkillTool declares a file under scripts/ that is exposed to the agent as
+// the tool skill.<skill>.<name> while the skill is enabled.
+type SkillTool struct {
+	Name           string                 `json:"name"`
+	Script         string                 `json:"script"`
+	Interpreter    string                 `json:"interpreter,omitempty"`
+	Description    string                 `json:"description,omitempty"`
+	Parameters     map[string]interface{} `json:"parameters,omitempty"`
+	TimeoutSeconds int                    `json:"timeout_seconds,omitempty"`
+}
+
+// SkillVersionInfo describes one archived version of a skill.
+type SkillVersionInfo struct {
+	Version   string `json:"version"`
+	Checksum  string `json:"checksum"`
+	Size      int64  `json:"size"`
+	CreatedAt string `json:"created_at"`
+	Current   bool   `json:"current"`
+}
+
+// SkillImportResult compares an imported skill archive with the installed
+// skill. Applied is false for a dry run.
+type SkillImportResult struct {
+	Name             string   `json:"name"`
+	Version          string   `json:"version"`
+	Checksum         string   `json:"checksum"`
+	PreviousVersion  string   `json:"previous_version,omitempty"`
+	PreviousChecksum string   `json:"previous_checksum,omitempty"`
+	Change           string   `json:"change"`
+	Added            []string `json:"added"`
+	Removed          []string `json:"removed"`
+	Modified         []string `json:"modified"`
+	Applied          bool     `json:"applied"`
 }
 
 type ChannelConfigMap map[string]map[string]interface{}
@@ -1,9 +1,11 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -25,7 +27,7 @@ var (
 	ErrBrowserToolTaskMissing      = errors.New("browser_tool_task_missing")
 )
 
-type browserToolRunFunc func(ctx context.Context, agentDir, task string, timeout time.Duration) (string, int, error)
+type browserToolRunFunc func(ctx context.Context, agentDir, task string, timeout time.Duration, stream io.Writer) (string, int, error)
 
 type browserTaskItem struct {
 	Task    string
@@ -72,7 +74,48 @@ func (t *BrowserTool) Name() string {
 	return "browser"
 }
 
+func (t *BrowserTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "Delegate browser tasks to local Playwright agent script. input must be an array.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Array of browser tasks; pass one item for single task.",
+					"minItems":    1,
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"task": map[string]interface{}{
+								"type":        "string",
+								"description": "Natural language task for browser agent. Required unless the legacy query alias is set.",
+							},
+							"query": map[string]interface{}{
+								"type":        "string",
+								"description": "Deprecated alias for task.",
+							},
+							"timeout_seconds": map[string]interface{}{
+								"type":        []string{"integer", "string"},
+								"minimum":     1,
+								"description": "Optional timeout in seconds; numeric strings are accepted.",
+							},
+						},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"items"},
+			"additionalProperties": false,
+		},
+	}
+}
+
 func (t *BrowserTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *BrowserTool) InvokeContext(ctx context.Context, inv ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
 	items, err := parseBrowserItems(input)
 	if err != nil {
 		return nil, err
@@ -81,7 +124,7 @@ func (t *BrowserTool) Invoke(input map[string]interface{}) (map[string]interface
 	results := make([]map[string]interface{}, 0, len(items))
 	allOK := true
 	for _, item := range items {
-		one, oneErr := t.invokeOne(item)
+		one, oneErr := t.invokeOne(ctx, item, inv.OutputWriter())
 		if oneErr != nil {
 			return nil, oneErr
 		}
@@ -109,9 +152,12 @@ func (t *BrowserTool) Invoke(input map[string]interface{}) (map[string]interface
 	}, nil
 }
 
-func (t *BrowserTool) invokeOne(item browserTaskItem) (map[string]interface{}, error) {
+func (t *BrowserTool) invokeOne(ctx context.Context, item browserTaskItem, stream io.Writer) (map[string]interface{}, error) {
 	startedAt := time.Now()
-	output, exitCode, err := t.runFn(context.Background(), t.agentDir, item.Task, item.Timeout)
+	output, exitCode, err := t.runFn(ctx, t.agentDir, item.Task, item.Timeout, stream)
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
 	ok := err == nil
 
 	result := map[string]interface{}{
@@ -191,14 +237,22 @@ func parseBrowserTimeout(raw interface{}) time.Duration {
 	return time.Duration(seconds) * time.Second
 }
 
-func runBrowserToolCommand(ctx context.Context, agentDir, task string, timeout time.Duration) (string, int, error) {
+func runBrowserToolCommand(ctx context.Context, agentDir, task string, timeout time.Duration, stream io.Writer) (string, int, error) {
 	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(cmdCtx, "node", "agent.js", task)
+	configureProcessGroup(cmd)
 	cmd.Dir = agentDir
-	outputBytes, err := cmd.CombinedOutput()
-	output := truncateOutput(string(outputBytes), browserToolMaxOutputBytes)
+	var outputBuf bytes.Buffer
+	if stream != nil {
+		cmd.Stdout = io.MultiWriter(&outputBuf, stream)
+	} else {
+		cmd.Stdout = &outputBuf
+	}
+	cmd.Stderr = cmd.Stdout
+	err := cmd.Run()
+	output := truncateOutput(outputBuf.String(), browserToolMaxOutputBytes)
 	if err != nil {
 		if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
 			return output, 124, cmdCtx.Err()
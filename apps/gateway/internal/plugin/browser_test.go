@@ -3,6 +3,7 @@ package plugin
 import (
 	"context"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -26,7 +27,7 @@ func TestBrowserToolInvokeParsesRunMeta(t *testing.T) {
 	if err != nil {
 		t.Fatalf("new browser tool failed: %v", err)
 	}
-	tool.runFn = func(_ context.Context, _ string, task string, _ time.Duration) (string, int, error) {
+	tool.runFn = func(_ context.Context, _ string, task string, _ time.Duration, _ io.Writer) (string, int, error) {
 		if task != "打开 bing 搜索 nextai" {
 			t.Fatalf("unexpected task: %q", task)
 		}
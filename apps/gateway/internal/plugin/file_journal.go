@@ -0,0 +1,215 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const fileJournalIndexName = "index.json"
+
+var ErrFileJournalNotFound = errors.New("file_journal_not_found")
+
+// FileJournal keeps the before-image of every file the file tools change
+// during an agent run, so that the run can be rolled back. Only the first
+// image per path is kept; later writes just move the recorded after-hash.
+// Images live on disk under dir/<run_id>/, the index is rewritten on every
+// change.
+type FileJournal struct {
+	dir string
+	mu  sync.Mutex
+}
+
+type fileJournalEntry struct {
+	Path      string      `json:"path"`
+	Existed   bool        `json:"existed"`
+	Blob      string      `json:"blob,omitempty"`
+	Perm      os.FileMode `json:"perm"`
+	AfterHash string      `json:"after_hash"`
+	Writes    int         `json:"writes"`
+}
+
+type FileRevertConflict struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type FileRevertResult struct {
+	Reverted  []string             `json:"reverted"`
+	Conflicts []FileRevertConflict `json:"conflicts,omitempty"`
+}
+
+func NewFileJournal(dir string) *FileJournal {
+	return &FileJournal{dir: dir}
+}
+
+// Reset drops every recorded run. The agent run registry does not survive a
+// restart, so journals from a previous process can no longer be reverted.
+func (j *FileJournal) Reset() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return os.RemoveAll(j.dir)
+}
+
+// Forget drops the journal of one run.
+func (j *FileJournal) Forget(runID string) {
+	if j == nil || runID == "" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_ = os.RemoveAll(j.runDir(runID))
+}
+
+// writeFile writes content to path and records the before-image under runID.
+// A nil journal or an empty runID writes without recording.
+func (j *FileJournal) writeFile(runID, path string, content []byte, perm os.FileMode) error {
+	if j == nil || runID == "" {
+		return os.WriteFile(path, content, perm)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries, err := j.loadLocked(runID)
+	if err != nil {
+		return err
+	}
+	idx := -1
+	for i := range entries {
+		if entries[i].Path == path {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		entry, err := j.captureLocked(runID, path, len(entries))
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		idx = len(entries) - 1
+	}
+	if err := os.WriteFile(path, content, perm); err != nil {
+		return err
+	}
+	entries[idx].AfterHash = contentHash(content)
+	entries[idx].Writes++
+	return j.saveLocked(runID, entries)
+}
+
+func (j *FileJournal) captureLocked(runID, path string, seq int) (fileJournalEntry, error) {
+	entry := fileJournalEntry{Path: path, Perm: 0o644}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entry, nil
+	}
+	if err != nil {
+		return entry, err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+	if err := os.MkdirAll(j.runDir(runID), 0o755); err != nil {
+		return entry, err
+	}
+	entry.Existed = true
+	entry.Perm = info.Mode().Perm()
+	entry.Blob = strconv.Itoa(seq) + ".orig"
+	if err := os.WriteFile(filepath.Join(j.runDir(runID), entry.Blob), raw, 0o600); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// Revert restores every file changed by runID to its before-image, deleting
+// files the run created. A file that changed after the run's last write is a
+// conflict; unless force is set nothing is restored when any conflict exists.
+// The journal is dropped once the run has been reverted.
+func (j *FileJournal) Revert(runID string, force bool) (FileRevertResult, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	result := FileRevertResult{Reverted: []string{}}
+	entries, err := j.loadLocked(runID)
+	if err != nil {
+		return result, err
+	}
+	if len(entries) == 0 {
+		return result, fmt.Errorf("%w: %s", ErrFileJournalNotFound, runID)
+	}
+	for _, entry := range entries {
+		current, err := os.ReadFile(entry.Path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			result.Conflicts = append(result.Conflicts, FileRevertConflict{Path: entry.Path, Reason: "file was removed after the run changed it"})
+		case err != nil:
+			result.Conflicts = append(result.Conflicts, FileRevertConflict{Path: entry.Path, Reason: err.Error()})
+		case contentHash(current) != entry.AfterHash:
+			result.Conflicts = append(result.Conflicts, FileRevertConflict{Path: entry.Path, Reason: "file changed after the run's last edit"})
+		}
+	}
+	if len(result.Conflicts) > 0 && !force {
+		return result, nil
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !entry.Existed {
+			if err := os.Remove(entry.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return result, err
+			}
+			result.Reverted = append(result.Reverted, entry.Path)
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(j.runDir(runID), entry.Blob))
+		if err != nil {
+			return result, err
+		}
+		if err := os.WriteFile(entry.Path, raw, entry.Perm); err != nil {
+			return result, err
+		}
+		result.Reverted = append(result.Reverted, entry.Path)
+	}
+	sort.Strings(result.Reverted)
+	return result, os.RemoveAll(j.runDir(runID))
+}
+
+func (j *FileJournal) runDir(runID string) string {
+	return filepath.Join(j.dir, filepath.Base(filepath.Clean("/"+runID)))
+}
+
+func (j *FileJournal) loadLocked(runID string) ([]fileJournalEntry, error) {
+	raw, err := os.ReadFile(filepath.Join(j.runDir(runID), fileJournalIndexName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []fileJournalEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (j *FileJournal) saveLocked(runID string, entries []fileJournalEntry) error {
+	if err := os.MkdirAll(j.runDir(runID), 0o755); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(j.runDir(runID), fileJournalIndexName), raw, 0o600)
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileJournalRevertsRunEdits(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"a.txt": "one\ntwo\n"})
+	journal := NewFileJournal(filepath.Join(t.TempDir(), "journal"))
+	policy := FilePolicy{AllowedRoots: []string{root}}
+	inv := ToolInvocation{RunID: "run-1"}
+	existing := filepath.Join(root, "a.txt")
+	created := filepath.Join(root, "new", "b.txt")
+
+	edit := NewEditFileLinesToolWithPolicy(policy, journal)
+	for _, content := range []string{"TWO", "2"} {
+		if _, err := edit.InvokeContext(context.Background(), inv, fileToolItems(map[string]interface{}{"path": existing, "start": 2, "end": 2, "content": content})); err != nil {
+			t.Fatalf("edit failed: %v", err)
+		}
+	}
+	if _, err := NewWriteFileTool(policy, journal).InvokeContext(context.Background(), inv, fileToolItems(map[string]interface{}{"path": created, "content": "b\n"})); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	result, err := journal.Revert("run-1", false)
+	if err != nil {
+		t.Fatalf("revert failed: %v", err)
+	}
+	if len(result.Reverted) != 2 || len(result.Conflicts) != 0 {
+		t.Fatalf("unexpected revert result: %#v", result)
+	}
+	raw, _ := os.ReadFile(existing)
+	if string(raw) != "one\ntwo\n" {
+		t.Fatalf("expected original content, got=%q", raw)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Fatalf("expected created file to be removed, got=%v", err)
+	}
+	if _, err := journal.Revert("run-1", false); !errors.Is(err, ErrFileJournalNotFound) {
+		t.Fatalf("expected journal to be dropped after revert, got=%v", err)
+	}
+}
+
+func TestFileJournalRevertReportsLaterChanges(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"a.txt": "one\n"})
+	journal := NewFileJournal(filepath.Join(t.TempDir(), "journal"))
+	target := filepath.Join(root, "a.txt")
+	if err := journal.writeFile("run-1", target, []byte("run\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("user\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := journal.Revert("run-1", false)
+	if err != nil {
+		t.Fatalf("revert failed: %v", err)
+	}
+	if len(result.Conflicts) != 1 || len(result.Reverted) != 0 {
+		t.Fatalf("expected one conflict and nothing reverted, got=%#v", result)
+	}
+	if raw, _ := os.ReadFile(target); string(raw) != "user\n" {
+		t.Fatalf("file changed despite conflict: %q", raw)
+	}
+
+	if _, err := journal.Revert("run-1", true); err != nil {
+		t.Fatalf("forced revert failed: %v", err)
+	}
+	if raw, _ := os.ReadFile(target); string(raw) != "one\n" {
+		t.Fatalf("expected forced revert to restore original, got=%q", raw)
+	}
+}
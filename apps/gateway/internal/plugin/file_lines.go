@@ -1,6 +1,7 @@
 package plugin
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -24,20 +25,70 @@ var (
 	ErrFileLinesToolFileNotFound   = errors.New("file_lines_tool_file_not_found")
 	ErrFileLinesToolFileRead       = errors.New("file_lines_tool_file_read_failed")
 	ErrFileLinesToolFileWrite      = errors.New("file_lines_tool_file_write_failed")
+	ErrFileLinesToolStale          = errors.New("file_lines_tool_stale")
 )
 
 type ViewFileLinesTool struct {
+	policy FilePolicy
 }
 
-func NewViewFileLinesTool(_ string) *ViewFileLinesTool {
-	return &ViewFileLinesTool{}
+// NewViewFileLinesTool confines the tool to root when it is not empty.
+func NewViewFileLinesTool(root string) *ViewFileLinesTool {
+	return NewViewFileLinesToolWithPolicy(rootFilePolicy(root))
+}
+
+func NewViewFileLinesToolWithPolicy(policy FilePolicy) *ViewFileLinesTool {
+	return &ViewFileLinesTool{policy: policy}
 }
 
 func (t *ViewFileLinesTool) Name() string {
 	return "view"
 }
 
+func (t *ViewFileLinesTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "Read line ranges for one or multiple files. input must be an array.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Array of view operations; pass one item for single-file view.",
+					"minItems":    1,
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"path": map[string]interface{}{
+								"type":        "string",
+								"description": "Absolute file path on local filesystem.",
+							},
+							"start": map[string]interface{}{
+								"type":        "integer",
+								"minimum":     1,
+								"description": "1-based starting line number (inclusive).",
+							},
+							"end": map[string]interface{}{
+								"type":        "integer",
+								"minimum":     1,
+								"description": "1-based ending line number (inclusive).",
+							},
+						},
+						"required":             []string{"path", "start", "end"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"items"},
+			"additionalProperties": false,
+		},
+	}
+}
+
 func (t *ViewFileLinesTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *ViewFileLinesTool) InvokeContext(_ context.Context, _ ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
 	items, err := parseInvocationItems(input, true)
 	if err != nil {
 		return nil, err
@@ -69,24 +120,88 @@ func (t *ViewFileLinesTool) Invoke(input map[string]interface{}) (map[string]int
 }
 
 type EditFileLinesTool struct {
+	policy  FilePolicy
+	journal *FileJournal
+}
+
+// NewEditFileLinesTool confines the tool to root when it is not empty.
+func NewEditFileLinesTool(root string) *EditFileLinesTool {
+	return NewEditFileLinesToolWithPolicy(rootFilePolicy(root), nil)
 }
 
-func NewEditFileLinesTool(_ string) *EditFileLinesTool {
-	return &EditFileLinesTool{}
+// NewEditFileLinesToolWithPolicy records before-images in journal when it is
+// not nil.
+func NewEditFileLinesToolWithPolicy(policy FilePolicy, journal *FileJournal) *EditFileLinesTool {
+	return &EditFileLinesTool{policy: policy, journal: journal}
 }
 
 func (t *EditFileLinesTool) Name() string {
 	return "edit"
 }
 
+func (t *EditFileLinesTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "Replace line ranges for one or multiple files. input must be an array.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Array of edit operations; pass one item for single-file edit.",
+					"minItems":    1,
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"path": map[string]interface{}{
+								"type":        "string",
+								"description": "Absolute file path on local filesystem.",
+							},
+							"start": map[string]interface{}{
+								"type":        "integer",
+								"minimum":     1,
+								"description": "1-based starting line number (inclusive).",
+							},
+							"end": map[string]interface{}{
+								"type":        "integer",
+								"minimum":     1,
+								"description": "1-based ending line number (inclusive).",
+							},
+							"content": map[string]interface{}{
+								"type":        "string",
+								"description": "Replacement text for the selected line range.",
+							},
+							"expected_old_text": map[string]interface{}{
+								"type":        "string",
+								"description": "Optional. Current text of the selected lines; the edit fails if the file no longer matches.",
+							},
+							"expected_sha256": map[string]interface{}{
+								"type":        "string",
+								"description": "Optional. File hash (sha256=...) reported by the last view or edit; the edit fails if the file changed since.",
+							},
+						},
+						"required":             []string{"path", "start", "end", "content"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"items"},
+			"additionalProperties": false,
+		},
+	}
+}
+
 func (t *EditFileLinesTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *EditFileLinesTool) InvokeContext(_ context.Context, inv ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
 	items, err := parseInvocationItems(input, true)
 	if err != nil {
 		return nil, err
 	}
 	results := make([]map[string]interface{}, 0, len(items))
 	for _, item := range items {
-		editResult, editErr := t.editOne(item)
+		editResult, editErr := t.editOne(inv.RunID, item)
 		if editErr != nil {
 			return nil, editErr
 		}
@@ -111,7 +226,7 @@ func (t *EditFileLinesTool) Invoke(input map[string]interface{}) (map[string]int
 }
 
 func (t *ViewFileLinesTool) viewOne(input map[string]interface{}) (map[string]interface{}, error) {
-	relPath, absPath, err := resolveFileLinesPath(input)
+	relPath, absPath, err := resolveFileLinesPath(input, t.policy, false)
 	if err != nil {
 		return nil, err
 	}
@@ -129,6 +244,7 @@ func (t *ViewFileLinesTool) viewOne(input map[string]interface{}) (map[string]in
 	}
 	lines, _ := splitFileLines(string(raw))
 	total := len(lines)
+	hash := contentHash(raw)
 	if total == 0 {
 		return map[string]interface{}{
 			"ok":          true,
@@ -137,11 +253,13 @@ func (t *ViewFileLinesTool) viewOne(input map[string]interface{}) (map[string]in
 			"end":         0,
 			"total_lines": 0,
 			"content":     "",
+			"sha256":      hash,
 			"text": fmt.Sprintf(
-				"view %s [empty] (fallback from requested [%d-%d], total=0)",
+				"view %s [empty] (fallback from requested [%d-%d], total=0) sha256=%s",
 				relPath,
 				start,
 				end,
+				shortContentHash(hash),
 			),
 		}, nil
 	}
@@ -161,16 +279,24 @@ func (t *ViewFileLinesTool) viewOne(input map[string]interface{}) (map[string]in
 		lineNo := actualStart + idx
 		numbered = append(numbered, fmt.Sprintf("%d: %s", lineNo, line))
 	}
-	text := fmt.Sprintf("view %s [%d-%d]\n%s", relPath, actualStart, actualEnd, strings.Join(numbered, "\n"))
+	text := fmt.Sprintf(
+		"view %s [%d-%d] sha256=%s\n%s",
+		relPath,
+		actualStart,
+		actualEnd,
+		shortContentHash(hash),
+		strings.Join(numbered, "\n"),
+	)
 	if fallbackToFull {
 		text = fmt.Sprintf(
-			"view %s [%d-%d] (fallback from requested [%d-%d], total=%d)\n%s",
+			"view %s [%d-%d] (fallback from requested [%d-%d], total=%d) sha256=%s\n%s",
 			relPath,
 			actualStart,
 			actualEnd,
 			start,
 			end,
 			total,
+			shortContentHash(hash),
 			strings.Join(numbered, "\n"),
 		)
 	}
@@ -181,12 +307,13 @@ func (t *ViewFileLinesTool) viewOne(input map[string]interface{}) (map[string]in
 		"end":         actualEnd,
 		"total_lines": total,
 		"content":     content,
+		"sha256":      hash,
 		"text":        text,
 	}, nil
 }
 
-func (t *EditFileLinesTool) editOne(input map[string]interface{}) (map[string]interface{}, error) {
-	relPath, absPath, err := resolveFileLinesPath(input)
+func (t *EditFileLinesTool) editOne(runID string, input map[string]interface{}) (map[string]interface{}, error) {
+	relPath, absPath, err := resolveFileLinesPath(input, t.policy, true)
 	if err != nil {
 		return nil, err
 	}
@@ -215,6 +342,9 @@ func (t *EditFileLinesTool) editOne(input map[string]interface{}) (map[string]in
 	if total == 0 || start > total || end > total {
 		return nil, fmt.Errorf("%w: path=%s total=%d range=%d-%d", ErrFileLinesToolOutOfRange, relPath, total, start, end)
 	}
+	if err := checkEditExpectations(input, raw, lines[start-1:end]); err != nil {
+		return nil, fmt.Errorf("%w: %s %v", ErrFileLinesToolStale, relPath, err)
+	}
 
 	replLines, _ := splitFileLines(content)
 	updatedLines := make([]string, 0, len(lines)-((end-start)+1)+len(replLines))
@@ -231,12 +361,13 @@ func (t *EditFileLinesTool) editOne(input map[string]interface{}) (map[string]in
 	if info, statErr := os.Stat(absPath); statErr == nil {
 		perm = info.Mode().Perm()
 	}
-	if err := os.WriteFile(absPath, []byte(output), perm); err != nil {
+	if err := t.journal.writeFile(runID, absPath, []byte(output), perm); err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrFileLinesToolFileWrite, err)
 	}
 
 	changed := end - start + 1
-	text := fmt.Sprintf("edit %s [%d-%d] replaced %d line(s).", relPath, start, end, changed)
+	hash := contentHash([]byte(output))
+	text := fmt.Sprintf("edit %s [%d-%d] replaced %d line(s). sha256=%s", relPath, start, end, changed, shortContentHash(hash))
 	return map[string]interface{}{
 		"ok":                true,
 		"path":              relPath,
@@ -245,10 +376,49 @@ func (t *EditFileLinesTool) editOne(input map[string]interface{}) (map[string]in
 		"replaced_lines":    changed,
 		"inserted_lines":    len(replLines),
 		"total_lines_after": len(updatedLines),
+		"sha256":            hash,
 		"text":              text,
 	}, nil
 }
 
+// checkEditExpectations guards against editing a file that changed since the
+// caller last viewed it. expected_sha256 may be the full hash of the file or a
+// prefix of at least 8 hex digits; expected_old_text must equal the lines being
+// replaced.
+func checkEditExpectations(input map[string]interface{}, raw []byte, current []string) error {
+	if expected := strings.ToLower(strings.TrimSpace(stringValue(input["expected_sha256"]))); expected != "" {
+		hash := contentHash(raw)
+		if len(expected) < 8 || !strings.HasPrefix(hash, expected) {
+			return fmt.Errorf("sha256 is %s, expected %s", shortContentHash(hash), expected)
+		}
+	}
+	if expectedRaw, ok := input["expected_old_text"]; ok && expectedRaw != nil {
+		expected, _ := expectedRaw.(string)
+		expected = strings.TrimSuffix(strings.ReplaceAll(expected, "\r\n", "\n"), "\n")
+		if actual := strings.Join(current, "\n"); actual != expected {
+			return fmt.Errorf("lines now read %q", clipStaleText(actual))
+		}
+	}
+	return nil
+}
+
+func clipStaleText(text string) string {
+	const limit = 200
+	if len(text) <= limit {
+		return text
+	}
+	return text[:limit] + "..."
+}
+
+// shortContentHash is the hash prefix shown to the model; edit accepts it as
+// expected_sha256.
+func shortContentHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
 func parseLineRange(input map[string]interface{}) (int, int, error) {
 	startRaw := input["start"]
 	if startRaw == nil {
@@ -299,7 +469,9 @@ func parseLineNumber(raw interface{}, fallback error) (int, error) {
 	return 0, fallback
 }
 
-func resolveFileLinesPath(input map[string]interface{}) (string, string, error) {
+// resolveFileLinesPath returns the cleaned path for display and the
+// symlink-resolved path for file access.
+func resolveFileLinesPath(input map[string]interface{}, policy FilePolicy, write bool) (string, string, error) {
 	path := strings.TrimSpace(stringValue(input["path"]))
 	if path == "" {
 		return "", "", ErrFileLinesToolPathMissing
@@ -308,7 +480,19 @@ func resolveFileLinesPath(input map[string]interface{}) (string, string, error)
 	if err != nil {
 		return "", "", err
 	}
-	return absPath, absPath, nil
+	resolved, err := policy.resolvePath(absPath, write)
+	if err != nil {
+		return "", "", err
+	}
+	return absPath, resolved, nil
+}
+
+func rootFilePolicy(root string) FilePolicy {
+	policy := FilePolicy{DenyGlobs: DefaultFileDenyGlobs}
+	if trimmed := strings.TrimSpace(root); trimmed != "" {
+		policy.AllowedRoots = []string{trimmed}
+	}
+	return policy
 }
 
 func normalizeAbsolutePath(raw string) (string, error) {
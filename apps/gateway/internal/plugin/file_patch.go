@@ -0,0 +1,396 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrFileToolPatchInvalid = errors.New("file_tool_patch_invalid")
+
+var patchHunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// PatchFileTool applies a unified diff to one file. Hunks are located at their
+// recorded line first and then at the nearest position where the old lines
+// match exactly. A file is only written when every hunk applies; otherwise the
+// result reports the conflicting hunks and the file is left untouched.
+type PatchFileTool struct {
+	policy  FilePolicy
+	journal *FileJournal
+}
+
+func NewPatchFileTool(policy FilePolicy, journal *FileJournal) *PatchFileTool {
+	return &PatchFileTool{policy: policy, journal: journal}
+}
+
+func (t *PatchFileTool) Name() string {
+	return "patch"
+}
+
+func (t *PatchFileTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "Apply a unified diff (one or more @@ hunks) to a file. The file is only written when every hunk applies; otherwise the result lists the conflicting hunks and the current file lines. Use /dev/null style @@ -0,0 hunks to create a file. input must be an array.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Array of patch operations, one per file.",
+					"minItems":    1,
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"path": map[string]interface{}{
+								"type":        "string",
+								"description": "Absolute path of the file to patch.",
+							},
+							"patch": map[string]interface{}{
+								"type":        "string",
+								"description": "Unified diff for this file; ---/+++ headers are optional.",
+							},
+						},
+						"required":             []string{"path", "patch"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"items"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+func (t *PatchFileTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *PatchFileTool) InvokeContext(_ context.Context, inv ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	return invokeFileItems(input, "\n", func(item map[string]interface{}) (map[string]interface{}, error) {
+		return t.patchOne(inv.RunID, item)
+	})
+}
+
+type patchHunk struct {
+	Header   string
+	OldStart int
+	OldLines []string
+	NewLines []string
+	// OldNoEOL and NewNoEOL record "\ No newline at end of file" markers.
+	OldNoEOL bool
+	NewNoEOL bool
+}
+
+type patchConflict struct {
+	Hunk   int
+	Header string
+	Reason string
+	Actual []string
+}
+
+func (t *PatchFileTool) patchOne(runID string, input map[string]interface{}) (map[string]interface{}, error) {
+	displayPath, absPath, err := resolveFileLinesPath(input, t.policy, true)
+	if err != nil {
+		return nil, err
+	}
+	diff, ok := input["patch"].(string)
+	if !ok || strings.TrimSpace(diff) == "" {
+		return nil, fmt.Errorf("%w: patch is required", ErrFileToolPatchInvalid)
+	}
+	hunks, err := parseUnifiedDiff(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	created := false
+	perm := os.FileMode(0o644)
+	raw, err := os.ReadFile(absPath)
+	switch {
+	case err == nil:
+		if info, statErr := os.Stat(absPath); statErr == nil {
+			perm = info.Mode().Perm()
+		}
+	case os.IsNotExist(err) && patchCreatesFile(hunks):
+		created = true
+	case os.IsNotExist(err):
+		return nil, fmt.Errorf("%w: %s", ErrFileLinesToolFileNotFound, displayPath)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrFileLinesToolFileRead, err)
+	}
+
+	lines, trailingNewline := splitFileLines(string(raw))
+	updated, trailingNewline, conflicts := applyPatchHunks(lines, trailingNewline, hunks)
+	if len(conflicts) > 0 {
+		details := make([]map[string]interface{}, 0, len(conflicts))
+		texts := make([]string, 0, len(conflicts))
+		for _, conflict := range conflicts {
+			details = append(details, map[string]interface{}{
+				"hunk":   conflict.Hunk,
+				"header": conflict.Header,
+				"reason": conflict.Reason,
+				"actual": strings.Join(conflict.Actual, "\n"),
+			})
+			text := fmt.Sprintf("hunk %d %s: %s", conflict.Hunk, conflict.Header, conflict.Reason)
+			if len(conflict.Actual) > 0 {
+				text += "\nfile has:\n" + strings.Join(conflict.Actual, "\n")
+			}
+			texts = append(texts, text)
+		}
+		return map[string]interface{}{
+			"ok":        false,
+			"path":      displayPath,
+			"hunks":     len(hunks),
+			"conflicts": details,
+			"text": fmt.Sprintf(
+				"patch %s not applied: %d of %d hunk(s) conflict; file unchanged.\n%s",
+				displayPath,
+				len(conflicts),
+				len(hunks),
+				strings.Join(texts, "\n"),
+			),
+		}, nil
+	}
+
+	output := strings.Join(updated, "\n")
+	if trailingNewline && len(updated) > 0 {
+		output += "\n"
+	}
+	if created {
+		if err := os.MkdirAll(filepath.Dir(absPath), 0o755); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFileLinesToolFileWrite, err)
+		}
+	}
+	if err := t.journal.writeFile(runID, absPath, []byte(output), perm); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileLinesToolFileWrite, err)
+	}
+	return map[string]interface{}{
+		"ok":                true,
+		"path":              displayPath,
+		"created":           created,
+		"hunks":             len(hunks),
+		"total_lines_after": len(updated),
+		"text":              fmt.Sprintf("patch %s applied %d hunk(s), %d line(s) now.", displayPath, len(hunks), len(updated)),
+	}, nil
+}
+
+// parseUnifiedDiff reads the hunks of a single-file unified diff. File headers
+// (`diff`, `index`, `---`, `+++`) and text before the first hunk are ignored.
+func parseUnifiedDiff(diff string) ([]patchHunk, error) {
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	hunks := make([]patchHunk, 0)
+	var current *patchHunk
+	oldLeft, newLeft := 0, 0
+	lastKind := byte(0)
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if oldLeft > 0 || newLeft > 0 {
+			return fmt.Errorf("%w: hunk %q is shorter than its header", ErrFileToolPatchInvalid, current.Header)
+		}
+		hunks = append(hunks, *current)
+		current = nil
+		return nil
+	}
+	for idx, line := range lines {
+		if match := patchHunkHeader.FindStringSubmatch(line); match != nil {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			oldStart, _ := strconv.Atoi(match[1])
+			oldLeft = patchRangeCount(match[2])
+			newLeft = patchRangeCount(match[4])
+			current = &patchHunk{Header: strings.TrimSpace(match[0]), OldStart: oldStart}
+			lastKind = 0
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if oldLeft == 0 && newLeft == 0 {
+			if strings.HasPrefix(line, `\`) {
+				markPatchNoEOL(current, lastKind)
+				continue
+			}
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if line == "" && idx == len(lines)-1 {
+			break
+		}
+		kind := byte(' ')
+		text := line
+		if line != "" {
+			kind, text = line[0], line[1:]
+		}
+		switch kind {
+		case ' ':
+			current.OldLines = append(current.OldLines, text)
+			current.NewLines = append(current.NewLines, text)
+			oldLeft--
+			newLeft--
+		case '-':
+			current.OldLines = append(current.OldLines, text)
+			oldLeft--
+		case '+':
+			current.NewLines = append(current.NewLines, text)
+			newLeft--
+		case '\\':
+			markPatchNoEOL(current, lastKind)
+			continue
+		default:
+			return nil, fmt.Errorf("%w: unexpected line %q in hunk %q", ErrFileToolPatchInvalid, line, current.Header)
+		}
+		if oldLeft < 0 || newLeft < 0 {
+			return nil, fmt.Errorf("%w: hunk %q is longer than its header", ErrFileToolPatchInvalid, current.Header)
+		}
+		lastKind = kind
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("%w: no hunks found", ErrFileToolPatchInvalid)
+	}
+	return hunks, nil
+}
+
+func patchRangeCount(raw string) int {
+	if raw == "" {
+		return 1
+	}
+	count, _ := strconv.Atoi(raw)
+	return count
+}
+
+func markPatchNoEOL(hunk *patchHunk, kind byte) {
+	switch kind {
+	case '-':
+		hunk.OldNoEOL = true
+	case '+':
+		hunk.NewNoEOL = true
+	case ' ':
+		hunk.OldNoEOL = true
+		hunk.NewNoEOL = true
+	}
+}
+
+func patchCreatesFile(hunks []patchHunk) bool {
+	for _, hunk := range hunks {
+		if hunk.OldStart != 0 || len(hunk.OldLines) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// applyPatchHunks returns the patched lines, whether the result ends with a
+// newline, and any hunks that could not be placed.
+func applyPatchHunks(lines []string, trailingNewline bool, hunks []patchHunk) ([]string, bool, []patchConflict) {
+	out := make([]string, 0, len(lines))
+	conflicts := make([]patchConflict, 0)
+	cursor := 0
+	offset := 0
+	for idx, hunk := range hunks {
+		expected := hunk.OldStart - 1 + offset
+		if len(hunk.OldLines) == 0 {
+			expected = hunk.OldStart + offset
+		}
+		pos, ok := locatePatchHunk(lines, hunk.OldLines, expected, cursor)
+		if !ok {
+			conflicts = append(conflicts, describePatchConflict(idx+1, hunk, lines, expected))
+			continue
+		}
+		out = append(out, lines[cursor:pos]...)
+		out = append(out, hunk.NewLines...)
+		cursor = pos + len(hunk.OldLines)
+		offset = pos - (hunk.OldStart - 1)
+		if len(hunk.OldLines) == 0 {
+			offset = pos - hunk.OldStart
+		}
+		if cursor == len(lines) {
+			if hunk.NewNoEOL {
+				trailingNewline = false
+			} else if hunk.OldNoEOL || len(lines) == 0 {
+				trailingNewline = true
+			}
+		}
+	}
+	out = append(out, lines[cursor:]...)
+	return out, trailingNewline, conflicts
+}
+
+// locatePatchHunk tries the expected position first and then searches
+// outwards, never before cursor so hunks cannot overlap.
+func locatePatchHunk(lines, old []string, expected, cursor int) (int, bool) {
+	maxPos := len(lines) - len(old)
+	if maxPos < cursor {
+		return 0, false
+	}
+	if expected < cursor {
+		expected = cursor
+	}
+	if expected > maxPos {
+		expected = maxPos
+	}
+	for delta := 0; ; delta++ {
+		before, after := expected-delta, expected+delta
+		if before < cursor && after > maxPos {
+			return 0, false
+		}
+		if after <= maxPos && patchLinesMatch(lines, old, after) {
+			return after, true
+		}
+		if delta > 0 && before >= cursor && patchLinesMatch(lines, old, before) {
+			return before, true
+		}
+	}
+}
+
+func patchLinesMatch(lines, old []string, pos int) bool {
+	if pos < 0 || pos+len(old) > len(lines) {
+		return false
+	}
+	for idx, line := range old {
+		if lines[pos+idx] != line {
+			return false
+		}
+	}
+	return true
+}
+
+func describePatchConflict(number int, hunk patchHunk, lines []string, expected int) patchConflict {
+	conflict := patchConflict{Hunk: number, Header: hunk.Header}
+	if expected < 0 {
+		expected = 0
+	}
+	if expected >= len(lines) {
+		conflict.Reason = fmt.Sprintf("expected content at line %d but the file has %d line(s)", expected+1, len(lines))
+		return conflict
+	}
+	for idx, want := range hunk.OldLines {
+		if expected+idx >= len(lines) {
+			conflict.Reason = fmt.Sprintf("file ends before line %d; expected %q", expected+idx+1, want)
+			break
+		}
+		if got := lines[expected+idx]; got != want {
+			conflict.Reason = fmt.Sprintf("line %d is %q, expected %q", expected+idx+1, got, want)
+			break
+		}
+	}
+	if conflict.Reason == "" {
+		conflict.Reason = "hunk overlaps an earlier hunk"
+	}
+	end := expected + len(hunk.OldLines)
+	if end > len(lines) {
+		end = len(lines)
+	}
+	conflict.Actual = append([]string{}, lines[expected:end]...)
+	return conflict
+}
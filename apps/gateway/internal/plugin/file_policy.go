@@ -0,0 +1,189 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	fileAllowedRootsEnv  = "NEXTAI_FILE_ALLOWED_ROOTS"
+	fileReadOnlyRootsEnv = "NEXTAI_FILE_READONLY_ROOTS"
+	fileDenyGlobsEnv     = "NEXTAI_FILE_DENY_GLOBS"
+)
+
+var ErrFileToolPathDenied = errors.New("file_tool_path_denied")
+
+// DefaultFileDenyGlobs keeps repository internals and secrets out of reach
+// unless NEXTAI_FILE_DENY_GLOBS says otherwise.
+var DefaultFileDenyGlobs = []string{".git", ".env", ".env.*"}
+
+// FilePolicy confines the file tools. Without any roots every absolute path
+// is reachable, as before policies existed; the deny globs apply either way.
+//
+// Reads are allowed under AllowedRoots and ReadOnlyRoots, writes only under
+// AllowedRoots and never under a read-only root. Paths are checked after
+// symlinks are resolved, so a link cannot point outside the roots. A deny
+// glob without a separator matches any single path component, one with a
+// separator matches the whole slash-separated path.
+//
+// DeniedRoots are out of reach even for reads and even when no other root is
+// set; the gateway puts its own data directory there. They are expected to be
+// absolute with symlinks already resolved.
+type FilePolicy struct {
+	AllowedRoots  []string
+	ReadOnlyRoots []string
+	DeniedRoots   []string
+	DenyGlobs     []string
+}
+
+func FilePolicyFromEnv() (FilePolicy, error) {
+	policy := FilePolicy{DenyGlobs: DefaultFileDenyGlobs}
+	if raw, ok := os.LookupEnv(fileDenyGlobsEnv); ok {
+		policy.DenyGlobs = splitPolicyList(raw)
+	}
+	var err error
+	if policy.AllowedRoots, err = filePolicyRoots(fileAllowedRootsEnv); err != nil {
+		return FilePolicy{}, err
+	}
+	if policy.ReadOnlyRoots, err = filePolicyRoots(fileReadOnlyRootsEnv); err != nil {
+		return FilePolicy{}, err
+	}
+	for _, pattern := range policy.DenyGlobs {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return FilePolicy{}, fmt.Errorf("invalid %s entry %q: %w", fileDenyGlobsEnv, pattern, err)
+		}
+	}
+	return policy, nil
+}
+
+func filePolicyRoots(key string) ([]string, error) {
+	roots := make([]string, 0)
+	for _, root := range filepath.SplitList(os.Getenv(key)) {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		resolved, err := resolveExistingPath(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", key, root, err)
+		}
+		roots = append(roots, resolved)
+	}
+	return roots, nil
+}
+
+// resolvePath checks an absolute path against the policy and returns it with
+// symlinks resolved; that is the path the tools should read or write.
+func (p FilePolicy) resolvePath(path string, write bool) (string, error) {
+	if err := p.checkDenyGlobs(path); err != nil {
+		return "", err
+	}
+	resolved, err := resolveNearestPath(path)
+	if err != nil {
+		if errors.Is(err, ErrFileToolPathDenied) {
+			return "", err
+		}
+		return "", fmt.Errorf("%w: %v", ErrFileLinesToolFileRead, err)
+	}
+	if err := p.checkDenyGlobs(resolved); err != nil {
+		return "", err
+	}
+	if err := p.checkDeniedRoots(resolved); err != nil {
+		return "", err
+	}
+	if len(p.AllowedRoots) == 0 && len(p.ReadOnlyRoots) == 0 {
+		return resolved, nil
+	}
+	if write {
+		if root, ok := matchRoot(p.ReadOnlyRoots, resolved); ok {
+			return "", fmt.Errorf("%w: %s is under read-only root %s", ErrFileToolPathDenied, path, root)
+		}
+		if _, ok := matchRoot(p.AllowedRoots, resolved); !ok {
+			return "", fmt.Errorf("%w: %s is outside the writable roots", ErrFileToolPathDenied, path)
+		}
+		return resolved, nil
+	}
+	if _, ok := matchRoot(p.AllowedRoots, resolved); ok {
+		return resolved, nil
+	}
+	if _, ok := matchRoot(p.ReadOnlyRoots, resolved); ok {
+		return resolved, nil
+	}
+	return "", fmt.Errorf("%w: %s is outside the allowed roots", ErrFileToolPathDenied, path)
+}
+
+func (p FilePolicy) checkDenyGlobs(path string) error {
+	slashPath := filepath.ToSlash(path)
+	components := strings.Split(slashPath, "/")
+	for _, pattern := range p.DenyGlobs {
+		if strings.Contains(pattern, "/") {
+			if wildcardMatch(pattern, slashPath) {
+				return fmt.Errorf("%w: %s matches deny pattern %q", ErrFileToolPathDenied, path, pattern)
+			}
+			continue
+		}
+		for _, component := range components {
+			if matched, _ := filepath.Match(pattern, component); matched {
+				return fmt.Errorf("%w: %s matches deny pattern %q", ErrFileToolPathDenied, path, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func (p FilePolicy) checkDeniedRoots(path string) error {
+	for _, root := range p.DeniedRoots {
+		if pathWithinRoot(root, path) {
+			return fmt.Errorf("%w: %s is under denied root %s", ErrFileToolPathDenied, path, root)
+		}
+	}
+	return nil
+}
+
+func matchRoot(roots []string, path string) (string, bool) {
+	for _, root := range roots {
+		resolvedRoot, err := resolveNearestPath(root)
+		if err != nil {
+			resolvedRoot = filepath.Clean(root)
+		}
+		if pathWithinRoot(resolvedRoot, path) {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+// resolveNearestPath resolves symlinks in the longest existing prefix of path
+// and appends the missing remainder unchanged.
+func resolveNearestPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	missing := make([]string, 0)
+	current := abs
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if _, lstatErr := os.Lstat(current); lstatErr == nil {
+			return "", fmt.Errorf("%w: %s is a dangling symlink", ErrFileToolPathDenied, current)
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return abs, nil
+		}
+		missing = append(missing, filepath.Base(current))
+		current = parent
+	}
+}
@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFilePolicyConfinesReadsAndWrites(t *testing.T) {
+	root := t.TempDir()
+	docs := filepath.Join(root, "docs")
+	outside := t.TempDir()
+	if err := os.Mkdir(docs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{docs, outside} {
+		if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy := FilePolicy{AllowedRoots: []string{root}, ReadOnlyRoots: []string{docs}}
+
+	if _, err := policy.resolvePath(filepath.Join(root, "new.txt"), true); err != nil {
+		t.Fatalf("expected write under allowed root, got=%v", err)
+	}
+	if _, err := policy.resolvePath(filepath.Join(docs, "a.txt"), false); err != nil {
+		t.Fatalf("expected read under read-only root, got=%v", err)
+	}
+	if _, err := policy.resolvePath(filepath.Join(docs, "a.txt"), true); !errors.Is(err, ErrFileToolPathDenied) {
+		t.Fatalf("expected write under read-only root to be denied, got=%v", err)
+	}
+	if _, err := policy.resolvePath(filepath.Join(outside, "a.txt"), false); !errors.Is(err, ErrFileToolPathDenied) {
+		t.Fatalf("expected read outside roots to be denied, got=%v", err)
+	}
+	if _, err := policy.resolvePath(filepath.Join(root, "..", filepath.Base(outside), "a.txt"), false); !errors.Is(err, ErrFileToolPathDenied) {
+		t.Fatalf("expected dot-dot escape to be denied, got=%v", err)
+	}
+}
+
+func TestFilePolicyRejectsSymlinkEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on windows")
+	}
+	root := t.TempDir()
+	outside := t.TempDir()
+	target := filepath.Join(outside, "secret.txt")
+	if err := os.WriteFile(target, []byte("secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "missing.txt"), filepath.Join(root, "dangling.txt")); err != nil {
+		t.Fatal(err)
+	}
+	policy := FilePolicy{AllowedRoots: []string{root}}
+	for _, path := range []string{
+		filepath.Join(root, "link.txt"),
+		filepath.Join(root, "dir", "secret.txt"),
+		filepath.Join(root, "dir", "new.txt"),
+		filepath.Join(root, "dangling.txt"),
+	} {
+		if _, err := policy.resolvePath(path, true); !errors.Is(err, ErrFileToolPathDenied) {
+			t.Fatalf("expected %s to be denied, got=%v", path, err)
+		}
+	}
+}
+
+func TestFilePolicyDenyGlobs(t *testing.T) {
+	root := t.TempDir()
+	policy := FilePolicy{DenyGlobs: append(append([]string{}, DefaultFileDenyGlobs...), "*/secrets/*.pem")}
+	for _, path := range []string{
+		filepath.Join(root, ".git", "config"),
+		filepath.Join(root, ".env"),
+		filepath.Join(root, "app", ".env.local"),
+		filepath.Join(root, "secrets", "key.pem"),
+	} {
+		if _, err := policy.resolvePath(path, false); !errors.Is(err, ErrFileToolPathDenied) {
+			t.Fatalf("expected %s to be denied, got=%v", path, err)
+		}
+	}
+	if _, err := policy.resolvePath(filepath.Join(root, ".gitignore"), false); err != nil {
+		t.Fatalf("expected .gitignore to be allowed, got=%v", err)
+	}
+}
+
+func TestFilePolicyDeniedRoots(t *testing.T) {
+	root, err := resolveExistingPath(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(root, "data")
+	writeTestFiles(t, root, map[string]string{
+		"data/state.json":           "{}\n",
+		"data/skills/evil/SKILL.md": "evil\n",
+		"notes.txt":                 "notes\n",
+	})
+	policy := FilePolicy{DeniedRoots: []string{data}}
+
+	for _, path := range []string{filepath.Join(data, "state.json"), filepath.Join(data, "skills", "new", "SKILL.md")} {
+		for _, write := range []bool{false, true} {
+			if _, err := policy.resolvePath(path, write); !errors.Is(err, ErrFileToolPathDenied) {
+				t.Fatalf("expected %s (write=%v) to be denied, got=%v", path, write, err)
+			}
+		}
+	}
+	if _, err := policy.resolvePath(filepath.Join(root, "notes.txt"), true); err != nil {
+		t.Fatalf("expected paths outside the denied root to stay reachable, got=%v", err)
+	}
+
+	out, err := NewGlobFileTool(policy).Invoke(fileToolItems(map[string]interface{}{"path": root, "pattern": "**/*"}))
+	if err != nil {
+		t.Fatalf("glob failed: %v", err)
+	}
+	if text, _ := out["text"].(string); !strings.Contains(text, "notes.txt") || strings.Contains(text, "state.json") || strings.Contains(text, "SKILL.md") {
+		t.Fatalf("expected the denied root to be skipped, got=%s", text)
+	}
+}
+
+func TestEditFileLinesToolRejectsPathOutsideRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(outside, []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewEditFileLinesTool(root).Invoke(map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"path": outside, "start": 1, "end": 1, "content": "b"}},
+	})
+	if !errors.Is(err, ErrFileToolPathDenied) {
+		t.Fatalf("expected path denied, got=%v", err)
+	}
+	raw, _ := os.ReadFile(outside)
+	if string(raw) != "a\n" {
+		t.Fatalf("file outside root was modified: %q", raw)
+	}
+}
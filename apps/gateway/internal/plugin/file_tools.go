@@ -0,0 +1,701 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	globToolDefaultResults = 200
+	globToolMaxResults     = 1000
+	grepToolDefaultMatches = 100
+	grepToolMaxMatches     = 500
+	grepToolMaxContext     = 10
+	grepToolMaxFileBytes   = 4 * 1024 * 1024
+	grepToolMaxLineRunes   = 500
+)
+
+var (
+	ErrFileToolFileExists     = errors.New("file_tool_file_exists")
+	ErrFileToolNotDirectory   = errors.New("file_tool_not_directory")
+	ErrFileToolPatternMissing = errors.New("file_tool_pattern_missing")
+	ErrFileToolPatternInvalid = errors.New("file_tool_pattern_invalid")
+)
+
+// WriteFileTool creates a file or replaces its whole content. Missing parent
+// directories are created.
+type WriteFileTool struct {
+	policy  FilePolicy
+	journal *FileJournal
+}
+
+func NewWriteFileTool(policy FilePolicy, journal *FileJournal) *WriteFileTool {
+	return &WriteFileTool{policy: policy, journal: journal}
+}
+
+func (t *WriteFileTool) Name() string {
+	return "write"
+}
+
+func (t *WriteFileTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "Create a file or replace its whole content; missing parent directories are created. Refuses to replace an existing file unless overwrite is true. input must be an array.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Array of write operations; pass one item for a single file.",
+					"minItems":    1,
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"path": map[string]interface{}{
+								"type":        "string",
+								"description": "Absolute file path on local filesystem.",
+							},
+							"content": map[string]interface{}{
+								"type":        "string",
+								"description": "Full file content.",
+							},
+							"overwrite": map[string]interface{}{
+								"type":        "boolean",
+								"description": "Replace the file when it already exists.",
+							},
+						},
+						"required":             []string{"path", "content"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"items"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+func (t *WriteFileTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *WriteFileTool) InvokeContext(_ context.Context, inv ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	return invokeFileItems(input, "\n", func(item map[string]interface{}) (map[string]interface{}, error) {
+		return t.writeOne(inv.RunID, item)
+	})
+}
+
+func (t *WriteFileTool) writeOne(runID string, input map[string]interface{}) (map[string]interface{}, error) {
+	displayPath, absPath, err := resolveFileLinesPath(input, t.policy, true)
+	if err != nil {
+		return nil, err
+	}
+	content, ok := input["content"].(string)
+	if !ok {
+		return nil, ErrFileLinesToolContentMissing
+	}
+	overwrite, _ := input["overwrite"].(bool)
+
+	perm := os.FileMode(0o644)
+	created := true
+	if info, statErr := os.Stat(absPath); statErr == nil {
+		if info.IsDir() {
+			return nil, fmt.Errorf("%w: %s is a directory", ErrFileLinesToolPathInvalid, displayPath)
+		}
+		if !overwrite {
+			return nil, fmt.Errorf("%w: %s", ErrFileToolFileExists, displayPath)
+		}
+		perm = info.Mode().Perm()
+		created = false
+	}
+	if err := os.MkdirAll(filepath.Dir(absPath), 0o755); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileLinesToolFileWrite, err)
+	}
+	if err := t.journal.writeFile(runID, absPath, []byte(content), perm); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileLinesToolFileWrite, err)
+	}
+
+	lines, _ := splitFileLines(content)
+	action := "overwrote"
+	if created {
+		action = "created"
+	}
+	return map[string]interface{}{
+		"ok":      true,
+		"path":    displayPath,
+		"created": created,
+		"bytes":   len(content),
+		"lines":   len(lines),
+		"text":    fmt.Sprintf("write %s %s (%d line(s), %d bytes).", displayPath, action, len(lines), len(content)),
+	}, nil
+}
+
+// GlobFileTool lists entries below a directory whose slash-separated relative
+// path matches a glob; `**` matches any number of directories. The default
+// pattern `*` lists the directory itself.
+type GlobFileTool struct {
+	policy FilePolicy
+}
+
+func NewGlobFileTool(policy FilePolicy) *GlobFileTool {
+	return &GlobFileTool{policy: policy}
+}
+
+func (t *GlobFileTool) Name() string {
+	return "glob"
+}
+
+func (t *GlobFileTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "List files and directories below a directory. Without pattern it lists the directory itself; ** matches any number of directories (e.g. **/*.go). input must be an array.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Array of glob operations; pass one item for a single directory.",
+					"minItems":    1,
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"path": map[string]interface{}{
+								"type":        "string",
+								"description": "Absolute directory path on local filesystem.",
+							},
+							"pattern": map[string]interface{}{
+								"type":        "string",
+								"description": "Glob relative to path; defaults to *.",
+							},
+							"max_results": map[string]interface{}{
+								"type":    "integer",
+								"minimum": 1,
+							},
+						},
+						"required":             []string{"path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"items"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+func (t *GlobFileTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *GlobFileTool) InvokeContext(ctx context.Context, _ ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	return invokeFileItems(input, "\n\n", func(item map[string]interface{}) (map[string]interface{}, error) {
+		return t.globOne(ctx, item)
+	})
+}
+
+func (t *GlobFileTool) globOne(ctx context.Context, input map[string]interface{}) (map[string]interface{}, error) {
+	displayPath, root, err := resolveFileToolDir(input, t.policy)
+	if err != nil {
+		return nil, err
+	}
+	pattern := strings.Trim(filepath.ToSlash(strings.TrimSpace(stringValue(input["pattern"]))), "/")
+	if pattern == "" {
+		pattern = "*"
+	}
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileToolPatternInvalid, err)
+	}
+	limit := parseFileToolLimit(input["max_results"], globToolDefaultResults, globToolMaxResults)
+	maxDepth := -1
+	if !strings.Contains(pattern, "**") {
+		maxDepth = strings.Count(pattern, "/") + 1
+	}
+
+	entries := make([]map[string]interface{}, 0)
+	lines := make([]string, 0)
+	truncated := false
+	walkErr := t.policy.walk(ctx, root, maxDepth, func(rel string, entry fs.DirEntry) error {
+		if !matchGlobPath(pattern, rel) {
+			return nil
+		}
+		if len(entries) >= limit {
+			truncated = true
+			return fs.SkipAll
+		}
+		kind := "file"
+		display := rel
+		if entry.IsDir() {
+			kind = "dir"
+			display += "/"
+		} else if entry.Type()&fs.ModeSymlink != 0 {
+			kind = "symlink"
+		}
+		item := map[string]interface{}{"path": filepath.Join(displayPath, filepath.FromSlash(rel)), "type": kind}
+		if info, err := entry.Info(); err == nil && kind == "file" {
+			item["size"] = info.Size()
+		}
+		entries = append(entries, item)
+		lines = append(lines, display)
+		return nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	header := fmt.Sprintf("glob %s %q: %d entr(ies)", displayPath, pattern, len(entries))
+	if truncated {
+		header += fmt.Sprintf(" (truncated at %d)", limit)
+	}
+	text := header
+	if len(lines) > 0 {
+		text += "\n" + strings.Join(lines, "\n")
+	}
+	return map[string]interface{}{
+		"ok":        true,
+		"path":      displayPath,
+		"pattern":   pattern,
+		"entries":   entries,
+		"count":     len(entries),
+		"truncated": truncated,
+		"text":      text,
+	}, nil
+}
+
+// GrepFileTool searches files for a regular expression (RE2 syntax). path may
+// be a file or a directory; `glob` narrows the files searched and matches the
+// base name unless it contains a `/`.
+type GrepFileTool struct {
+	policy FilePolicy
+}
+
+func NewGrepFileTool(policy FilePolicy) *GrepFileTool {
+	return &GrepFileTool{policy: policy}
+}
+
+func (t *GrepFileTool) Name() string {
+	return "grep"
+}
+
+func (t *GrepFileTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "Search file contents with a regular expression (RE2 syntax) in a file or recursively in a directory. Matches are reported as path:line:text with optional context lines. input must be an array.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Array of searches; pass one item for a single search.",
+					"minItems":    1,
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"path": map[string]interface{}{
+								"type":        "string",
+								"description": "Absolute file or directory path on local filesystem.",
+							},
+							"pattern": map[string]interface{}{
+								"type":        "string",
+								"description": "Regular expression to search for.",
+							},
+							"glob": map[string]interface{}{
+								"type":        "string",
+								"description": "Only search files matching this glob (base name, or relative path when it contains /).",
+							},
+							"ignore_case": map[string]interface{}{
+								"type": "boolean",
+							},
+							"context_lines": map[string]interface{}{
+								"type":        "integer",
+								"minimum":     0,
+								"maximum":     10,
+								"description": "Lines of context before and after each match.",
+							},
+							"max_matches": map[string]interface{}{
+								"type":    "integer",
+								"minimum": 1,
+							},
+						},
+						"required":             []string{"path", "pattern"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"items"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+func (t *GrepFileTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *GrepFileTool) InvokeContext(ctx context.Context, _ ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	return invokeFileItems(input, "\n\n", func(item map[string]interface{}) (map[string]interface{}, error) {
+		return t.grepOne(ctx, item)
+	})
+}
+
+type grepMatch struct {
+	Path string
+	Line int
+	Text string
+}
+
+func (t *GrepFileTool) grepOne(ctx context.Context, input map[string]interface{}) (map[string]interface{}, error) {
+	displayPath, absPath, err := resolveFileLinesPath(input, t.policy, false)
+	if err != nil {
+		return nil, err
+	}
+	expr := stringValue(input["pattern"])
+	if expr == "" {
+		return nil, ErrFileToolPatternMissing
+	}
+	if ignoreCase, _ := input["ignore_case"].(bool); ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileToolPatternInvalid, err)
+	}
+	include := strings.Trim(filepath.ToSlash(strings.TrimSpace(stringValue(input["glob"]))), "/")
+	if include != "" {
+		if _, err := path.Match(strings.ReplaceAll(include, "**", "*"), ""); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFileToolPatternInvalid, err)
+		}
+	}
+	contextLines := parseFileToolLimit(input["context_lines"], 0, grepToolMaxContext)
+	limit := parseFileToolLimit(input["max_matches"], grepToolDefaultMatches, grepToolMaxMatches)
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrFileLinesToolFileNotFound, displayPath)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileLinesToolFileRead, err)
+	}
+
+	matches := make([]grepMatch, 0)
+	blocks := make([]string, 0)
+	files := 0
+	truncated := false
+	searchFile := func(filePath, shownPath string) {
+		fileMatches, block, more := grepFile(filePath, shownPath, re, contextLines, limit-len(matches))
+		if len(fileMatches) > 0 {
+			files++
+			matches = append(matches, fileMatches...)
+			blocks = append(blocks, block)
+		}
+		truncated = truncated || more
+	}
+	if !info.IsDir() {
+		searchFile(absPath, displayPath)
+	} else {
+		walkErr := t.policy.walk(ctx, absPath, -1, func(rel string, entry fs.DirEntry) error {
+			if entry.IsDir() || entry.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			if include != "" {
+				target := rel
+				if !strings.Contains(include, "/") {
+					target = path.Base(rel)
+				}
+				if !matchGlobPath(include, target) {
+					return nil
+				}
+			}
+			if len(matches) >= limit {
+				truncated = true
+				return fs.SkipAll
+			}
+			searchFile(filepath.Join(absPath, filepath.FromSlash(rel)), filepath.Join(displayPath, filepath.FromSlash(rel)))
+			return nil
+		})
+		if walkErr != nil {
+			return nil, walkErr
+		}
+	}
+
+	items := make([]map[string]interface{}, 0, len(matches))
+	for _, match := range matches {
+		items = append(items, map[string]interface{}{"path": match.Path, "line": match.Line, "text": match.Text})
+	}
+	header := fmt.Sprintf("grep %q in %s: %d match(es) in %d file(s)", stringValue(input["pattern"]), displayPath, len(matches), files)
+	if truncated {
+		header += fmt.Sprintf(" (truncated at %d)", limit)
+	}
+	text := header
+	if len(blocks) > 0 {
+		text += "\n" + strings.Join(blocks, "\n--\n")
+	}
+	return map[string]interface{}{
+		"ok":        true,
+		"path":      displayPath,
+		"matches":   items,
+		"count":     len(matches),
+		"files":     files,
+		"truncated": truncated,
+		"text":      text,
+	}, nil
+}
+
+// grepFile returns up to limit matches and a grep-style block where matched
+// lines use `path:line:` and context lines use `path-line-`. Binary and very
+// large files are skipped.
+func grepFile(filePath, shownPath string, re *regexp.Regexp, contextLines, limit int) ([]grepMatch, string, bool) {
+	if limit <= 0 {
+		return nil, "", true
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || info.Size() > grepToolMaxFileBytes {
+		return nil, "", false
+	}
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, "", false
+	}
+	head := raw
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, "", false
+	}
+	lines, _ := splitFileLines(string(raw))
+	matches := make([]grepMatch, 0)
+	matched := make([]int, 0)
+	more := false
+	for idx, line := range lines {
+		if !re.MatchString(line) {
+			continue
+		}
+		if len(matches) >= limit {
+			more = true
+			break
+		}
+		matches = append(matches, grepMatch{Path: shownPath, Line: idx + 1, Text: clipGrepLine(line)})
+		matched = append(matched, idx)
+	}
+	if len(matches) == 0 {
+		return nil, "", more
+	}
+
+	out := make([]string, 0, len(matched)*(2*contextLines+1))
+	isMatch := make(map[int]bool, len(matched))
+	for _, idx := range matched {
+		isMatch[idx] = true
+	}
+	last := -1
+	for _, idx := range matched {
+		from := idx - contextLines
+		if from < 0 {
+			from = 0
+		}
+		if from <= last {
+			from = last + 1
+		} else if last >= 0 {
+			out = append(out, "--")
+		}
+		to := idx + contextLines
+		if to >= len(lines) {
+			to = len(lines) - 1
+		}
+		for line := from; line <= to; line++ {
+			sep := "-"
+			if isMatch[line] {
+				sep = ":"
+			}
+			out = append(out, shownPath+sep+strconv.Itoa(line+1)+sep+clipGrepLine(lines[line]))
+		}
+		if to > last {
+			last = to
+		}
+	}
+	return matches, strings.Join(out, "\n"), more
+}
+
+func clipGrepLine(line string) string {
+	runes := []rune(line)
+	if len(runes) <= grepToolMaxLineRunes {
+		return line
+	}
+	return string(runes[:grepToolMaxLineRunes]) + "..."
+}
+
+// walk visits entries below root in lexical order with slash-separated
+// relative paths. Entries matching the deny globs or under a denied root are
+// skipped together with their subtree, and symlinked directories are not followed. maxDepth < 0
+// means unlimited.
+func (p FilePolicy) walk(ctx context.Context, root string, maxDepth int, fn func(rel string, entry fs.DirEntry) error) error {
+	err := filepath.WalkDir(root, func(current string, entry fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return context.Cause(ctx)
+		}
+		if current == root {
+			return err
+		}
+		if err != nil {
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, relErr := filepath.Rel(root, current)
+		if relErr != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if p.checkDenyGlobs(current) != nil || p.checkDeniedRoots(current) != nil {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if _, err := p.resolvePath(current, false); err != nil {
+				return nil
+			}
+		}
+		if err := fn(rel, entry); err != nil {
+			return err
+		}
+		if entry.IsDir() && maxDepth >= 0 && strings.Count(rel, "/")+1 >= maxDepth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("%w: %v", ErrFileLinesToolFileRead, err)
+	}
+	return err
+}
+
+// matchGlobPath matches a slash-separated path segment by segment; a `**`
+// segment matches zero or more segments.
+func matchGlobPath(pattern, rel string) bool {
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchGlobSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for skip := 0; skip <= len(parts); skip++ {
+			if matchGlobSegments(pattern[1:], parts[skip:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchGlobSegments(pattern[1:], parts[1:])
+}
+
+func resolveFileToolDir(input map[string]interface{}, policy FilePolicy) (string, string, error) {
+	displayPath, absPath, err := resolveFileLinesPath(input, policy, false)
+	if err != nil {
+		return "", "", err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", fmt.Errorf("%w: %s", ErrFileLinesToolFileNotFound, displayPath)
+		}
+		return "", "", fmt.Errorf("%w: %v", ErrFileLinesToolFileRead, err)
+	}
+	if !info.IsDir() {
+		return "", "", fmt.Errorf("%w: %s", ErrFileToolNotDirectory, displayPath)
+	}
+	return displayPath, absPath, nil
+}
+
+func parseFileToolLimit(raw interface{}, def, max int) int {
+	value := def
+	switch v := raw.(type) {
+	case float64:
+		value = int(v)
+	case int:
+		value = v
+	case string:
+		if parsed, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			value = parsed
+		}
+	}
+	if value < 0 || (value == 0 && def > 0) {
+		value = def
+	}
+	if value > max {
+		value = max
+	}
+	return value
+}
+
+// invokeFileItems runs one operation per item and merges the results the
+// same way view and edit do. Earlier items may already have changed files
+// when a later one fails, so a failing item is reported as an ok=false result
+// instead of discarding the others; the error itself is returned only when
+// every item failed.
+func invokeFileItems(input map[string]interface{}, sep string, one func(map[string]interface{}) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	items, err := parseInvocationItems(input, true)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]map[string]interface{}, 0, len(items))
+	allOK := true
+	var firstErr error
+	failed := 0
+	for _, item := range items {
+		result, err := one(item)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			path := strings.TrimSpace(stringValue(item["path"]))
+			result = map[string]interface{}{
+				"ok":    false,
+				"path":  path,
+				"error": err.Error(),
+				"text":  fmt.Sprintf("%s failed: %v", path, err),
+			}
+		}
+		if ok, _ := result["ok"].(bool); !ok {
+			allOK = false
+		}
+		results = append(results, result)
+	}
+	if failed == len(items) {
+		return nil, firstErr
+	}
+	if len(results) == 1 {
+		return results[0], nil
+	}
+	texts := make([]string, 0, len(results))
+	for _, item := range results {
+		if text, ok := item["text"].(string); ok {
+			texts = append(texts, text)
+		}
+	}
+	return map[string]interface{}{
+		"ok":      allOK,
+		"count":   len(results),
+		"results": results,
+		"text":    strings.Join(texts, sep),
+	}, nil
+}
@@ -0,0 +1,247 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		abs := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func fileToolItems(item map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"items": []interface{}{item}}
+}
+
+func TestWriteFileToolCreatesAndRefusesToClobber(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "nested", "dir", "a.txt")
+	tool := NewWriteFileTool(FilePolicy{AllowedRoots: []string{root}}, nil)
+
+	out, err := tool.Invoke(fileToolItems(map[string]interface{}{"path": target, "content": "one\ntwo\n"}))
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if created, _ := out["created"].(bool); !created {
+		t.Fatalf("expected created=true, got=%#v", out)
+	}
+	_, err = tool.Invoke(fileToolItems(map[string]interface{}{"path": target, "content": "three\n"}))
+	if !errors.Is(err, ErrFileToolFileExists) {
+		t.Fatalf("expected file exists error, got=%v", err)
+	}
+	if _, err := tool.Invoke(fileToolItems(map[string]interface{}{"path": target, "content": "three\n", "overwrite": true})); err != nil {
+		t.Fatalf("overwrite failed: %v", err)
+	}
+	raw, _ := os.ReadFile(target)
+	if string(raw) != "three\n" {
+		t.Fatalf("unexpected content: %q", raw)
+	}
+}
+
+func TestWriteFileToolReportsFailedItemsAfterEarlierWrites(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"exists.txt": "keep\n"})
+	tool := NewWriteFileTool(FilePolicy{AllowedRoots: []string{root}}, nil)
+
+	out, err := tool.Invoke(map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"path": filepath.Join(root, "new.txt"), "content": "fresh\n"},
+		map[string]interface{}{"path": filepath.Join(root, "exists.txt"), "content": "clobber\n"},
+	}})
+	if err != nil {
+		t.Fatalf("expected per-item results, got=%v", err)
+	}
+	results, _ := out["results"].([]map[string]interface{})
+	if ok, _ := out["ok"].(bool); ok || len(results) != 2 {
+		t.Fatalf("expected ok=false with two results, got=%#v", out)
+	}
+	if ok, _ := results[0]["ok"].(bool); !ok {
+		t.Fatalf("expected first write to succeed, got=%#v", results[0])
+	}
+	if ok, _ := results[1]["ok"].(bool); ok || !strings.Contains(stringValue(results[1]["error"]), ErrFileToolFileExists.Error()) {
+		t.Fatalf("expected second item to report the clobber error, got=%#v", results[1])
+	}
+	if raw, _ := os.ReadFile(filepath.Join(root, "new.txt")); string(raw) != "fresh\n" {
+		t.Fatalf("expected first file to be written, got=%q", raw)
+	}
+
+	_, err = tool.Invoke(map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"path": filepath.Join(root, "exists.txt"), "content": "x"},
+		map[string]interface{}{"path": filepath.Join(root, "new.txt"), "content": "x"},
+	}})
+	if !errors.Is(err, ErrFileToolFileExists) {
+		t.Fatalf("expected the error when every item fails, got=%v", err)
+	}
+}
+
+func TestGlobFileToolMatchesRecursivePatterns(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"main.go":          "package main\n",
+		"README.md":        "readme\n",
+		"pkg/util/util.go": "package util\n",
+		".git/config":      "[core]\n",
+	})
+	tool := NewGlobFileTool(FilePolicy{DenyGlobs: DefaultFileDenyGlobs})
+
+	out, err := tool.Invoke(fileToolItems(map[string]interface{}{"path": root, "pattern": "**/*.go"}))
+	if err != nil {
+		t.Fatalf("glob failed: %v", err)
+	}
+	text, _ := out["text"].(string)
+	if !strings.Contains(text, "main.go") || !strings.Contains(text, "pkg/util/util.go") || strings.Contains(text, "README.md") {
+		t.Fatalf("unexpected glob text: %s", text)
+	}
+
+	out, err = tool.Invoke(fileToolItems(map[string]interface{}{"path": root}))
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	text, _ = out["text"].(string)
+	if !strings.Contains(text, "pkg/") || strings.Contains(text, "util.go") || strings.Contains(text, ".git") {
+		t.Fatalf("unexpected listing: %s", text)
+	}
+}
+
+func TestGrepFileToolReportsContextLines(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"a.go":  "one\ntwo\nneedle here\nfour\nfive\n",
+		"b.txt": "needle too\n",
+	})
+	tool := NewGrepFileTool(FilePolicy{})
+
+	out, err := tool.Invoke(fileToolItems(map[string]interface{}{"path": root, "pattern": "need(le)", "glob": "*.go", "context_lines": 1}))
+	if err != nil {
+		t.Fatalf("grep failed: %v", err)
+	}
+	if count, _ := out["count"].(int); count != 1 {
+		t.Fatalf("expected one match, got=%#v", out)
+	}
+	text, _ := out["text"].(string)
+	aPath := filepath.Join(root, "a.go")
+	for _, want := range []string{aPath + "-2-two", aPath + ":3:needle here", aPath + "-4-four"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in grep output:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "b.txt") {
+		t.Fatalf("glob filter ignored: %s", text)
+	}
+
+	_, err = tool.Invoke(fileToolItems(map[string]interface{}{"path": root, "pattern": "("}))
+	if !errors.Is(err, ErrFileToolPatternInvalid) {
+		t.Fatalf("expected invalid pattern error, got=%v", err)
+	}
+}
+
+func TestPatchFileToolAppliesHunksWithOffset(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "a.txt")
+	writeTestFiles(t, root, map[string]string{"a.txt": "extra\nalpha\nbeta\ngamma\ndelta\nepsilon\nzeta\n"})
+	patch := strings.Join([]string{
+		"--- a/a.txt",
+		"+++ b/a.txt",
+		"@@ -1,3 +1,3 @@",
+		" alpha",
+		"-beta",
+		"+BETA",
+		" gamma",
+		"@@ -5,2 +5,3 @@",
+		" epsilon",
+		" zeta",
+		"+eta",
+		"",
+	}, "\n")
+
+	out, err := NewPatchFileTool(FilePolicy{}, nil).Invoke(fileToolItems(map[string]interface{}{"path": target, "patch": patch}))
+	if err != nil {
+		t.Fatalf("patch failed: %v", err)
+	}
+	if ok, _ := out["ok"].(bool); !ok {
+		t.Fatalf("expected ok=true, got=%#v", out)
+	}
+	raw, _ := os.ReadFile(target)
+	if string(raw) != "extra\nalpha\nBETA\ngamma\ndelta\nepsilon\nzeta\neta\n" {
+		t.Fatalf("unexpected patched content: %q", raw)
+	}
+}
+
+func TestPatchFileToolReportsConflictsWithoutWriting(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "a.txt")
+	original := "alpha\nbeta\ngamma\n"
+	writeTestFiles(t, root, map[string]string{"a.txt": original})
+	patch := "@@ -1,2 +1,2 @@\n alpha\n-beta\n+BETA\n@@ -3,1 +3,1 @@\n-GAMMA\n+gamma!\n"
+
+	out, err := NewPatchFileTool(FilePolicy{}, nil).Invoke(fileToolItems(map[string]interface{}{"path": target, "patch": patch}))
+	if err != nil {
+		t.Fatalf("patch returned error: %v", err)
+	}
+	if ok, _ := out["ok"].(bool); ok {
+		t.Fatalf("expected ok=false, got=%#v", out)
+	}
+	text, _ := out["text"].(string)
+	if !strings.Contains(text, "hunk 2") || !strings.Contains(text, `expected "GAMMA"`) {
+		t.Fatalf("unexpected conflict report: %s", text)
+	}
+	raw, _ := os.ReadFile(target)
+	if string(raw) != original {
+		t.Fatalf("file changed despite conflict: %q", raw)
+	}
+}
+
+func TestPatchFileToolCreatesFileFromEmptyOrigin(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "new", "b.txt")
+	patch := "--- /dev/null\n+++ b/b.txt\n@@ -0,0 +1,2 @@\n+first\n+second\n\\ No newline at end of file\n"
+
+	if _, err := NewPatchFileTool(FilePolicy{}, nil).Invoke(fileToolItems(map[string]interface{}{"path": target, "patch": patch})); err != nil {
+		t.Fatalf("patch failed: %v", err)
+	}
+	raw, _ := os.ReadFile(target)
+	if string(raw) != "first\nsecond" {
+		t.Fatalf("unexpected created content: %q", raw)
+	}
+}
+
+func TestEditFileLinesToolRejectsStaleExpectations(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "a.txt")
+	writeTestFiles(t, root, map[string]string{"a.txt": "one\ntwo\nthree\n"})
+	view, err := NewViewFileLinesTool(root).Invoke(fileToolItems(map[string]interface{}{"path": target, "start": 1, "end": 3}))
+	if err != nil {
+		t.Fatalf("view failed: %v", err)
+	}
+	hash, _ := view["sha256"].(string)
+	if text, _ := view["text"].(string); !strings.Contains(text, "sha256="+hash[:12]) {
+		t.Fatalf("expected hash in view text: %s", text)
+	}
+	edit := NewEditFileLinesTool(root)
+
+	_, err = edit.Invoke(fileToolItems(map[string]interface{}{"path": target, "start": 2, "end": 2, "content": "TWO", "expected_old_text": "zwei"}))
+	if !errors.Is(err, ErrFileLinesToolStale) {
+		t.Fatalf("expected stale error for old text, got=%v", err)
+	}
+	if _, err := edit.Invoke(fileToolItems(map[string]interface{}{"path": target, "start": 2, "end": 2, "content": "TWO", "expected_old_text": "two\n", "expected_sha256": hash[:12]})); err != nil {
+		t.Fatalf("guarded edit failed: %v", err)
+	}
+	_, err = edit.Invoke(fileToolItems(map[string]interface{}{"path": target, "start": 3, "end": 3, "content": "THREE", "expected_sha256": hash}))
+	if !errors.Is(err, ErrFileLinesToolStale) {
+		t.Fatalf("expected stale error for old hash, got=%v", err)
+	}
+	raw, _ := os.ReadFile(target)
+	if string(raw) != "one\nTWO\nthree\n" {
+		t.Fatalf("unexpected content: %q", raw)
+	}
+}
@@ -1,6 +1,9 @@
 package plugin
 
-import "context"
+import (
+	"context"
+	"io"
+)
 
 type ChannelPlugin interface {
 	Name() string
@@ -11,3 +14,87 @@ type ToolPlugin interface {
 	Name() string
 	Invoke(input map[string]interface{}) (map[string]interface{}, error)
 }
+
+// ToolInvocation describes who triggered a tool call. Fields are empty when a
+// tool is invoked outside of an agent run.
+type ToolInvocation struct {
+	ChatID    string
+	UserID    string
+	SessionID string
+	Channel   string
+	RunID     string
+	CallID    string
+
+	// Env holds the stored environment variables (/envs). Tools that start
+	// subprocesses add them on top of the gateway's own environment.
+	Env map[string]string
+
+	// OnOutput, when set, receives incremental tool output while the call is
+	// still running. It may be called from any goroutine.
+	OnOutput func(chunk string)
+}
+
+// OutputWriter returns a writer that forwards to OnOutput, or nil when the
+// caller did not ask for streamed output.
+func (inv ToolInvocation) OutputWriter() io.Writer {
+	if inv.OnOutput == nil {
+		return nil
+	}
+	return outputFunc(inv.OnOutput)
+}
+
+type outputFunc func(chunk string)
+
+func (f outputFunc) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		f(string(p))
+	}
+	return len(p), nil
+}
+
+// ToolPluginV2 receives the request context so that cancellation and
+// deadlines reach child processes and outbound HTTP calls.
+type ToolPluginV2 interface {
+	Name() string
+	InvokeContext(ctx context.Context, inv ToolInvocation, input map[string]interface{}) (map[string]interface{}, error)
+}
+
+// ToolSpec describes a tool to the model. Parameters is a JSON Schema for the
+// call arguments; see ValidateToolInput for the supported keywords.
+type ToolSpec struct {
+	Description string
+	Parameters  map[string]interface{}
+}
+
+// ToolSpecProvider is implemented by tools that declare their own schema.
+// Tools without it are offered to the model with a schema-less definition and
+// their arguments are not validated.
+type ToolSpecProvider interface {
+	ToolSpec() ToolSpec
+}
+
+type legacyToolPlugin struct {
+	ToolPlugin
+}
+
+type legacySpecToolPlugin struct {
+	legacyToolPlugin
+	ToolSpecProvider
+}
+
+func (p legacyToolPlugin) InvokeContext(_ context.Context, _ ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	return p.Invoke(input)
+}
+
+// AdaptToolPlugin returns tp unchanged when it already implements
+// ToolPluginV2 and wraps it otherwise; the wrapper ignores ctx and keeps
+// ToolSpecProvider.
+func AdaptToolPlugin(tp ToolPlugin) ToolPluginV2 {
+	if v2, ok := tp.(ToolPluginV2); ok {
+		return v2
+	}
+	if provider, ok := tp.(ToolSpecProvider); ok {
+		return legacySpecToolPlugin{legacyToolPlugin: legacyToolPlugin{ToolPlugin: tp}, ToolSpecProvider: provider}
+	}
+	return legacyToolPlugin{ToolPlugin: tp}
+}
@@ -0,0 +1,339 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	mcpProtocolVersion   = "2025-03-26"
+	mcpDefaultTimeout    = 60 * time.Second
+	mcpMaxToolPages      = 20
+	mcpToolNamePrefix    = "mcp__"
+	mcpToolNameSeparator = "__"
+	mcpMaxToolNameLength = 64
+	mcpClientName        = "nextai-gateway"
+	mcpClientVersion     = "0.1.0"
+)
+
+var (
+	ErrMCPServerConfigInvalid = errors.New("mcp_server_config_invalid")
+	ErrMCPServerUnavailable   = errors.New("mcp_server_unavailable")
+	ErrMCPCallFailed          = errors.New("mcp_call_failed")
+)
+
+var mcpToolNameInvalidChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// MCPServerConfig describes one MCP server. Command starts a stdio server as a
+// child process; URL connects to a streamable HTTP server instead.
+type MCPServerConfig struct {
+	Name    string
+	Command string
+	Args    []string
+	Env     map[string]string
+	Dir     string
+	URL     string
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+type mcpTransport interface {
+	request(ctx context.Context, method string, params interface{}) (json.RawMessage, error)
+	notify(ctx context.Context, method string, params interface{}) error
+	close()
+}
+
+type mcpMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      json.RawMessage  `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *mcpMessageError `json:"error,omitempty"`
+}
+
+type mcpMessageError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (m mcpMessage) isResponse() bool {
+	return m.Method == "" && len(m.ID) > 0
+}
+
+func (m mcpMessage) isRequest() bool {
+	return m.Method != "" && len(m.ID) > 0
+}
+
+func (m mcpMessage) outcome() (json.RawMessage, error) {
+	if m.Error != nil {
+		return nil, fmt.Errorf("%w: %s (code %d)", ErrMCPCallFailed, m.Error.Message, m.Error.Code)
+	}
+	return m.Result, nil
+}
+
+func newMCPRequest(id int64, method string, params interface{}) ([]byte, error) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method}
+	if id > 0 {
+		msg["id"] = id
+	}
+	if params != nil {
+		msg["params"] = params
+	}
+	return json.Marshal(msg)
+}
+
+// MCPClient is a connection to one MCP server and the tools it exposed when
+// the connection was made.
+type MCPClient struct {
+	name       string
+	transport  mcpTransport
+	timeout    time.Duration
+	serverName string
+	tools      []*MCPTool
+}
+
+type MCPTool struct {
+	client     *MCPClient
+	name       string
+	remoteName string
+	spec       ToolSpec
+}
+
+// ConnectMCPServer starts or connects to the server, runs the initialize
+// handshake and lists its tools. The returned client owns the server process
+// until Close.
+func ConnectMCPServer(ctx context.Context, cfg MCPServerConfig) (*MCPClient, error) {
+	name := strings.TrimSpace(cfg.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrMCPServerConfigInvalid)
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = mcpDefaultTimeout
+	}
+
+	var transport mcpTransport
+	var err error
+	command := strings.TrimSpace(cfg.Command)
+	url := strings.TrimSpace(cfg.URL)
+	switch {
+	case command != "" && url != "":
+		return nil, fmt.Errorf("%w: set either command or url, not both", ErrMCPServerConfigInvalid)
+	case command != "":
+		transport, err = startMCPStdioTransport(command, cfg.Args, cfg.Env, cfg.Dir)
+	case url != "":
+		transport, err = newMCPHTTPTransport(url, cfg.Headers)
+	default:
+		return nil, fmt.Errorf("%w: command or url is required", ErrMCPServerConfigInvalid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client := &MCPClient{name: name, transport: transport, timeout: timeout}
+	if err := client.initialize(ctx); err != nil {
+		transport.close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *MCPClient) initialize(ctx context.Context) error {
+	raw, err := c.request(ctx, "initialize", map[string]interface{}{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]interface{}{"name": mcpClientName, "version": mcpClientVersion},
+	})
+	if err != nil {
+		return err
+	}
+	var init struct {
+		ServerInfo struct {
+			Name string `json:"name"`
+		} `json:"serverInfo"`
+	}
+	if err := json.Unmarshal(raw, &init); err != nil {
+		return fmt.Errorf("%w: invalid initialize result: %v", ErrMCPServerUnavailable, err)
+	}
+	c.serverName = init.ServerInfo.Name
+	if err := c.transport.notify(ctx, "notifications/initialized", nil); err != nil {
+		return err
+	}
+	return c.loadTools(ctx)
+}
+
+func (c *MCPClient) loadTools(ctx context.Context) error {
+	seen := map[string]struct{}{}
+	cursor := ""
+	for page := 0; page < mcpMaxToolPages; page++ {
+		var params interface{}
+		if cursor != "" {
+			params = map[string]interface{}{"cursor": cursor}
+		}
+		raw, err := c.request(ctx, "tools/list", params)
+		if err != nil {
+			return err
+		}
+		var list struct {
+			Tools []struct {
+				Name        string                 `json:"name"`
+				Description string                 `json:"description"`
+				InputSchema map[string]interface{} `json:"inputSchema"`
+			} `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return fmt.Errorf("%w: invalid tools/list result: %v", ErrMCPServerUnavailable, err)
+		}
+		for _, item := range list.Tools {
+			name := MCPToolName(c.name, item.Name)
+			if _, dup := seen[name]; dup || strings.TrimSpace(item.Name) == "" {
+				continue
+			}
+			seen[name] = struct{}{}
+			schema := item.InputSchema
+			if schema == nil {
+				schema = map[string]interface{}{"type": "object"}
+			}
+			c.tools = append(c.tools, &MCPTool{
+				client:     c,
+				name:       name,
+				remoteName: item.Name,
+				spec:       ToolSpec{Description: item.Description, Parameters: schema},
+			})
+		}
+		cursor = list.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *MCPClient) request(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.transport.request(callCtx, method, params)
+}
+
+// Name returns the configured server name, which prefixes its tool names.
+func (c *MCPClient) Name() string {
+	return c.name
+}
+
+// ServerName returns the name the server reported during initialize.
+func (c *MCPClient) ServerName() string {
+	return c.serverName
+}
+
+func (c *MCPClient) Tools() []*MCPTool {
+	return c.tools
+}
+
+func (c *MCPClient) Close() {
+	c.transport.close()
+}
+
+// MCPToolName namespaces a remote tool as mcp__<server>__<tool>, folded to the
+// characters model APIs accept in function names.
+func MCPToolName(server, tool string) string {
+	clean := func(raw string) string {
+		return strings.Trim(mcpToolNameInvalidChars.ReplaceAllString(strings.ToLower(strings.TrimSpace(raw)), "_"), "_")
+	}
+	name := mcpToolNamePrefix + clean(server) + mcpToolNameSeparator + clean(tool)
+	if len(name) > mcpMaxToolNameLength {
+		name = name[:mcpMaxToolNameLength]
+	}
+	return name
+}
+
+func (t *MCPTool) Name() string {
+	return t.name
+}
+
+func (t *MCPTool) ToolSpec() ToolSpec {
+	return t.spec
+}
+
+func (t *MCPTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+// InvokeContext calls tools/call. A result flagged isError is returned with
+// ok=false so the model can see and react to the server's message.
+func (t *MCPTool) InvokeContext(ctx context.Context, _ ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	raw, err := t.client.request(ctx, "tools/call", map[string]interface{}{
+		"name":      t.remoteName,
+		"arguments": input,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, err
+	}
+	var call struct {
+		Content           []map[string]interface{} `json:"content"`
+		StructuredContent interface{}              `json:"structuredContent"`
+		IsError           bool                     `json:"isError"`
+	}
+	if err := json.Unmarshal(raw, &call); err != nil {
+		return nil, fmt.Errorf("%w: invalid tools/call result: %v", ErrMCPCallFailed, err)
+	}
+	text := formatMCPContent(call.Content)
+	if text == "" && call.StructuredContent != nil {
+		if encoded, err := json.Marshal(call.StructuredContent); err == nil {
+			text = string(encoded)
+		}
+	}
+	if call.IsError && text == "" {
+		text = fmt.Sprintf("mcp tool %q failed", t.remoteName)
+	}
+	result := map[string]interface{}{
+		"ok":      !call.IsError,
+		"server":  t.client.name,
+		"tool":    t.remoteName,
+		"content": call.Content,
+		"text":    text,
+	}
+	if call.StructuredContent != nil {
+		result["structured_content"] = call.StructuredContent
+	}
+	return result, nil
+}
+
+func formatMCPContent(content []map[string]interface{}) string {
+	parts := make([]string, 0, len(content))
+	for _, item := range content {
+		kind, _ := item["type"].(string)
+		switch kind {
+		case "text":
+			if text, _ := item["text"].(string); text != "" {
+				parts = append(parts, text)
+			}
+		case "resource":
+			resource, _ := item["resource"].(map[string]interface{})
+			if text, _ := resource["text"].(string); text != "" {
+				parts = append(parts, text)
+			} else if uri, _ := resource["uri"].(string); uri != "" {
+				parts = append(parts, fmt.Sprintf("[resource %s]", uri))
+			}
+		case "resource_link":
+			uri, _ := item["uri"].(string)
+			parts = append(parts, fmt.Sprintf("[resource %s]", uri))
+		default:
+			mimeType, _ := item["mimeType"].(string)
+			parts = append(parts, strings.TrimSpace(fmt.Sprintf("[%s %s]", kind, mimeType)))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
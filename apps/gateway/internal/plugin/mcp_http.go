@@ -0,0 +1,220 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mcpHTTPMaxErrorBodyBytes = 2 * 1024
+	mcpHTTPCloseTimeout      = 5 * time.Second
+	mcpSessionHeader         = "Mcp-Session-Id"
+	mcpProtocolHeader        = "MCP-Protocol-Version"
+)
+
+// mcpHTTPTransport implements the streamable HTTP transport: every message is
+// a POST and the reply is either a JSON body or an SSE stream that ends with
+// the matching response.
+type mcpHTTPTransport struct {
+	endpoint   string
+	headers    map[string]string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	nextID    int64
+	sessionID string
+}
+
+func newMCPHTTPTransport(endpoint string, headers map[string]string) (*mcpHTTPTransport, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http(s) url", ErrMCPServerConfigInvalid)
+	}
+	return &mcpHTTPTransport{
+		endpoint:   endpoint,
+		headers:    headers,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+func (t *mcpHTTPTransport) request(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	t.mu.Lock()
+	t.nextID++
+	id := t.nextID
+	t.mu.Unlock()
+
+	payload, err := newMCPRequest(id, method, params)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.post(ctx, payload)
+	if err != nil {
+		return nil, t.wrapErr(ctx, method, err)
+	}
+	defer resp.Body.Close()
+	if sessionID := resp.Header.Get(mcpSessionHeader); sessionID != "" && method == "initialize" {
+		t.mu.Lock()
+		t.sessionID = sessionID
+		t.mu.Unlock()
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var msg *mcpMessage
+	if mediaType == "text/event-stream" {
+		msg, err = readMCPEventStream(resp.Body, id)
+	} else {
+		msg, err = readMCPJSONBody(resp.Body, id)
+	}
+	if err != nil {
+		return nil, t.wrapErr(ctx, method, err)
+	}
+	return msg.outcome()
+}
+
+func (t *mcpHTTPTransport) notify(ctx context.Context, method string, params interface{}) error {
+	payload, err := newMCPRequest(0, method, params)
+	if err != nil {
+		return err
+	}
+	resp, err := t.post(ctx, payload)
+	if err != nil {
+		return t.wrapErr(ctx, method, err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+// close ends the session; servers that do not track sessions ignore it.
+func (t *mcpHTTPTransport) close() {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.sessionID = ""
+	t.mu.Unlock()
+	if sessionID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mcpHTTPCloseTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.endpoint, nil)
+	if err != nil {
+		return
+	}
+	t.setHeaders(req, sessionID)
+	if resp, err := t.httpClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (t *mcpHTTPTransport) post(ctx context.Context, payload []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.mu.Unlock()
+	t.setHeaders(req, sessionID)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, mcpHTTPMaxErrorBodyBytes))
+		resp.Body.Close()
+		return nil, fmt.Errorf("http %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (t *mcpHTTPTransport) setHeaders(req *http.Request, sessionID string) {
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set(mcpProtocolHeader, mcpProtocolVersion)
+	if sessionID != "" {
+		req.Header.Set(mcpSessionHeader, sessionID)
+	}
+}
+
+func (t *mcpHTTPTransport) wrapErr(ctx context.Context, method string, err error) error {
+	if ctx.Err() != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %s timed out", ErrMCPCallFailed, method)
+		}
+		return context.Cause(ctx)
+	}
+	return fmt.Errorf("%w: %s: %v", ErrMCPServerUnavailable, method, err)
+}
+
+func readMCPJSONBody(body io.Reader, id int64) (*mcpMessage, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimSpace(raw)
+	var batch []mcpMessage
+	if len(raw) > 0 && raw[0] == '[' {
+		if err := json.Unmarshal(raw, &batch); err != nil {
+			return nil, fmt.Errorf("invalid response body: %v", err)
+		}
+	} else {
+		var msg mcpMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return nil, fmt.Errorf("invalid response body: %v", err)
+		}
+		batch = append(batch, msg)
+	}
+	for i := range batch {
+		if batch[i].isResponse() && string(batch[i].ID) == strconv.FormatInt(id, 10) {
+			return &batch[i], nil
+		}
+	}
+	return nil, errors.New("response is missing")
+}
+
+// readMCPEventStream reads SSE events until the response to id arrives.
+// Notifications and server requests sent on the stream are skipped.
+func readMCPEventStream(body io.Reader, id int64) (*mcpMessage, error) {
+	reader := bufio.NewReader(body)
+	want := strconv.FormatInt(id, 10)
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		trimmed := strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(trimmed, "data:") {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(trimmed, "data:"), " "))
+		}
+		if (trimmed == "" || err != nil) && data.Len() > 0 {
+			var msg mcpMessage
+			if json.Unmarshal([]byte(data.String()), &msg) == nil && msg.isResponse() && string(msg.ID) == want {
+				return &msg, nil
+			}
+			data.Reset()
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("stream ended before the response")
+			}
+			return nil, err
+		}
+	}
+}
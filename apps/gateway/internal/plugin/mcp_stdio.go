@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const mcpStdioMaxStderrBytes = 4 * 1024
+
+// mcpStdioTransport speaks newline-delimited JSON-RPC with a child process.
+// A reader goroutine routes responses to waiting requests and answers the
+// server's own requests.
+type mcpStdioTransport struct {
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stdin  io.WriteCloser
+	stderr *mcpTailBuffer
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan mcpMessage
+	done    chan struct{}
+	err     error
+}
+
+func startMCPStdioTransport(command string, args []string, env map[string]string, dir string) (*mcpStdioTransport, error) {
+	procCtx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(procCtx, command, args...)
+	configureProcessGroup(cmd)
+	cmd.Dir = strings.TrimSpace(dir)
+	cmd.Env = os.Environ()
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("%w: %v", ErrMCPServerUnavailable, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("%w: %v", ErrMCPServerUnavailable, err)
+	}
+	stderr := &mcpTailBuffer{limit: mcpStdioMaxStderrBytes}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("%w: %v", ErrMCPServerUnavailable, err)
+	}
+
+	t := &mcpStdioTransport{
+		cmd:     cmd,
+		cancel:  cancel,
+		stdin:   stdin,
+		stderr:  stderr,
+		pending: map[int64]chan mcpMessage{},
+		done:    make(chan struct{}),
+	}
+	go t.readLoop(stdout)
+	return t, nil
+}
+
+func (t *mcpStdioTransport) readLoop(stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	var readErr error
+	for {
+		line, err := reader.ReadBytes('\n')
+		if trimmed := strings.TrimSpace(string(line)); trimmed != "" {
+			var msg mcpMessage
+			if json.Unmarshal([]byte(trimmed), &msg) == nil {
+				t.dispatch(msg)
+			}
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+	waitErr := t.cmd.Wait()
+
+	t.mu.Lock()
+	reason := "server exited"
+	if waitErr != nil {
+		reason = waitErr.Error()
+	} else if readErr != nil && !errors.Is(readErr, io.EOF) {
+		reason = readErr.Error()
+	}
+	if tail := strings.TrimSpace(t.stderr.String()); tail != "" {
+		reason += ": " + tail
+	}
+	t.err = fmt.Errorf("%w: %s", ErrMCPServerUnavailable, reason)
+	t.pending = map[int64]chan mcpMessage{}
+	t.mu.Unlock()
+	close(t.done)
+}
+
+func (t *mcpStdioTransport) dispatch(msg mcpMessage) {
+	switch {
+	case msg.isResponse():
+		id, err := strconv.ParseInt(string(msg.ID), 10, 64)
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		ch, ok := t.pending[id]
+		delete(t.pending, id)
+		t.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	case msg.isRequest():
+		reply := map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID}
+		if msg.Method == "ping" {
+			reply["result"] = map[string]interface{}{}
+		} else {
+			reply["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+		}
+		if payload, err := json.Marshal(reply); err == nil {
+			_ = t.write(payload)
+		}
+	}
+}
+
+func (t *mcpStdioTransport) write(payload []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if _, err := t.stdin.Write(append(payload, '\n')); err != nil {
+		return fmt.Errorf("%w: %v", ErrMCPServerUnavailable, err)
+	}
+	return nil
+}
+
+func (t *mcpStdioTransport) request(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	t.mu.Lock()
+	if t.err != nil {
+		err := t.err
+		t.mu.Unlock()
+		return nil, err
+	}
+	t.nextID++
+	id := t.nextID
+	ch := make(chan mcpMessage, 1)
+	t.pending[id] = ch
+	t.mu.Unlock()
+
+	payload, err := newMCPRequest(id, method, params)
+	if err == nil {
+		err = t.write(payload)
+	}
+	if err != nil {
+		t.forget(id)
+		return nil, err
+	}
+
+	select {
+	case msg := <-ch:
+		return msg.outcome()
+	case <-t.done:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return nil, t.err
+	case <-ctx.Done():
+		t.forget(id)
+		if payload, err := newMCPRequest(0, "notifications/cancelled", map[string]interface{}{
+			"requestId": id,
+			"reason":    "request cancelled by client",
+		}); err == nil {
+			_ = t.write(payload)
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %s timed out", ErrMCPCallFailed, method)
+		}
+		return nil, context.Cause(ctx)
+	}
+}
+
+func (t *mcpStdioTransport) forget(id int64) {
+	t.mu.Lock()
+	delete(t.pending, id)
+	t.mu.Unlock()
+}
+
+func (t *mcpStdioTransport) notify(_ context.Context, method string, params interface{}) error {
+	payload, err := newMCPRequest(0, method, params)
+	if err != nil {
+		return err
+	}
+	return t.write(payload)
+}
+
+// close closes stdin so a well-behaved server can exit, then kills the
+// process group if it has not gone away shortly after.
+func (t *mcpStdioTransport) close() {
+	_ = t.stdin.Close()
+	select {
+	case <-t.done:
+	case <-time.After(processKillWaitDelay):
+		t.cancel()
+		<-t.done
+	}
+	t.cancel()
+}
+
+// mcpTailBuffer keeps the last limit bytes written to it.
+type mcpTailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (b *mcpTailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = b.buf[len(b.buf)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *mcpTailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
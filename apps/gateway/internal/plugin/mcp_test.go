@@ -0,0 +1,209 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mcpStubProcessEnv = "NEXTAI_MCP_STUB_PROCESS"
+
+// mcpStubReply answers one JSON-RPC message the way a small MCP server with
+// an echo tool and a failing tool would. Notifications get no reply.
+func mcpStubReply(raw []byte) ([]byte, bool) {
+	var msg struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Params struct {
+			Name      string                 `json:"name"`
+			Arguments map[string]interface{} `json:"arguments"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil || len(msg.ID) == 0 {
+		return nil, false
+	}
+	var result interface{}
+	switch msg.Method {
+	case "initialize":
+		result = map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]interface{}{"name": "stub", "version": "1.0.0"},
+		}
+	case "tools/list":
+		result = map[string]interface{}{"tools": []interface{}{
+			map[string]interface{}{
+				"name":        "echo",
+				"description": "Echo the text back.",
+				"inputSchema": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}},
+					"required":   []string{"text"},
+				},
+			},
+			map[string]interface{}{"name": "fail.always"},
+		}}
+	case "tools/call":
+		if msg.Params.Name == "echo" {
+			result = map[string]interface{}{"content": []interface{}{
+				map[string]interface{}{"type": "text", "text": fmt.Sprintf("echo: %v", msg.Params.Arguments["text"])},
+			}}
+		} else {
+			result = map[string]interface{}{
+				"isError": true,
+				"content": []interface{}{map[string]interface{}{"type": "text", "text": "it broke"}},
+			}
+		}
+	default:
+		out, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0", "id": msg.ID,
+			"error": map[string]interface{}{"code": -32601, "message": "method not found"},
+		})
+		return out, true
+	}
+	out, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+	return out, true
+}
+
+// TestMCPStubProcess is not a real test: it runs the stub server on stdio
+// when the test binary is started by the stdio transport.
+func TestMCPStubProcess(t *testing.T) {
+	if os.Getenv(mcpStubProcessEnv) != "1" {
+		return
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if reply, ok := mcpStubReply(line); ok {
+			os.Stdout.Write(append(reply, '\n'))
+		}
+		if err != nil {
+			os.Exit(0)
+		}
+	}
+}
+
+func newMCPStubHTTPServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		reply, ok := mcpStubReply(body)
+		if !ok {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if strings.Contains(string(body), `"initialize"`) {
+			w.Header().Set(mcpSessionHeader, "session-1")
+		} else if r.Header.Get(mcpSessionHeader) != "session-1" {
+			http.Error(w, "missing session", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(string(body), `"tools/call"`) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", reply)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(reply)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func assertMCPStubTools(t *testing.T, client *MCPClient) {
+	t.Helper()
+	tools := client.Tools()
+	if len(tools) != 2 || tools[0].Name() != "mcp__stub__echo" || tools[1].Name() != "mcp__stub__fail_always" {
+		t.Fatalf("unexpected tools: %+v", tools)
+	}
+	if tools[0].ToolSpec().Description != "Echo the text back." {
+		t.Fatalf("unexpected spec: %+v", tools[0].ToolSpec())
+	}
+	if err := ValidateToolInput(tools[0].ToolSpec().Parameters, map[string]interface{}{}); !errors.Is(err, ErrToolInputInvalid) {
+		t.Fatalf("expected schema from server to be enforced, got=%v", err)
+	}
+
+	result, err := tools[0].InvokeContext(context.Background(), ToolInvocation{}, map[string]interface{}{"text": "hi"})
+	if err != nil {
+		t.Fatalf("invoke echo failed: %v", err)
+	}
+	if result["ok"] != true || result["text"] != "echo: hi" {
+		t.Fatalf("unexpected echo result: %#v", result)
+	}
+	result, err = tools[1].InvokeContext(context.Background(), ToolInvocation{}, nil)
+	if err != nil {
+		t.Fatalf("invoke fail tool returned error: %v", err)
+	}
+	if result["ok"] != false || result["text"] != "it broke" || result["tool"] != "fail.always" {
+		t.Fatalf("unexpected error result: %#v", result)
+	}
+}
+
+func TestMCPClientOverStdio(t *testing.T) {
+	client, err := ConnectMCPServer(context.Background(), MCPServerConfig{
+		Name:    "stub",
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestMCPStubProcess$"},
+		Env:     map[string]string{mcpStubProcessEnv: "1"},
+		Timeout: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer client.Close()
+	if client.ServerName() != "stub" {
+		t.Fatalf("unexpected server name: %q", client.ServerName())
+	}
+	assertMCPStubTools(t, client)
+}
+
+func TestMCPClientOverHTTP(t *testing.T) {
+	srv := newMCPStubHTTPServer(t)
+	client, err := ConnectMCPServer(context.Background(), MCPServerConfig{Name: "Stub", URL: srv.URL})
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer client.Close()
+	assertMCPStubTools(t, client)
+}
+
+func TestConnectMCPServerReportsStartupFailures(t *testing.T) {
+	if _, err := ConnectMCPServer(context.Background(), MCPServerConfig{Name: "x"}); !errors.Is(err, ErrMCPServerConfigInvalid) {
+		t.Fatalf("expected config error, got=%v", err)
+	}
+	if _, err := ConnectMCPServer(context.Background(), MCPServerConfig{Name: "x", URL: "ftp://host"}); !errors.Is(err, ErrMCPServerConfigInvalid) {
+		t.Fatalf("expected url error, got=%v", err)
+	}
+	_, err := ConnectMCPServer(context.Background(), MCPServerConfig{
+		Name:    "x",
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^$"},
+		Timeout: 10 * time.Second,
+	})
+	if !errors.Is(err, ErrMCPServerUnavailable) {
+		t.Fatalf("expected exited server to be unavailable, got=%v", err)
+	}
+}
+
+func TestMCPToolNameIsNamespaced(t *testing.T) {
+	if got := MCPToolName("My Server", "files/read.v2"); got != "mcp__my_server__files_read_v2" {
+		t.Fatalf("unexpected name: %q", got)
+	}
+	if got := MCPToolName("s", strings.Repeat("x", 100)); len(got) != mcpMaxToolNameLength {
+		t.Fatalf("expected name to be clipped, got len=%d", len(got))
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package plugin
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const processKillWaitDelay = 2 * time.Second
+
+// configureProcessGroup starts cmd in its own process group so that a
+// cancelled context kills the whole tree, not only the direct child.
+func configureProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		if cmd.Process == nil {
+			return nil
+		}
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = processKillWaitDelay
+}
+
+// interruptProcessGroup sends SIGINT to the process group cmd leads, the
+// same signal Ctrl-C delivers to a foreground job.
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
+
+func makeFIFO(path string) error {
+	return syscall.Mkfifo(path, 0o600)
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package plugin
+
+import (
+	"errors"
+	"os/exec"
+	"time"
+)
+
+const processKillWaitDelay = 2 * time.Second
+
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = processKillWaitDelay
+}
+
+func interruptProcessGroup(_ *exec.Cmd) error {
+	return errors.New("interrupt is not supported on windows")
+}
+
+func makeFIFO(_ string) error {
+	return errors.New("fifo is not supported on windows")
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// configureSandbox moves the command into a fresh network namespace when the
+// policy asks for it, and applies the resource limits. Unprivileged users
+// need a user namespace as well.
+func configureSandbox(cmd *exec.Cmd, policy ShellPolicy) error {
+	if err := wrapResourceLimits(cmd, policy); err != nil {
+		return err
+	}
+	if !policy.NoNetwork {
+		return nil
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	if uid := os.Geteuid(); uid != 0 {
+		gid := os.Getegid()
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	}
+	return nil
+}
+
+// wrapResourceLimits runs the command through sh, which sets the rlimits with
+// ulimit and then execs the original program. The limits are therefore in
+// place before the program or anything it starts runs.
+func wrapResourceLimits(cmd *exec.Cmd, policy ShellPolicy) error {
+	if !policy.hasResourceLimits() || cmd.Err != nil {
+		return nil
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrShellToolSandboxUnavailable, err)
+	}
+	limits := []string{}
+	if policy.MaxCPUSeconds > 0 {
+		// The soft limit sends SIGXCPU a second before the hard limit kills.
+		limits = append(limits, fmt.Sprintf("ulimit -S -t %d", policy.MaxCPUSeconds), fmt.Sprintf("ulimit -H -t %d", policy.MaxCPUSeconds+1))
+	}
+	if policy.MaxMemoryBytes > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -v %d", max(policy.MaxMemoryBytes/1024, 1)))
+	}
+	if policy.MaxFileBytes > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -f %d", max(policy.MaxFileBytes/512, 1)))
+	}
+	script := strings.Join(limits, " && ") + ` && exec "$0" "$@"`
+	cmd.Args = append([]string{"sh", "-c", script, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sh
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package plugin
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func configureSandbox(_ *exec.Cmd, policy ShellPolicy) error {
+	if policy.NoNetwork {
+		return fmt.Errorf("%w: network isolation requires linux namespaces", ErrShellToolSandboxUnavailable)
+	}
+	if policy.hasResourceLimits() {
+		return fmt.Errorf("%w: resource limits require linux", ErrShellToolSandboxUnavailable)
+	}
+	return nil
+}
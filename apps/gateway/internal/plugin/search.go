@@ -76,6 +76,14 @@ type searchResult struct {
 }
 
 func NewSearchToolFromEnv() (*SearchTool, error) {
+	return NewSearchToolWithConfig(nil)
+}
+
+// NewSearchToolWithConfig starts from the provider settings in the
+// environment and overrides them with cfg, shaped as
+// {"default_provider": "tavily", "providers": {"tavily": {"api_key": "...", "base_url": "..."}}}.
+// A provider listed in cfg with an empty api_key is dropped.
+func NewSearchToolWithConfig(cfg map[string]interface{}) (*SearchTool, error) {
 	providers := map[string]searchProviderConfig{}
 	if cfg, ok := searchProviderFromEnv(searchProviderSerpAPI, searchSerpAPIKeyEnv, searchSerpAPIBaseEnv, searchSerpAPIDefaultURL); ok {
 		providers[cfg.Name] = cfg
@@ -86,11 +94,32 @@ func NewSearchToolFromEnv() (*SearchTool, error) {
 	if cfg, ok := searchProviderFromEnv(searchProviderBrave, searchBraveKeyEnv, searchBraveBaseEnv, searchBraveDefaultURL); ok {
 		providers[cfg.Name] = cfg
 	}
+	overrides, _ := cfg["providers"].(map[string]interface{})
+	for rawName, rawProvider := range overrides {
+		name := strings.ToLower(strings.TrimSpace(rawName))
+		if !isSupportedSearchProvider(name) {
+			return nil, fmt.Errorf("%w: %s", ErrSearchToolProviderUnsupported, rawName)
+		}
+		entry, _ := rawProvider.(map[string]interface{})
+		apiKey := strings.TrimSpace(stringValue(entry["api_key"]))
+		if apiKey == "" {
+			delete(providers, name)
+			continue
+		}
+		baseURL := strings.TrimSpace(stringValue(entry["base_url"]))
+		if baseURL == "" {
+			baseURL = searchProviderDefaultURL(name)
+		}
+		providers[name] = searchProviderConfig{Name: name, APIKey: apiKey, BaseURL: baseURL}
+	}
 	if len(providers) == 0 {
 		return nil, ErrSearchToolProvidersMissing
 	}
 
-	defaultProvider := strings.ToLower(strings.TrimSpace(os.Getenv(searchDefaultProviderEnv)))
+	defaultProvider := strings.ToLower(strings.TrimSpace(stringValue(cfg["default_provider"])))
+	if defaultProvider == "" {
+		defaultProvider = strings.ToLower(strings.TrimSpace(os.Getenv(searchDefaultProviderEnv)))
+	}
 	if defaultProvider == "" {
 		defaultProvider = pickDefaultSearchProvider(providers)
 	}
@@ -112,7 +141,57 @@ func (t *SearchTool) Name() string {
 	return "search"
 }
 
+func (t *SearchTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "Search the web via configured search APIs. input must be an array.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Array of search requests; pass one item for single query.",
+					"minItems":    1,
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"query": map[string]interface{}{
+								"type":        "string",
+								"description": "Search query text. Required unless the legacy q alias is set.",
+							},
+							"q": map[string]interface{}{
+								"type":        "string",
+								"description": "Deprecated alias for query.",
+							},
+							"provider": map[string]interface{}{
+								"type":        "string",
+								"description": "Optional provider override: serpapi | tavily | brave.",
+							},
+							"count": map[string]interface{}{
+								"type":        []string{"integer", "string"},
+								"minimum":     1,
+								"description": "Optional max results per query; numeric strings are accepted.",
+							},
+							"timeout_seconds": map[string]interface{}{
+								"type":        []string{"integer", "string"},
+								"minimum":     1,
+								"description": "Optional timeout for a single query; numeric strings are accepted.",
+							},
+						},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"items"},
+			"additionalProperties": false,
+		},
+	}
+}
+
 func (t *SearchTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *SearchTool) InvokeContext(ctx context.Context, _ ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
 	items, err := parseSearchItems(input, t.defaultProvider)
 	if err != nil {
 		return nil, err
@@ -121,7 +200,7 @@ func (t *SearchTool) Invoke(input map[string]interface{}) (map[string]interface{
 	results := make([]map[string]interface{}, 0, len(items))
 	allOK := true
 	for _, item := range items {
-		one, oneErr := t.invokeOne(item)
+		one, oneErr := t.invokeOne(ctx, item)
 		if oneErr != nil {
 			return nil, oneErr
 		}
@@ -149,7 +228,7 @@ func (t *SearchTool) Invoke(input map[string]interface{}) (map[string]interface{
 	}, nil
 }
 
-func (t *SearchTool) invokeOne(item searchItem) (map[string]interface{}, error) {
+func (t *SearchTool) invokeOne(parent context.Context, item searchItem) (map[string]interface{}, error) {
 	providerName := strings.ToLower(strings.TrimSpace(item.Provider))
 	if providerName == "" {
 		providerName = t.defaultProvider
@@ -163,11 +242,14 @@ func (t *SearchTool) invokeOne(item searchItem) (map[string]interface{}, error)
 	}
 
 	startedAt := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), item.Timeout)
+	ctx, cancel := context.WithTimeout(parent, item.Timeout)
 	defer cancel()
 
 	searchResults, err := t.searchWithProvider(ctx, providerCfg, item.Query, item.Count)
 	durationMs := time.Since(startedAt).Milliseconds()
+	if parent.Err() != nil {
+		return nil, context.Cause(parent)
+	}
 
 	if err != nil {
 		return map[string]interface{}{
@@ -568,6 +650,19 @@ func isSupportedSearchProvider(name string) bool {
 	}
 }
 
+func searchProviderDefaultURL(name string) string {
+	switch name {
+	case searchProviderSerpAPI:
+		return searchSerpAPIDefaultURL
+	case searchProviderTavily:
+		return searchTavilyDefaultURL
+	case searchProviderBrave:
+		return searchBraveDefaultURL
+	default:
+		return ""
+	}
+}
+
 func searchProviderFromEnv(name, keyEnv, baseEnv, defaultBase string) (searchProviderConfig, bool) {
 	apiKey := strings.TrimSpace(os.Getenv(keyEnv))
 	if apiKey == "" {
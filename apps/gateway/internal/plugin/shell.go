@@ -1,9 +1,11 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"runtime"
 	"strconv"
@@ -23,17 +25,61 @@ var (
 	ErrShellToolExecutorUnavailable = errors.New("shell_tool_executor_unavailable")
 )
 
-type ShellTool struct{}
+type ShellTool struct {
+	policy ShellPolicy
+}
 
 func NewShellTool() *ShellTool {
 	return &ShellTool{}
 }
 
+func NewShellToolWithPolicy(policy ShellPolicy) *ShellTool {
+	return &ShellTool{policy: policy}
+}
+
 func (t *ShellTool) Name() string {
 	return "shell"
 }
 
+func (t *ShellTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "Execute one or multiple shell commands under server security controls. input must be an array.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Array of shell command operations; pass one item for single command.",
+					"minItems":    1,
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"command": map[string]interface{}{
+								"type": "string",
+							},
+							"cwd": map[string]interface{}{
+								"type": "string",
+							},
+							"timeout_seconds": map[string]interface{}{
+								"type":    "integer",
+								"minimum": 1,
+							},
+						},
+						"required":             []string{"command"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required": []string{"items"},
+		},
+	}
+}
+
 func (t *ShellTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *ShellTool) InvokeContext(ctx context.Context, inv ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
 	items, err := parseShellItems(input)
 	if err != nil {
 		return nil, err
@@ -41,7 +87,7 @@ func (t *ShellTool) Invoke(input map[string]interface{}) (map[string]interface{}
 	results := make([]map[string]interface{}, 0, len(items))
 	allOK := true
 	for _, item := range items {
-		one, oneErr := t.invokeOne(item)
+		one, oneErr := t.invokeOne(ctx, item, inv)
 		if oneErr != nil {
 			return nil, oneErr
 		}
@@ -67,14 +113,21 @@ func (t *ShellTool) Invoke(input map[string]interface{}) (map[string]interface{}
 	}, nil
 }
 
-func (t *ShellTool) invokeOne(input map[string]interface{}) (map[string]interface{}, error) {
+func (t *ShellTool) invokeOne(parent context.Context, input map[string]interface{}, inv ToolInvocation) (map[string]interface{}, error) {
 	command := strings.TrimSpace(stringValue(input["command"]))
 	if command == "" {
 		return nil, ErrShellToolCommandMissing
 	}
+	if err := t.policy.checkCommand(command); err != nil {
+		return nil, err
+	}
+	cwd, err := t.policy.resolveCwd(strings.TrimSpace(stringValue(input["cwd"])))
+	if err != nil {
+		return nil, err
+	}
 
 	timeout := parseShellTimeout(input["timeout_seconds"])
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
 	program, baseArgs, resolveErr := resolveShellExecutor(runtime.GOOS, exec.LookPath)
@@ -83,12 +136,31 @@ func (t *ShellTool) invokeOne(input map[string]interface{}) (map[string]interfac
 	}
 	args := append(append([]string{}, baseArgs...), command)
 	cmd := exec.CommandContext(ctx, program, args...)
-	if cwd := strings.TrimSpace(stringValue(input["cwd"])); cwd != "" {
-		cmd.Dir = cwd
+	if err := configureSandbox(cmd, t.policy); err != nil {
+		return nil, err
 	}
+	configureProcessGroup(cmd)
+	cmd.Dir = cwd
+	cmd.Env = t.policy.commandEnv(inv.Env)
 
-	outputBytes, err := cmd.CombinedOutput()
-	output := truncateOutput(string(outputBytes), shellToolMaxOutputBytes)
+	var outputBuf bytes.Buffer
+	var sink io.Writer = &outputBuf
+	if stream := inv.OutputWriter(); stream != nil {
+		sink = io.MultiWriter(&outputBuf, stream)
+	}
+	cmd.Stdout = sink
+	cmd.Stderr = sink
+	err = cmd.Start()
+	if err != nil && t.policy.NoNetwork {
+		return nil, fmt.Errorf("%w: %v", ErrShellToolSandboxUnavailable, err)
+	}
+	if err == nil {
+		err = cmd.Wait()
+	}
+	if parent.Err() != nil {
+		return nil, context.Cause(parent)
+	}
+	output := truncateOutput(outputBuf.String(), shellToolMaxOutputBytes)
 	ok := err == nil
 	exitCode := 0
 
@@ -0,0 +1,373 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	shellAllowCommandsEnv  = "NEXTAI_SHELL_ALLOW_COMMANDS"
+	shellDenyCommandsEnv   = "NEXTAI_SHELL_DENY_COMMANDS"
+	shellAllowedRootsEnv   = "NEXTAI_SHELL_ALLOWED_ROOTS"
+	shellScrubEnvEnv       = "NEXTAI_SHELL_SCRUB_ENV"
+	shellEnvPassthroughEnv = "NEXTAI_SHELL_ENV_PASSTHROUGH"
+	shellMaxCPUSecondsEnv  = "NEXTAI_SHELL_MAX_CPU_SECONDS"
+	shellMaxMemoryMBEnv    = "NEXTAI_SHELL_MAX_MEMORY_MB"
+	shellMaxFileSizeMBEnv  = "NEXTAI_SHELL_MAX_FILE_SIZE_MB"
+	shellNoNetworkEnv      = "NEXTAI_SHELL_NO_NETWORK"
+
+	bytesPerMB = 1024 * 1024
+)
+
+var (
+	ErrShellToolCommandDenied      = errors.New("shell_tool_command_denied")
+	ErrShellToolCwdDenied          = errors.New("shell_tool_cwd_denied")
+	ErrShellToolSandboxUnavailable = errors.New("shell_tool_sandbox_unavailable")
+)
+
+// shellBaseEnv is kept when the environment is scrubbed so that ordinary
+// commands still find their binaries and locale.
+var shellBaseEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_ALL", "LC_CTYPE", "TERM", "TZ", "TMPDIR"}
+
+// shellAssignmentPrefix matches a leading `NAME=value` word, which only sets
+// the environment of the command that follows it.
+var shellAssignmentPrefix = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=\S*\s*`)
+
+// shellSegmentKeywords are reserved words that can precede a command within a
+// segment; shellSegmentClosers end a compound command and run nothing.
+var (
+	shellSegmentKeywords = map[string]bool{"then": true, "do": true, "else": true, "elif": true, "if": true, "while": true, "until": true, "time": true}
+	shellSegmentClosers  = map[string]bool{"}": true, ")": true, "fi": true, "done": true, "esac": true}
+)
+
+// ShellPolicy restricts what the shell tool may run. The zero value allows
+// everything, which matches the behaviour before policies existed.
+//
+// Command patterns use shell-style wildcards (`*` and `?`) and are matched
+// against every command segment split on `;`, `&&`, `||`, `|`, `&` and
+// newlines, with subshell and group brackets, `!`, `time`, `if`/`then`/`do`
+// style keywords and `NAME=value` prefixes stripped. AllowedRoots only constrains the starting directory; it is not a
+// filesystem jail.
+type ShellPolicy struct {
+	AllowCommands  []string
+	DenyCommands   []string
+	AllowedRoots   []string
+	ScrubEnv       bool
+	EnvPassthrough []string
+	MaxCPUSeconds  int
+	MaxMemoryBytes int64
+	MaxFileBytes   int64
+	NoNetwork      bool
+}
+
+func ShellPolicyFromEnv() (ShellPolicy, error) {
+	policy := ShellPolicy{
+		AllowCommands:  splitPolicyList(os.Getenv(shellAllowCommandsEnv)),
+		DenyCommands:   splitPolicyList(os.Getenv(shellDenyCommandsEnv)),
+		EnvPassthrough: splitPolicyList(os.Getenv(shellEnvPassthroughEnv)),
+	}
+	for _, root := range filepath.SplitList(os.Getenv(shellAllowedRootsEnv)) {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		resolved, err := resolveExistingPath(root)
+		if err != nil {
+			return ShellPolicy{}, fmt.Errorf("invalid %s entry %q: %w", shellAllowedRootsEnv, root, err)
+		}
+		policy.AllowedRoots = append(policy.AllowedRoots, resolved)
+	}
+	var err error
+	if policy.ScrubEnv, err = policyBool(shellScrubEnvEnv); err != nil {
+		return ShellPolicy{}, err
+	}
+	if policy.NoNetwork, err = policyBool(shellNoNetworkEnv); err != nil {
+		return ShellPolicy{}, err
+	}
+	cpu, err := policyInt(shellMaxCPUSecondsEnv)
+	if err != nil {
+		return ShellPolicy{}, err
+	}
+	memoryMB, err := policyInt(shellMaxMemoryMBEnv)
+	if err != nil {
+		return ShellPolicy{}, err
+	}
+	fileMB, err := policyInt(shellMaxFileSizeMBEnv)
+	if err != nil {
+		return ShellPolicy{}, err
+	}
+	policy.MaxCPUSeconds = int(cpu)
+	policy.MaxMemoryBytes = memoryMB * bytesPerMB
+	policy.MaxFileBytes = fileMB * bytesPerMB
+	return policy, nil
+}
+
+func (p ShellPolicy) hasResourceLimits() bool {
+	return p.MaxCPUSeconds > 0 || p.MaxMemoryBytes > 0 || p.MaxFileBytes > 0
+}
+
+// checkCommand applies the deny patterns first, then requires every segment
+// to match an allow pattern when an allowlist is configured. Substitutions
+// cannot be checked segment by segment, so they are rejected whenever any
+// pattern is configured.
+//
+// The check is textual and best-effort: it does not follow quoting, and a
+// nested interpreter (sh -c '...', eval, a script file) can still run
+// commands the patterns would deny. Use it to steer the model, not as a
+// security boundary; the sandbox options are the enforcement layer.
+func (p ShellPolicy) checkCommand(command string) error {
+	if len(p.AllowCommands) > 0 || len(p.DenyCommands) > 0 {
+		for _, marker := range []string{"`", "$(", "<(", ">("} {
+			if strings.Contains(command, marker) {
+				return fmt.Errorf("%w: command substitution is not allowed with command patterns", ErrShellToolCommandDenied)
+			}
+		}
+	}
+	segments := splitShellSegments(command)
+	for _, pattern := range p.DenyCommands {
+		if wildcardMatch(pattern, command) {
+			return fmt.Errorf("%w: command matches deny pattern %q", ErrShellToolCommandDenied, pattern)
+		}
+		for _, segment := range segments {
+			if wildcardMatch(pattern, segment) {
+				return fmt.Errorf("%w: %q matches deny pattern %q", ErrShellToolCommandDenied, segment, pattern)
+			}
+		}
+	}
+	if len(p.AllowCommands) == 0 {
+		return nil
+	}
+	for _, segment := range segments {
+		allowed := false
+		for _, pattern := range p.AllowCommands {
+			if wildcardMatch(pattern, segment) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %q does not match any allowed pattern", ErrShellToolCommandDenied, segment)
+		}
+	}
+	return nil
+}
+
+// resolveCwd returns the directory the command starts in. With allowed roots
+// configured, an empty cwd defaults to the first root and relative paths are
+// resolved against it.
+func (p ShellPolicy) resolveCwd(cwd string) (string, error) {
+	if len(p.AllowedRoots) == 0 {
+		return cwd, nil
+	}
+	if cwd == "" {
+		return p.AllowedRoots[0], nil
+	}
+	if !filepath.IsAbs(cwd) {
+		cwd = filepath.Join(p.AllowedRoots[0], cwd)
+	}
+	resolved, err := resolveExistingPath(cwd)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrShellToolCwdDenied, err)
+	}
+	for _, root := range p.AllowedRoots {
+		if pathWithinRoot(root, resolved) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is outside the allowed roots", ErrShellToolCwdDenied, resolved)
+}
+
+// commandEnv builds the environment of a command: the gateway's environment
+// with the stored variables in extra layered on top. A scrubbed environment
+// keeps only the base and passthrough names from either layer. nil means the
+// command inherits the gateway's environment unchanged.
+func (p ShellPolicy) commandEnv(extra map[string]string) []string {
+	lookup := func(name string) (string, bool) {
+		if value, ok := extra[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}
+	if !p.ScrubEnv {
+		if len(extra) == 0 {
+			return nil
+		}
+		env := make([]string, 0, len(extra))
+		for _, entry := range os.Environ() {
+			name, _, _ := strings.Cut(entry, "=")
+			if _, ok := extra[name]; !ok {
+				env = append(env, entry)
+			}
+		}
+		names := make([]string, 0, len(extra))
+		for name := range extra {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			env = append(env, name+"="+extra[name])
+		}
+		return env
+	}
+	names := append(append([]string{}, shellBaseEnv...), p.EnvPassthrough...)
+	env := make([]string, 0, len(names))
+	seen := map[string]struct{}{}
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		if value, ok := lookup(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// splitShellSegments splits command on control operators. An `&` or `|` that
+// belongs to a redirection (`2>&1`, `<&3`, `&>file`, `>|file`) is kept.
+func splitShellSegments(command string) []string {
+	var out []string
+	start := 0
+	flush := func(end int) {
+		if segment := trimShellSegment(command[start:end]); segment != "" {
+			out = append(out, segment)
+		}
+	}
+	for i := 0; i < len(command); i++ {
+		var prev, next byte
+		if i > 0 {
+			prev = command[i-1]
+		}
+		if i+1 < len(command) {
+			next = command[i+1]
+		}
+		switch command[i] {
+		case ';', '\n':
+		case '&':
+			if next != '&' && (prev == '>' || prev == '<' || next == '>') {
+				continue
+			}
+		case '|':
+			if next != '|' && prev == '>' {
+				continue
+			}
+		default:
+			continue
+		}
+		flush(i)
+		if (command[i] == '&' || command[i] == '|') && next == command[i] {
+			i++
+		}
+		start = i + 1
+	}
+	flush(len(command))
+	return out
+}
+
+// trimShellSegment strips the wrappers that may precede the command in a
+// segment so patterns match the command itself. Segments that only close a
+// compound command are dropped.
+func trimShellSegment(segment string) string {
+	segment = strings.TrimSpace(segment)
+	for {
+		before := segment
+		switch {
+		case strings.HasPrefix(segment, "("):
+			segment = segment[1:]
+		case segment == "{" || segment == "!" || strings.HasPrefix(segment, "{ ") || strings.HasPrefix(segment, "! ") ||
+			strings.HasPrefix(segment, "{\t") || strings.HasPrefix(segment, "!\t"):
+			segment = segment[1:]
+		default:
+			word, rest, _ := strings.Cut(segment, " ")
+			if shellSegmentKeywords[word] {
+				segment = rest
+			} else if loc := shellAssignmentPrefix.FindStringIndex(segment); loc != nil {
+				segment = segment[loc[1]:]
+			}
+		}
+		segment = strings.TrimSpace(segment)
+		if segment == before {
+			break
+		}
+	}
+	segment = strings.TrimSpace(strings.TrimRight(segment, ")"))
+	if strings.HasSuffix(segment, " }") {
+		segment = strings.TrimSpace(strings.TrimSuffix(segment, "}"))
+	}
+	if shellSegmentClosers[segment] {
+		return ""
+	}
+	return segment
+}
+
+func wildcardMatch(pattern, value string) bool {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return false
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, `.*`)
+	expr = strings.ReplaceAll(expr, `\?`, `.`)
+	re, err := regexp.Compile(`(?s)^` + expr + `$`)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
+
+func resolveExistingPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+func pathWithinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+func splitPolicyList(raw string) []string {
+	out := make([]string, 0)
+	for _, part := range strings.Split(raw, ",") {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
+
+func policyBool(key string) (bool, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %q", key, raw)
+	}
+	return value, nil
+}
+
+func policyInt(key string) (int64, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, raw)
+	}
+	return value, nil
+}
@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestShellPolicyCheckCommand(t *testing.T) {
+	policy := ShellPolicy{
+		AllowCommands: []string{"ls*", "git status*", "printf *"},
+		DenyCommands:  []string{"*rm -rf*"},
+	}
+	for _, command := range []string{"ls -la", "git status && ls", "printf ok | ls"} {
+		if err := policy.checkCommand(command); err != nil {
+			t.Fatalf("expected %q to be allowed, got=%v", command, err)
+		}
+	}
+	for _, command := range []string{"curl example.com", "ls; rm -rf /tmp/x", "ls $(whoami)", "printf `id`"} {
+		if err := policy.checkCommand(command); !errors.Is(err, ErrShellToolCommandDenied) {
+			t.Fatalf("expected %q to be denied, got=%v", command, err)
+		}
+	}
+	if err := (ShellPolicy{DenyCommands: []string{"sudo *"}}).checkCommand("echo hi && sudo reboot"); !errors.Is(err, ErrShellToolCommandDenied) {
+		t.Fatalf("expected deny pattern to match a later segment, got=%v", err)
+	}
+	if err := (ShellPolicy{DenyCommands: []string{"rm *"}}).checkCommand("echo $(rm -rf /tmp/x)"); !errors.Is(err, ErrShellToolCommandDenied) {
+		t.Fatalf("expected substitution to be denied under deny patterns, got=%v", err)
+	}
+}
+
+func TestShellPolicyKeepsRedirectionsInSegment(t *testing.T) {
+	policy := ShellPolicy{AllowCommands: []string{"go *"}}
+	for _, command := range []string{"go test ./... 2>&1", "go vet ./... &>/dev/null", "go build ./... >|out.log 2>&1 && go test ./..."} {
+		if err := policy.checkCommand(command); err != nil {
+			t.Fatalf("expected %q to be allowed, got=%v", command, err)
+		}
+	}
+	if err := policy.checkCommand("go test ./... & curl example.com"); !errors.Is(err, ErrShellToolCommandDenied) {
+		t.Fatalf("expected background separator to still split, got=%v", err)
+	}
+}
+
+func TestShellPolicyDenyPatternsSeeThroughWrappers(t *testing.T) {
+	policy := ShellPolicy{DenyCommands: []string{"rm -rf *"}}
+	for _, command := range []string{
+		"(rm -rf x)",
+		"{ rm -rf x; }",
+		"if true; then rm -rf x; fi",
+		"for f in a; do rm -rf x; done",
+		"if false; then :; else rm -rf x; fi",
+		"FOO=1 rm -rf x",
+		"! rm -rf x",
+		"time rm -rf x",
+	} {
+		if err := policy.checkCommand(command); !errors.Is(err, ErrShellToolCommandDenied) {
+			t.Fatalf("expected %q to be denied, got=%v", command, err)
+		}
+	}
+	allow := ShellPolicy{AllowCommands: []string{"go *", "true"}}
+	if err := allow.checkCommand("if true; then GOFLAGS=-v go test ./...; fi"); err != nil {
+		t.Fatalf("expected wrapped allowed command to pass, got=%v", err)
+	}
+}
+
+func TestShellPolicyResolveCwdStaysInsideRoots(t *testing.T) {
+	root, err := resolveExistingPath(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	policy := ShellPolicy{AllowedRoots: []string{root}}
+
+	if cwd, err := policy.resolveCwd(""); err != nil || cwd != root {
+		t.Fatalf("expected default cwd to be the root, got=%q err=%v", cwd, err)
+	}
+	if cwd, err := policy.resolveCwd("sub"); err != nil || cwd != filepath.Join(root, "sub") {
+		t.Fatalf("expected relative cwd inside root, got=%q err=%v", cwd, err)
+	}
+	if _, err := policy.resolveCwd(filepath.Dir(root)); !errors.Is(err, ErrShellToolCwdDenied) {
+		t.Fatalf("expected parent directory to be denied, got=%v", err)
+	}
+	if _, err := policy.resolveCwd("../"); !errors.Is(err, ErrShellToolCwdDenied) {
+		t.Fatalf("expected escaping relative cwd to be denied, got=%v", err)
+	}
+}
+
+func TestShellToolRejectsCommandOutsidePolicy(t *testing.T) {
+	tool := NewShellToolWithPolicy(ShellPolicy{AllowCommands: []string{"printf *"}})
+	_, err := tool.Invoke(map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"command": "id"}},
+	})
+	if !errors.Is(err, ErrShellToolCommandDenied) {
+		t.Fatalf("expected ErrShellToolCommandDenied, got=%v", err)
+	}
+}
+
+func TestShellToolScrubsEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses posix shell syntax")
+	}
+	t.Setenv("NEXTAI_TEST_SHELL_SECRET", "secret-value")
+	t.Setenv("NEXTAI_TEST_SHELL_KEEP", "kept-value")
+	tool := NewShellToolWithPolicy(ShellPolicy{ScrubEnv: true, EnvPassthrough: []string{"NEXTAI_TEST_SHELL_KEEP"}})
+	result, err := tool.Invoke(map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"command": `printf "%s|%s" "$NEXTAI_TEST_SHELL_SECRET" "$NEXTAI_TEST_SHELL_KEEP"`}},
+	})
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	if output, _ := result["output"].(string); output != "|kept-value" {
+		t.Fatalf("expected scrubbed environment, got=%q", output)
+	}
+}
+
+func TestShellToolLayersInvocationEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses posix shell syntax")
+	}
+	t.Setenv("NEXTAI_TEST_SHELL_KEEP", "os-value")
+	t.Setenv("NEXTAI_TEST_SHELL_SECRET", "os-secret")
+	inv := ToolInvocation{Env: map[string]string{
+		"NEXTAI_TEST_SHELL_KEEP":   "stored-value",
+		"NEXTAI_TEST_SHELL_STORED": "stored-only",
+	}}
+	input := map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"command": `printf "%s|%s|%s" "$NEXTAI_TEST_SHELL_KEEP" "$NEXTAI_TEST_SHELL_STORED" "$NEXTAI_TEST_SHELL_SECRET"`}},
+	}
+
+	result, err := NewShellTool().InvokeContext(context.Background(), inv, input)
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	if output, _ := result["output"].(string); output != "stored-value|stored-only|os-secret" {
+		t.Fatalf("expected stored env over process env, got=%q", output)
+	}
+
+	scrubbed := NewShellToolWithPolicy(ShellPolicy{ScrubEnv: true, EnvPassthrough: []string{"NEXTAI_TEST_SHELL_KEEP"}})
+	result, err = scrubbed.InvokeContext(context.Background(), inv, input)
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	if output, _ := result["output"].(string); output != "stored-value||" {
+		t.Fatalf("expected scrub to apply to stored env, got=%q", output)
+	}
+}
+
+func TestShellToolAppliesFileSizeLimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are linux only")
+	}
+	dir := t.TempDir()
+	tool := NewShellToolWithPolicy(ShellPolicy{MaxFileBytes: 1024})
+	result, err := tool.Invoke(map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"command": "head -c 4096 /dev/zero > big.bin", "cwd": dir}},
+	})
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	info, statErr := os.Stat(filepath.Join(dir, "big.bin"))
+	if ok, _ := result["ok"].(bool); ok || statErr != nil || info.Size() > 1024 {
+		t.Fatalf("expected write to be cut at the limit, result=%v stat=%v", result, statErr)
+	}
+}
+
+func TestShellToolAppliesLimitsBeforeCommandRuns(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are linux only")
+	}
+	tool := NewShellToolWithPolicy(ShellPolicy{MaxCPUSeconds: 5, MaxFileBytes: 1024})
+	result, err := tool.Invoke(map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"command": "ulimit -t; ulimit -f"}},
+	})
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	if output, _ := result["output"].(string); output != "5\n2\n" {
+		t.Fatalf("expected limits in place when the command starts, got=%q", output)
+	}
+}
+
+func TestShellToolNoNetworkIsolatesInterfaces(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("network namespaces are linux only")
+	}
+	tool := NewShellToolWithPolicy(ShellPolicy{NoNetwork: true})
+	result, err := tool.Invoke(map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"command": "cat /proc/net/dev"}},
+	})
+	if errors.Is(err, ErrShellToolSandboxUnavailable) {
+		t.Skipf("namespaces unavailable in this environment: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	output, _ := result["output"].(string)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n")[2:] {
+		if name := strings.TrimSpace(strings.SplitN(line, ":", 2)[0]); name != "lo" {
+			t.Fatalf("expected only loopback inside the sandbox, got=%q", output)
+		}
+	}
+}
@@ -0,0 +1,805 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	shellSessionDefaultName     = "default"
+	shellSessionDefaultExecWait = 20 * time.Second
+	shellSessionDefaultIOWait   = 200 * time.Millisecond
+	shellSessionMaxWait         = 120 * time.Second
+	shellSessionInterruptWait   = 2 * time.Second
+	shellSessionMaxBufferBytes  = 256 * 1024
+	shellSessionMaxSessions     = 16
+	shellSessionMarkerPrefix    = "__NEXTAI_DONE_"
+)
+
+var (
+	ErrShellSessionActionInvalid = errors.New("shell_session_action_invalid")
+	ErrShellSessionNotFound      = errors.New("shell_session_not_found")
+	ErrShellSessionBusy          = errors.New("shell_session_busy")
+	ErrShellSessionIdle          = errors.New("shell_session_idle")
+	ErrShellSessionLimit         = errors.New("shell_session_limit_reached")
+	ErrShellSessionUnsupported   = errors.New("shell_session_unsupported")
+)
+
+// ShellSessionTool keeps long-lived shells per chat so that the working
+// directory, exported variables and background jobs survive between calls.
+// Sessions idle for longer than idleTimeout are closed by a background reaper.
+type ShellSessionTool struct {
+	policy      ShellPolicy
+	idleTimeout time.Duration
+
+	mu        sync.Mutex
+	sessions  map[string]*shellSession
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type shellSession struct {
+	name    string
+	cwd     string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	cancel  context.CancelFunc
+	fifoDir string
+
+	mu       sync.Mutex
+	changed  chan struct{}
+	buf      []byte
+	base     int64
+	cursor   int64
+	running  *shellSessionExec
+	exited   bool
+	exitCode int
+	lastUsed time.Time
+}
+
+type shellSessionExec struct {
+	command    string
+	marker     string
+	start      int64
+	done       bool
+	exitCode   int
+	cwd        string
+	cwdChecked bool
+	stdin      *os.File
+	fifo       string
+}
+
+func NewShellSessionTool(policy ShellPolicy, idleTimeout time.Duration) *ShellSessionTool {
+	t := &ShellSessionTool{
+		policy:      policy,
+		idleTimeout: idleTimeout,
+		sessions:    map[string]*shellSession{},
+		stop:        make(chan struct{}),
+	}
+	if idleTimeout > 0 {
+		go t.reapLoop()
+	}
+	return t
+}
+
+func (t *ShellSessionTool) Name() string {
+	return "shell_session"
+}
+
+func (t *ShellSessionTool) ToolSpec() ToolSpec {
+	return ToolSpec{
+		Description: "Drive a persistent shell kept per chat: cd, exported variables and background jobs carry across calls. Use exec to run a command, read to poll output of a long command, write to send stdin to a running program (e.g. a REPL) and close when done.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action": map[string]interface{}{
+					"type": "string",
+					"enum": []string{"open", "exec", "read", "write", "close"},
+				},
+				"session": map[string]interface{}{
+					"type":        "string",
+					"description": "Optional session name within the chat; defaults to \"default\".",
+				},
+				"command": map[string]interface{}{
+					"type":        "string",
+					"description": "Command for exec.",
+				},
+				"input": map[string]interface{}{
+					"type":        "string",
+					"description": "Raw stdin for write; include a trailing newline to submit a line.",
+				},
+				"close_stdin": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Close stdin of the running program after write.",
+				},
+				"cwd": map[string]interface{}{
+					"type":        "string",
+					"description": "Starting directory when the session is created.",
+				},
+				"timeout_seconds": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     1,
+					"description": "How long exec waits before returning with the command still running.",
+				},
+				"wait_ms": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     0,
+					"description": "How long read or write waits for output.",
+				},
+			},
+			"required":             []string{"action"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+// Close stops the reaper and kills every open session.
+func (t *ShellSessionTool) Close() {
+	t.closeOnce.Do(func() {
+		close(t.stop)
+		t.mu.Lock()
+		sessions := t.sessions
+		t.sessions = map[string]*shellSession{}
+		t.mu.Unlock()
+		for _, sess := range sessions {
+			sess.close()
+		}
+	})
+}
+
+func (t *ShellSessionTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *ShellSessionTool) InvokeContext(ctx context.Context, inv ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	action := strings.ToLower(strings.TrimSpace(stringValue(input["action"])))
+	name := strings.TrimSpace(stringValue(input["session"]))
+	if name == "" {
+		name = shellSessionDefaultName
+	}
+	key := inv.ChatID + "\x00" + name
+
+	switch action {
+	case "open":
+		sess, created, err := t.openSession(key, name, strings.TrimSpace(stringValue(input["cwd"])), inv.Env)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"ok":      true,
+			"session": name,
+			"created": created,
+			"cwd":     sess.cwd,
+			"text":    fmt.Sprintf("shell session %q is open (cwd: %s)", name, sess.cwd),
+		}, nil
+	case "exec":
+		command := strings.TrimSpace(stringValue(input["command"]))
+		if command == "" {
+			return nil, ErrShellToolCommandMissing
+		}
+		if err := t.policy.checkCommand(command); err != nil {
+			return nil, err
+		}
+		sess, _, err := t.openSession(key, name, strings.TrimSpace(stringValue(input["cwd"])), inv.Env)
+		if err != nil {
+			return nil, err
+		}
+		if err := sess.exec(command); err != nil {
+			return nil, err
+		}
+		wait := parseShellSessionWait(input, "timeout_seconds", time.Second, shellSessionDefaultExecWait)
+		if err := sess.waitFor(ctx, wait, func() bool { return sess.running.done }, inv.OutputWriter()); err != nil {
+			t.interrupt(key, sess)
+			return nil, err
+		}
+		if err := t.checkCwd(sess); err != nil {
+			return nil, err
+		}
+		return sess.snapshot(), nil
+	case "read":
+		sess, err := t.getSession(key)
+		if err != nil {
+			return nil, err
+		}
+		wait := parseShellSessionWait(input, "wait_ms", time.Millisecond, 0)
+		if err := sess.waitFor(ctx, wait, sess.hasUnreadLocked, inv.OutputWriter()); err != nil {
+			return nil, err
+		}
+		if err := t.checkCwd(sess); err != nil {
+			return nil, err
+		}
+		return sess.snapshot(), nil
+	case "write":
+		sess, err := t.getSession(key)
+		if err != nil {
+			return nil, err
+		}
+		closeStdin, _ := input["close_stdin"].(bool)
+		if err := sess.write(stringValue(input["input"]), closeStdin); err != nil {
+			return nil, err
+		}
+		wait := parseShellSessionWait(input, "wait_ms", time.Millisecond, shellSessionDefaultIOWait)
+		if err := sess.waitFor(ctx, wait, func() bool { return sess.running.done }, inv.OutputWriter()); err != nil {
+			t.interrupt(key, sess)
+			return nil, err
+		}
+		if err := t.checkCwd(sess); err != nil {
+			return nil, err
+		}
+		return sess.snapshot(), nil
+	case "close":
+		t.mu.Lock()
+		sess, ok := t.sessions[key]
+		delete(t.sessions, key)
+		t.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrShellSessionNotFound, name)
+		}
+		sess.close()
+		return map[string]interface{}{
+			"ok":      true,
+			"session": name,
+			"text":    fmt.Sprintf("shell session %q closed", name),
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrShellSessionActionInvalid, action)
+	}
+}
+
+func (t *ShellSessionTool) getSession(key string) (*shellSession, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sess, ok := t.sessions[key]
+	if !ok {
+		return nil, ErrShellSessionNotFound
+	}
+	sess.touch()
+	return sess, nil
+}
+
+// openSession returns the existing session for key, replacing it when its
+// shell has exited, or starts a new one.
+func (t *ShellSessionTool) openSession(key, name, cwd string, env map[string]string) (*shellSession, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sess, ok := t.sessions[key]; ok {
+		if !sess.hasExited() {
+			sess.touch()
+			return sess, false, nil
+		}
+		delete(t.sessions, key)
+	}
+	if len(t.sessions) >= shellSessionMaxSessions {
+		return nil, false, ErrShellSessionLimit
+	}
+	sess, err := startShellSession(name, cwd, t.policy, env)
+	if err != nil {
+		return nil, false, err
+	}
+	t.sessions[key] = sess
+	return sess, true, nil
+}
+
+// interrupt stops the running command after the call driving it was
+// cancelled, like Ctrl-C in a terminal. The shell traps SIGINT and survives;
+// a command that ignores the signal takes the whole session down with it.
+func (t *ShellSessionTool) interrupt(key string, sess *shellSession) {
+	running := func() bool {
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		return sess.running != nil && !sess.running.done && !sess.exited
+	}
+	if !running() {
+		return
+	}
+	if err := interruptProcessGroup(sess.cmd); err == nil {
+		_ = sess.waitFor(context.Background(), shellSessionInterruptWait, func() bool { return sess.running.done }, nil)
+		if !running() {
+			return
+		}
+	}
+	t.mu.Lock()
+	if t.sessions[key] == sess {
+		delete(t.sessions, key)
+	}
+	t.mu.Unlock()
+	sess.close()
+}
+
+// checkCwd enforces the allowed roots on the directory a finished command
+// left the shell in. A session that wandered outside is moved back to where
+// it started before the next command can run there.
+func (t *ShellSessionTool) checkCwd(sess *shellSession) error {
+	if len(t.policy.AllowedRoots) == 0 {
+		return nil
+	}
+	sess.mu.Lock()
+	run := sess.running
+	if run == nil || !run.done || run.cwdChecked || run.cwd == "" {
+		sess.mu.Unlock()
+		return nil
+	}
+	run.cwdChecked = true
+	cwd := run.cwd
+	sess.mu.Unlock()
+
+	if _, err := t.policy.resolveCwd(cwd); err == nil {
+		return nil
+	}
+	if _, err := io.WriteString(sess.stdin, "cd "+shellQuote(sess.cwd)+"\n"); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: command left the session in %s; moved back to %s", ErrShellToolCwdDenied, cwd, sess.cwd)
+}
+
+func (t *ShellSessionTool) reapLoop() {
+	interval := t.idleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.reapIdle(time.Now())
+		}
+	}
+}
+
+func (t *ShellSessionTool) reapIdle(now time.Time) {
+	t.mu.Lock()
+	expired := make([]*shellSession, 0)
+	for key, sess := range t.sessions {
+		if sess.hasExited() || now.Sub(sess.lastUsedAt()) > t.idleTimeout {
+			expired = append(expired, sess)
+			delete(t.sessions, key)
+		}
+	}
+	t.mu.Unlock()
+	for _, sess := range expired {
+		sess.close()
+	}
+}
+
+func startShellSession(name, cwd string, policy ShellPolicy, env map[string]string) (*shellSession, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrShellSessionUnsupported
+	}
+	program, _, err := resolveShellExecutor(runtime.GOOS, exec.LookPath)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := policy.resolveCwd(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	fifoDir, err := os.MkdirTemp("", "nextai-shell-session-")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelSession := func() {
+		cancel()
+		_ = os.RemoveAll(fifoDir)
+	}
+	// The shell reads commands from stdin; -l keeps PATH consistent with the
+	// one-shot shell tool.
+	cmd := exec.CommandContext(ctx, program, "-l")
+	if err := configureSandbox(cmd, policy); err != nil {
+		cancelSession()
+		return nil, err
+	}
+	configureProcessGroup(cmd)
+	cmd.Dir = dir
+	cmd.Env = policy.commandEnv(env)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancelSession()
+		return nil, err
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		cancelSession()
+		return nil, err
+	}
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	err = cmd.Start()
+	_ = writer.Close()
+	if err != nil {
+		cancelSession()
+		_ = reader.Close()
+		if policy.NoNetwork {
+			return nil, fmt.Errorf("%w: %v", ErrShellToolSandboxUnavailable, err)
+		}
+		return nil, err
+	}
+	// Trapping SIGINT (rather than ignoring it, which commands would inherit)
+	// lets interrupt stop the running command without killing the shell.
+	if _, err := io.WriteString(stdin, "trap : INT\n"); err != nil {
+		cancelSession()
+		_ = reader.Close()
+		return nil, err
+	}
+	if dir == "" {
+		dir = "."
+	}
+	sess := &shellSession{
+		name:     name,
+		cwd:      dir,
+		cmd:      cmd,
+		stdin:    stdin,
+		cancel:   cancel,
+		fifoDir:  fifoDir,
+		changed:  make(chan struct{}),
+		lastUsed: time.Now(),
+	}
+	go sess.pump(reader)
+	go sess.wait()
+	return sess, nil
+}
+
+func (s *shellSession) pump(reader io.ReadCloser) {
+	defer reader.Close()
+	chunk := make([]byte, 4096)
+	for {
+		n, err := reader.Read(chunk)
+		if n > 0 {
+			s.mu.Lock()
+			s.buf = append(s.buf, chunk[:n]...)
+			s.consumeMarkerLocked()
+			s.trimLocked()
+			s.notifyLocked()
+			s.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *shellSession) wait() {
+	_ = s.cmd.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exited = true
+	s.exitCode = s.cmd.ProcessState.ExitCode()
+	s.notifyLocked()
+}
+
+// consumeMarkerLocked strips the completion marker written after each exec
+// from the buffer and records the command's exit status and the directory it
+// left the shell in.
+func (s *shellSession) consumeMarkerLocked() {
+	run := s.running
+	if run == nil || run.done {
+		return
+	}
+	from := int(run.start - s.base)
+	if from < 0 {
+		from = 0
+	}
+	token := []byte("\n" + run.marker + ":")
+	idx := bytes.Index(s.buf[from:], token)
+	if idx < 0 {
+		return
+	}
+	idx += from
+	end := bytes.IndexByte(s.buf[idx+len(token):], '\n')
+	if end < 0 {
+		return
+	}
+	end += idx + len(token)
+	status, cwd, _ := strings.Cut(string(s.buf[idx+len(token):end]), ":")
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		code = -1
+	}
+	s.buf = append(s.buf[:idx], s.buf[end+1:]...)
+	run.done = true
+	run.exitCode = code
+	run.cwd = cwd
+	run.closeStdin()
+}
+
+func (r *shellSessionExec) closeStdin() {
+	if r.stdin != nil {
+		_ = r.stdin.Close()
+		_ = os.Remove(r.fifo)
+		r.stdin = nil
+	}
+}
+
+func (s *shellSession) trimLocked() {
+	overflow := len(s.buf) - shellSessionMaxBufferBytes
+	if overflow <= 0 {
+		return
+	}
+	s.buf = append([]byte(nil), s.buf[overflow:]...)
+	s.base += int64(overflow)
+	if s.cursor < s.base {
+		s.cursor = s.base
+	}
+}
+
+func (s *shellSession) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *shellSession) exec(command string) error {
+	s.mu.Lock()
+	if s.exited {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: session %q has exited", ErrShellSessionNotFound, s.name)
+	}
+	if s.running != nil && !s.running.done {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %q is still running; use read, write or close", ErrShellSessionBusy, s.running.command)
+	}
+	nonce := randomHex(8)
+	// Each command reads stdin from its own FIFO, so input sent with write
+	// never mixes with the command stream the shell itself is reading. The
+	// FIFO is opened read-write to avoid blocking until the shell opens it.
+	fifo := filepath.Join(s.fifoDir, "stdin-"+nonce)
+	if err := makeFIFO(fifo); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrShellSessionUnsupported, err)
+	}
+	stdin, err := os.OpenFile(fifo, os.O_RDWR, 0)
+	if err != nil {
+		s.mu.Unlock()
+		_ = os.Remove(fifo)
+		return err
+	}
+	run := &shellSessionExec{
+		command: command,
+		marker:  shellSessionMarkerPrefix + nonce,
+		start:   s.base + int64(len(s.buf)),
+		stdin:   stdin,
+		fifo:    fifo,
+	}
+	s.running = run
+	s.mu.Unlock()
+
+	line := fmt.Sprintf("eval %s < %s; printf '\\n%%s:%%s:%%s\\n' '%s' \"$?\" \"$PWD\"\n", shellQuote(command), shellQuote(fifo), run.marker)
+	if _, err := io.WriteString(s.stdin, line); err != nil {
+		s.mu.Lock()
+		run.done = true
+		run.exitCode = -1
+		run.closeStdin()
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// write sends raw input to the running command's stdin and optionally closes
+// it so that programs reading until EOF can finish.
+func (s *shellSession) write(input string, closeStdin bool) error {
+	s.mu.Lock()
+	if s.running == nil || s.running.done || s.exited || s.running.stdin == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: no command is running in session %q", ErrShellSessionIdle, s.name)
+	}
+	run := s.running
+	stdin := run.stdin
+	s.mu.Unlock()
+
+	if input != "" {
+		if _, err := io.WriteString(stdin, input); err != nil {
+			return err
+		}
+	}
+	if closeStdin {
+		s.mu.Lock()
+		run.closeStdin()
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// waitFor blocks until cond holds, the shell exits, wait elapses or ctx is
+// done. cond is evaluated with s.mu held. Unread output is copied to stream,
+// when set, as it arrives; it is still returned by the next snapshot.
+func (s *shellSession) waitFor(ctx context.Context, wait time.Duration, cond func() bool, stream io.Writer) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	streamed := int64(-1)
+	for {
+		s.mu.Lock()
+		var chunk []byte
+		if stream != nil {
+			if streamed < s.cursor {
+				streamed = s.cursor
+			}
+			if streamed < s.base {
+				streamed = s.base
+			}
+			if end := s.base + int64(len(s.buf)) - int64(s.heldBackLocked()); end > streamed {
+				chunk = append(chunk, s.buf[streamed-s.base:end-s.base]...)
+				streamed = end
+			}
+		}
+		done := s.exited || cond()
+		changed := s.changed
+		s.mu.Unlock()
+		if len(chunk) > 0 {
+			_, _ = stream.Write(chunk)
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+func (s *shellSession) hasUnreadLocked() bool {
+	return s.base+int64(len(s.buf))-int64(s.heldBackLocked()) > s.cursor
+}
+
+// heldBackLocked is the length of a trailing, partially received marker that
+// must not be returned to the caller yet.
+func (s *shellSession) heldBackLocked() int {
+	if s.running == nil || s.running.done {
+		return 0
+	}
+	token := "\n" + s.running.marker + ":"
+	// A complete marker line is consumed as soon as it arrives, so a token
+	// still in the buffer is waiting for the rest of its line.
+	if idx := bytes.LastIndex(s.buf, []byte(token)); idx >= 0 {
+		return len(s.buf) - idx
+	}
+	for n := len(token) - 1; n > 0; n-- {
+		if n <= len(s.buf) && strings.HasPrefix(token, string(s.buf[len(s.buf)-n:])) {
+			return n
+		}
+	}
+	return 0
+}
+
+// snapshot returns the output produced since the previous call and advances
+// the read cursor.
+func (s *shellSession) snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+	end := s.base + int64(len(s.buf)) - int64(s.heldBackLocked())
+	start := s.cursor
+	if start < s.base {
+		start = s.base
+	}
+	output := ""
+	if end > start {
+		output = string(s.buf[start-s.base : end-s.base])
+		s.cursor = end
+	}
+	output = truncateOutput(output, shellToolMaxOutputBytes)
+
+	result := map[string]interface{}{
+		"ok":      true,
+		"session": s.name,
+		"output":  output,
+		"running": s.running != nil && !s.running.done && !s.exited,
+		"exited":  s.exited,
+	}
+	status := "idle"
+	switch {
+	case s.exited:
+		status = fmt.Sprintf("shell exited with code %d", s.exitCode)
+		result["ok"] = false
+	case s.running == nil:
+	case !s.running.done:
+		status = "still running"
+	default:
+		status = fmt.Sprintf("exit code %d", s.running.exitCode)
+		result["exit_code"] = s.running.exitCode
+		result["ok"] = s.running.exitCode == 0
+	}
+	header := fmt.Sprintf("[session %s]", s.name)
+	if s.running != nil {
+		header = fmt.Sprintf("[session %s] $ %s", s.name, s.running.command)
+	}
+	if output == "" {
+		output = "(no new output)"
+	}
+	result["text"] = fmt.Sprintf("%s\n%s\n(%s)", header, strings.TrimRight(output, "\n"), status)
+	return result
+}
+
+func (s *shellSession) touch() {
+	s.mu.Lock()
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *shellSession) lastUsedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastUsed
+}
+
+func (s *shellSession) hasExited() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exited
+}
+
+func (s *shellSession) close() {
+	_ = s.stdin.Close()
+	s.cancel()
+	s.mu.Lock()
+	if s.running != nil {
+		s.running.closeStdin()
+	}
+	s.mu.Unlock()
+	_ = os.RemoveAll(s.fifoDir)
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func parseShellSessionWait(input map[string]interface{}, key string, unit time.Duration, def time.Duration) time.Duration {
+	raw, ok := input[key]
+	if !ok || raw == nil {
+		return def
+	}
+	var value float64
+	switch v := raw.(type) {
+	case float64:
+		value = v
+	case int:
+		value = float64(v)
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return def
+		}
+		value = parsed
+	default:
+		return def
+	}
+	if value < 0 {
+		return def
+	}
+	wait := time.Duration(value * float64(unit))
+	if wait > shellSessionMaxWait {
+		wait = shellSessionMaxWait
+	}
+	return wait
+}
+
+func randomHex(n int) string {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(raw)
+}
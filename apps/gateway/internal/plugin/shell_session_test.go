@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestShellSessionTool(t *testing.T) *ShellSessionTool {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell sessions are unix only")
+	}
+	tool := NewShellSessionTool(ShellPolicy{}, 0)
+	t.Cleanup(tool.Close)
+	return tool
+}
+
+func invokeSession(t *testing.T, tool *ShellSessionTool, chatID string, input map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	result, err := tool.InvokeContext(context.Background(), ToolInvocation{ChatID: chatID}, input)
+	if err != nil {
+		t.Fatalf("invoke %v failed: %v", input, err)
+	}
+	return result
+}
+
+func TestShellSessionKeepsStateAcrossCalls(t *testing.T) {
+	tool := newTestShellSessionTool(t)
+	dir := t.TempDir()
+
+	invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "exec", "command": "cd '" + dir + "' && export NEXTAI_SESSION_VALUE=kept"})
+	result := invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "exec", "command": `pwd; printf '%s' "$NEXTAI_SESSION_VALUE"`})
+	output, _ := result["output"].(string)
+	if !strings.Contains(output, dir) || !strings.HasSuffix(output, "kept") || result["exit_code"] != 0 {
+		t.Fatalf("expected cwd and variable to persist, got=%v", result)
+	}
+	if strings.Contains(output, shellSessionMarkerPrefix) {
+		t.Fatalf("completion marker leaked into output: %q", output)
+	}
+
+	failed := invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "exec", "command": "false"})
+	if failed["exit_code"] != 1 || failed["ok"] != false {
+		t.Fatalf("expected exit code 1, got=%v", failed)
+	}
+}
+
+func TestShellSessionReadsLongRunningOutputIncrementally(t *testing.T) {
+	tool := newTestShellSessionTool(t)
+
+	started := invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "exec", "command": "printf early; sleep 0.3; printf late", "timeout_seconds": 0})
+	if started["running"] != true {
+		t.Fatalf("expected command to keep running, got=%v", started)
+	}
+	if _, err := tool.InvokeContext(context.Background(), ToolInvocation{ChatID: "chat-1"}, map[string]interface{}{"action": "exec", "command": "true"}); !errors.Is(err, ErrShellSessionBusy) {
+		t.Fatalf("expected busy session, got=%v", err)
+	}
+
+	collected := started["output"].(string)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		result := invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "read", "wait_ms": 1000})
+		collected += result["output"].(string)
+		if result["running"] == false {
+			if result["exit_code"] != 0 {
+				t.Fatalf("expected exit code 0, got=%v", result)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("command did not finish, collected=%q", collected)
+		}
+	}
+	if collected != "earlylate" {
+		t.Fatalf("expected all output exactly once, got=%q", collected)
+	}
+}
+
+func TestShellSessionWritesStdinToRunningCommand(t *testing.T) {
+	tool := newTestShellSessionTool(t)
+
+	if _, err := tool.InvokeContext(context.Background(), ToolInvocation{ChatID: "chat-1"}, map[string]interface{}{"action": "open"}); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	if _, err := tool.InvokeContext(context.Background(), ToolInvocation{ChatID: "chat-1"}, map[string]interface{}{"action": "write", "input": "id\n"}); !errors.Is(err, ErrShellSessionIdle) {
+		t.Fatalf("expected write to idle shell to be rejected, got=%v", err)
+	}
+	invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "exec", "command": `read line; printf 'got:%s' "$line"`, "timeout_seconds": 0})
+	result := invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "write", "input": "hello\n", "wait_ms": 5000})
+	if result["output"] != "got:hello" || result["running"] != false {
+		t.Fatalf("expected stdin to reach the command, got=%v", result)
+	}
+}
+
+func TestShellSessionsAreKeyedPerChatAndReaped(t *testing.T) {
+	tool := newTestShellSessionTool(t)
+
+	invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "open"})
+	if _, err := tool.InvokeContext(context.Background(), ToolInvocation{ChatID: "chat-2"}, map[string]interface{}{"action": "read"}); !errors.Is(err, ErrShellSessionNotFound) {
+		t.Fatalf("expected other chat to have no session, got=%v", err)
+	}
+
+	tool.idleTimeout = time.Minute
+	tool.reapIdle(time.Now().Add(time.Hour))
+	if _, err := tool.InvokeContext(context.Background(), ToolInvocation{ChatID: "chat-1"}, map[string]interface{}{"action": "read"}); !errors.Is(err, ErrShellSessionNotFound) {
+		t.Fatalf("expected idle session to be reaped, got=%v", err)
+	}
+}
+
+func TestShellSessionInterruptsCommandOnCancel(t *testing.T) {
+	tool := newTestShellSessionTool(t)
+	invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "exec", "command": "true"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if _, err := tool.InvokeContext(ctx, ToolInvocation{ChatID: "chat-1"}, map[string]interface{}{"action": "exec", "command": "sleep 30"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected cancelled exec, got=%v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected cancellation to return promptly, took %v", elapsed)
+	}
+
+	result := invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "exec", "command": "printf alive"})
+	if result["output"] != "alive" || result["exit_code"] != 0 {
+		t.Fatalf("expected the session to survive the interrupt, got=%v", result)
+	}
+}
+
+func TestShellSessionStreamsOutput(t *testing.T) {
+	tool := newTestShellSessionTool(t)
+
+	var mu sync.Mutex
+	var streamed strings.Builder
+	inv := ToolInvocation{ChatID: "chat-1", OnOutput: func(chunk string) {
+		mu.Lock()
+		defer mu.Unlock()
+		streamed.WriteString(chunk)
+	}}
+	result, err := tool.InvokeContext(context.Background(), inv, map[string]interface{}{"action": "exec", "command": "printf one; sleep 0.2; printf two"})
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if streamed.String() != "onetwo" || result["output"] != "onetwo" {
+		t.Fatalf("expected streamed output to match the result, streamed=%q result=%v", streamed.String(), result)
+	}
+}
+
+func TestShellSessionKeepsCwdInsideAllowedRoots(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell sessions are unix only")
+	}
+	root, err := resolveExistingPath(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := NewShellSessionTool(ShellPolicy{AllowedRoots: []string{root}}, 0)
+	t.Cleanup(tool.Close)
+
+	if _, err := tool.InvokeContext(context.Background(), ToolInvocation{ChatID: "chat-1"}, map[string]interface{}{"action": "exec", "command": "cd /"}); !errors.Is(err, ErrShellToolCwdDenied) {
+		t.Fatalf("expected leaving the roots to be denied, got=%v", err)
+	}
+	result := invokeSession(t, tool, "chat-1", map[string]interface{}{"action": "exec", "command": "pwd -P"})
+	if output, _ := result["output"].(string); strings.TrimSpace(output) != root {
+		t.Fatalf("expected the session to be moved back to %s, got=%v", root, result)
+	}
+}
@@ -1,9 +1,14 @@
 package plugin
 
 import (
+	"context"
 	"errors"
 	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestResolveShellExecutorWindowsPrefersPowerShell(t *testing.T) {
@@ -74,6 +79,52 @@ func TestResolveShellExecutorReturnsUnavailableWhenNoneFound(t *testing.T) {
 	}
 }
 
+func TestShellToolInvokeContextKillsChildProcesses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are unix only")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	startedAt := time.Now()
+	_, err := NewShellTool().InvokeContext(ctx, ToolInvocation{}, map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"command": "sleep 30 & sleep 30; wait"}},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got=%v", err)
+	}
+	if elapsed := time.Since(startedAt); elapsed > 5*time.Second {
+		t.Fatalf("expected cancellation to stop the shell promptly, took=%s", elapsed)
+	}
+}
+
+func TestShellToolInvokeContextStreamsOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a posix shell")
+	}
+	var mu sync.Mutex
+	var chunks []string
+	inv := ToolInvocation{OnOutput: func(chunk string) {
+		mu.Lock()
+		defer mu.Unlock()
+		chunks = append(chunks, chunk)
+	}}
+	out, err := NewShellTool().InvokeContext(context.Background(), inv, map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"command": "printf one; sleep 0.2; printf two"}},
+	})
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	if got, _ := out["output"].(string); got != "onetwo" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(chunks) < 2 || strings.Join(chunks, "") != "onetwo" {
+		t.Fatalf("expected output to arrive in several chunks, got=%q", chunks)
+	}
+}
+
 func fakeLookPath(available map[string]bool) func(file string) (string, error) {
 	return func(file string) (string, error) {
 		if available[file] {
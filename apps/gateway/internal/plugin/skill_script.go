@@ -0,0 +1,218 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const skillScriptDefaultInterpreter = "sh"
+
+// skillScriptShells are the interpreters whose scripts checkScript can read
+// as shell commands.
+var skillScriptShells = map[string]struct{}{"sh": {}, "bash": {}, "dash": {}, "ash": {}, "ksh": {}, "zsh": {}}
+
+// SkillScript is a script a skill exposes as a tool. The script body runs from
+// Path when the skill lives on disk, otherwise Content is written to a
+// temporary file for the duration of the call.
+type SkillScript struct {
+	Name        string
+	Skill       string
+	Script      string
+	Description string
+	Parameters  map[string]interface{}
+	Interpreter string
+	Path        string
+	Content     string
+	Dir         string
+	Timeout     time.Duration
+}
+
+// SkillScriptTool runs a skill script under the same policy as the shell
+// tool. The call arguments are written to the script's stdin as JSON.
+type SkillScriptTool struct {
+	script SkillScript
+	policy ShellPolicy
+}
+
+func NewSkillScriptTool(script SkillScript, policy ShellPolicy) *SkillScriptTool {
+	return &SkillScriptTool{script: script, policy: policy}
+}
+
+func (t *SkillScriptTool) Name() string {
+	return t.script.Name
+}
+
+func (t *SkillScriptTool) ToolSpec() ToolSpec {
+	description := strings.TrimSpace(t.script.Description)
+	if description == "" {
+		description = fmt.Sprintf("Run %s from skill %q.", t.script.Script, t.script.Skill)
+	}
+	parameters := t.script.Parameters
+	if parameters == nil {
+		parameters = map[string]interface{}{"type": "object"}
+	}
+	return ToolSpec{Description: description, Parameters: parameters}
+}
+
+func (t *SkillScriptTool) Invoke(input map[string]interface{}) (map[string]interface{}, error) {
+	return t.InvokeContext(context.Background(), ToolInvocation{}, input)
+}
+
+func (t *SkillScriptTool) InvokeContext(parent context.Context, inv ToolInvocation, input map[string]interface{}) (map[string]interface{}, error) {
+	interpreter := strings.Fields(t.script.Interpreter)
+	if len(interpreter) == 0 {
+		interpreter = []string{skillScriptDefaultInterpreter}
+	}
+	path := t.script.Path
+	if path == "" {
+		tmp, err := writeSkillScriptFile(t.script.Script, t.script.Content)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp)
+		path = tmp
+	}
+	command := strings.Join(append(append([]string{}, interpreter...), path), " ")
+	if err := t.policy.checkCommand(command); err != nil {
+		return nil, err
+	}
+	if err := t.checkScript(interpreter[0], path); err != nil {
+		return nil, err
+	}
+	cwd, err := t.policy.resolveCwd("")
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := t.script.Timeout
+	if timeout <= 0 {
+		timeout = shellToolDefaultTimeout
+	}
+	if timeout > shellToolMaxTimeout {
+		timeout = shellToolMaxTimeout
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	args := append(append([]string{}, interpreter[1:]...), path)
+	cmd := exec.CommandContext(ctx, interpreter[0], args...)
+	if err := configureSandbox(cmd, t.policy); err != nil {
+		return nil, err
+	}
+	configureProcessGroup(cmd)
+	cmd.Dir = cwd
+	cmd.Env = t.commandEnv(inv.Env)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	var outputBuf bytes.Buffer
+	var sink io.Writer = &outputBuf
+	if stream := inv.OutputWriter(); stream != nil {
+		sink = io.MultiWriter(&outputBuf, stream)
+	}
+	cmd.Stdout = sink
+	cmd.Stderr = sink
+	err = cmd.Start()
+	if err != nil && t.policy.NoNetwork {
+		return nil, fmt.Errorf("%w: %v", ErrShellToolSandboxUnavailable, err)
+	}
+	if err == nil {
+		err = cmd.Wait()
+	}
+	if parent.Err() != nil {
+		return nil, context.Cause(parent)
+	}
+	output := truncateOutput(outputBuf.String(), shellToolMaxOutputBytes)
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			exitCode = exitErr.ExitCode()
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			exitCode = 124
+		default:
+			exitCode = -1
+		}
+	}
+	return map[string]interface{}{
+		"ok":        err == nil,
+		"skill":     t.script.Skill,
+		"script":    t.script.Script,
+		"exit_code": exitCode,
+		"output":    output,
+		"text":      formatShellText(command, err == nil, exitCode, output),
+	}, nil
+}
+
+// checkScript applies the command patterns to the script body, not just to
+// the command line that runs it, so a script cannot run what the shell tool
+// may not. Only shell scripts can be read that way; other interpreters are
+// refused while patterns are configured.
+func (t *SkillScriptTool) checkScript(interpreter, path string) error {
+	if len(t.policy.AllowCommands) == 0 && len(t.policy.DenyCommands) == 0 {
+		return nil
+	}
+	if _, ok := skillScriptShells[filepath.Base(interpreter)]; !ok {
+		return fmt.Errorf("%w: %s scripts cannot be checked against command patterns", ErrShellToolCommandDenied, interpreter)
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return t.policy.checkCommand(strings.Join(lines, "\n"))
+}
+
+// commandEnv adds the skill name and directory to the environment the shell
+// policy allows.
+func (t *SkillScriptTool) commandEnv(extra map[string]string) []string {
+	env := t.policy.commandEnv(extra)
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append(env, "NEXTAI_SKILL_NAME="+t.script.Skill)
+	if t.script.Dir != "" {
+		env = append(env, "NEXTAI_SKILL_DIR="+t.script.Dir)
+	}
+	return env
+}
+
+func writeSkillScriptFile(script, content string) (string, error) {
+	file, err := os.CreateTemp("", "nextai-skill-*"+filepath.Ext(script))
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSkillScriptToolPassesInputOnStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a posix shell")
+	}
+	tool := NewSkillScriptTool(SkillScript{
+		Name:    "skill.deploy.ship",
+		Skill:   "deploy",
+		Script:  "scripts/ship.sh",
+		Content: "read -r input; echo \"$NEXTAI_SKILL_NAME $input\"",
+	}, ShellPolicy{})
+	out, err := tool.InvokeContext(context.Background(), ToolInvocation{}, map[string]interface{}{"env": "staging"})
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	if out["ok"] != true || strings.TrimSpace(out["output"].(string)) != `deploy {"env":"staging"}` {
+		t.Fatalf("unexpected result: %#v", out)
+	}
+
+	tool = NewSkillScriptTool(SkillScript{Name: "skill.deploy.fail", Skill: "deploy", Script: "scripts/fail.sh", Content: "exit 3"}, ShellPolicy{})
+	out, err = tool.InvokeContext(context.Background(), ToolInvocation{}, nil)
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	if out["ok"] != false || out["exit_code"] != 3 {
+		t.Fatalf("expected failed script result, got=%#v", out)
+	}
+}
+
+func TestSkillScriptToolRunsFileFromSkillDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a posix shell")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scripts", "where.sh")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`printf "%s" "$NEXTAI_SKILL_DIR"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tool := NewSkillScriptTool(SkillScript{Name: "skill.demo.where", Skill: "demo", Script: "scripts/where.sh", Path: path, Dir: dir}, ShellPolicy{})
+	out, err := tool.InvokeContext(context.Background(), ToolInvocation{}, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("invoke failed: %v", err)
+	}
+	if out["output"] != dir {
+		t.Fatalf("expected skill dir in env, got=%#v", out)
+	}
+}
+
+func TestSkillScriptToolFollowsShellPolicy(t *testing.T) {
+	tool := NewSkillScriptTool(SkillScript{
+		Name:        "skill.demo.py",
+		Skill:       "demo",
+		Script:      "scripts/run.py",
+		Content:     "print(1)",
+		Interpreter: "python3 -u",
+	}, ShellPolicy{AllowCommands: []string{"sh *"}})
+	if _, err := tool.InvokeContext(context.Background(), ToolInvocation{}, nil); !errors.Is(err, ErrShellToolCommandDenied) {
+		t.Fatalf("expected interpreter outside the allowlist to be denied, got=%v", err)
+	}
+
+	tool = NewSkillScriptTool(SkillScript{
+		Name:    "skill.demo.clean",
+		Skill:   "demo",
+		Script:  "scripts/clean.sh",
+		Content: "#!/bin/sh\necho cleaning\nrm -rf /tmp/nextai-skill-test\n",
+	}, ShellPolicy{DenyCommands: []string{"rm *"}})
+	if _, err := tool.InvokeContext(context.Background(), ToolInvocation{}, nil); !errors.Is(err, ErrShellToolCommandDenied) {
+		t.Fatalf("expected denied command in the script body to be rejected, got=%v", err)
+	}
+
+	tool = NewSkillScriptTool(SkillScript{
+		Name:        "skill.demo.py",
+		Skill:       "demo",
+		Script:      "scripts/run.py",
+		Content:     "import os; os.system('rm -rf /')",
+		Interpreter: "python3",
+	}, ShellPolicy{DenyCommands: []string{"rm *"}})
+	if _, err := tool.InvokeContext(context.Background(), ToolInvocation{}, nil); !errors.Is(err, ErrShellToolCommandDenied) {
+		t.Fatalf("expected non-shell script to be refused under deny patterns, got=%v", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	tool = NewSkillScriptTool(SkillScript{
+		Name:    "skill.demo.hello",
+		Skill:   "demo",
+		Script:  "scripts/hello.sh",
+		Content: "# greet\nprintf hello\n",
+	}, ShellPolicy{AllowCommands: []string{"sh *", "printf *"}})
+	out, err := tool.InvokeContext(context.Background(), ToolInvocation{}, nil)
+	if err != nil || out["output"] != "hello" {
+		t.Fatalf("expected allowed script to run, got=%#v err=%v", out, err)
+	}
+}
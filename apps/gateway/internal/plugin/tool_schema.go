@@ -0,0 +1,242 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrToolInputInvalid = errors.New("tool_input_invalid")
+
+// ValidateToolInput checks input against a JSON Schema. Only the keywords the
+// tool schemas use are supported: type, properties, required,
+// additionalProperties, items, minItems, maxItems, enum, minimum, maximum,
+// minLength and maxLength. Unknown keywords are ignored. The returned error
+// wraps ErrToolInputInvalid and names the first offending field.
+func ValidateToolInput(schema map[string]interface{}, input map[string]interface{}) error {
+	if len(schema) == 0 {
+		return nil
+	}
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	if msg := validateSchemaValue(schema, input, ""); msg != "" {
+		return fmt.Errorf("%w: %s", ErrToolInputInvalid, msg)
+	}
+	return nil
+}
+
+func validateSchemaValue(schema map[string]interface{}, value interface{}, path string) string {
+	if types := schemaStrings(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, typ := range types {
+			if schemaTypeMatches(typ, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Sprintf("%s must be %s, got %s", schemaPathName(path), strings.Join(types, " or "), schemaTypeName(value))
+		}
+	}
+	if enum, ok := schema["enum"]; ok {
+		if !schemaEnumContains(enum, value) {
+			return fmt.Sprintf("%s must be one of %s", schemaPathName(path), strings.Join(schemaStrings(enum), ", "))
+		}
+	}
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return validateSchemaObject(schema, typed, path)
+	case []interface{}:
+		if limit, ok := schemaNumber(schema["minItems"]); ok && float64(len(typed)) < limit {
+			return fmt.Sprintf("%s must have at least %v item(s)", schemaPathName(path), limit)
+		}
+		if limit, ok := schemaNumber(schema["maxItems"]); ok && float64(len(typed)) > limit {
+			return fmt.Sprintf("%s must have at most %v item(s)", schemaPathName(path), limit)
+		}
+		if itemSchema, ok := schema["items"].(map[string]interface{}); ok {
+			for idx, item := range typed {
+				if msg := validateSchemaValue(itemSchema, item, path+"["+strconv.Itoa(idx)+"]"); msg != "" {
+					return msg
+				}
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(typed))
+		if limit, ok := schemaNumber(schema["minLength"]); ok && length < limit {
+			return fmt.Sprintf("%s must be at least %v character(s)", schemaPathName(path), limit)
+		}
+		if limit, ok := schemaNumber(schema["maxLength"]); ok && length > limit {
+			return fmt.Sprintf("%s must be at most %v character(s)", schemaPathName(path), limit)
+		}
+	case float64, int, int64:
+		number, _ := schemaNumber(typed)
+		if limit, ok := schemaNumber(schema["minimum"]); ok && number < limit {
+			return fmt.Sprintf("%s must be >= %v", schemaPathName(path), limit)
+		}
+		if limit, ok := schemaNumber(schema["maximum"]); ok && number > limit {
+			return fmt.Sprintf("%s must be <= %v", schemaPathName(path), limit)
+		}
+	}
+	return ""
+}
+
+func validateSchemaObject(schema map[string]interface{}, value map[string]interface{}, path string) string {
+	for _, name := range schemaStrings(schema["required"]) {
+		if _, ok := value[name]; !ok {
+			return fmt.Sprintf("%s is required", schemaPathName(joinSchemaPath(path, name)))
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if propSchema, ok := properties[key].(map[string]interface{}); ok {
+			if msg := validateSchemaValue(propSchema, value[key], joinSchemaPath(path, key)); msg != "" {
+				return msg
+			}
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return fmt.Sprintf("%s is not allowed", schemaPathName(joinSchemaPath(path, key)))
+			}
+		case map[string]interface{}:
+			if msg := validateSchemaValue(extra, value[key], joinSchemaPath(path, key)); msg != "" {
+				return msg
+			}
+		}
+	}
+	return ""
+}
+
+func schemaTypeMatches(typ string, value interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	case "number":
+		_, ok := schemaNumber(value)
+		return ok
+	case "integer":
+		number, ok := schemaNumber(value)
+		return ok && number == math.Trunc(number)
+	default:
+		return true
+	}
+}
+
+func schemaTypeName(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		if number, ok := schemaNumber(typed); ok {
+			if number == math.Trunc(number) {
+				return "integer"
+			}
+			return "number"
+		}
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func schemaEnumContains(enum interface{}, value interface{}) bool {
+	var options []interface{}
+	switch typed := enum.(type) {
+	case []interface{}:
+		options = typed
+	case []string:
+		for _, item := range typed {
+			options = append(options, item)
+		}
+	default:
+		return true
+	}
+	for _, option := range options {
+		// Options and values may be objects or arrays, which == would panic on.
+		if reflect.DeepEqual(option, value) {
+			return true
+		}
+		left, leftOK := schemaNumber(option)
+		right, rightOK := schemaNumber(value)
+		if leftOK && rightOK && left == right {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaStrings(raw interface{}) []string {
+	switch typed := raw.(type) {
+	case string:
+		return []string{typed}
+	case []string:
+		return typed
+	case []interface{}:
+		out := make([]string, 0, len(typed))
+		for _, item := range typed {
+			out = append(out, fmt.Sprint(item))
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+func schemaNumber(raw interface{}) (float64, bool) {
+	switch typed := raw.(type) {
+	case float64:
+		return typed, true
+	case float32:
+		return float64(typed), true
+	case int:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	default:
+		return 0, false
+	}
+}
+
+func joinSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func schemaPathName(path string) string {
+	if path == "" {
+		return "input"
+	}
+	return path
+}
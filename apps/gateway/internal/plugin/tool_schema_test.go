@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateToolInput(t *testing.T) {
+	schema := NewEditFileLinesTool("").ToolSpec().Parameters
+	valid := map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"path": "/tmp/a", "start": float64(1), "end": float64(2), "content": "x"},
+	}}
+	if err := ValidateToolInput(schema, valid); err != nil {
+		t.Fatalf("expected valid input, got=%v", err)
+	}
+
+	cases := []struct {
+		input map[string]interface{}
+		want  string
+	}{
+		{map[string]interface{}{}, "items is required"},
+		{map[string]interface{}{"items": []interface{}{}}, "items must have at least 1 item(s)"},
+		{map[string]interface{}{"items": []interface{}{map[string]interface{}{"path": "/a", "start": "1", "end": float64(1), "content": ""}}}, "items[0].start must be integer, got string"},
+		{map[string]interface{}{"items": []interface{}{map[string]interface{}{"path": "/a", "start": 1.5, "end": float64(2), "content": ""}}}, "items[0].start must be integer, got number"},
+		{map[string]interface{}{"items": []interface{}{map[string]interface{}{"path": "/a", "start": float64(1), "end": float64(1)}}}, "items[0].content is required"},
+		{map[string]interface{}{"items": []interface{}{map[string]interface{}{"path": "/a", "start": float64(1), "end": float64(1), "content": "", "mode": "x"}}}, "items[0].mode is not allowed"},
+	}
+	for _, tc := range cases {
+		err := ValidateToolInput(schema, tc.input)
+		if !errors.Is(err, ErrToolInputInvalid) || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("expected %q, got=%v", tc.want, err)
+		}
+	}
+
+	session := NewShellSessionTool(ShellPolicy{}, 0)
+	defer session.Close()
+	if err := ValidateToolInput(session.ToolSpec().Parameters, map[string]interface{}{"action": "jump"}); err == nil || !strings.Contains(err.Error(), "action must be one of open, exec, read, write, close") {
+		t.Fatalf("expected enum error, got=%v", err)
+	}
+
+	enumSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"target": map[string]interface{}{"enum": []interface{}{"all", map[string]interface{}{"id": float64(1)}, []interface{}{"a"}}},
+		},
+	}
+	for _, target := range []interface{}{map[string]interface{}{"id": float64(1)}, []interface{}{"a"}} {
+		if err := ValidateToolInput(enumSchema, map[string]interface{}{"target": target}); err != nil {
+			t.Fatalf("expected %v to match the enum, got=%v", target, err)
+		}
+	}
+	if err := ValidateToolInput(enumSchema, map[string]interface{}{"target": map[string]interface{}{"id": float64(2)}}); !errors.Is(err, ErrToolInputInvalid) {
+		t.Fatalf("expected object outside the enum to be rejected, got=%v", err)
+	}
+}
+
+func TestValidateToolInputAcceptsLegacyBrowserAndSearchShapes(t *testing.T) {
+	browser := map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"query": "open example.com", "timeout_seconds": "30"},
+	}}
+	if err := ValidateToolInput((&BrowserTool{}).ToolSpec().Parameters, browser); err != nil {
+		t.Fatalf("expected legacy browser input to be valid, got=%v", err)
+	}
+	search := map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"q": "nextai", "count": "3", "timeout_seconds": "5"},
+	}}
+	if err := ValidateToolInput((&SearchTool{}).ToolSpec().Parameters, search); err != nil {
+		t.Fatalf("expected legacy search input to be valid, got=%v", err)
+	}
+}
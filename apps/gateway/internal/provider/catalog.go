@@ -10,6 +10,9 @@ import (
 const (
 	AdapterDemo             = "demo"
 	AdapterOpenAICompatible = "openai-compatible"
+	AdapterAnthropic        = "anthropic"
+	AdapterGemini           = "gemini"
+	AdapterOllama           = "ollama"
 )
 
 type ModelSpec struct {
@@ -74,6 +77,89 @@ var builtinProviders = map[string]ProviderSpec{
 			},
 		},
 	},
+	"anthropic": {
+		ID:                 "anthropic",
+		Name:               "ANTHROPIC",
+		APIKeyPrefix:       "ANTHROPIC_API_KEY",
+		AllowCustomBaseURL: true,
+		DefaultBaseURL:     "https://api.anthropic.com/v1",
+		Adapter:            AdapterAnthropic,
+		Models: []ModelSpec{
+			{
+				ID:     "claude-3-5-haiku-latest",
+				Name:   "Claude 3.5 Haiku",
+				Status: "active",
+				Capabilities: domain.ModelCapabilities{
+					Temperature: true,
+					Attachment:  true,
+					ToolCall:    true,
+					Input:       &domain.ModelModalities{Text: true, Image: true},
+					Output:      &domain.ModelModalities{Text: true},
+				},
+				Limit: domain.ModelLimit{Context: 200000, Output: 8192},
+			},
+			{
+				ID:     "claude-sonnet-4-0",
+				Name:   "Claude Sonnet 4",
+				Status: "active",
+				Capabilities: domain.ModelCapabilities{
+					Temperature: true,
+					Reasoning:   true,
+					Attachment:  true,
+					ToolCall:    true,
+					Input:       &domain.ModelModalities{Text: true, Image: true},
+					Output:      &domain.ModelModalities{Text: true},
+				},
+				Limit: domain.ModelLimit{Context: 200000, Output: 64000},
+			},
+		},
+	},
+	"gemini": {
+		ID:                 "gemini",
+		Name:               "GEMINI",
+		APIKeyPrefix:       "GEMINI_API_KEY",
+		AllowCustomBaseURL: true,
+		DefaultBaseURL:     "https://generativelanguage.googleapis.com/v1beta",
+		Adapter:            AdapterGemini,
+		Models: []ModelSpec{
+			{
+				ID:     "gemini-2.0-flash",
+				Name:   "Gemini 2.0 Flash",
+				Status: "active",
+				Capabilities: domain.ModelCapabilities{
+					Temperature: true,
+					Attachment:  true,
+					ToolCall:    true,
+					Input:       &domain.ModelModalities{Text: true, Image: true, Audio: true},
+					Output:      &domain.ModelModalities{Text: true},
+				},
+				Limit: domain.ModelLimit{Context: 1048576, Output: 8192},
+			},
+			{
+				ID:     "gemini-2.5-flash",
+				Name:   "Gemini 2.5 Flash",
+				Status: "active",
+				Capabilities: domain.ModelCapabilities{
+					Temperature: true,
+					Reasoning:   true,
+					Attachment:  true,
+					ToolCall:    true,
+					Input:       &domain.ModelModalities{Text: true, Image: true, Audio: true},
+					Output:      &domain.ModelModalities{Text: true},
+				},
+				Limit: domain.ModelLimit{Context: 1048576, Output: 65536},
+			},
+		},
+	},
+	"ollama": {
+		ID:                 "ollama",
+		Name:               "OLLAMA",
+		APIKeyPrefix:       "OLLAMA_API_KEY",
+		AllowCustomBaseURL: true,
+		DefaultBaseURL:     "http://127.0.0.1:11434",
+		Adapter:            AdapterOllama,
+		Models:             []ModelSpec{},
+	},
 }
 
 var providerTypes = []ProviderTypeSpec{
@@ -85,6 +171,18 @@ var providerTypes = []ProviderTypeSpec{
 		ID:          AdapterOpenAICompatible,
 		DisplayName: "openai Compatible",
 	},
+	{
+		ID:          AdapterAnthropic,
+		DisplayName: "anthropic",
+	},
+	{
+		ID:          AdapterGemini,
+		DisplayName: "gemini",
+	},
+	{
+		ID:          AdapterOllama,
+		DisplayName: "ollama",
+	},
 }
 
 func ListBuiltinProviderIDs() []string {
@@ -134,8 +232,12 @@ func ResolveAdapter(providerID string) string {
 }
 
 func ResolveModels(providerID string, aliases map[string]string) []domain.ModelInfo {
+	return ResolveModelsWithDiscovered(providerID, aliases, nil)
+}
+
+func ResolveModelsWithDiscovered(providerID string, aliases map[string]string, discovered []string) []domain.ModelInfo {
 	spec := ResolveProvider(providerID)
-	out := make([]domain.ModelInfo, 0, len(spec.Models)+len(aliases))
+	out := make([]domain.ModelInfo, 0, len(spec.Models)+len(discovered)+len(aliases))
 	seen := map[string]struct{}{}
 	modelByID := map[string]domain.ModelInfo{}
 
@@ -152,6 +254,24 @@ func ResolveModels(providerID string, aliases map[string]string) []domain.ModelI
 		seen[model.ID] = struct{}{}
 	}
 
+	for _, modelID := range discovered {
+		modelID = strings.TrimSpace(modelID)
+		if modelID == "" {
+			continue
+		}
+		if _, exists := seen[modelID]; exists {
+			continue
+		}
+		item := domain.ModelInfo{
+			ID:     modelID,
+			Name:   modelID,
+			Status: "discovered",
+		}
+		out = append(out, item)
+		modelByID[modelID] = item
+		seen[modelID] = struct{}{}
+	}
+
 	keys := sortedAliasKeys(aliases)
 	for _, alias := range keys {
 		target := strings.TrimSpace(aliases[alias])
@@ -228,6 +348,16 @@ func ResolveModelID(providerID, requestedModelID string, aliases map[string]stri
 	return "", false
 }
 
+func ResolveModelLimit(providerID, modelID string) (domain.ModelLimit, bool) {
+	modelID = strings.TrimSpace(modelID)
+	for _, model := range ResolveProvider(providerID).Models {
+		if model.ID == modelID {
+			return model.Limit, model.Limit.Context > 0
+		}
+	}
+	return domain.ModelLimit{}, false
+}
+
 func DefaultModelID(providerID string) string {
 	spec := ResolveProvider(providerID)
 	if len(spec.Models) == 0 {
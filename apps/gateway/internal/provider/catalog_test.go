@@ -50,3 +50,52 @@ func TestListProviderTypes(t *testing.T) {
 		t.Fatalf("unexpected second provider type: %+v", types[1])
 	}
 }
+
+func TestResolveProviderAnthropicUsesAnthropicAdapter(t *testing.T) {
+	if got := ResolveAdapter("anthropic"); got != AdapterAnthropic {
+		t.Fatalf("expected anthropic adapter, got=%q", got)
+	}
+	if got := DefaultModelID("anthropic"); got == "" {
+		t.Fatalf("expected anthropic default model")
+	}
+	found := false
+	for _, item := range ListProviderTypes() {
+		if item.ID == AdapterAnthropic {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected anthropic provider type to be listed")
+	}
+}
+
+func TestResolveProviderGeminiUsesGeminiAdapter(t *testing.T) {
+	if got := ResolveAdapter("gemini"); got != AdapterGemini {
+		t.Fatalf("expected gemini adapter, got=%q", got)
+	}
+	if got := DefaultModelID("gemini"); got == "" {
+		t.Fatalf("expected gemini default model")
+	}
+}
+
+func TestResolveModelsWithDiscoveredListsRuntimeModels(t *testing.T) {
+	models := ResolveModelsWithDiscovered("ollama", map[string]string{
+		"fast": "llama3.2:latest",
+	}, []string{"llama3.2:latest", "qwen2.5:7b", ""})
+	if len(models) != 3 {
+		t.Fatalf("expected 3 models, got=%d (%+v)", len(models), models)
+	}
+	byID := map[string]string{}
+	for _, model := range models {
+		byID[model.ID] = model.Status
+		if model.ID == "fast" && model.AliasOf != "llama3.2:latest" {
+			t.Fatalf("expected alias_of for fast model, got=%q", model.AliasOf)
+		}
+	}
+	if byID["llama3.2:latest"] != "discovered" || byID["qwen2.5:7b"] != "discovered" {
+		t.Fatalf("expected discovered models, got=%v", byID)
+	}
+	if got, ok := ResolveModelID("ollama", "qwen2.5:7b", nil); !ok || got != "qwen2.5:7b" {
+		t.Fatalf("expected discovered model to resolve, got=%q ok=%v", got, ok)
+	}
+}
@@ -13,25 +13,30 @@ import (
 )
 
 type ProviderSetting struct {
-	APIKey       string            `json:"api_key"`
-	BaseURL      string            `json:"base_url"`
-	DisplayName  string            `json:"display_name,omitempty"`
-	Enabled      *bool             `json:"enabled,omitempty"`
-	Headers      map[string]string `json:"headers,omitempty"`
-	TimeoutMS    int               `json:"timeout_ms,omitempty"`
-	ModelAliases map[string]string `json:"model_aliases,omitempty"`
+	APIKey           string            `json:"api_key"`
+	BaseURL          string            `json:"base_url"`
+	DisplayName      string            `json:"display_name,omitempty"`
+	Enabled          *bool             `json:"enabled,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	TimeoutMS        int               `json:"timeout_ms,omitempty"`
+	ModelAliases     map[string]string `json:"model_aliases,omitempty"`
+	DiscoveredModels []string          `json:"discovered_models,omitempty"`
 }
 
 type State struct {
-	Chats      map[string]domain.ChatSpec         `json:"chats"`
-	Histories  map[string][]domain.RuntimeMessage `json:"histories"`
-	CronJobs   map[string]domain.CronJobSpec      `json:"cron_jobs"`
-	CronStates map[string]domain.CronJobState     `json:"cron_states"`
-	Providers  map[string]ProviderSetting         `json:"providers"`
-	ActiveLLM  domain.ModelSlotConfig             `json:"active_llm"`
-	Envs       map[string]string                  `json:"envs"`
-	Skills     map[string]domain.SkillSpec        `json:"skills"`
-	Channels   domain.ChannelConfigMap            `json:"channels"`
+	Chats        map[string]domain.ChatSpec         `json:"chats"`
+	Histories    map[string][]domain.RuntimeMessage `json:"histories"`
+	CronJobs     map[string]domain.CronJobSpec      `json:"cron_jobs"`
+	CronStates   map[string]domain.CronJobState     `json:"cron_states"`
+	Providers    map[string]ProviderSetting         `json:"providers"`
+	ActiveLLM    domain.ModelSlotConfig             `json:"active_llm"`
+	FallbackLLMs []domain.ModelSlotConfig           `json:"fallback_llms,omitempty"`
+	Envs         map[string]string                  `json:"envs"`
+	Skills       map[string]domain.SkillSpec        `json:"skills"`
+	Channels     domain.ChannelConfigMap            `json:"channels"`
+	Usage        map[string]domain.UsageRecord      `json:"usage,omitempty"`
+	Tools        map[string]domain.ToolConfig       `json:"tools,omitempty"`
+	MCPServers   map[string]domain.MCPServerConfig  `json:"mcp_servers,omitempty"`
 }
 
 type Store struct {
@@ -63,9 +68,12 @@ func defaultState(dataDir string) State {
 		Providers: map[string]ProviderSetting{
 			"openai": defaultProviderSetting(),
 		},
-		ActiveLLM: domain.ModelSlotConfig{},
-		Envs:      map[string]string{},
-		Skills:    map[string]domain.SkillSpec{},
+		ActiveLLM:  domain.ModelSlotConfig{},
+		Envs:       map[string]string{},
+		Skills:     map[string]domain.SkillSpec{},
+		Usage:      map[string]domain.UsageRecord{},
+		Tools:      map[string]domain.ToolConfig{},
+		MCPServers: map[string]domain.MCPServerConfig{},
 		Channels: domain.ChannelConfigMap{
 			"console": {
 				"enabled":    true,
@@ -151,6 +159,19 @@ func (s *Store) load() error {
 			Model:      activeModelID,
 		}
 	}
+	fallbackLLMs := make([]domain.ModelSlotConfig, 0, len(state.FallbackLLMs))
+	for _, slot := range state.FallbackLLMs {
+		providerID := normalizeProviderID(slot.ProviderID)
+		modelID := strings.TrimSpace(slot.Model)
+		if providerID == "" || modelID == "" {
+			continue
+		}
+		if _, ok := normalizedProviders[providerID]; !ok {
+			continue
+		}
+		fallbackLLMs = append(fallbackLLMs, domain.ModelSlotConfig{ProviderID: providerID, Model: modelID})
+	}
+	state.FallbackLLMs = fallbackLLMs
 	if state.Envs == nil {
 		state.Envs = map[string]string{}
 	}
@@ -160,6 +181,15 @@ func (s *Store) load() error {
 	if state.Channels == nil {
 		state.Channels = domain.ChannelConfigMap{}
 	}
+	if state.Usage == nil {
+		state.Usage = map[string]domain.UsageRecord{}
+	}
+	if state.Tools == nil {
+		state.Tools = map[string]domain.ToolConfig{}
+	}
+	if state.MCPServers == nil {
+		state.MCPServers = map[string]domain.MCPServerConfig{}
+	}
 	if _, ok := state.Channels["console"]; !ok {
 		state.Channels["console"] = map[string]interface{}{
 			"enabled":    true,
@@ -0,0 +1,501 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/provider"
+)
+
+const (
+	ProviderAnthropic = "anthropic"
+
+	defaultAnthropicBaseURL   = "https://api.anthropic.com/v1"
+	defaultAnthropicVersion   = "2023-06-01"
+	defaultAnthropicMaxTokens = 4096
+)
+
+type anthropicAdapter struct{}
+
+func (a *anthropicAdapter) ID() string {
+	return provider.AdapterAnthropic
+}
+
+func (a *anthropicAdapter) GenerateTurn(ctx context.Context, req domain.AgentProcessRequest, cfg GenerateConfig, tools []ToolDefinition, runner *Runner) (TurnResult, error) {
+	return runner.generateAnthropicTurn(ctx, req, cfg, tools)
+}
+
+func (a *anthropicAdapter) GenerateTurnStream(
+	ctx context.Context,
+	req domain.AgentProcessRequest,
+	cfg GenerateConfig,
+	tools []ToolDefinition,
+	runner *Runner,
+	onDelta func(string),
+) (TurnResult, error) {
+	return runner.generateAnthropicTurnStream(ctx, req, cfg, tools, onDelta)
+}
+
+type anthropicMessagesRequest struct {
+	Model     string                    `json:"model"`
+	MaxTokens int                       `json:"max_tokens"`
+	System    string                    `json:"system,omitempty"`
+	Messages  []anthropicMessage        `json:"messages"`
+	Tools     []anthropicToolDefinition `json:"tools,omitempty"`
+	Stream    bool                      `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicToolDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type anthropicMessagesResponse struct {
+	Content []struct {
+		Type  string          `json:"type"`
+		Text  string          `json:"text,omitempty"`
+		ID    string          `json:"id,omitempty"`
+		Name  string          `json:"name,omitempty"`
+		Input json.RawMessage `json:"input,omitempty"`
+	} `json:"content"`
+	StopReason string          `json:"stop_reason"`
+	Usage      *anthropicUsage `json:"usage,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage *anthropicUsage `json:"usage,omitempty"`
+	} `json:"message"`
+	Usage        *anthropicUsage `json:"usage,omitempty"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id,omitempty"`
+		Name string `json:"name,omitempty"`
+	} `json:"content_block"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text,omitempty"`
+		PartialJSON string `json:"partial_json,omitempty"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (r *Runner) generateAnthropicTurn(ctx context.Context, req domain.AgentProcessRequest, cfg GenerateConfig, tools []ToolDefinition) (TurnResult, error) {
+	payload, ok, err := buildAnthropicPayload(req, cfg, tools, false)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if !ok {
+		return TurnResult{Text: generateDemoReply(req)}, nil
+	}
+
+	requestCtx := ctx
+	cancel := func() {}
+	if cfg.TimeoutMS > 0 {
+		requestCtx, cancel = context.WithTimeout(ctx, time.Duration(cfg.TimeoutMS)*time.Millisecond)
+	}
+	defer cancel()
+
+	resp, err := r.doAnthropicRequest(requestCtx, cfg, payload)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+	if err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to read provider response",
+			Err:     err,
+		}
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return TurnResult{}, &RunnerError{
+			Code:       ErrorCodeProviderRequestFailed,
+			Message:    fmt.Sprintf("provider returned status %d", resp.StatusCode),
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	var completion anthropicMessagesResponse
+	if err := json.Unmarshal(respBody, &completion); err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider response is not valid json",
+			Err:     err,
+		}
+	}
+
+	textParts := make([]string, 0, len(completion.Content))
+	rawCalls := make([]openAIToolCall, 0)
+	for _, block := range completion.Content {
+		switch block.Type {
+		case "text":
+			if text := strings.TrimSpace(block.Text); text != "" {
+				textParts = append(textParts, text)
+			}
+		case "tool_use":
+			arguments := strings.TrimSpace(string(block.Input))
+			if arguments == "" || arguments == "null" {
+				arguments = "{}"
+			}
+			rawCalls = append(rawCalls, openAIToolCall{
+				ID:       strings.TrimSpace(block.ID),
+				Type:     "function",
+				Function: openAIFunctionCall{Name: strings.TrimSpace(block.Name), Arguments: arguments},
+			})
+		}
+	}
+
+	toolCalls, err := parseOpenAIToolCalls(rawCalls)
+	if err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: err.Error(),
+			Err:     err,
+		}
+	}
+	text := strings.Join(textParts, "\n")
+	if text == "" && len(toolCalls) == 0 {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider response has empty content",
+		}
+	}
+	turn := TurnResult{Text: text, ToolCalls: toolCalls}
+	if completion.Usage != nil {
+		turn.Usage = newTokenUsage(completion.Usage.InputTokens, completion.Usage.OutputTokens, 0)
+	}
+	return turn, nil
+}
+
+func (r *Runner) generateAnthropicTurnStream(
+	ctx context.Context,
+	req domain.AgentProcessRequest,
+	cfg GenerateConfig,
+	tools []ToolDefinition,
+	onDelta func(string),
+) (TurnResult, error) {
+	payload, ok, err := buildAnthropicPayload(req, cfg, tools, true)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if !ok {
+		return TurnResult{Text: generateDemoReply(req)}, nil
+	}
+
+	requestCtx := ctx
+	cancel := func() {}
+	if cfg.TimeoutMS > 0 {
+		requestCtx, cancel = context.WithTimeout(ctx, time.Duration(cfg.TimeoutMS)*time.Millisecond)
+	}
+	defer cancel()
+
+	resp, err := r.doAnthropicRequest(requestCtx, cfg, payload)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+		return TurnResult{}, &RunnerError{
+			Code:       ErrorCodeProviderRequestFailed,
+			Message:    fmt.Sprintf("provider returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody))),
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	var replyBuilder strings.Builder
+	toolCalls := map[int]*openAIToolCall{}
+	var streamErr *RunnerError
+	promptTokens, completionTokens := 0, 0
+	processData := func(data string) error {
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("provider stream chunk is not valid json: %w", err)
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message.Usage != nil {
+				promptTokens = event.Message.Usage.InputTokens
+				completionTokens = event.Message.Usage.OutputTokens
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				completionTokens = event.Usage.OutputTokens
+			}
+		case "content_block_start":
+			if event.ContentBlock.Type != "tool_use" {
+				return nil
+			}
+			toolCalls[event.Index] = &openAIToolCall{
+				ID:       strings.TrimSpace(event.ContentBlock.ID),
+				Type:     "function",
+				Function: openAIFunctionCall{Name: strings.TrimSpace(event.ContentBlock.Name)},
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				if event.Delta.Text == "" {
+					return nil
+				}
+				replyBuilder.WriteString(event.Delta.Text)
+				if onDelta != nil {
+					onDelta(event.Delta.Text)
+				}
+			case "input_json_delta":
+				current, ok := toolCalls[event.Index]
+				if !ok {
+					return fmt.Errorf("provider stream input delta for unknown block %d", event.Index)
+				}
+				current.Function.Arguments += event.Delta.PartialJSON
+			}
+		case "error":
+			message := strings.TrimSpace(event.Error.Message)
+			if message == "" {
+				message = strings.TrimSpace(event.Error.Type)
+			}
+			streamErr = &RunnerError{
+				Code:    ErrorCodeProviderRequestFailed,
+				Message: fmt.Sprintf("provider stream error: %s", message),
+			}
+		}
+		return nil
+	}
+
+	if err := consumeSSEData(resp.Body, processData); err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider stream response is invalid",
+			Err:     err,
+		}
+	}
+	if streamErr != nil {
+		return TurnResult{}, streamErr
+	}
+
+	orderedIndexes := make([]int, 0, len(toolCalls))
+	for idx := range toolCalls {
+		orderedIndexes = append(orderedIndexes, idx)
+	}
+	sort.Ints(orderedIndexes)
+	aggregatedToolCalls := make([]openAIToolCall, 0, len(orderedIndexes))
+	for _, idx := range orderedIndexes {
+		aggregatedToolCalls = append(aggregatedToolCalls, *toolCalls[idx])
+	}
+
+	parsedToolCalls, err := parseOpenAIToolCalls(aggregatedToolCalls)
+	if err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: err.Error(),
+			Err:     err,
+		}
+	}
+
+	reply := replyBuilder.String()
+	if strings.TrimSpace(reply) == "" && len(parsedToolCalls) == 0 {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider response has empty content",
+		}
+	}
+	return TurnResult{Text: reply, ToolCalls: parsedToolCalls, Usage: newTokenUsage(promptTokens, completionTokens, 0)}, nil
+}
+
+func buildAnthropicPayload(req domain.AgentProcessRequest, cfg GenerateConfig, tools []ToolDefinition, stream bool) (anthropicMessagesRequest, bool, error) {
+	if strings.TrimSpace(cfg.APIKey) == "" {
+		return anthropicMessagesRequest{}, false, &RunnerError{Code: ErrorCodeProviderNotConfigured, Message: "provider api_key is required"}
+	}
+	system, messages := toAnthropicMessages(req.Input)
+	if len(messages) == 0 {
+		return anthropicMessagesRequest{}, false, nil
+	}
+	return anthropicMessagesRequest{
+		Model:     cfg.Model,
+		MaxTokens: anthropicMaxTokens(cfg),
+		System:    system,
+		Messages:  messages,
+		Tools:     toAnthropicTools(tools),
+		Stream:    stream,
+	}, true, nil
+}
+
+// anthropicMaxTokens asks for the model's full output limit when the catalog
+// knows it; the Messages API requires max_tokens on every request.
+func anthropicMaxTokens(cfg GenerateConfig) int {
+	if limit, _ := provider.ResolveModelLimit(cfg.ProviderID, cfg.Model); limit.Output > 0 {
+		return limit.Output
+	}
+	return defaultAnthropicMaxTokens
+}
+
+func (r *Runner) doAnthropicRequest(ctx context.Context, cfg GenerateConfig, payload anthropicMessagesRequest) (*http.Response, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultAnthropicBaseURL
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to encode provider request",
+			Err:     err,
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to create provider request",
+			Err:     err,
+		}
+	}
+	httpReq.Header.Set("x-api-key", strings.TrimSpace(cfg.APIKey))
+	httpReq.Header.Set("anthropic-version", defaultAnthropicVersion)
+	httpReq.Header.Set("Content-Type", "application/json")
+	if payload.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+	for key, value := range cfg.Headers {
+		k := strings.TrimSpace(key)
+		v := strings.TrimSpace(value)
+		if k == "" || v == "" {
+			continue
+		}
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := r.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "provider request failed",
+			Err:     err,
+		}
+	}
+	return resp, nil
+}
+
+func toAnthropicMessages(input []domain.AgentInputMessage) (string, []anthropicMessage) {
+	systemParts := make([]string, 0, 1)
+	out := make([]anthropicMessage, 0, len(input))
+	appendBlocks := func(role string, blocks []anthropicContentBlock) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(out); n > 0 && out[n-1].Role == role {
+			out[n-1].Content = append(out[n-1].Content, blocks...)
+			return
+		}
+		out = append(out, anthropicMessage{Role: role, Content: blocks})
+	}
+
+	for _, msg := range input {
+		role := normalizeRole(msg.Role)
+		content := strings.TrimSpace(flattenText(msg.Content))
+
+		switch role {
+		case "system":
+			if content != "" {
+				systemParts = append(systemParts, content)
+			}
+		case "assistant":
+			blocks := make([]anthropicContentBlock, 0, 1)
+			if content != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: content})
+			}
+			for _, call := range parseToolCallsFromMetadata(msg.Metadata) {
+				var arguments map[string]interface{}
+				if err := json.Unmarshal([]byte(call.Function.Arguments), &arguments); err != nil || arguments == nil {
+					arguments = map[string]interface{}{}
+				}
+				input, _ := json.Marshal(arguments)
+				blocks = append(blocks, anthropicContentBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: input,
+				})
+			}
+			appendBlocks("assistant", blocks)
+		case "tool":
+			toolCallID := metadataString(msg.Metadata, "tool_call_id")
+			if toolCallID == "" {
+				if content != "" {
+					appendBlocks("user", []anthropicContentBlock{{Type: "text", Text: content}})
+				}
+				continue
+			}
+			appendBlocks("user", []anthropicContentBlock{{
+				Type:      "tool_result",
+				ToolUseID: toolCallID,
+				Content:   content,
+			}})
+		default:
+			if content == "" {
+				continue
+			}
+			appendBlocks("user", []anthropicContentBlock{{Type: "text", Text: content}})
+		}
+	}
+	return strings.Join(systemParts, "\n\n"), out
+}
+
+func toAnthropicTools(tools []ToolDefinition) []anthropicToolDefinition {
+	if len(tools) == 0 {
+		return nil
+	}
+	out := make([]anthropicToolDefinition, 0, len(tools))
+	for _, item := range tools {
+		name := strings.TrimSpace(item.Name)
+		if name == "" {
+			continue
+		}
+		out = append(out, anthropicToolDefinition{
+			Name:        name,
+			Description: strings.TrimSpace(item.Description),
+			InputSchema: normalizeToolParameters(item.Parameters),
+		})
+	}
+	return out
+}
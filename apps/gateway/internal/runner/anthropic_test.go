@@ -0,0 +1,241 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/provider"
+)
+
+func TestGenerateTurnAnthropicMapsMessagesAndToolUse(t *testing.T) {
+	t.Parallel()
+	var apiKey string
+	var version string
+	var req map[string]interface{}
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/messages" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		apiKey = r.Header.Get("x-api-key")
+		version = r.Header.Get("anthropic-version")
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{
+			"content":[
+				{"type":"text","text":"let me check"},
+				{"type":"tool_use","id":"toolu_1","name":"view","input":{"items":[{"path":"/tmp/a.txt","start":1,"end":2}]}}
+			],
+			"stop_reason":"tool_use"
+		}`))
+	}))
+	defer mock.Close()
+
+	r := NewWithHTTPClient(mock.Client())
+	turn, err := r.GenerateTurn(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{
+			{Role: "system", Type: "message", Content: []domain.RuntimeContent{{Type: "text", Text: "be brief"}}},
+			{Role: "user", Type: "message", Content: []domain.RuntimeContent{{Type: "text", Text: "read file"}}},
+			{
+				Role: "assistant",
+				Type: "message",
+				Metadata: map[string]interface{}{
+					"tool_calls": []interface{}{
+						map[string]interface{}{
+							"id":   "toolu_0",
+							"type": "function",
+							"function": map[string]interface{}{
+								"name":      "shell",
+								"arguments": `{"items":[{"command":"pwd"}]}`,
+							},
+						},
+					},
+				},
+			},
+			{
+				Role:     "tool",
+				Type:     "message",
+				Content:  []domain.RuntimeContent{{Type: "text", Text: "/tmp"}},
+				Metadata: map[string]interface{}{"tool_call_id": "toolu_0", "name": "shell"},
+			},
+		},
+	}, GenerateConfig{
+		ProviderID: ProviderAnthropic,
+		Model:      "claude-3-5-haiku-latest",
+		APIKey:     "sk-ant-test",
+		BaseURL:    mock.URL,
+	}, []ToolDefinition{{Name: "view", Description: "view file", Parameters: map[string]interface{}{"type": "object"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if apiKey != "sk-ant-test" || version != defaultAnthropicVersion {
+		t.Fatalf("unexpected auth headers: key=%q version=%q", apiKey, version)
+	}
+	if req["system"] != "be brief" {
+		t.Fatalf("expected system prompt to be hoisted, got=%v", req["system"])
+	}
+	if req["max_tokens"] != float64(8192) {
+		t.Fatalf("expected max_tokens from the model catalog, got=%v", req["max_tokens"])
+	}
+	tools, _ := req["tools"].([]interface{})
+	if len(tools) != 1 {
+		t.Fatalf("expected one tool definition, got=%v", req["tools"])
+	}
+	if schema, _ := tools[0].(map[string]interface{})["input_schema"].(map[string]interface{}); schema["type"] != "object" {
+		t.Fatalf("unexpected tool schema: %v", tools[0])
+	}
+
+	messages, _ := req["messages"].([]interface{})
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got=%d (%v)", len(messages), messages)
+	}
+	assistant := messages[1].(map[string]interface{})
+	assistantBlocks := assistant["content"].([]interface{})
+	toolUse := assistantBlocks[0].(map[string]interface{})
+	if assistant["role"] != "assistant" || toolUse["type"] != "tool_use" || toolUse["id"] != "toolu_0" || toolUse["name"] != "shell" {
+		t.Fatalf("unexpected assistant message: %v", assistant)
+	}
+	if _, ok := toolUse["input"].(map[string]interface{}); !ok {
+		t.Fatalf("expected tool_use input object, got=%v", toolUse["input"])
+	}
+	result := messages[2].(map[string]interface{})
+	resultBlock := result["content"].([]interface{})[0].(map[string]interface{})
+	if result["role"] != "user" || resultBlock["type"] != "tool_result" || resultBlock["tool_use_id"] != "toolu_0" || resultBlock["content"] != "/tmp" {
+		t.Fatalf("unexpected tool result message: %v", result)
+	}
+
+	if turn.Text != "let me check" {
+		t.Fatalf("unexpected text: %q", turn.Text)
+	}
+	if len(turn.ToolCalls) != 1 || turn.ToolCalls[0].ID != "toolu_1" || turn.ToolCalls[0].Name != "view" {
+		t.Fatalf("unexpected tool calls: %+v", turn.ToolCalls)
+	}
+	items, _ := turn.ToolCalls[0].Arguments["items"].([]interface{})
+	if len(items) != 1 {
+		t.Fatalf("unexpected tool call arguments: %+v", turn.ToolCalls[0].Arguments)
+	}
+}
+
+func TestAnthropicMaxTokensFallsBackForUnknownModels(t *testing.T) {
+	t.Parallel()
+	if got := anthropicMaxTokens(GenerateConfig{ProviderID: ProviderAnthropic, Model: "claude-sonnet-4-0"}); got != 64000 {
+		t.Fatalf("expected catalog output limit, got=%d", got)
+	}
+	if got := anthropicMaxTokens(GenerateConfig{ProviderID: "custom-anthropic", Model: "my-model"}); got != defaultAnthropicMaxTokens {
+		t.Fatalf("expected default max_tokens, got=%d", got)
+	}
+}
+
+func TestGenerateTurnStreamAnthropicEmitsDeltasAndToolUse(t *testing.T) {
+	t.Parallel()
+	var stream bool
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		stream, _ = req["stream"].(bool)
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`event: message_start` + "\n" + `data: {"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[],"usage":{"input_tokens":20,"output_tokens":1}}}`,
+			`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`event: ping` + "\n" + `data: {"type":"ping"}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hel"}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`,
+			`event: content_block_stop` + "\n" + `data: {"type":"content_block_stop","index":0}`,
+			`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_9","name":"shell","input":{}}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"items\":[{\"comm"}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"and\":\"ls\"}]}"}}`,
+			`event: content_block_stop` + "\n" + `data: {"type":"content_block_stop","index":1}`,
+			`event: message_delta` + "\n" + `data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`,
+			`event: message_stop` + "\n" + `data: {"type":"message_stop"}`,
+		}
+		_, _ = w.Write([]byte(strings.Join(events, "\n\n") + "\n\n"))
+	}))
+	defer mock.Close()
+
+	deltas := make([]string, 0, 2)
+	r := NewWithHTTPClient(mock.Client())
+	turn, err := r.GenerateTurnStream(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{{
+			Role:    "user",
+			Type:    "message",
+			Content: []domain.RuntimeContent{{Type: "text", Text: "hi"}},
+		}},
+	}, GenerateConfig{
+		ProviderID: ProviderAnthropic,
+		Model:      "claude-3-5-haiku-latest",
+		APIKey:     "sk-ant-test",
+		BaseURL:    mock.URL,
+		AdapterID:  provider.AdapterAnthropic,
+	}, nil, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !stream {
+		t.Fatalf("expected stream=true in request")
+	}
+	if strings.Join(deltas, "|") != "hel|lo" || turn.Text != "hello" {
+		t.Fatalf("unexpected deltas=%v text=%q", deltas, turn.Text)
+	}
+	if len(turn.ToolCalls) != 1 || turn.ToolCalls[0].ID != "toolu_9" || turn.ToolCalls[0].Name != "shell" {
+		t.Fatalf("unexpected tool calls: %+v", turn.ToolCalls)
+	}
+	items, _ := turn.ToolCalls[0].Arguments["items"].([]interface{})
+	if len(items) != 1 || items[0].(map[string]interface{})["command"] != "ls" {
+		t.Fatalf("unexpected tool call arguments: %+v", turn.ToolCalls[0].Arguments)
+	}
+	if turn.Usage != (domain.TokenUsage{PromptTokens: 20, CompletionTokens: 7, TotalTokens: 27}) {
+		t.Fatalf("unexpected usage: %+v", turn.Usage)
+	}
+}
+
+func TestGenerateTurnStreamAnthropicErrorEvent(t *testing.T) {
+	t.Parallel()
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"))
+	}))
+	defer mock.Close()
+
+	r := NewWithHTTPClient(mock.Client())
+	_, err := r.GenerateTurnStream(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{{
+			Role:    "user",
+			Type:    "message",
+			Content: []domain.RuntimeContent{{Type: "text", Text: "hi"}},
+		}},
+	}, GenerateConfig{
+		ProviderID: ProviderAnthropic,
+		Model:      "claude-3-5-haiku-latest",
+		APIKey:     "sk-ant-test",
+		BaseURL:    mock.URL,
+	}, nil, nil)
+	assertRunnerCode(t, err, ErrorCodeProviderRequestFailed)
+	if !strings.Contains(err.Error(), "Overloaded") {
+		t.Fatalf("expected error message to include provider detail, got=%v", err)
+	}
+}
+
+func TestGenerateTurnAnthropicRequiresAPIKey(t *testing.T) {
+	r := New()
+	_, err := r.GenerateTurn(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{{
+			Role:    "user",
+			Type:    "message",
+			Content: []domain.RuntimeContent{{Type: "text", Text: "hi"}},
+		}},
+	}, GenerateConfig{ProviderID: ProviderAnthropic, Model: "claude-3-5-haiku-latest"}, nil)
+	assertRunnerCode(t, err, ErrorCodeProviderNotConfigured)
+}
@@ -0,0 +1,201 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"nextai/apps/gateway/internal/domain"
+)
+
+const (
+	contextMessageOverheadTokens = 4
+	contextSummarySnippetRunes   = 160
+	contextSummaryShareDivisor   = 10
+)
+
+type ContextWindow struct {
+	ContextTokens int
+	OutputTokens  int
+}
+
+type ContextCompaction struct {
+	DroppedMessages int
+	Summarized      bool
+	TokensBefore    int
+	TokensAfter     int
+	Budget          int
+}
+
+func (c ContextCompaction) Applied() bool {
+	return c.DroppedMessages > 0
+}
+
+// Budget reserves room for the reply, capped at a quarter of the window so
+// that small models still keep most of their context for the prompt.
+func (w ContextWindow) Budget() int {
+	if w.ContextTokens <= 0 {
+		return 0
+	}
+	reserve := w.OutputTokens
+	if reserve <= 0 || reserve > w.ContextTokens/4 {
+		reserve = w.ContextTokens / 4
+	}
+	return w.ContextTokens - reserve
+}
+
+func EstimateTokens(input []domain.AgentInputMessage) int {
+	total := 0
+	for _, msg := range input {
+		total += estimateMessageTokens(msg)
+	}
+	return total
+}
+
+// FitContextWindow drops the oldest conversation messages until the estimated
+// prompt fits the window. Leading system messages and everything from the
+// latest user message onwards are always kept, and an assistant tool-call message is only dropped together
+// with its tool results. Dropped messages are replaced by a short summary.
+func FitContextWindow(input []domain.AgentInputMessage, window ContextWindow) ([]domain.AgentInputMessage, ContextCompaction) {
+	budget := window.Budget()
+	before := EstimateTokens(input)
+	result := ContextCompaction{TokensBefore: before, TokensAfter: before, Budget: budget}
+	if budget <= 0 || before <= budget {
+		return input, result
+	}
+
+	pinned := 0
+	for pinned < len(input) && normalizeRole(input[pinned].Role) == "system" {
+		pinned++
+	}
+	groups := groupContextMessages(input[pinned:])
+	keepFrom := len(groups) - 1
+	for i := len(groups) - 1; i >= 0; i-- {
+		if normalizeRole(groups[i][0].Role) == "user" {
+			keepFrom = i
+			break
+		}
+	}
+	if keepFrom <= 0 {
+		return input, result
+	}
+
+	pinnedTokens := EstimateTokens(input[:pinned])
+	remaining := before - pinnedTokens
+	summaryBudget := budget / contextSummaryShareDivisor
+	dropped := make([]domain.AgentInputMessage, 0)
+	cut := 0
+	for cut < keepFrom {
+		summaryTokens := 0
+		if len(dropped) > 0 {
+			summaryTokens = estimateMessageTokens(buildContextSummary(dropped, summaryBudget))
+		}
+		if pinnedTokens+summaryTokens+remaining <= budget {
+			break
+		}
+		for _, msg := range groups[cut] {
+			remaining -= estimateMessageTokens(msg)
+			dropped = append(dropped, msg)
+		}
+		cut++
+	}
+	if len(dropped) == 0 {
+		return input, result
+	}
+
+	out := make([]domain.AgentInputMessage, 0, len(input)-len(dropped)+1)
+	out = append(out, input[:pinned]...)
+	summary := buildContextSummary(dropped, summaryBudget)
+	if pinnedTokens+estimateMessageTokens(summary)+remaining <= budget {
+		out = append(out, summary)
+		result.Summarized = true
+	}
+	for _, group := range groups[cut:] {
+		out = append(out, group...)
+	}
+	result.DroppedMessages = len(dropped)
+	result.TokensAfter = EstimateTokens(out)
+	return out, result
+}
+
+func groupContextMessages(input []domain.AgentInputMessage) [][]domain.AgentInputMessage {
+	groups := make([][]domain.AgentInputMessage, 0, len(input))
+	openToolCalls := false
+	for _, msg := range input {
+		role := normalizeRole(msg.Role)
+		if role == "tool" && openToolCalls {
+			last := len(groups) - 1
+			groups[last] = append(groups[last], msg)
+			continue
+		}
+		openToolCalls = role == "assistant" && len(parseToolCallsFromMetadata(msg.Metadata)) > 0
+		groups = append(groups, []domain.AgentInputMessage{msg})
+	}
+	return groups
+}
+
+func buildContextSummary(dropped []domain.AgentInputMessage, budget int) domain.AgentInputMessage {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("Earlier conversation was compacted to fit the context window (%d messages omitted). Summary of omitted messages:", len(dropped)))
+	used := estimateTextTokens(builder.String())
+	for _, msg := range dropped {
+		role := normalizeRole(msg.Role)
+		text := strings.Join(strings.Fields(flattenText(msg.Content)), " ")
+		if role == "assistant" && text == "" {
+			names := make([]string, 0, 1)
+			for _, call := range parseToolCallsFromMetadata(msg.Metadata) {
+				names = append(names, call.Function.Name)
+			}
+			if len(names) == 0 {
+				continue
+			}
+			text = "called " + strings.Join(names, ", ")
+		}
+		if text == "" {
+			continue
+		}
+		if utf8.RuneCountInString(text) > contextSummarySnippetRunes {
+			text = string([]rune(text)[:contextSummarySnippetRunes]) + "..."
+		}
+		line := fmt.Sprintf("\n- %s: %s", role, text)
+		cost := estimateTextTokens(line)
+		if used+cost > budget {
+			builder.WriteString("\n- ...")
+			break
+		}
+		builder.WriteString(line)
+		used += cost
+	}
+	return domain.AgentInputMessage{
+		Role:    "system",
+		Type:    "message",
+		Content: []domain.RuntimeContent{{Type: "text", Text: builder.String()}},
+	}
+}
+
+func estimateMessageTokens(msg domain.AgentInputMessage) int {
+	tokens := contextMessageOverheadTokens + estimateTextTokens(flattenText(msg.Content))
+	if calls, ok := msg.Metadata["tool_calls"]; ok {
+		if raw, err := json.Marshal(calls); err == nil {
+			tokens += estimateTextTokens(string(raw))
+		}
+	}
+	return tokens
+}
+
+// estimateTextTokens approximates tokenizer output without a vocabulary:
+// roughly four ASCII characters per token and one token per non-ASCII rune,
+// which keeps CJK text from being underestimated.
+func estimateTextTokens(text string) int {
+	ascii := 0
+	other := 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"nextai/apps/gateway/internal/domain"
+)
+
+func textMessage(role, text string) domain.AgentInputMessage {
+	return domain.AgentInputMessage{Role: role, Type: "message", Content: []domain.RuntimeContent{{Type: "text", Text: text}}}
+}
+
+func TestFitContextWindowKeepsInputWithinBudget(t *testing.T) {
+	input := []domain.AgentInputMessage{textMessage("system", "guide"), textMessage("user", "hello")}
+	out, compaction := FitContextWindow(input, ContextWindow{ContextTokens: 1000, OutputTokens: 100})
+	if compaction.Applied() || len(out) != 2 {
+		t.Fatalf("expected no compaction, got=%+v out=%d", compaction, len(out))
+	}
+	out, compaction = FitContextWindow(input, ContextWindow{})
+	if compaction.Applied() || len(out) != 2 {
+		t.Fatalf("expected unknown window to skip compaction, got=%+v", compaction)
+	}
+}
+
+func TestFitContextWindowDropsOldestAndSummarizes(t *testing.T) {
+	long := strings.Repeat("a", 1200)
+	input := []domain.AgentInputMessage{
+		textMessage("system", "guide"),
+		textMessage("user", "first question "+long),
+		textMessage("assistant", "first answer "+long),
+		textMessage("user", "second question "+long),
+		textMessage("assistant", "second answer "+long),
+		textMessage("user", "latest question"),
+	}
+	out, compaction := FitContextWindow(input, ContextWindow{ContextTokens: 1000, OutputTokens: 250})
+	if !compaction.Applied() || !compaction.Summarized {
+		t.Fatalf("expected summarized compaction, got=%+v", compaction)
+	}
+	if compaction.TokensAfter > compaction.Budget || compaction.TokensAfter >= compaction.TokensBefore {
+		t.Fatalf("unexpected token accounting: %+v", compaction)
+	}
+	if flattenText(out[0].Content) != "guide" {
+		t.Fatalf("expected leading system message to be kept, got=%+v", out[0])
+	}
+	summary := flattenText(out[1].Content)
+	if out[1].Role != "system" || !strings.Contains(summary, "first question") {
+		t.Fatalf("expected summary of dropped messages, got=%q", summary)
+	}
+	if last := out[len(out)-1]; flattenText(last.Content) != "latest question" {
+		t.Fatalf("expected latest user message to be kept, got=%+v", last)
+	}
+}
+
+func TestFitContextWindowKeepsToolCallPairsTogether(t *testing.T) {
+	long := strings.Repeat("b", 600)
+	input := []domain.AgentInputMessage{
+		textMessage("user", "list files"),
+		{
+			Role: "assistant",
+			Type: "message",
+			Metadata: map[string]interface{}{
+				"tool_calls": []interface{}{
+					map[string]interface{}{
+						"id":       "call_1",
+						"type":     "function",
+						"function": map[string]interface{}{"name": "shell", "arguments": `{"items":[{"command":"ls"}]}`},
+					},
+				},
+			},
+		},
+		{
+			Role:     "tool",
+			Type:     "message",
+			Content:  []domain.RuntimeContent{{Type: "text", Text: long}},
+			Metadata: map[string]interface{}{"tool_call_id": "call_1", "name": "shell"},
+		},
+		textMessage("assistant", "done"),
+		textMessage("user", "thanks"),
+	}
+	out, compaction := FitContextWindow(input, ContextWindow{ContextTokens: 200, OutputTokens: 50})
+	if !compaction.Applied() {
+		t.Fatalf("expected compaction, got=%+v", compaction)
+	}
+	for i, msg := range out {
+		if msg.Role != "tool" {
+			continue
+		}
+		if i == 0 || len(parseToolCallsFromMetadata(out[i-1].Metadata)) == 0 {
+			t.Fatalf("tool result kept without its tool call: %+v", out)
+		}
+	}
+	if last := out[len(out)-1]; flattenText(last.Content) != "thanks" {
+		t.Fatalf("expected latest user message to be kept, got=%+v", last)
+	}
+}
+
+func TestEstimateTokensCountsNonASCIIPerRune(t *testing.T) {
+	ascii := estimateTextTokens("abcdefgh")
+	cjk := estimateTextTokens("你好世界")
+	if ascii != 2 || cjk != 4 {
+		t.Fatalf("unexpected estimates ascii=%d cjk=%d", ascii, cjk)
+	}
+}
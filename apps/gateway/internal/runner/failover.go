@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"nextai/apps/gateway/internal/domain"
+)
+
+type FailoverAttempt struct {
+	ProviderID string
+	Model      string
+	Err        error
+}
+
+type FailoverResult struct {
+	SlotIndex  int
+	ProviderID string
+	Model      string
+	Attempts   []FailoverAttempt
+}
+
+// GenerateTurnWithFailover tries each slot in order and moves to the next one only when the
+// previous slot failed with a retryable provider error before streaming any delta.
+// A non-nil onDelta selects the streaming path.
+func (r *Runner) GenerateTurnWithFailover(
+	ctx context.Context,
+	req domain.AgentProcessRequest,
+	slots []GenerateConfig,
+	tools []ToolDefinition,
+	onDelta func(string),
+) (TurnResult, FailoverResult, error) {
+	result := FailoverResult{}
+	if len(slots) == 0 {
+		return TurnResult{}, result, &RunnerError{Code: ErrorCodeProviderNotConfigured, Message: "no provider slot configured"}
+	}
+
+	var lastErr error
+	for idx, cfg := range slots {
+		result.SlotIndex = idx
+		result.ProviderID = cfg.ProviderID
+		result.Model = cfg.Model
+
+		var turn TurnResult
+		var err error
+		if onDelta != nil {
+			emitted := false
+			turn, err = r.GenerateTurnStream(ctx, req, cfg, tools, func(delta string) {
+				if delta != "" {
+					emitted = true
+				}
+				onDelta(delta)
+			})
+			if err != nil && emitted {
+				return TurnResult{}, result, err
+			}
+		} else {
+			turn, err = r.GenerateTurn(ctx, req, cfg, tools)
+		}
+		if err == nil {
+			return turn, result, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil || !IsRetryableProviderError(err) {
+			return TurnResult{}, result, err
+		}
+		result.Attempts = append(result.Attempts, FailoverAttempt{
+			ProviderID: cfg.ProviderID,
+			Model:      cfg.Model,
+			Err:        err,
+		})
+	}
+	return TurnResult{}, result, lastErr
+}
+
+func IsRetryableProviderError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var runnerErr *RunnerError
+	if !errors.As(err, &runnerErr) || runnerErr.Code != ErrorCodeProviderRequestFailed {
+		return false
+	}
+	switch {
+	case runnerErr.StatusCode == http.StatusTooManyRequests:
+		return true
+	case runnerErr.StatusCode >= http.StatusInternalServerError:
+		return true
+	case runnerErr.StatusCode == 0:
+		return runnerErr.Err != nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nextai/apps/gateway/internal/domain"
+)
+
+func TestGenerateTurnWithFailoverMovesToNextSlotOnRetryableErrors(t *testing.T) {
+	t.Parallel()
+	rateLimited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer rateLimited.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"from fallback"}}]}`))
+	}))
+	defer healthy.Close()
+
+	r := NewWithHTTPClient(healthy.Client())
+	turn, result, err := r.GenerateTurnWithFailover(context.Background(), failoverTestRequest(), []GenerateConfig{
+		{ProviderID: ProviderOpenAI, Model: "gpt-4o-mini", APIKey: "sk-a", BaseURL: rateLimited.URL},
+		{ProviderID: "backup", Model: "m2", APIKey: "sk-b", BaseURL: healthy.URL, AdapterID: "openai-compatible"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if turn.Text != "from fallback" {
+		t.Fatalf("unexpected text: %q", turn.Text)
+	}
+	if result.SlotIndex != 1 || result.ProviderID != "backup" || len(result.Attempts) != 1 {
+		t.Fatalf("unexpected failover result: %+v", result)
+	}
+}
+
+func TestGenerateTurnWithFailoverStopsOnNonRetryableError(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	badRequest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer badRequest.Close()
+
+	r := NewWithHTTPClient(badRequest.Client())
+	_, result, err := r.GenerateTurnWithFailover(context.Background(), failoverTestRequest(), []GenerateConfig{
+		{ProviderID: ProviderOpenAI, Model: "gpt-4o-mini", APIKey: "sk-a", BaseURL: badRequest.URL},
+		{ProviderID: ProviderOpenAI, Model: "gpt-4.1-mini", APIKey: "sk-a", BaseURL: badRequest.URL},
+	}, nil, nil)
+	assertRunnerCode(t, err, ErrorCodeProviderRequestFailed)
+	if calls != 1 || result.SlotIndex != 0 {
+		t.Fatalf("expected no retry for 400, calls=%d result=%+v", calls, result)
+	}
+}
+
+func TestIsRetryableProviderError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&RunnerError{Code: ErrorCodeProviderRequestFailed, StatusCode: http.StatusBadGateway}, true},
+		{&RunnerError{Code: ErrorCodeProviderRequestFailed, StatusCode: http.StatusTooManyRequests}, true},
+		{&RunnerError{Code: ErrorCodeProviderRequestFailed, Err: errors.New("connection refused")}, true},
+		{&RunnerError{Code: ErrorCodeProviderRequestFailed, StatusCode: http.StatusUnauthorized}, false},
+		{&RunnerError{Code: ErrorCodeProviderInvalidReply, StatusCode: http.StatusBadGateway}, false},
+		{&RunnerError{Code: ErrorCodeProviderRequestFailed, Err: context.Canceled}, false},
+	}
+	for i, tc := range cases {
+		if got := IsRetryableProviderError(tc.err); got != tc.want {
+			t.Fatalf("case %d: got=%v want=%v (%v)", i, got, tc.want, tc.err)
+		}
+	}
+}
+
+func failoverTestRequest() domain.AgentProcessRequest {
+	return domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{{
+			Role:    "user",
+			Type:    "message",
+			Content: []domain.RuntimeContent{{Type: "text", Text: "hi"}},
+		}},
+	}
+}
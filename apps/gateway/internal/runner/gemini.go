@@ -0,0 +1,503 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/provider"
+)
+
+const (
+	ProviderGemini = "gemini"
+
+	defaultGeminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+)
+
+type geminiAdapter struct{}
+
+func (a *geminiAdapter) ID() string {
+	return provider.AdapterGemini
+}
+
+func (a *geminiAdapter) GenerateTurn(ctx context.Context, req domain.AgentProcessRequest, cfg GenerateConfig, tools []ToolDefinition, runner *Runner) (TurnResult, error) {
+	return runner.generateGeminiTurn(ctx, req, cfg, tools)
+}
+
+func (a *geminiAdapter) GenerateTurnStream(
+	ctx context.Context,
+	req domain.AgentProcessRequest,
+	cfg GenerateConfig,
+	tools []ToolDefinition,
+	runner *Runner,
+	onDelta func(string),
+) (TurnResult, error) {
+	return runner.generateGeminiTurnStream(ctx, req, cfg, tools, onDelta)
+}
+
+type geminiGenerateRequest struct {
+	Contents          []geminiContent `json:"contents"`
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool    `json:"tools,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type geminiGenerateResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason,omitempty"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason,omitempty"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error,omitempty"`
+}
+
+func (r *Runner) generateGeminiTurn(ctx context.Context, req domain.AgentProcessRequest, cfg GenerateConfig, tools []ToolDefinition) (TurnResult, error) {
+	payload, ok, err := buildGeminiPayload(req, cfg, tools)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if !ok {
+		return TurnResult{Text: generateDemoReply(req)}, nil
+	}
+
+	requestCtx := ctx
+	cancel := func() {}
+	if cfg.TimeoutMS > 0 {
+		requestCtx, cancel = context.WithTimeout(ctx, time.Duration(cfg.TimeoutMS)*time.Millisecond)
+	}
+	defer cancel()
+
+	resp, err := r.doGeminiRequest(requestCtx, cfg, payload, false)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+	if err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to read provider response",
+			Err:     err,
+		}
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return TurnResult{}, &RunnerError{
+			Code:       ErrorCodeProviderRequestFailed,
+			Message:    fmt.Sprintf("provider returned status %d", resp.StatusCode),
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	var completion geminiGenerateResponse
+	if err := json.Unmarshal(respBody, &completion); err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider response is not valid json",
+			Err:     err,
+		}
+	}
+	if len(completion.Candidates) == 0 {
+		message := "provider response has no candidates"
+		if reason := strings.TrimSpace(completion.PromptFeedback.BlockReason); reason != "" {
+			message = fmt.Sprintf("provider blocked prompt: %s", reason)
+		}
+		return TurnResult{}, &RunnerError{Code: ErrorCodeProviderInvalidReply, Message: message}
+	}
+
+	textParts := make([]string, 0, 1)
+	rawCalls := make([]openAIToolCall, 0)
+	for _, part := range completion.Candidates[0].Content.Parts {
+		if text := strings.TrimSpace(part.Text); text != "" {
+			textParts = append(textParts, text)
+		}
+		if part.FunctionCall != nil {
+			rawCalls = append(rawCalls, geminiFunctionCallToOpenAI(part.FunctionCall))
+		}
+	}
+
+	toolCalls, err := parseOpenAIToolCalls(rawCalls)
+	if err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: err.Error(),
+			Err:     err,
+		}
+	}
+	text := strings.Join(textParts, "\n")
+	if text == "" && len(toolCalls) == 0 {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider response has empty content",
+		}
+	}
+	return TurnResult{Text: text, ToolCalls: toolCalls, Usage: completion.usage()}, nil
+}
+
+func (r *Runner) generateGeminiTurnStream(
+	ctx context.Context,
+	req domain.AgentProcessRequest,
+	cfg GenerateConfig,
+	tools []ToolDefinition,
+	onDelta func(string),
+) (TurnResult, error) {
+	payload, ok, err := buildGeminiPayload(req, cfg, tools)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if !ok {
+		return TurnResult{Text: generateDemoReply(req)}, nil
+	}
+
+	requestCtx := ctx
+	cancel := func() {}
+	if cfg.TimeoutMS > 0 {
+		requestCtx, cancel = context.WithTimeout(ctx, time.Duration(cfg.TimeoutMS)*time.Millisecond)
+	}
+	defer cancel()
+
+	resp, err := r.doGeminiRequest(requestCtx, cfg, payload, true)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+		return TurnResult{}, &RunnerError{
+			Code:       ErrorCodeProviderRequestFailed,
+			Message:    fmt.Sprintf("provider returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody))),
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	var replyBuilder strings.Builder
+	rawCalls := make([]openAIToolCall, 0)
+	var streamErr *RunnerError
+	usage := domain.TokenUsage{}
+	processData := func(data string) error {
+		var chunk geminiGenerateResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("provider stream chunk is not valid json: %w", err)
+		}
+		if chunk.UsageMetadata != nil {
+			usage = chunk.usage()
+		}
+		if chunk.Error != nil {
+			streamErr = &RunnerError{
+				Code:    ErrorCodeProviderRequestFailed,
+				Message: fmt.Sprintf("provider stream error: %s", strings.TrimSpace(chunk.Error.Message)),
+			}
+			return nil
+		}
+		if len(chunk.Candidates) == 0 {
+			return nil
+		}
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			if part.Text != "" {
+				replyBuilder.WriteString(part.Text)
+				if onDelta != nil {
+					onDelta(part.Text)
+				}
+			}
+			if part.FunctionCall != nil {
+				rawCalls = append(rawCalls, geminiFunctionCallToOpenAI(part.FunctionCall))
+			}
+		}
+		return nil
+	}
+
+	if err := consumeSSEData(resp.Body, processData); err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider stream response is invalid",
+			Err:     err,
+		}
+	}
+	if streamErr != nil {
+		return TurnResult{}, streamErr
+	}
+
+	parsedToolCalls, err := parseOpenAIToolCalls(rawCalls)
+	if err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: err.Error(),
+			Err:     err,
+		}
+	}
+
+	reply := replyBuilder.String()
+	if strings.TrimSpace(reply) == "" && len(parsedToolCalls) == 0 {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider response has empty content",
+		}
+	}
+	return TurnResult{Text: reply, ToolCalls: parsedToolCalls, Usage: usage}, nil
+}
+
+func (r geminiGenerateResponse) usage() domain.TokenUsage {
+	if r.UsageMetadata == nil {
+		return domain.TokenUsage{}
+	}
+	return newTokenUsage(r.UsageMetadata.PromptTokenCount, r.UsageMetadata.CandidatesTokenCount, r.UsageMetadata.TotalTokenCount)
+}
+
+func buildGeminiPayload(req domain.AgentProcessRequest, cfg GenerateConfig, tools []ToolDefinition) (geminiGenerateRequest, bool, error) {
+	if strings.TrimSpace(cfg.APIKey) == "" {
+		return geminiGenerateRequest{}, false, &RunnerError{Code: ErrorCodeProviderNotConfigured, Message: "provider api_key is required"}
+	}
+	system, contents := toGeminiContents(req.Input)
+	if len(contents) == 0 {
+		return geminiGenerateRequest{}, false, nil
+	}
+	payload := geminiGenerateRequest{
+		Contents: contents,
+		Tools:    toGeminiTools(tools),
+	}
+	if system != "" {
+		payload.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: system}}}
+	}
+	return payload, true, nil
+}
+
+func (r *Runner) doGeminiRequest(ctx context.Context, cfg GenerateConfig, payload geminiGenerateRequest, stream bool) (*http.Response, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultGeminiBaseURL
+	}
+	model := strings.TrimPrefix(strings.TrimSpace(cfg.Model), "models/")
+	endpoint := baseURL + "/models/" + url.PathEscape(model) + ":generateContent"
+	if stream {
+		endpoint = baseURL + "/models/" + url.PathEscape(model) + ":streamGenerateContent?alt=sse"
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to encode provider request",
+			Err:     err,
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to create provider request",
+			Err:     err,
+		}
+	}
+	httpReq.Header.Set("x-goog-api-key", strings.TrimSpace(cfg.APIKey))
+	httpReq.Header.Set("Content-Type", "application/json")
+	if stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+	for key, value := range cfg.Headers {
+		k := strings.TrimSpace(key)
+		v := strings.TrimSpace(value)
+		if k == "" || v == "" {
+			continue
+		}
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := r.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "provider request failed",
+			Err:     err,
+		}
+	}
+	return resp, nil
+}
+
+func toGeminiContents(input []domain.AgentInputMessage) (string, []geminiContent) {
+	systemParts := make([]string, 0, 1)
+	out := make([]geminiContent, 0, len(input))
+	callNames := map[string]string{}
+	appendParts := func(role string, parts []geminiPart) {
+		if len(parts) == 0 {
+			return
+		}
+		if n := len(out); n > 0 && out[n-1].Role == role {
+			out[n-1].Parts = append(out[n-1].Parts, parts...)
+			return
+		}
+		out = append(out, geminiContent{Role: role, Parts: parts})
+	}
+
+	for _, msg := range input {
+		role := normalizeRole(msg.Role)
+		content := strings.TrimSpace(flattenText(msg.Content))
+
+		switch role {
+		case "system":
+			if content != "" {
+				systemParts = append(systemParts, content)
+			}
+		case "assistant":
+			parts := make([]geminiPart, 0, 1)
+			if content != "" {
+				parts = append(parts, geminiPart{Text: content})
+			}
+			for _, call := range parseToolCallsFromMetadata(msg.Metadata) {
+				callNames[call.ID] = call.Function.Name
+				args := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(args) {
+					args = json.RawMessage("{}")
+				}
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{
+					Name: call.Function.Name,
+					Args: args,
+				}})
+			}
+			appendParts("model", parts)
+		case "tool":
+			name := metadataString(msg.Metadata, "name")
+			if name == "" {
+				name = callNames[metadataString(msg.Metadata, "tool_call_id")]
+			}
+			if name == "" {
+				if content != "" {
+					appendParts("user", []geminiPart{{Text: content}})
+				}
+				continue
+			}
+			appendParts("user", []geminiPart{{FunctionResponse: &geminiFunctionResponse{
+				Name:     name,
+				Response: geminiToolResponse(content),
+			}}})
+		default:
+			if content == "" {
+				continue
+			}
+			appendParts("user", []geminiPart{{Text: content}})
+		}
+	}
+	return strings.Join(systemParts, "\n\n"), out
+}
+
+func geminiToolResponse(content string) map[string]interface{} {
+	var out map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &out); err == nil && out != nil {
+		return out
+	}
+	return map[string]interface{}{"content": content}
+}
+
+func geminiFunctionCallToOpenAI(call *geminiFunctionCall) openAIToolCall {
+	arguments := strings.TrimSpace(string(call.Args))
+	if arguments == "" || arguments == "null" {
+		arguments = "{}"
+	}
+	return openAIToolCall{
+		ID:       strings.TrimSpace(call.ID),
+		Type:     "function",
+		Function: openAIFunctionCall{Name: strings.TrimSpace(call.Name), Arguments: arguments},
+	}
+}
+
+func toGeminiTools(tools []ToolDefinition) []geminiTool {
+	if len(tools) == 0 {
+		return nil
+	}
+	declarations := make([]geminiFunctionDeclaration, 0, len(tools))
+	for _, item := range tools {
+		name := strings.TrimSpace(item.Name)
+		if name == "" {
+			continue
+		}
+		params, _ := sanitizeGeminiSchema(normalizeToolParameters(item.Parameters)).(map[string]interface{})
+		declarations = append(declarations, geminiFunctionDeclaration{
+			Name:        name,
+			Description: strings.TrimSpace(item.Description),
+			Parameters:  params,
+		})
+	}
+	if len(declarations) == 0 {
+		return nil
+	}
+	return []geminiTool{{FunctionDeclarations: declarations}}
+}
+
+func sanitizeGeminiSchema(in interface{}) interface{} {
+	switch value := in.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			if key == "additionalProperties" || key == "$schema" {
+				continue
+			}
+			// Gemini accepts a single type only; keep the first one listed.
+			if types, ok := item.([]interface{}); ok && key == "type" && len(types) > 0 {
+				out[key] = types[0]
+				continue
+			}
+			out[key] = sanitizeGeminiSchema(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			out = append(out, sanitizeGeminiSchema(item))
+		}
+		return out
+	default:
+		return in
+	}
+}
@@ -0,0 +1,215 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nextai/apps/gateway/internal/domain"
+)
+
+func TestGenerateTurnGeminiMapsContentsAndFunctionCalls(t *testing.T) {
+	t.Parallel()
+	var apiKey string
+	var path string
+	var req map[string]interface{}
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("x-goog-api-key")
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{
+			"candidates":[{"content":{"role":"model","parts":[
+				{"text":"checking"},
+				{"functionCall":{"name":"view","args":{"items":[{"path":"/tmp/a.txt","start":1,"end":3}]}}},
+				{"functionCall":{"name":"shell","args":{"items":[{"command":"ls"}]}}}
+			]},"finishReason":"STOP"}]
+		}`))
+	}))
+	defer mock.Close()
+
+	r := NewWithHTTPClient(mock.Client())
+	turn, err := r.GenerateTurn(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{
+			{Role: "system", Type: "message", Content: []domain.RuntimeContent{{Type: "text", Text: "be brief"}}},
+			{Role: "user", Type: "message", Content: []domain.RuntimeContent{{Type: "text", Text: "where am i"}}},
+			{
+				Role: "assistant",
+				Type: "message",
+				Metadata: map[string]interface{}{
+					"tool_calls": []interface{}{
+						map[string]interface{}{
+							"id":       "call_1",
+							"type":     "function",
+							"function": map[string]interface{}{"name": "shell", "arguments": `{"items":[{"command":"pwd"}]}`},
+						},
+					},
+				},
+			},
+			{
+				Role:     "tool",
+				Type:     "message",
+				Content:  []domain.RuntimeContent{{Type: "text", Text: "/tmp"}},
+				Metadata: map[string]interface{}{"tool_call_id": "call_1"},
+			},
+		},
+	}, GenerateConfig{
+		ProviderID: ProviderGemini,
+		Model:      "gemini-2.0-flash",
+		APIKey:     "g-test",
+		BaseURL:    mock.URL,
+	}, []ToolDefinition{{
+		Name: "view",
+		Parameters: map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"type": "object", "additionalProperties": false},
+				},
+				"timeout_seconds": map[string]interface{}{"type": []string{"integer", "string"}},
+			},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if apiKey != "g-test" {
+		t.Fatalf("unexpected api key header: %q", apiKey)
+	}
+	if path != "/models/gemini-2.0-flash:generateContent" {
+		t.Fatalf("unexpected request path: %s", path)
+	}
+	system, _ := req["systemInstruction"].(map[string]interface{})
+	if parts, _ := system["parts"].([]interface{}); len(parts) != 1 || parts[0].(map[string]interface{})["text"] != "be brief" {
+		t.Fatalf("unexpected system instruction: %v", req["systemInstruction"])
+	}
+
+	tools, _ := req["tools"].([]interface{})
+	if len(tools) != 1 {
+		t.Fatalf("unexpected tools payload: %v", req["tools"])
+	}
+	declarations, _ := tools[0].(map[string]interface{})["functionDeclarations"].([]interface{})
+	if len(declarations) != 1 {
+		t.Fatalf("unexpected function declarations: %v", tools[0])
+	}
+	if raw, _ := json.Marshal(declarations[0]); strings.Contains(string(raw), "additionalProperties") {
+		t.Fatalf("expected additionalProperties to be stripped, got=%s", raw)
+	}
+	params, _ := declarations[0].(map[string]interface{})["parameters"].(map[string]interface{})
+	props, _ := params["properties"].(map[string]interface{})
+	if timeout, _ := props["timeout_seconds"].(map[string]interface{}); timeout["type"] != "integer" {
+		t.Fatalf("expected type list to collapse to its first type, got=%v", props["timeout_seconds"])
+	}
+
+	contents, _ := req["contents"].([]interface{})
+	if len(contents) != 3 {
+		t.Fatalf("expected 3 contents, got=%d (%v)", len(contents), contents)
+	}
+	model := contents[1].(map[string]interface{})
+	call := model["parts"].([]interface{})[0].(map[string]interface{})["functionCall"].(map[string]interface{})
+	if model["role"] != "model" || call["name"] != "shell" {
+		t.Fatalf("unexpected model content: %v", model)
+	}
+	toolContent := contents[2].(map[string]interface{})
+	response := toolContent["parts"].([]interface{})[0].(map[string]interface{})["functionResponse"].(map[string]interface{})
+	if toolContent["role"] != "user" || response["name"] != "shell" {
+		t.Fatalf("unexpected function response content: %v", toolContent)
+	}
+	if body, _ := response["response"].(map[string]interface{}); body["content"] != "/tmp" {
+		t.Fatalf("unexpected function response body: %v", response)
+	}
+
+	if turn.Text != "checking" {
+		t.Fatalf("unexpected text: %q", turn.Text)
+	}
+	if len(turn.ToolCalls) != 2 || turn.ToolCalls[0].Name != "view" || turn.ToolCalls[1].Name != "shell" {
+		t.Fatalf("unexpected tool calls: %+v", turn.ToolCalls)
+	}
+	if turn.ToolCalls[0].ID == "" || turn.ToolCalls[0].ID == turn.ToolCalls[1].ID {
+		t.Fatalf("expected distinct generated call ids, got=%+v", turn.ToolCalls)
+	}
+}
+
+func TestGenerateTurnStreamGeminiUsesStreamGenerateContent(t *testing.T) {
+	t.Parallel()
+	var path string
+	var alt string
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		alt = r.URL.Query().Get("alt")
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(strings.Join([]string{
+			`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"hel"}]}}]}`,
+			`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"lo"}]}}]}`,
+			`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"shell","args":{"items":[{"command":"ls"}]}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":3,"totalTokenCount":7}}`,
+		}, "\n\n") + "\n\n"))
+	}))
+	defer mock.Close()
+
+	deltas := make([]string, 0, 2)
+	r := NewWithHTTPClient(mock.Client())
+	turn, err := r.GenerateTurnStream(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{{
+			Role:    "user",
+			Type:    "message",
+			Content: []domain.RuntimeContent{{Type: "text", Text: "hi"}},
+		}},
+	}, GenerateConfig{
+		ProviderID: ProviderGemini,
+		Model:      "gemini-2.0-flash",
+		APIKey:     "g-test",
+		BaseURL:    mock.URL,
+	}, nil, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/models/gemini-2.0-flash:streamGenerateContent" || alt != "sse" {
+		t.Fatalf("unexpected stream endpoint: path=%s alt=%s", path, alt)
+	}
+	if strings.Join(deltas, "|") != "hel|lo" || turn.Text != "hello" {
+		t.Fatalf("unexpected deltas=%v text=%q", deltas, turn.Text)
+	}
+	if len(turn.ToolCalls) != 1 || turn.ToolCalls[0].Name != "shell" {
+		t.Fatalf("unexpected tool calls: %+v", turn.ToolCalls)
+	}
+	if turn.Usage.PromptTokens != 4 || turn.Usage.TotalTokens != 7 {
+		t.Fatalf("unexpected usage: %+v", turn.Usage)
+	}
+}
+
+func TestGenerateTurnGeminiBlockedPrompt(t *testing.T) {
+	t.Parallel()
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"candidates":[],"promptFeedback":{"blockReason":"SAFETY"}}`))
+	}))
+	defer mock.Close()
+
+	r := NewWithHTTPClient(mock.Client())
+	_, err := r.GenerateTurn(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{{
+			Role:    "user",
+			Type:    "message",
+			Content: []domain.RuntimeContent{{Type: "text", Text: "hi"}},
+		}},
+	}, GenerateConfig{
+		ProviderID: ProviderGemini,
+		Model:      "gemini-2.0-flash",
+		APIKey:     "g-test",
+		BaseURL:    mock.URL,
+	}, nil)
+	assertRunnerCode(t, err, ErrorCodeProviderInvalidReply)
+	if !strings.Contains(err.Error(), "SAFETY") {
+		t.Fatalf("expected block reason in error, got=%v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"nextai/apps/gateway/internal/domain"
+	"nextai/apps/gateway/internal/provider"
+)
+
+const (
+	ProviderOllama = "ollama"
+
+	defaultOllamaBaseURL = "http://127.0.0.1:11434"
+)
+
+type ollamaAdapter struct{}
+
+func (a *ollamaAdapter) ID() string {
+	return provider.AdapterOllama
+}
+
+func (a *ollamaAdapter) GenerateTurn(ctx context.Context, req domain.AgentProcessRequest, cfg GenerateConfig, tools []ToolDefinition, runner *Runner) (TurnResult, error) {
+	return runner.generateOllamaTurn(ctx, req, cfg, tools, false, nil)
+}
+
+func (a *ollamaAdapter) GenerateTurnStream(
+	ctx context.Context,
+	req domain.AgentProcessRequest,
+	cfg GenerateConfig,
+	tools []ToolDefinition,
+	runner *Runner,
+	onDelta func(string),
+) (TurnResult, error) {
+	return runner.generateOllamaTurn(ctx, req, cfg, tools, true, onDelta)
+}
+
+func (a *ollamaAdapter) ListModels(ctx context.Context, cfg GenerateConfig, runner *Runner) ([]DiscoveredModel, error) {
+	return runner.listOllamaModels(ctx, cfg)
+}
+
+type ollamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Tools    []openAIToolDefinition `json:"tools,omitempty"`
+	Stream   bool                   `json:"stream"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments,omitempty"`
+	} `json:"function"`
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	Error           string        `json:"error,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+	EvalCount       int           `json:"eval_count,omitempty"`
+}
+
+type ollamaTagsResponse struct {
+	Models []struct {
+		Name  string `json:"name"`
+		Model string `json:"model"`
+	} `json:"models"`
+}
+
+func (r *Runner) generateOllamaTurn(
+	ctx context.Context,
+	req domain.AgentProcessRequest,
+	cfg GenerateConfig,
+	tools []ToolDefinition,
+	stream bool,
+	onDelta func(string),
+) (TurnResult, error) {
+	payload := ollamaChatRequest{
+		Model:    cfg.Model,
+		Messages: toOllamaMessages(req.Input),
+		Tools:    toOpenAITools(tools),
+		Stream:   stream,
+	}
+	if len(payload.Messages) == 0 {
+		return TurnResult{Text: generateDemoReply(req)}, nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to encode provider request",
+			Err:     err,
+		}
+	}
+
+	requestCtx := ctx
+	cancel := func() {}
+	if cfg.TimeoutMS > 0 {
+		requestCtx, cancel = context.WithTimeout(ctx, time.Duration(cfg.TimeoutMS)*time.Millisecond)
+	}
+	defer cancel()
+
+	resp, err := r.doOllamaRequest(requestCtx, cfg, http.MethodPost, "/api/chat", body)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+		return TurnResult{}, &RunnerError{
+			Code:       ErrorCodeProviderRequestFailed,
+			Message:    fmt.Sprintf("provider returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody))),
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	var replyBuilder strings.Builder
+	rawCalls := make([]openAIToolCall, 0)
+	usage := domain.TokenUsage{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return TurnResult{}, &RunnerError{
+				Code:    ErrorCodeProviderInvalidReply,
+				Message: "provider response is not valid json",
+				Err:     err,
+			}
+		}
+		if chunk.Error != "" {
+			return TurnResult{}, &RunnerError{
+				Code:    ErrorCodeProviderRequestFailed,
+				Message: fmt.Sprintf("provider error: %s", strings.TrimSpace(chunk.Error)),
+			}
+		}
+		if delta := chunk.Message.Content; delta != "" {
+			replyBuilder.WriteString(delta)
+			if onDelta != nil {
+				onDelta(delta)
+			}
+		}
+		for _, call := range chunk.Message.ToolCalls {
+			arguments := strings.TrimSpace(string(call.Function.Arguments))
+			if arguments == "" || arguments == "null" {
+				arguments = "{}"
+			}
+			rawCalls = append(rawCalls, openAIToolCall{
+				ID:       strings.TrimSpace(call.ID),
+				Type:     "function",
+				Function: openAIFunctionCall{Name: strings.TrimSpace(call.Function.Name), Arguments: arguments},
+			})
+		}
+		if chunk.Done {
+			usage = newTokenUsage(chunk.PromptEvalCount, chunk.EvalCount, 0)
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to read provider response",
+			Err:     err,
+		}
+	}
+
+	toolCalls, err := parseOpenAIToolCalls(rawCalls)
+	if err != nil {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: err.Error(),
+			Err:     err,
+		}
+	}
+	reply := replyBuilder.String()
+	if !stream {
+		reply = strings.TrimSpace(reply)
+	}
+	if strings.TrimSpace(reply) == "" && len(toolCalls) == 0 {
+		return TurnResult{}, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider response has empty content",
+		}
+	}
+	return TurnResult{Text: reply, ToolCalls: toolCalls, Usage: usage}, nil
+}
+
+func (r *Runner) listOllamaModels(ctx context.Context, cfg GenerateConfig) ([]DiscoveredModel, error) {
+	requestCtx := ctx
+	cancel := func() {}
+	if cfg.TimeoutMS > 0 {
+		requestCtx, cancel = context.WithTimeout(ctx, time.Duration(cfg.TimeoutMS)*time.Millisecond)
+	}
+	defer cancel()
+
+	resp, err := r.doOllamaRequest(requestCtx, cfg, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to read provider response",
+			Err:     err,
+		}
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, &RunnerError{
+			Code:       ErrorCodeProviderRequestFailed,
+			Message:    fmt.Sprintf("provider returned status %d", resp.StatusCode),
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	var tags ollamaTagsResponse
+	if err := json.Unmarshal(respBody, &tags); err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider response is not valid json",
+			Err:     err,
+		}
+	}
+	out := make([]DiscoveredModel, 0, len(tags.Models))
+	for _, item := range tags.Models {
+		id := strings.TrimSpace(item.Model)
+		if id == "" {
+			id = strings.TrimSpace(item.Name)
+		}
+		if id == "" {
+			continue
+		}
+		name := strings.TrimSpace(item.Name)
+		if name == "" {
+			name = id
+		}
+		out = append(out, DiscoveredModel{ID: id, Name: name})
+	}
+	return out, nil
+}
+
+func (r *Runner) doOllamaRequest(ctx context.Context, cfg GenerateConfig, method, path string, body []byte) (*http.Response, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultOllamaBaseURL
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, baseURL+path, reader)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to create provider request",
+			Err:     err,
+		}
+	}
+	if apiKey := strings.TrimSpace(cfg.APIKey); apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range cfg.Headers {
+		k := strings.TrimSpace(key)
+		v := strings.TrimSpace(value)
+		if k == "" || v == "" {
+			continue
+		}
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := r.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "provider request failed",
+			Err:     err,
+		}
+	}
+	return resp, nil
+}
+
+func toOllamaMessages(input []domain.AgentInputMessage) []ollamaMessage {
+	out := make([]ollamaMessage, 0, len(input))
+	for _, msg := range input {
+		role := normalizeRole(msg.Role)
+		content := strings.TrimSpace(flattenText(msg.Content))
+
+		switch role {
+		case "assistant":
+			item := ollamaMessage{Role: role, Content: content}
+			for _, call := range parseToolCallsFromMetadata(msg.Metadata) {
+				toolCall := ollamaToolCall{}
+				toolCall.Function.Name = call.Function.Name
+				toolCall.Function.Arguments = json.RawMessage(call.Function.Arguments)
+				if !json.Valid(toolCall.Function.Arguments) {
+					toolCall.Function.Arguments = json.RawMessage("{}")
+				}
+				item.ToolCalls = append(item.ToolCalls, toolCall)
+			}
+			if item.Content == "" && len(item.ToolCalls) == 0 {
+				continue
+			}
+			out = append(out, item)
+		case "tool":
+			out = append(out, ollamaMessage{
+				Role:     role,
+				Content:  content,
+				ToolName: metadataString(msg.Metadata, "name"),
+			})
+		default:
+			if content == "" {
+				continue
+			}
+			out = append(out, ollamaMessage{Role: role, Content: content})
+		}
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nextai/apps/gateway/internal/domain"
+)
+
+func TestGenerateTurnOllamaParsesToolCalls(t *testing.T) {
+	t.Parallel()
+	var req map[string]interface{}
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/chat" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"shell","arguments":{"items":[{"command":"ls"}]}}}]},"done":true}`))
+	}))
+	defer mock.Close()
+
+	r := NewWithHTTPClient(mock.Client())
+	turn, err := r.GenerateTurn(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{
+			{Role: "user", Type: "message", Content: []domain.RuntimeContent{{Type: "text", Text: "list"}}},
+			{
+				Role: "assistant",
+				Type: "message",
+				Metadata: map[string]interface{}{
+					"tool_calls": []interface{}{
+						map[string]interface{}{
+							"id":       "call_1",
+							"type":     "function",
+							"function": map[string]interface{}{"name": "shell", "arguments": `{"items":[{"command":"pwd"}]}`},
+						},
+					},
+				},
+			},
+			{
+				Role:     "tool",
+				Type:     "message",
+				Content:  []domain.RuntimeContent{{Type: "text", Text: "/tmp"}},
+				Metadata: map[string]interface{}{"tool_call_id": "call_1", "name": "shell"},
+			},
+		},
+	}, GenerateConfig{ProviderID: ProviderOllama, Model: "llama3.2", BaseURL: mock.URL}, []ToolDefinition{{Name: "shell"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stream, ok := req["stream"].(bool); !ok || stream {
+		t.Fatalf("expected stream=false in request, got=%v", req["stream"])
+	}
+	messages, _ := req["messages"].([]interface{})
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got=%v", messages)
+	}
+	assistant := messages[1].(map[string]interface{})
+	calls, _ := assistant["tool_calls"].([]interface{})
+	if len(calls) != 1 {
+		t.Fatalf("unexpected assistant tool calls: %v", assistant)
+	}
+	args := calls[0].(map[string]interface{})["function"].(map[string]interface{})["arguments"]
+	if _, ok := args.(map[string]interface{}); !ok {
+		t.Fatalf("expected object arguments for ollama, got=%T", args)
+	}
+	if tool := messages[2].(map[string]interface{}); tool["role"] != "tool" || tool["tool_name"] != "shell" {
+		t.Fatalf("unexpected tool message: %v", tool)
+	}
+
+	if len(turn.ToolCalls) != 1 || turn.ToolCalls[0].Name != "shell" || turn.ToolCalls[0].ID == "" {
+		t.Fatalf("unexpected tool calls: %+v", turn.ToolCalls)
+	}
+}
+
+func TestGenerateTurnStreamOllamaReadsNDJSON(t *testing.T) {
+	t.Parallel()
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = w.Write([]byte(strings.Join([]string{
+			`{"message":{"role":"assistant","content":"hel"},"done":false}`,
+			`{"message":{"role":"assistant","content":"lo"},"done":false}`,
+			`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":5,"eval_count":2}`,
+		}, "\n") + "\n"))
+	}))
+	defer mock.Close()
+
+	deltas := make([]string, 0, 2)
+	r := NewWithHTTPClient(mock.Client())
+	turn, err := r.GenerateTurnStream(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{{
+			Role:    "user",
+			Type:    "message",
+			Content: []domain.RuntimeContent{{Type: "text", Text: "hi"}},
+		}},
+	}, GenerateConfig{ProviderID: ProviderOllama, Model: "llama3.2", BaseURL: mock.URL}, nil, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(deltas, "|") != "hel|lo" || turn.Text != "hello" {
+		t.Fatalf("unexpected deltas=%v text=%q", deltas, turn.Text)
+	}
+	if turn.Usage.TotalTokens != 7 {
+		t.Fatalf("unexpected usage: %+v", turn.Usage)
+	}
+}
+
+func TestGenerateTurnOllamaErrorPayload(t *testing.T) {
+	t.Parallel()
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"error":"model \"missing\" not found"}`))
+	}))
+	defer mock.Close()
+
+	r := NewWithHTTPClient(mock.Client())
+	_, err := r.GenerateTurn(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{{
+			Role:    "user",
+			Type:    "message",
+			Content: []domain.RuntimeContent{{Type: "text", Text: "hi"}},
+		}},
+	}, GenerateConfig{ProviderID: ProviderOllama, Model: "missing", BaseURL: mock.URL}, nil)
+	assertRunnerCode(t, err, ErrorCodeProviderRequestFailed)
+}
+
+func TestListModelsOllamaTags(t *testing.T) {
+	t.Parallel()
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/tags" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"models":[{"name":"qwen2.5:7b","model":"qwen2.5:7b"},{"name":"llama3.2:latest","model":"llama3.2:latest"}]}`))
+	}))
+	defer mock.Close()
+
+	r := NewWithHTTPClient(mock.Client())
+	models, err := r.ListModels(context.Background(), GenerateConfig{ProviderID: ProviderOllama, BaseURL: mock.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(models) != 2 || models[0].ID != "llama3.2:latest" || models[1].ID != "qwen2.5:7b" {
+		t.Fatalf("unexpected models: %+v", models)
+	}
+}
+
+func TestListModelsDemoAdapterNotSupported(t *testing.T) {
+	r := New()
+	_, err := r.ListModels(context.Background(), GenerateConfig{ProviderID: ProviderDemo})
+	assertRunnerCode(t, err, ErrorCodeProviderNotSupported)
+}
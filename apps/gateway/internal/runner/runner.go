@@ -30,9 +30,10 @@ const (
 )
 
 type RunnerError struct {
-	Code    string
-	Message string
-	Err     error
+	Code       string
+	Message    string
+	StatusCode int
+	Err        error
 }
 
 type InvalidToolCallError struct {
@@ -115,6 +116,7 @@ type ToolCall struct {
 type TurnResult struct {
 	Text      string
 	ToolCalls []ToolCall
+	Usage     domain.TokenUsage
 }
 
 type ProviderAdapter interface {
@@ -127,6 +129,16 @@ type StreamProviderAdapter interface {
 	GenerateTurnStream(ctx context.Context, req domain.AgentProcessRequest, cfg GenerateConfig, tools []ToolDefinition, runner *Runner, onDelta func(string)) (TurnResult, error)
 }
 
+type DiscoveredModel struct {
+	ID   string
+	Name string
+}
+
+type ModelDiscoveryAdapter interface {
+	ProviderAdapter
+	ListModels(ctx context.Context, cfg GenerateConfig, runner *Runner) ([]DiscoveredModel, error)
+}
+
 type Runner struct {
 	httpClient *http.Client
 	adapters   map[string]ProviderAdapter
@@ -146,6 +158,9 @@ func NewWithHTTPClient(client *http.Client) *Runner {
 	}
 	r.registerAdapter(&demoAdapter{})
 	r.registerAdapter(&openAICompatibleAdapter{})
+	r.registerAdapter(&anthropicAdapter{})
+	r.registerAdapter(&geminiAdapter{})
+	r.registerAdapter(&ollamaAdapter{})
 	return r
 }
 
@@ -255,6 +270,36 @@ func (r *Runner) GenerateTurnStream(
 	return turn, nil
 }
 
+func (r *Runner) ListModels(ctx context.Context, cfg GenerateConfig) ([]DiscoveredModel, error) {
+	providerID := strings.ToLower(strings.TrimSpace(cfg.ProviderID))
+	adapterID := strings.TrimSpace(cfg.AdapterID)
+	if adapterID == "" {
+		adapterID = defaultAdapterForProvider(providerID)
+	}
+	adapter, ok := r.adapters[adapterID]
+	if !ok {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderNotSupported,
+			Message: fmt.Sprintf("adapter %q is not supported", adapterID),
+		}
+	}
+	discovery, ok := adapter.(ModelDiscoveryAdapter)
+	if !ok {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderNotSupported,
+			Message: fmt.Sprintf("adapter %q does not support model discovery", adapterID),
+		}
+	}
+	models, err := discovery.ListModels(ctx, cfg, r)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+	return models, nil
+}
+
 type demoAdapter struct{}
 
 func (a *demoAdapter) ID() string {
@@ -286,12 +331,22 @@ func (a *openAICompatibleAdapter) GenerateTurnStream(
 	return runner.generateOpenAICompatibleTurnStream(ctx, req, cfg, tools, onDelta)
 }
 
+func (a *openAICompatibleAdapter) ListModels(ctx context.Context, cfg GenerateConfig, runner *Runner) ([]DiscoveredModel, error) {
+	return runner.listOpenAICompatibleModels(ctx, cfg)
+}
+
 func defaultAdapterForProvider(providerID string) string {
 	switch providerID {
 	case "", ProviderDemo:
 		return provider.AdapterDemo
 	case ProviderOpenAI:
 		return provider.AdapterOpenAICompatible
+	case ProviderAnthropic:
+		return provider.AdapterAnthropic
+	case ProviderGemini:
+		return provider.AdapterGemini
+	case ProviderOllama:
+		return provider.AdapterOllama
 	default:
 		return ""
 	}
@@ -391,8 +446,9 @@ func (r *Runner) generateOpenAICompatibleTurn(ctx context.Context, req domain.Ag
 
 	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
 		return TurnResult{}, &RunnerError{
-			Code:    ErrorCodeProviderRequestFailed,
-			Message: fmt.Sprintf("provider returned status %d", resp.StatusCode),
+			Code:       ErrorCodeProviderRequestFailed,
+			Message:    fmt.Sprintf("provider returned status %d", resp.StatusCode),
+			StatusCode: resp.StatusCode,
 		}
 	}
 
@@ -428,7 +484,7 @@ func (r *Runner) generateOpenAICompatibleTurn(ctx context.Context, req domain.Ag
 		}
 	}
 
-	return TurnResult{Text: text, ToolCalls: toolCalls}, nil
+	return TurnResult{Text: text, ToolCalls: toolCalls, Usage: completion.Usage.toDomain()}, nil
 }
 
 func (r *Runner) generateOpenAICompatibleTurnStream(
@@ -449,24 +505,16 @@ func (r *Runner) generateOpenAICompatibleTurnStream(
 	}
 
 	payload := openAIChatRequest{
-		Model:    cfg.Model,
-		Messages: toOpenAIMessages(req.Input),
-		Tools:    toOpenAITools(tools),
-		Stream:   true,
+		Model:         cfg.Model,
+		Messages:      toOpenAIMessages(req.Input),
+		Tools:         toOpenAITools(tools),
+		Stream:        true,
+		StreamOptions: &openAIStreamOptions{IncludeUsage: true},
 	}
 	if len(payload.Messages) == 0 {
 		return TurnResult{Text: generateDemoReply(req)}, nil
 	}
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return TurnResult{}, &RunnerError{
-			Code:    ErrorCodeProviderRequestFailed,
-			Message: "failed to encode provider request",
-			Err:     err,
-		}
-	}
-
 	requestCtx := ctx
 	cancel := func() {}
 	if cfg.TimeoutMS > 0 {
@@ -474,32 +522,17 @@ func (r *Runner) generateOpenAICompatibleTurnStream(
 	}
 	defer cancel()
 
-	httpReq, err := http.NewRequestWithContext(requestCtx, http.MethodPost, baseURL+"/chat/completions", bytes.NewReader(body))
+	resp, err := r.postOpenAIChatStream(requestCtx, baseURL, apiKey, cfg.Headers, payload)
 	if err != nil {
-		return TurnResult{}, &RunnerError{
-			Code:    ErrorCodeProviderRequestFailed,
-			Message: "failed to create provider request",
-			Err:     err,
-		}
-	}
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Accept", "text/event-stream")
-	for key, value := range cfg.Headers {
-		k := strings.TrimSpace(key)
-		v := strings.TrimSpace(value)
-		if k == "" || v == "" {
-			continue
-		}
-		httpReq.Header.Set(k, v)
+		return TurnResult{}, err
 	}
-
-	resp, err := r.httpClient.Do(httpReq)
-	if err != nil {
-		return TurnResult{}, &RunnerError{
-			Code:    ErrorCodeProviderRequestFailed,
-			Message: "provider request failed",
-			Err:     err,
+	if resp.StatusCode == http.StatusBadRequest && payload.StreamOptions != nil {
+		// Some OpenAI-compatible servers reject stream_options. Retry once
+		// without it; the turn then simply reports no usage.
+		resp.Body.Close()
+		payload.StreamOptions = nil
+		if resp, err = r.postOpenAIChatStream(requestCtx, baseURL, apiKey, cfg.Headers, payload); err != nil {
+			return TurnResult{}, err
 		}
 	}
 	defer resp.Body.Close()
@@ -507,13 +540,15 @@ func (r *Runner) generateOpenAICompatibleTurnStream(
 	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
 		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
 		return TurnResult{}, &RunnerError{
-			Code:    ErrorCodeProviderRequestFailed,
-			Message: fmt.Sprintf("provider returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody))),
+			Code:       ErrorCodeProviderRequestFailed,
+			Message:    fmt.Sprintf("provider returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody))),
+			StatusCode: resp.StatusCode,
 		}
 	}
 
 	var replyBuilder strings.Builder
 	toolCalls := map[int]*openAIToolCall{}
+	usage := domain.TokenUsage{}
 	processData := func(data string) error {
 		if data == "[DONE]" {
 			return nil
@@ -522,6 +557,9 @@ func (r *Runner) generateOpenAICompatibleTurnStream(
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
 			return fmt.Errorf("provider stream chunk is not valid json: %w", err)
 		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage.toDomain()
+		}
 		if len(chunk.Choices) == 0 {
 			return nil
 		}
@@ -599,14 +637,160 @@ func (r *Runner) generateOpenAICompatibleTurnStream(
 		}
 	}
 
-	return TurnResult{Text: reply, ToolCalls: parsedToolCalls}, nil
+	return TurnResult{Text: reply, ToolCalls: parsedToolCalls, Usage: usage}, nil
+}
+
+func (r *Runner) postOpenAIChatStream(ctx context.Context, baseURL, apiKey string, headers map[string]string, payload openAIChatRequest) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to encode provider request",
+			Err:     err,
+		}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to create provider request",
+			Err:     err,
+		}
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	for key, value := range headers {
+		k := strings.TrimSpace(key)
+		v := strings.TrimSpace(value)
+		if k == "" || v == "" {
+			continue
+		}
+		httpReq.Header.Set(k, v)
+	}
+	resp, err := r.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "provider request failed",
+			Err:     err,
+		}
+	}
+	return resp, nil
+}
+
+func (r *Runner) listOpenAICompatibleModels(ctx context.Context, cfg GenerateConfig) ([]DiscoveredModel, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+
+	requestCtx := ctx
+	cancel := func() {}
+	if cfg.TimeoutMS > 0 {
+		requestCtx, cancel = context.WithTimeout(ctx, time.Duration(cfg.TimeoutMS)*time.Millisecond)
+	}
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(requestCtx, http.MethodGet, baseURL+"/models", nil)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to create provider request",
+			Err:     err,
+		}
+	}
+	if apiKey := strings.TrimSpace(cfg.APIKey); apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	for key, value := range cfg.Headers {
+		k := strings.TrimSpace(key)
+		v := strings.TrimSpace(value)
+		if k == "" || v == "" {
+			continue
+		}
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := r.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "provider request failed",
+			Err:     err,
+		}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+	if err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderRequestFailed,
+			Message: "failed to read provider response",
+			Err:     err,
+		}
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, &RunnerError{
+			Code:       ErrorCodeProviderRequestFailed,
+			Message:    fmt.Sprintf("provider returned status %d", resp.StatusCode),
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, &RunnerError{
+			Code:    ErrorCodeProviderInvalidReply,
+			Message: "provider response is not valid json",
+			Err:     err,
+		}
+	}
+	out := make([]DiscoveredModel, 0, len(list.Data))
+	for _, item := range list.Data {
+		id := strings.TrimSpace(item.ID)
+		if id == "" {
+			continue
+		}
+		out = append(out, DiscoveredModel{ID: id, Name: id})
+	}
+	return out, nil
 }
 
 type openAIChatRequest struct {
-	Model    string                 `json:"model"`
-	Messages []openAIMessage        `json:"messages"`
-	Tools    []openAIToolDefinition `json:"tools,omitempty"`
-	Stream   bool                   `json:"stream,omitempty"`
+	Model         string                 `json:"model"`
+	Messages      []openAIMessage        `json:"messages"`
+	Tools         []openAIToolDefinition `json:"tools,omitempty"`
+	Stream        bool                   `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions   `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *openAIUsage) toDomain() domain.TokenUsage {
+	if u == nil {
+		return domain.TokenUsage{}
+	}
+	return newTokenUsage(u.PromptTokens, u.CompletionTokens, u.TotalTokens)
+}
+
+func newTokenUsage(prompt, completion, total int) domain.TokenUsage {
+	if total == 0 {
+		total = prompt + completion
+	}
+	return domain.TokenUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: total}
 }
 
 type openAIMessage struct {
@@ -646,6 +830,7 @@ type openAIChatResponse struct {
 			ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage *openAIUsage `json:"usage,omitempty"`
 }
 
 type openAIChatStreamResponse struct {
@@ -655,6 +840,7 @@ type openAIChatStreamResponse struct {
 			ToolCalls []openAIStreamToolCall `json:"tool_calls,omitempty"`
 		} `json:"delta"`
 	} `json:"choices"`
+	Usage *openAIUsage `json:"usage,omitempty"`
 }
 
 type openAIStreamToolCall struct {
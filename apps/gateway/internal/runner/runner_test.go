@@ -369,6 +369,7 @@ func TestGenerateTurnStreamOpenAISendsNativeDeltas(t *testing.T) {
 		w.Header().Set("Content-Type", "text/event-stream")
 		_, _ = fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hel\"}}]}\n\n")
 		_, _ = fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":9,\"completion_tokens\":2,\"total_tokens\":11}}\n\n")
 		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
 	}))
 	defer mock.Close()
@@ -404,6 +405,52 @@ func TestGenerateTurnStreamOpenAISendsNativeDeltas(t *testing.T) {
 	if got, ok := requestBody["stream"].(bool); !ok || !got {
 		t.Fatalf("expected stream=true in request, got=%#v", requestBody["stream"])
 	}
+	if options, _ := requestBody["stream_options"].(map[string]interface{}); options["include_usage"] != true {
+		t.Fatalf("expected stream_options.include_usage=true, got=%#v", requestBody["stream_options"])
+	}
+	if turn.Usage != (domain.TokenUsage{PromptTokens: 9, CompletionTokens: 2, TotalTokens: 11}) {
+		t.Fatalf("unexpected usage: %+v", turn.Usage)
+	}
+}
+
+func TestGenerateTurnStreamOpenAIRetriesWithoutStreamOptions(t *testing.T) {
+	t.Parallel()
+	var attempts []bool
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, hasOptions := body["stream_options"]
+		attempts = append(attempts, hasOptions)
+		if hasOptions {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"message":"unknown field stream_options"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n")
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer mock.Close()
+
+	r := NewWithHTTPClient(mock.Client())
+	turn, err := r.GenerateTurnStream(context.Background(), domain.AgentProcessRequest{
+		Input: []domain.AgentInputMessage{{
+			Role:    "user",
+			Type:    "message",
+			Content: []domain.RuntimeContent{{Type: "text", Text: "hello"}},
+		}},
+	}, GenerateConfig{
+		ProviderID: ProviderOpenAI,
+		Model:      "gpt-4o-mini",
+		APIKey:     "sk-test",
+		BaseURL:    mock.URL,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if turn.Text != "hi" || len(attempts) != 2 || !attempts[0] || attempts[1] {
+		t.Fatalf("expected a retry without stream_options, text=%q attempts=%v", turn.Text, attempts)
+	}
 }
 
 func TestGenerateTurnStreamOpenAIAggregatesToolCalls(t *testing.T) {
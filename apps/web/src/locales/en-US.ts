@@ -341,6 +341,7 @@ export const enUS: WebMessages = {
   "chat.emptyByFilter": "No chats found for current user/channel.",
   "chat.unnamed": "Untitled chat",
   "chat.meta": "Updated at {{updatedAt}}",
+  "chat.compacted": "History compacted ({{count}} earlier messages omitted)",
   "chat.delete": "Delete",
   "chat.deleteConfirm": "Delete session {{sessionId}}? This action cannot be undone.",
   "chat.emptyMessages": "No messages yet. Send your first one.",
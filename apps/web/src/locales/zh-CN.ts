@@ -340,6 +340,7 @@ export const zhCN = {
   "chat.emptyByFilter": "当前用户/渠道下暂无会话。",
   "chat.unnamed": "未命名会话",
   "chat.meta": "会话 {{sessionId}} | 更新于 {{updatedAt}}",
+  "chat.compacted": "历史已压缩（省略 {{count}} 条较早消息）",
   "chat.delete": "删除",
   "chat.deleteConfirm": "\u786e\u8ba4\u5220\u9664\u4f1a\u8bdd {{sessionId}}\uff1f\u8be5\u64cd\u4f5c\u4e0d\u53ef\u6062\u590d\u3002",
   "chat.emptyMessages": "暂无消息，发送你的第一条内容吧。",
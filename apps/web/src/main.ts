@@ -256,7 +256,9 @@ interface ViewToolCallNotice {
   order?: number;
   step?: number;
   toolName?: string;
+  toolCallID?: string;
   outputReady?: boolean;
+  output?: string;
 }
 
 interface ViewMessageTimelineEntry {
@@ -267,11 +269,13 @@ interface ViewMessageTimelineEntry {
 }
 
 interface AgentToolCallPayload {
+  id?: string;
   name?: string;
   input?: Record<string, unknown>;
 }
 
 interface AgentToolResultPayload {
+  id?: string;
   name?: string;
   ok?: boolean;
   summary?: string;
@@ -316,6 +320,7 @@ const DEFAULT_CHANNEL = "console";
 const WEB_CHAT_CHANNEL = DEFAULT_CHANNEL;
 const DEFAULT_CRON_JOB_ID = "cron-default";
 const CRON_META_SYSTEM_DEFAULT = "system_default";
+const CHAT_META_CONTEXT_COMPACTION = "context_compaction";
 const QQ_CHANNEL = "qq";
 const DEFAULT_QQ_API_BASE = "https://api.sgroup.qq.com";
 const QQ_SANDBOX_API_BASE = "https://sandbox.api.sgroup.qq.com";
@@ -345,7 +350,7 @@ const SYSTEM_PROMPT_WORKSPACE_PATHS = ["docs/AI/AGENTS.md", "docs/AI/ai-tools.md
 const SYSTEM_PROMPT_WORKSPACE_PATH_SET = new Set(SYSTEM_PROMPT_WORKSPACE_PATHS.map((path) => path.toLowerCase()));
 const SETTINGS_KEY = "nextai.web.chat.settings";
 const LOCALE_KEY = "nextai.web.locale";
-const BUILTIN_PROVIDER_IDS = new Set(["openai"]);
+const BUILTIN_PROVIDER_IDS = new Set(["openai", "anthropic", "gemini", "ollama"]);
 const TABS: TabKey[] = ["chat", "cron"];
 const customSelectInstances = new Map<HTMLSelectElement, CustomSelectInstance>();
 const scrollbarActivityTimers = new WeakMap<HTMLElement, number>();
@@ -2546,7 +2551,7 @@ function consumeSSEBlock(block: string, onDelta: (delta: string) => void, onEven
   if (typeof payload.type === "string" && onEvent) {
     onEvent(payload);
   }
-  if (typeof payload.delta === "string") {
+  if (typeof payload.delta === "string" && payload.type !== "tool_output_delta") {
     onDelta(payload.delta);
   }
   return false;
@@ -2590,6 +2595,10 @@ function renderChatList(): void {
     meta.textContent = t("chat.meta", {
       updatedAt: compactTime(chat.updated_at),
     });
+    const compaction = chat.meta?.[CHAT_META_CONTEXT_COMPACTION] as { dropped_messages?: unknown } | undefined;
+    if (compaction && typeof compaction.dropped_messages === "number") {
+      button.title = t("chat.compacted", { count: compaction.dropped_messages });
+    }
 
     button.append(title, meta);
     actions.appendChild(button);
@@ -2768,6 +2777,10 @@ function handleToolCallEvent(event: AgentStreamEvent, assistantID: string): void
     appendToolCallNoticeToAssistant(assistantID, notice);
     return;
   }
+  if (event.type === "tool_output_delta") {
+    applyToolOutputDeltaEvent(event, assistantID);
+    return;
+  }
   if (event.type === "tool_result") {
     applyToolResultEvent(event, assistantID);
   }
@@ -2840,11 +2853,13 @@ function formatToolCallNotice(event: AgentStreamEvent): ViewToolCallNotice | nul
   if (detail === "") {
     return null;
   }
+  const toolCallID = normalizeToolName(event.tool_call?.id);
   return {
     summary: formatToolCallSummary(event.tool_call),
     raw: detail,
     step: parsePositiveInteger(event.step),
     toolName: toolName === "" ? undefined : toolName,
+    toolCallID: toolCallID === "" ? undefined : toolCallID,
     outputReady: toolName === "shell" ? false : true,
   };
 }
@@ -2887,7 +2902,9 @@ function applyToolResultEvent(event: AgentStreamEvent, assistantID: string): voi
     return;
   }
   const step = parsePositiveInteger(event.step);
-  const notice = findPendingToolCallNotice(target.toolCalls, toolName, step);
+  const notice =
+    findToolCallNoticeByID(target.toolCalls, event.tool_result?.id) ??
+    findPendingToolCallNotice(target.toolCalls, toolName, step);
   if (notice) {
     notice.raw = output;
     notice.outputReady = true;
@@ -2903,6 +2920,40 @@ function applyToolResultEvent(event: AgentStreamEvent, assistantID: string): voi
   });
 }
 
+// Output deltas are matched to their call by id, so parallel calls and tools
+// other than shell each stream into their own notice.
+function applyToolOutputDeltaEvent(event: AgentStreamEvent, assistantID: string): void {
+  if (typeof event.delta !== "string" || event.delta === "") {
+    return;
+  }
+  const target = state.messages.find((item) => item.id === assistantID);
+  if (!target) {
+    return;
+  }
+  const toolName = normalizeToolName(event.tool_call?.name);
+  const notice =
+    findToolCallNoticeByID(target.toolCalls, event.tool_call?.id) ??
+    findPendingToolCallNotice(target.toolCalls, toolName, parsePositiveInteger(event.step));
+  if (!notice) {
+    return;
+  }
+  if (notice.outputReady) {
+    // The notice shows the call itself; stream the output below it.
+    notice.output = `${notice.output ?? ""}${event.delta}`;
+  } else {
+    notice.raw = notice.raw === t("chat.toolCallOutputPending") ? event.delta : `${notice.raw}${event.delta}`;
+  }
+  renderMessageInPlace(assistantID);
+}
+
+function findToolCallNoticeByID(notices: ViewToolCallNotice[], id: unknown): ViewToolCallNotice | undefined {
+  const toolCallID = normalizeToolName(id);
+  if (toolCallID === "") {
+    return undefined;
+  }
+  return notices.find((item) => item.toolCallID === toolCallID);
+}
+
 function findPendingToolCallNotice(
   notices: ViewToolCallNotice[],
   toolName: string,
@@ -3059,6 +3110,12 @@ function renderMessageNode(node: HTMLLIElement, message: ViewMessage): void {
     raw.textContent = toolCall.raw;
 
     details.append(summary, raw);
+    if (toolCall.output) {
+      const output = document.createElement("pre");
+      output.className = "tool-call-raw";
+      output.textContent = toolCall.output;
+      details.appendChild(output);
+    }
     toolCallList.appendChild(details);
     node.appendChild(toolCallList);
   }
@@ -3751,8 +3808,8 @@ function resolveProviderIDForUpsert(selectedProviderType: string): string {
   if (selectedProviderType === "") {
     return "";
   }
-  if (selectedProviderType === "openai") {
-    return ensureUniqueProviderID("openai");
+  if (BUILTIN_PROVIDER_IDS.has(selectedProviderType)) {
+    return ensureUniqueProviderID(selectedProviderType);
   }
   const baseProviderID = slugifyProviderID(modelsProviderNameInput.value) || slugifyProviderID(selectedProviderType) || "provider";
   return ensureUniqueProviderID(baseProviderID);
@@ -5782,6 +5839,29 @@ function buildToolCallNoticeFromRaw(raw: string): ViewToolCallNotice | null {
   };
 }
 
+// Persisted tool_result notices carry the shell output of an earlier
+// tool_call notice; fold them into it instead of listing them twice.
+function mergePersistedToolResultNotice(notices: ViewToolCallNotice[], raw: string, notice: ViewToolCallNotice): boolean {
+  if (!raw.includes('"type":"tool_result"')) {
+    return false;
+  }
+  if (notice.toolName !== "shell") {
+    return true;
+  }
+  for (let idx = notices.length - 1; idx >= 0; idx -= 1) {
+    const item = notices[idx];
+    if (item.toolName !== "shell" || item.raw !== t("chat.toolCallOutputUnavailable")) {
+      continue;
+    }
+    if (notice.step !== undefined && item.step !== undefined && item.step !== notice.step) {
+      continue;
+    }
+    item.raw = notice.raw;
+    return true;
+  }
+  return false;
+}
+
 function parsePersistedToolCallNotices(metadata: Record<string, unknown> | null): ViewToolCallNotice[] {
   if (!metadata) {
     return [];
@@ -5805,6 +5885,9 @@ function parsePersistedToolCallNotices(metadata: Record<string, unknown> | null)
     }
     const rawText = typeof obj.raw === "string" ? obj.raw : "";
     const notice = buildToolCallNoticeFromRaw(rawText);
+    if (notice && mergePersistedToolResultNotice(notices, rawText, notice)) {
+      continue;
+    }
     if (notice) {
       const persistedOrder = parsePositiveInteger(obj.order);
       if (persistedOrder !== undefined) {
@@ -11,19 +11,34 @@ const requiredPaths = [
   "/chats",
   "/chats/{chat_id}",
   "/agent/process",
+  "/agent/runs",
+  "/agent/runs/{run_id}/cancel",
+  "/agent/runs/{run_id}/revert",
+  "/agent/runs/{run_id}/approvals/{call_id}",
+  "/tools",
   "/channels/qq/inbound",
   "/channels/qq/state",
   "/cron/jobs",
   "/models",
   "/models/catalog",
   "/models/{provider_id}",
+  "/models/{provider_id}/refresh",
+  "/usage",
   "/envs",
   "/skills",
+  "/skills/import",
+  "/skills/{skill_name}/export",
+  "/skills/{skill_name}/versions",
+  "/skills/{skill_name}/rollback",
   "/workspace/files",
   "/workspace/files/{file_path}",
   "/workspace/export",
   "/workspace/import",
   "/config/channels",
+  "/config/tools",
+  "/config/tools/{tool_name}",
+  "/config/mcp-servers",
+  "/config/mcp-servers/{server_name}",
 ];
 
 test("openapi contains required paths", async () => {